@@ -28,6 +28,10 @@ var (
 	}
 
 	alwaysDumpHex = false
+	strict        = false
+
+	framesDir = ""
+	frameRate = 30.0
 )
 
 func init() {
@@ -37,6 +41,15 @@ func init() {
 
 	pf.BoolVarP(&alwaysDumpHex, "always_dump_hex", "d", alwaysDumpHex,
 		"Always dump hex content of packets.")
+
+	pf.BoolVar(&strict, "strict", strict,
+		"Exit with an error if any event fails to decode.")
+
+	pf.StringVar(&framesDir, "frames_dir", framesDir,
+		"If set, instead of dumping the file, render it as a sequence of PNG frames in this directory.")
+
+	pf.Float64Var(&frameRate, "frame_rate", frameRate,
+		"The number of frames per second of stream time to render with --frames_dir.")
 }
 
 var rootCmd = &cobra.Command{
@@ -59,19 +72,66 @@ func Execute() {
 }
 
 func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) error {
+	if framesDir != "" {
+		if len(args) != 1 {
+			return errors.New("exactly one file must be specified with --frames_dir")
+		}
+		if frameRate <= 0 {
+			return errors.Errorf("invalid --frame_rate %v", frameRate)
+		}
+
+		interval := time.Duration(float64(time.Second) / frameRate)
+		failures, err := exportFrames(c, args[0], framesDir, interval)
+		var readErrs []error
+		if err != nil {
+			logging.S(c).Errorf("Error exporting frames from %q: %s", args[0], err)
+			readErrs = append(readErrs, errors.Wrapf(err, "exporting frames from %q", args[0]))
+		}
+		return reportFailures(failures, readErrs)
+	}
+
+	// A file that can't be read doesn't stop the others from being dumped, so
+	// that the output shows every failure.
+	var (
+		failures int
+		readErrs []error
+	)
 	for _, arg := range args {
-		if err := dumpFile(c, arg, os.Stdout); err != nil {
+		fileFailures, err := dumpFile(c, arg, os.Stdout)
+		failures += fileFailures
+		if err != nil {
 			logging.S(c).Errorf("Error dumping file %q: %s", arg, err)
-			return err
+			readErrs = append(readErrs, errors.Wrapf(err, "dumping file %q", arg))
 		}
 	}
-	return nil
+	return reportFailures(failures, readErrs)
+}
+
+// reportFailures reports the number of events that failed to decode, and the
+// errors that stopped files from being read. It returns an error if any file
+// could not be read, or, in strict mode, if any event failed to decode.
+func reportFailures(failures int, readErrs []error) error {
+	for _, err := range readErrs {
+		fmt.Fprintf(os.Stderr, "Failed: %s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d event(s) failed to decode.\n", failures)
+
+	switch {
+	case len(readErrs) > 0:
+		return errors.Errorf("%d file(s) could not be read", len(readErrs))
+	case strict && failures > 0:
+		return errors.Errorf("%d event(s) failed to decode", failures)
+	default:
+		return nil
+	}
 }
 
-func dumpFile(c context.Context, path string, out io.Writer) (err error) {
+// dumpFile writes the contents of the stream file at path to out. It returns
+// the number of events that failed to decode.
+func dumpFile(c context.Context, path string, out io.Writer) (failures int, err error) {
 	sr, err := streamfile.MakeEventStreamReader(path)
 	if err != nil {
-		return errors.Wrap(err, "opening file")
+		return 0, errors.Wrap(err, "opening file")
 	}
 	defer func() {
 		if err := sr.Close(); err != nil {
@@ -112,9 +172,9 @@ func dumpFile(c context.Context, path string, out io.Writer) (err error) {
 		if err != nil {
 			if err == io.EOF {
 				logging.S(c).Debugf("Encountered EOF.")
-				return nil
+				return failures, nil
 			}
-			return errors.Wrap(err, "reading events from file")
+			return failures, errors.Wrap(err, "reading events from file")
 		}
 
 		var offset time.Duration
@@ -123,6 +183,7 @@ func dumpFile(c context.Context, path string, out io.Writer) (err error) {
 			if offset, err = ptypes.Duration(v); err != nil {
 				logging.S(c).Warnf("Failed to decode offset from event #%d: %s", index, err)
 				offset = 0
+				failures++
 			}
 		}
 		mustWrite(fmt.Fprintf(out, "Packet #%d at offset %s:", index, offset))
@@ -131,12 +192,14 @@ func dumpFile(c context.Context, path string, out io.Writer) (err error) {
 			device := sr.ResolveDeviceForIndex(pkt.Device)
 			if device == nil {
 				mustWrite(fmt.Fprintf(out, "  Packet references out-of-range device %d:\n%s", pkt.Device, pkt))
+				failures++
 				continue
 			}
 
 			decoded, err := pkt.Decode(device)
 			if err != nil {
 				mustWrite(fmt.Fprintf(out, "  Failed to decode event: %s\n%s", err, pkt))
+				failures++
 				continue
 			}
 
@@ -148,6 +211,12 @@ func dumpFile(c context.Context, path string, out io.Writer) (err error) {
 					mustWrite(fmt.Fprintf(out, "  Strip state for strip %d:\n", ss.StripNumber))
 					mustDumpHex(ss.Pixels.Bytes())
 				}
+
+			default:
+				// We can only interpret PixelPusher packets. Show the raw packet
+				// rather than omitting it.
+				mustWrite(fmt.Fprintf(out, "  Packet for device %q is not a PixelPusher packet, and can't be "+
+					"decoded further:\n%s\n", device.Id, pkt))
 			}
 		}
 	}
@@ -0,0 +1,183 @@
+package pixelcat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/protocol/pixelpusher"
+	"github.com/danjacques/gopushpixels/replay/streamfile"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+)
+
+// frameState is the reconstructed pixel state of every device in a file.
+//
+// Strips are laid out in metadata device order, then strip order, and are
+// pre-populated with black pixels, so every rendered frame has the same
+// dimensions.
+type frameState struct {
+	strips []web.Strip
+	// index maps a device ID to the index of its first strip in strips.
+	index map[string]int
+}
+
+func newFrameState(md *streamfile.Metadata) *frameState {
+	fs := frameState{
+		index: make(map[string]int),
+	}
+	if md == nil {
+		return &fs
+	}
+
+	for _, d := range md.Devices {
+		if _, ok := fs.index[d.Id]; ok {
+			continue
+		}
+		fs.index[d.Id] = len(fs.strips)
+		for i := range d.Strip {
+			fs.strips = append(fs.strips, web.Strip{
+				Number: i,
+				Pixels: make([]web.Pixel, d.PixelsPerStrip),
+			})
+		}
+	}
+	return &fs
+}
+
+// apply updates the state of device id's strips from pp.
+func (fs *frameState) apply(id string, md *streamfile.Metadata_Device, pp *pixelpusher.Packet) {
+	base, ok := fs.index[id]
+	if !ok {
+		return
+	}
+
+	for _, ss := range pp.StripStates {
+		n := int(ss.StripNumber)
+		if n >= len(md.Strip) {
+			continue
+		}
+
+		pixels := fs.strips[base+n].Pixels
+		for i := 0; i < ss.Pixels.Len() && i < len(pixels); i++ {
+			p := ss.Pixels.Pixel(i)
+			pixels[i] = web.Pixel{R: p.Red, G: p.Green, B: p.Blue}
+		}
+	}
+}
+
+// exportFrames renders the stream file at path as a sequence of PNG images in
+// dir, one for every interval of the stream.
+//
+// Each image is a composite of all of the file's devices, showing the pixel
+// state at that point in the stream. exportFrames returns the number of events
+// that failed to decode.
+func exportFrames(c context.Context, path, dir string, interval time.Duration) (int, error) {
+	if interval <= 0 {
+		return 0, errors.Errorf("invalid frame interval %s", interval)
+	}
+
+	sr, err := streamfile.MakeEventStreamReader(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "opening file")
+	}
+	defer func() {
+		if err := sr.Close(); err != nil {
+			logging.S(c).Warnf("Failed to close stream file %q: %s", path, err)
+		}
+	}()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, errors.Wrapf(err, "creating frames directory %q", dir)
+	}
+
+	fs := newFrameState(sr.Metadata())
+	frame, failures := 0, 0
+	unsupported := make(map[string]int)
+	writeFrame := func() error {
+		framePath := filepath.Join(dir, fmt.Sprintf("frame-%06d.png", frame))
+		fd, err := os.Create(framePath)
+		if err != nil {
+			return errors.Wrapf(err, "creating frame %q", framePath)
+		}
+		if err := web.RenderStripPNG(fs.strips, fd); err != nil {
+			_ = fd.Close()
+			return errors.Wrapf(err, "rendering frame %q", framePath)
+		}
+		if err := fd.Close(); err != nil {
+			return errors.Wrapf(err, "closing frame %q", framePath)
+		}
+		frame++
+		return nil
+	}
+
+	for index := 0; ; index++ {
+		e, err := sr.ReadEvent()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return failures, errors.Wrap(err, "reading events from file")
+		}
+
+		var offset time.Duration
+		if v := e.Offset; v != nil {
+			if offset, err = ptypes.Duration(v); err != nil {
+				logging.S(c).Warnf("Failed to decode offset from event #%d: %s", index, err)
+				failures++
+				continue
+			}
+		}
+
+		// Emit every frame that falls before this event.
+		for time.Duration(frame)*interval < offset {
+			if err := writeFrame(); err != nil {
+				return failures, err
+			}
+		}
+
+		pkt := e.GetPacket()
+		if pkt == nil {
+			continue
+		}
+		d := sr.ResolveDeviceForIndex(pkt.Device)
+		if d == nil {
+			logging.S(c).Warnf("Event #%d references out-of-range device %d.", index, pkt.Device)
+			failures++
+			continue
+		}
+		decoded, err := pkt.Decode(d)
+		if err != nil {
+			logging.S(c).Warnf("Failed to decode event #%d: %s", index, err)
+			failures++
+			continue
+		}
+		if decoded.PixelPusher == nil {
+			// Only PixelPusher packets can be rendered.
+			if unsupported[d.Id] == 0 {
+				logging.S(c).Warnf("Event #%d for device %q is not a PixelPusher packet; its packets "+
+					"will not be rendered.", index, d.Id)
+			}
+			unsupported[d.Id]++
+			continue
+		}
+		fs.apply(d.Id, d, decoded.PixelPusher)
+	}
+	for id, count := range unsupported {
+		logging.S(c).Warnf("Skipped %d packet(s) for device %q, which could not be rendered.", count, id)
+	}
+
+	// Emit the final state.
+	if err := writeFrame(); err != nil {
+		return failures, err
+	}
+	logging.S(c).Infof("Wrote %d frame(s) to %q.", frame, dir)
+	return failures, nil
+}
@@ -0,0 +1,325 @@
+// Package pixelfleet implements a dashboard that aggregates the status of
+// several PixelProxy instances.
+package pixelfleet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ppweb "github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util"
+	"github.com/danjacques/pixelproxy/util/logging"
+	"github.com/danjacques/pixelproxy/web"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	app = util.Application{
+		Verbosity:    zap.InfoLevel,
+		Production:   false,
+		ColorizeLogs: true,
+	}
+
+	peers         []string
+	httpAddr      = ":8080"
+	pollPeriod    = 5 * time.Second
+	pollTimeout   = 2 * time.Second
+	httpRefresh   = 5 * time.Second
+	logPollErrors = false
+)
+
+func init() {
+	pf := rootCmd.PersistentFlags()
+
+	app.AddFlags(pf)
+
+	pf.StringSliceVarP(&peers, "peer", "p", nil,
+		"The base URL of a PixelProxy instance to monitor (e.g., http://host:80). Can be specified multiple times.")
+
+	pf.StringVar(&httpAddr, "http_addr", httpAddr,
+		"Address for HTTP server to listen on.")
+
+	pf.DurationVar(&pollPeriod, "poll_period", pollPeriod,
+		"Period at which peer status is polled.")
+
+	pf.DurationVar(&pollTimeout, "poll_timeout", pollTimeout,
+		"Amount of time to wait for a single peer's status before giving up.")
+
+	pf.DurationVar(&httpRefresh, "http_refresh", httpRefresh,
+		"Interval at which the dashboard page refreshes itself. If <= 0, it will not refresh.")
+
+	pf.BoolVar(&logPollErrors, "log_poll_errors", logPollErrors,
+		"Log every failed peer poll, rather than only changes in peer reachability.")
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "pixelfleet",
+	Short: "Aggregate the status of several PixelProxy instances",
+	Long:  ``, // TODO: Fill in long descrpition.
+	Run: func(cmd *cobra.Command, args []string) {
+		app.Run(context.Background(), func(c context.Context) error {
+			return rootCmdRun(c, cmd, args)
+		})
+	},
+}
+
+// Execute runs the main application.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) error {
+	if len(peers) == 0 {
+		return errors.New("at least one --peer must be specified")
+	}
+
+	fleet := fleet{
+		peers: make([]*PeerStatus, len(peers)),
+	}
+	for i, p := range peers {
+		fleet.peers[i] = &PeerStatus{
+			URL: strings.TrimSuffix(p, "/"),
+		}
+	}
+
+	webMux := mux.NewRouter()
+	webMux.Path("/").Methods("GET").HandlerFunc(fleet.handleDashboard)
+	webMux.Path("/_api/fleet").Methods("GET").HandlerFunc(web.HandleJSON(fleet.handleFleet))
+
+	webServer := http.Server{
+		Addr:    httpAddr,
+		Handler: webMux,
+	}
+
+	// Shutdown our web server when our Context is cancelled.
+	go func() {
+		<-c.Done()
+		if err := webServer.Shutdown(c); err != nil {
+			logging.S(c).Warnf("Error during web server shutdown: %s", err)
+		}
+	}()
+
+	go func() {
+		_ = util.LoopUntil(c, pollPeriod, func(c context.Context) error {
+			fleet.pollAll(c)
+			return nil
+		})
+	}()
+
+	logging.S(c).Infof("Serving fleet dashboard for %d peer(s) on %q", len(fleet.peers), webServer.Addr)
+	if err := webServer.ListenAndServe(); err != nil {
+		if errors.Cause(err) != http.ErrServerClosed {
+			return err
+		}
+	}
+	return nil
+}
+
+// PeerStatus is the last-observed status of a single PixelProxy instance.
+type PeerStatus struct {
+	// URL is the base URL of the peer.
+	URL string `json:"url"`
+
+	// Status is the peer's last successfully-polled status. It may be nil if
+	// the peer has never been polled successfully.
+	Status *ppweb.Status `json:"status,omitempty"`
+	// Updated is the time when Status was polled.
+	Updated time.Time `json:"updated,omitempty"`
+
+	// Error, if not empty, is the error from the most recent poll. If set,
+	// Status is stale.
+	Error string `json:"error,omitempty"`
+}
+
+// fleet holds the polled status of all peers.
+type fleet struct {
+	mu    sync.Mutex
+	peers []*PeerStatus
+}
+
+// pollAll polls all peers in parallel and updates their status.
+func (f *fleet) pollAll(c context.Context) {
+	var wg sync.WaitGroup
+	for i := range f.peers {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.poll(c, i)
+		}()
+	}
+	wg.Wait()
+}
+
+func (f *fleet) poll(c context.Context, i int) {
+	f.mu.Lock()
+	url := f.peers[i].URL
+	f.mu.Unlock()
+
+	c, cancelFunc := context.WithTimeout(c, pollTimeout)
+	defer cancelFunc()
+
+	st, err := loadPixelProxyStatus(c, url)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ps := *f.peers[i]
+	if err != nil {
+		if logPollErrors || ps.Error == "" {
+			logging.S(c).Warnf("Could not poll status from %s: %s", url, err)
+		}
+		ps.Error = err.Error()
+	} else {
+		if ps.Error != "" {
+			logging.S(c).Infof("Peer %s is reachable again.", url)
+		}
+		ps.Status, ps.Updated, ps.Error = st, time.Now(), ""
+	}
+	f.peers[i] = &ps
+}
+
+// snapshot returns the current status of all peers.
+//
+// The returned PeerStatus values are replaced, not modified, by polling, so
+// they are safe to use without holding the lock.
+func (f *fleet) snapshot() []*PeerStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make([]*PeerStatus, len(f.peers))
+	copy(result, f.peers)
+	return result
+}
+
+func (f *fleet) handleFleet(rw http.ResponseWriter, req *http.Request) interface{} {
+	return f.snapshot()
+}
+
+func (f *fleet) handleDashboard(rw http.ResponseWriter, req *http.Request) {
+	data := struct {
+		Peers   []*PeerStatus
+		Refresh int
+		Now     time.Time
+	}{
+		Peers:   f.snapshot(),
+		Refresh: int(httpRefresh / time.Second),
+		Now:     time.Now(),
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(rw, &data); err != nil {
+		logging.S(req.Context()).Errorf("Failed to render dashboard: %s", err)
+	}
+}
+
+// loadPixelProxyStatus loads the status of the PixelProxy instance at the
+// base URL, pp.
+func loadPixelProxyStatus(c context.Context, pp string) (*ppweb.Status, error) {
+	url := pp + "/_api/status"
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(c)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
+	// Parse the response body as JSON.
+	var ws ppweb.Status
+	r := json.NewDecoder(resp.Body)
+	if err := r.Decode(&ws); err != nil {
+		return nil, errors.Wrap(err, "decoding JSON response")
+	}
+	return &ws, nil
+}
+
+// peerState returns a short description of what the peer is doing.
+func peerState(ps *PeerStatus) string {
+	switch {
+	case ps.Status == nil:
+		return "unknown"
+	case ps.Status.Status.RecordStatus != nil:
+		return "recording"
+	case ps.Status.Status.PlaybackStatus != nil:
+		if ps.Status.Status.PlaybackStatus.Paused {
+			return "paused"
+		}
+		return "playing"
+	case ps.Status.Status.ProxyForwarding:
+		return "forwarding"
+	default:
+		return "idle"
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"peerState": peerState,
+	"since": func(now, t time.Time) time.Duration {
+		return now.Sub(t).Truncate(time.Second)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <title>PixelProxy Fleet</title>
+  {{ if gt .Refresh 0 }}<meta http-equiv="refresh" content="{{ .Refresh }}">{{ end }}
+  <style>
+    body { font-family: sans-serif; }
+    table { border-collapse: collapse; }
+    th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+    .error { color: #b00; }
+  </style>
+</head>
+<body>
+  <h1>PixelProxy Fleet</h1>
+  <table>
+    <tr>
+      <th>Peer</th>
+      <th>State</th>
+      <th>Description</th>
+      <th>Devices</th>
+      <th>Uptime</th>
+      <th>Last Update</th>
+    </tr>
+    {{ range .Peers }}
+    <tr>
+      <td><a href="{{ .URL }}">{{ .URL }}</a></td>
+      <td>{{ peerState . }}</td>
+      <td>{{ with .Status }}{{ .Status.Description }}{{ end }}</td>
+      <td>{{ with .Status }}{{ len .Devices }}{{ end }}</td>
+      <td>{{ with .Status }}{{ .Status.Uptime }}{{ end }}</td>
+      <td>
+        {{ if not .Updated.IsZero }}{{ since $.Now .Updated }} ago{{ else }}never{{ end }}
+        {{ with .Error }}<div class="error">{{ . }}</div>{{ end }}
+      </td>
+    </tr>
+    {{ end }}
+  </table>
+</body>
+</html>
+`))
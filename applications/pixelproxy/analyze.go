@@ -0,0 +1,196 @@
+package pixelproxy
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+)
+
+const (
+	// analysisRateWindow is the window over which peak packet rates are
+	// measured.
+	analysisRateWindow = time.Second
+
+	// analysisMaxLagSections is the maximum number of lagging sections that an
+	// analysis reports.
+	analysisMaxLagSections = 100
+)
+
+// packetRateWindow tracks the peak number of packets within a sliding window.
+type packetRateWindow struct {
+	offsets []time.Duration
+	peak    int
+}
+
+func (w *packetRateWindow) add(offset time.Duration) {
+	w.offsets = append(w.offsets, offset)
+	for offset-w.offsets[0] >= analysisRateWindow {
+		w.offsets = w.offsets[1:]
+	}
+	if len(w.offsets) > w.peak {
+		w.peak = len(w.offsets)
+	}
+}
+
+// budgetQueue models a sender that can send one packet per interval. It
+// reports how far behind its scheduled offset each packet would be sent.
+type budgetQueue struct {
+	interval time.Duration
+	finish   time.Duration
+}
+
+func newBudgetQueue(packetsPerSecond float64) *budgetQueue {
+	if packetsPerSecond <= 0 {
+		return nil
+	}
+	return &budgetQueue{interval: time.Duration(float64(time.Second) / packetsPerSecond)}
+}
+
+// add queues a packet scheduled at offset, and returns its lag.
+func (q *budgetQueue) add(offset time.Duration) time.Duration {
+	if q.finish < offset {
+		q.finish = offset
+	}
+	q.finish += q.interval
+	return q.finish - offset
+}
+
+// AnalyzeFile implements web.ControllerProxy.
+//
+// Budgets are modeled as senders with a fixed packet rate. A packet that
+// would be sent more than the playback MaxLagAge after its offset is likely to
+// be dropped, and the sections of the file where that happens are reported.
+func (ctrl *Controller) AnalyzeFile(c context.Context, name string) (*web.FileAnalysis, error) {
+	switch exists, err := ctrl.Storage.HasFile(name); {
+	case err != nil:
+		return nil, err
+	case !exists:
+		return nil, web.ErrFileNotFound
+	}
+
+	// Analysis reads the entire file, so treat it as a storage job.
+	done, err := ctrl.startJob(c)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	ctrl.mu.Lock()
+	maxLagAge := ctrl.PlaybackMaxLagAge
+	ctrl.mu.Unlock()
+
+	sr, err := ctrl.Storage.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sr.Close(); err != nil {
+			logging.S(c).Warnf("Failed to close reader for %q: %s", name, err)
+		}
+	}()
+
+	fa := web.FileAnalysis{
+		Name:               name,
+		DevicePacketBudget: ctrl.DevicePacketBudget,
+		TotalPacketBudget:  ctrl.TotalPacketBudget,
+		MaxLagAge:          maxLagAge,
+	}
+
+	var (
+		total        packetRateWindow
+		devices      = make(map[string]*packetRateWindow)
+		totalQueue   = newBudgetQueue(ctrl.TotalPacketBudget)
+		deviceQueues = make(map[string]*budgetQueue)
+		lastOffset   = time.Duration(-1)
+		section      *web.LagSection
+	)
+	for {
+		e, err := sr.ReadEvent()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrapf(err, "reading event #%d", fa.Events)
+		}
+		if err := c.Err(); err != nil {
+			return nil, err
+		}
+
+		pkt := e.GetPacket()
+		if pkt == nil {
+			continue
+		}
+		d := sr.ResolveDeviceForIndex(pkt.Device)
+		if d == nil {
+			continue
+		}
+		offset, err := ptypes.Duration(e.Offset)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid offset for event #%d", fa.Events)
+		}
+
+		fa.Events++
+		fa.Duration = offset
+		if lastOffset >= 0 {
+			if interval := offset - lastOffset; fa.Events == 2 || interval < fa.MinInterval {
+				fa.MinInterval = interval
+			}
+		}
+		lastOffset = offset
+
+		// Track peak rates.
+		total.add(offset)
+		dw := devices[d.Id]
+		if dw == nil {
+			dw = &packetRateWindow{}
+			devices[d.Id] = dw
+		}
+		dw.add(offset)
+		if dw.peak > fa.MaxDevicePacketsPerSecond {
+			fa.MaxDevicePacketsPerSecond = dw.peak
+			fa.MaxDevice = d.Id
+		}
+
+		// Determine how far behind this packet would be sent.
+		var lag time.Duration
+		if totalQueue != nil {
+			lag = totalQueue.add(offset)
+		}
+		if ctrl.DevicePacketBudget > 0 {
+			dq := deviceQueues[d.Id]
+			if dq == nil {
+				dq = newBudgetQueue(ctrl.DevicePacketBudget)
+				deviceQueues[d.Id] = dq
+			}
+			if dl := dq.add(offset); dl > lag {
+				lag = dl
+			}
+		}
+
+		// Track sections where packets are likely to be dropped.
+		switch {
+		case lag <= maxLagAge:
+			section = nil
+		case section != nil:
+			section.End = offset
+			if lag > section.MaxLag {
+				section.MaxLag = lag
+			}
+		case len(fa.LagSections) < analysisMaxLagSections:
+			fa.LagSections = append(fa.LagSections, web.LagSection{Start: offset, End: offset, MaxLag: lag})
+			section = &fa.LagSections[len(fa.LagSections)-1]
+		}
+	}
+
+	fa.MaxPacketsPerSecond = total.peak
+	fa.ExceedsBudget = (fa.TotalPacketBudget > 0 && float64(fa.MaxPacketsPerSecond) > fa.TotalPacketBudget) ||
+		(fa.DevicePacketBudget > 0 && float64(fa.MaxDevicePacketsPerSecond) > fa.DevicePacketBudget) ||
+		len(fa.LagSections) > 0
+	return &fa, nil
+}
@@ -30,6 +30,9 @@ import (
 	"go.uber.org/zap"
 )
 
+// trashPurgePeriod is how often expired files are purged from the trash.
+const trashPurgePeriod = time.Hour
+
 // Application-level flag variables.
 var (
 	app = util.Application{
@@ -39,24 +42,67 @@ var (
 	}
 
 	interfaceName        = ""
+	interfacePreference  []string
+	startupDelay         = time.Duration(0)
+	startupWaitInterface = time.Duration(0)
 	discoveryAddress     = ""
 	discoveryExpiration  = time.Minute
 	proxyAddress         = ""
 	proxyDiscoveryPeriod = time.Second
 	proxyGroupOffset     = int32(0)
+	proxyMaxDevices      = 0
+	deviceIDFormat       = DeviceIDFormatRaw
+	proxyLayout          ProxyLayout
+
+	discoveryBroadcastRetry = util.Retry{
+		Attempts:   1,
+		Delay:      10 * time.Millisecond,
+		MaxDelay:   250 * time.Millisecond,
+		Multiplier: 2,
+	}
 
 	playbackMaxLagAge       = 100 * time.Millisecond
 	playbackAutoResumeDelay = time.Duration(0)
-
-	httpAddr        = ":80"
-	httpCacheAssets = true
+	idleBlackoutTimeout     = time.Duration(0)
+	safeMode                = false
+	playbackRequireDevices  = false
+	playbackNoRoutePolicy   = NoRoutePolicyDrop
+	playbackNoRouteDevice   = ""
+	playbackDeviceBudget    = float64(0)
+	playbackTotalBudget     = float64(0)
+	deviceFailureThreshold  = 0
+	deviceSkipDown          = false
+
+	httpAddr              = ":80"
+	httpCacheAssets       = true
+	httpLandingPage       = web.DefaultLandingPage
+	httpTemplateReload    = false
+	httpTLSCertFile       = ""
+	httpTLSKeyFile        = ""
+	httpKeepAlive         = true
+	httpIdleTimeout       = time.Duration(0)
+	httpReadHeaderTimeout = time.Duration(0)
+	httpMaxUploadBytes    = int64(4 << 30)
 
 	storagePath                  = filepath.Join(os.TempDir(), "pixelproxy")
 	storageWriteCompression      = streamfile.CompressionFlag(streamfile.Compression_SNAPPY)
 	storageWriteCompressionLevel = -1
-
-	enableSnapshot     = false
-	snapshotSampleRate = 2 * time.Second
+	storageReadAheadBytes        = int64(0)
+	storageMaxConcurrentJobs     = 0
+	storageWarnFreeBytes         = int64(256 * 1024 * 1024)
+	storageMinFreeBytes          = int64(0)
+	storageTrash                 = false
+	storageTrashRetention        = time.Duration(0)
+
+	recordMaxBytes    = int64(0)
+	recordMaxDuration = time.Duration(0)
+
+	enableSnapshot         = false
+	snapshotSampleRate     = 2 * time.Second
+	snapshotStaleThreshold = 10 * time.Second
+	snapshotMaxBytes       = int64(0)
+
+	snapshotDeviceSampleRates []string
 )
 
 func init() {
@@ -72,12 +118,35 @@ func init() {
 	pf.StringVar(&interfaceName, "interface", interfaceName,
 		"Name of the network interface to use. If empty, an interface will be chosen.")
 
+	pf.StringSliceVar(&interfacePreference, "interface_preference", nil,
+		"An ordered list of preferred network interfaces. At startup, the first one that is up and "+
+			"has an IPv4 address is used. Can be specified multiple times. Exclusive with --interface.")
+
+	pf.DurationVar(&startupDelay, "startup_delay", startupDelay,
+		"Amount of time to wait before setting up networking at startup. While waiting, the HTTP "+
+			"server reports that PixelProxy is initializing.")
+
+	pf.DurationVar(&startupWaitInterface, "startup_wait_interface", startupWaitInterface,
+		"If >0, wait up to this long at startup (after --startup_delay) for --interface, or one of "+
+			"--interface_preference, to be up with an IPv4 address before setting up networking.")
+
 	pf.StringVar(&discoveryAddress, "discovery_address", discoveryAddress,
 		"Local address to listen on for discovery. If empty, listen on default address.")
 
 	pf.DurationVar(&discoveryExpiration, "discovery_expiration", discoveryExpiration,
 		"Period of non-communication before expiring a discovered device.")
 
+	pf.IntVar(&discoveryBroadcastRetry.Attempts, "discovery_broadcast_attempts", discoveryBroadcastRetry.Attempts,
+		"The number of times to attempt each proxy discovery broadcast before giving up.")
+	pf.DurationVar(&discoveryBroadcastRetry.Delay, "discovery_broadcast_retry_delay", discoveryBroadcastRetry.Delay,
+		"The delay before the first discovery broadcast retry.")
+	pf.DurationVar(&discoveryBroadcastRetry.MaxDelay, "discovery_broadcast_retry_max_delay",
+		discoveryBroadcastRetry.MaxDelay,
+		"The maximum delay between discovery broadcast retries.")
+	pf.Float64Var(&discoveryBroadcastRetry.Multiplier, "discovery_broadcast_retry_multiplier",
+		discoveryBroadcastRetry.Multiplier,
+		"The factor by which the discovery broadcast retry delay grows after each retry.")
+
 	pf.StringVar(&proxyAddress, "proxy_address", proxyAddress,
 		"The network [ADDR][:PORT] that proxy devices should identify as. You probably "+
 			"do NOT want to supply a port, as that effectively restricts to a single proxy "+
@@ -91,6 +160,23 @@ func init() {
 			"group identifier. This can be used to differentiate proxy devices while maintaining "+
 			"relative group ordering.")
 
+	pf.IntVar(&proxyMaxDevices, "proxy_max_devices", proxyMaxDevices,
+		"If >0, the maximum number of proxy devices to create. Devices discovered beyond this are "+
+			"not proxied. This protects the proxy from segments with very many devices.")
+
+	pf.IntVar(&proxyLayout.Strips, "proxy_strips", proxyLayout.Strips,
+		"If >0, the number of strips that proxy devices advertise, instead of mirroring their "+
+			"source devices. Intended for testing downstream software against other geometries.")
+
+	pf.IntVar(&proxyLayout.PixelsPerStrip, "proxy_pixels_per_strip", proxyLayout.PixelsPerStrip,
+		"If >0, the number of pixels per strip that proxy devices advertise, instead of mirroring "+
+			"their source devices. Intended for testing downstream software against other geometries.")
+
+	pf.StringVar(&deviceIDFormat, "device_id_format", deviceIDFormat,
+		"The format of device IDs shown in the UI and API: \""+DeviceIDFormatRaw+"\" for the devices' own "+
+			"IDs, or \""+DeviceIDFormatOrdinal+"\" for IDs derived from their group and controller ordinals. "+
+			"Devices can always be referenced by their own IDs.")
+
 	pf.DurationVar(&playbackMaxLagAge, "playback_max_lag_age", playbackMaxLagAge,
 		"The maximum amount of time that a packet can lag behind realtime before we "+
 			"discard it. This is used as a fudge factor.")
@@ -99,11 +185,73 @@ func init() {
 		"The amount of time after (a) playback has been paused, and (b) the proxy has received "+
 			"at least one packet since then that we automatically resume the playback stream.")
 
+	pf.DurationVar(&idleBlackoutTimeout, "idle_blackout_timeout", idleBlackoutTimeout,
+		"If >0, the amount of time after which, if no packets have been forwarded and nothing is "+
+			"playing, all devices are blacked out until activity resumes.")
+
+	pf.BoolVar(&safeMode, "safe_mode", safeMode,
+		"Start idle, without playing the default file. The default file setting is left unchanged.")
+
+	pf.BoolVar(&playbackRequireDevices, "playback_require_devices", playbackRequireDevices,
+		"Refuse to play a file if none of the devices that it references are registered.")
+
+	pf.StringVar(&playbackNoRoutePolicy, "playback_no_route_policy", playbackNoRoutePolicy,
+		"What to do with playback packets for devices that aren't registered: \""+NoRoutePolicyDrop+"\" "+
+			"to drop them, \""+NoRoutePolicyLog+"\" to drop and log them, or \""+NoRoutePolicyCatchAll+"\" to "+
+			"send them to --playback_no_route_device.")
+
+	pf.StringVar(&playbackNoRouteDevice, "playback_no_route_device", playbackNoRouteDevice,
+		"The ID of the device that receives unroutable playback packets under the \""+
+			NoRoutePolicyCatchAll+"\" no-route policy.")
+
+	pf.Float64Var(&playbackDeviceBudget, "playback_device_packet_budget", playbackDeviceBudget,
+		"If >0, the number of packets per second that a single device can be sent. File analysis "+
+			"reports whether files exceed it.")
+
+	pf.Float64Var(&playbackTotalBudget, "playback_total_packet_budget", playbackTotalBudget,
+		"If >0, the number of packets per second that can be sent to all devices together. File "+
+			"analysis reports whether files exceed it.")
+
+	pf.IntVar(&deviceFailureThreshold, "device_failure_threshold", deviceFailureThreshold,
+		"If >0, the number of consecutive failed sends after which a device is marked down. It is "+
+			"marked up again when a send succeeds.")
+
+	pf.BoolVar(&deviceSkipDown, "device_skip_down", deviceSkipDown,
+		"Don't send packets to devices that are marked down, except for a periodic retry. Requires "+
+			"--device_failure_threshold.")
+
 	pf.StringVar(&httpAddr, "http_addr", httpAddr, "The HTTP [ADDR]:PORT to listen on.")
 
 	pf.BoolVar(&httpCacheAssets, "http_cache_assets", httpCacheAssets,
 		"Cache web assets after loading. Can be disabled for development.")
 
+	pf.BoolVar(&httpTemplateReload, "http_template_reload", httpTemplateReload,
+		"Install the /_api/reloadTemplates endpoint, which rebuilds cached web templates. "+
+			"Intended for template development.")
+
+	pf.StringVar(&httpLandingPage, "http_landing_page", httpLandingPage,
+		"The page that the root path redirects to (e.g., /render.html).")
+
+	pf.StringVar(&httpTLSCertFile, "http_tls_cert_file", httpTLSCertFile,
+		"Path to a TLS certificate file. If set along with --http_tls_key_file, HTTP is served over "+
+			"TLS, and HTTP/2 is enabled.")
+
+	pf.StringVar(&httpTLSKeyFile, "http_tls_key_file", httpTLSKeyFile,
+		"Path to the TLS private key file for --http_tls_cert_file.")
+
+	pf.BoolVar(&httpKeepAlive, "http_keep_alive", httpKeepAlive,
+		"Allow HTTP/1.1 clients to reuse connections for multiple requests.")
+
+	pf.DurationVar(&httpIdleTimeout, "http_idle_timeout", httpIdleTimeout,
+		"The amount of time to keep an idle keep-alive connection open. If 0, idle connections "+
+			"are not closed.")
+
+	pf.DurationVar(&httpReadHeaderTimeout, "http_read_header_timeout", httpReadHeaderTimeout,
+		"The amount of time allowed to read a request's headers. If 0, there is no limit.")
+
+	pf.Int64Var(&httpMaxUploadBytes, "http_max_upload_bytes", httpMaxUploadBytes,
+		"The maximum size of a file upload, in bytes. If 0, there is no limit.")
+
 	pf.StringVar(&storagePath, "storage_path", storagePath, "The file storage path.")
 
 	pf.Var(&storageWriteCompression, "storage_write_compression",
@@ -113,11 +261,56 @@ func init() {
 		"If enabled/supported, the compression level to use. <0 means default level, the higher "+
 			"the number the more CPU is used to achieve better compression.")
 
+	pf.IntVar(&storageMaxConcurrentJobs, "storage_max_concurrent_jobs", storageMaxConcurrentJobs,
+		"If >0, the maximum number of heavy storage jobs (merges and migrations) that can run at "+
+			"once. Additional jobs wait for a running job to finish.")
+
+	pf.Int64Var(&storageWarnFreeBytes, "storage_warn_free_bytes", storageWarnFreeBytes,
+		"Warn at startup if the storage temporary directory, which all writes are staged through, "+
+			"has less than this many bytes free. If <= 0, no warning is issued.")
+
+	pf.Int64Var(&storageMinFreeBytes, "storage_min_free_bytes", storageMinFreeBytes,
+		"If >0, refuse to start recordings, merges, and migrations when the storage temporary directory "+
+			"has less than this many bytes free, rather than risk filling the disk mid-write.")
+
+	pf.BoolVar(&storageTrash, "storage_trash", storageTrash,
+		"Move deleted files into a trash directory under the storage path, from which they can be "+
+			"restored, rather than removing them.")
+
+	pf.DurationVar(&storageTrashRetention, "storage_trash_retention", storageTrashRetention,
+		"If >0, permanently delete files that have been in the trash for longer than this (e.g., 168h). "+
+			"If <= 0, trashed files are kept until they are restored.")
+
+	pf.Int64Var(&recordMaxBytes, "record_max_bytes", recordMaxBytes,
+		"If >0, the default maximum number of bytes of events that a recording can hold. When it is "+
+			"reached, the recording is stopped and saved. Individual recordings may override this.")
+
+	pf.DurationVar(&recordMaxDuration, "record_max_duration", recordMaxDuration,
+		"If >0, the default maximum duration of a recording. When it is reached, the recording is "+
+			"stopped and saved. Individual recordings may override this.")
+
+	pf.Int64Var(&storageReadAheadBytes, "storage_read_ahead_bytes", storageReadAheadBytes,
+		"If >0, the number of bytes of a file to read ahead into the OS file cache when it is "+
+			"opened for playback. This can reduce playback startup lag for large files on slow disks.")
+
 	pf.BoolVar(&enableSnapshot, "enable_snapshot", enableSnapshot,
 		"Enable in-memory snapshot of data sent to devices, allowing previews.")
 
 	pf.DurationVar(&snapshotSampleRate, "snapshot_sample_rate", snapshotSampleRate,
 		"The rate at which pixel data will be snapshotted.")
+
+	pf.DurationVar(&snapshotStaleThreshold, "snapshot_stale_threshold", snapshotStaleThreshold,
+		"A strip that has not been updated for this long is marked as stale in previews. "+
+			"If <= 0, strips are never marked stale.")
+
+	pf.StringSliceVar(&snapshotDeviceSampleRates, "snapshot_device_sample_rate", nil,
+		"A per-device minimum snapshot interval, as ID=DURATION (e.g., \"pp0=10s\"). Use this to sample "+
+			"large devices less often. Can be specified multiple times.")
+
+	pf.Int64Var(&snapshotMaxBytes, "snapshot_max_bytes", snapshotMaxBytes,
+		"The maximum amount of pixel data retained for snapshots and fresh previews, across all devices. When "+
+			"exceeded, the least-recently-previewed devices are evicted until they are next previewed. If <= 0, "+
+			"there is no limit.")
 }
 
 var rootCmd = &cobra.Command{
@@ -140,6 +333,62 @@ func Execute() {
 }
 
 func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr error) {
+	// Validate our landing page before we start anything.
+	if err := web.ValidateLandingPage(httpLandingPage); err != nil {
+		logging.S(c).Errorf("Invalid HTTP landing page: %s", err)
+		return err
+	}
+	if err := ValidateDeviceIDFormat(deviceIDFormat); err != nil {
+		logging.S(c).Errorf("Invalid device ID format: %s", err)
+		return err
+	}
+	if err := proxyLayout.Validate(); err != nil {
+		logging.S(c).Errorf("Invalid proxy layout: %s", err)
+		return err
+	}
+	if err := ValidateNoRoutePolicy(playbackNoRoutePolicy, playbackNoRouteDevice); err != nil {
+		logging.S(c).Errorf("Invalid playback no-route policy: %s", err)
+		return err
+	}
+	if deviceSkipDown && deviceFailureThreshold <= 0 {
+		err := errors.New("--device_skip_down requires --device_failure_threshold")
+		logging.S(c).Errorf("Invalid device health configuration: %s", err)
+		return err
+	}
+	if (httpTLSCertFile == "") != (httpTLSKeyFile == "") {
+		err := errors.New("--http_tls_cert_file and --http_tls_key_file must be specified together")
+		logging.S(c).Errorf("Invalid HTTP TLS configuration: %s", err)
+		return err
+	}
+
+	if interfaceName != "" && len(interfacePreference) > 0 {
+		err := errors.New("--interface and --interface_preference are mutually exclusive")
+		logging.S(c).Errorf("Invalid interface configuration: %s", err)
+		return err
+	}
+
+	// Wait for the network to come up, if configured. Until then, report that
+	// we're initializing over HTTP.
+	if startupDelay > 0 || startupWaitInterface > 0 {
+		is := startInitializingServer(c, httpAddr, httpTLSCertFile, httpTLSKeyFile)
+		err := waitForStartup(c)
+		is.stop(c)
+		if err != nil {
+			logging.S(c).Errorf("Failed while waiting to start: %s", err)
+			return err
+		}
+	}
+
+	// Choose our network interface from our preferences, if supplied.
+	if len(interfacePreference) > 0 {
+		var err error
+		if interfaceName, err = selectInterface(interfacePreference); err != nil {
+			logging.S(c).Errorf("Could not select a preferred interface: %s", err)
+			return err
+		}
+		logging.S(c).Infof("Selected preferred interface %q.", interfaceName)
+	}
+
 	// Resolve our discovery broadcast network addresses.
 	var discoveryAddr *network.ResolvedConn
 	if discoveryAddress != "" {
@@ -175,11 +424,24 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 		Root:                   storagePath,
 		WriterCompression:      storageWriteCompression.Value(),
 		WriterCompressionLevel: storageWriteCompressionLevel,
+		ReadAheadBytes:         storageReadAheadBytes,
+		MinFreeBytes:           storageMinFreeBytes,
+		UseTrash:               storageTrash,
+		TrashRetention:         storageTrashRetention,
 	}
-	if err := storage.Prepare(c); err != nil {
+	if err := storage.Prepare(logging.WithComponent(c, "storage")); err != nil {
 		logging.S(c).Errorf("Could not create storage root directory %q: %s", storage.Root, err)
 		return err
 	}
+	if storageWarnFreeBytes > 0 {
+		switch free, err := storage.TempFreeBytes(); {
+		case err != nil:
+			logging.S(c).Debugf("Could not determine free space for %q: %s", storage.TempDir(), err)
+		case free < storageWarnFreeBytes:
+			logging.S(c).Warnf("Storage temporary directory %q has only %d byte(s) free; "+
+				"recordings and merges may fail.", storage.TempDir(), free)
+		}
+	}
 
 	// Allow our processes to cancel the Context if something goes wrong.
 	c, cancelFunc := context.WithCancel(c)
@@ -223,7 +485,7 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 	// registrations for all discovered devices.
 	router := device.Router{
 		Registry: &reg,
-		Logger:   logging.S(c),
+		Logger:   logging.S(logging.WithComponent(c, "router")),
 	}
 	defer router.Shutdown()
 
@@ -243,6 +505,18 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 		}()
 	}
 
+	// Purge expired files from the trash.
+	if storageTrashRetention > 0 {
+		startOperation("Trash purge", func() error {
+			return util.LoopUntil(c, trashPurgePeriod, func(c context.Context) error {
+				if err := storage.PurgeTrash(c); err != nil {
+					logging.S(c).Warnf("Failed to purge trash: %s", err)
+				}
+				return nil
+			})
+		})
+	}
+
 	// Manage proxy devices.
 	proxyManager := proxy.Manager{
 		AddressRegistry: proxy.AddressRegistry{
@@ -251,14 +525,14 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 		},
 		ProxyAddr:   proxyAddr.Addr.IP,
 		GroupOffset: proxyGroupOffset,
-		Logger:      logging.S(c),
+		Logger:      logging.S(logging.WithComponent(c, "proxy")),
 	}
 	defer func() {
 		operationFinished("Proxy manager", proxyManager.Close())
 	}()
 
 	// Keep a snapshot of proxy strip states.
-	var snapshots *device.SnapshotManager
+	var sampler *snapshotSampler
 	if enableSnapshot {
 		// Our packets come from two places:
 		// 1) Packets sent to proxies, which are routed to devices.
@@ -266,9 +540,16 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 		//
 		// Note that the Proxy does NOT use the Router, so we need to intercept
 		// packets in both places to get the latest snapshots at all times.
-		snapshots = &device.SnapshotManager{
+		deviceIntervals, err := parseDeviceIntervals(snapshotDeviceSampleRates)
+		if err != nil {
+			logging.S(c).Errorf("Invalid snapshot device sample rate: %s", err)
+			return err
+		}
+		sampler = &snapshotSampler{
 			// Sample at a rate faster than our render refresh (currently 5s).
-			SampleRate: snapshotSampleRate,
+			SampleRate:      snapshotSampleRate,
+			DeviceIntervals: deviceIntervals,
+			MaxBytes:        snapshotMaxBytes,
 		}
 
 		// Listen for packets received by the proxy.
@@ -277,12 +558,12 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 			// If it was dropped (e.g., during playback), it isn't representative of
 			// the current device state.
 			if forwarded {
-				snapshots.HandlePacket(d, pkt)
+				sampler.HandlePacket(d, pkt)
 			}
 		}))
 
 		// Listen for packets sent to our Router. This occurs for playback packets.
-		router.AddListener(device.ListenerFunc(snapshots.HandlePacket))
+		router.AddListener(sampler)
 	}
 
 	// Discovery transmitter for our proxy devices.
@@ -294,22 +575,9 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 	}()
 
 	proxyTransmitter := discovery.Transmitter{
-		Logger: logging.S(c),
+		Logger: logging.S(logging.WithComponent(c, "discovery")),
 	}
 
-	startOperation("Discovery broadcast", func() error {
-		return util.LoopUntil(c, proxyDiscoveryPeriod, func(c context.Context) error {
-			devices := proxyManager.ProxyDevices()
-			logging.S(c).Debugf("Broadcasting discovery for %d proxy device(s)...", len(devices))
-			for _, d := range devices {
-				if err := proxyTransmitter.Broadcast(&proxyTransmitterSender, d.DiscoveryHeaders()); err != nil {
-					logging.S(c).Warnf("Failed to broadcast discovery for proxy device %q: %s", d, err)
-				}
-			}
-			return nil
-		})
-	})
-
 	// Set up discovery.
 	discoveryConn, err := discoveryAddr.ListenMulticastUDP4()
 	if err != nil {
@@ -318,7 +586,7 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 	}
 
 	l := discovery.Listener{
-		Logger: logging.S(c),
+		Logger: logging.S(logging.WithComponent(c, "discovery")),
 
 		// Filter any proxy device addresses, so we don't end up proxying our own
 		// proxies.
@@ -343,14 +611,28 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 	}
 	defer discoveryReg.Shutdown()
 
-	// Listen on our discovery address for advertised devices.
+	// Listen on our discovery address for advertised devices. Failures to create
+	// their proxies are reported by the Controller.
+	var proxyErrs proxyErrors
 	startOperation("Discovery listener", func() error {
 		return discovery.ListenAndRegister(c, &l, &discoveryReg, func(d device.D) error {
+			// Refuse to proxy new devices beyond our limit.
+			if proxyMaxDevices > 0 && !hasProxyFor(&proxyManager, d) {
+				if n := len(proxyManager.ProxyDevices()); n >= proxyMaxDevices {
+					err := errors.Errorf("proxy device limit (%d) reached", proxyMaxDevices)
+					logging.S(c).Warnf("Not creating proxy for device %s: %s", d, err)
+					proxyErrs.record(d.ID(), err)
+					return nil
+				}
+			}
+
 			// Add the device to our proxy manager. This will cause a proxy device
-			// to be created for it.
-			if err := proxyManager.AddDevice(d); err != nil {
+			// to be created for it, advertising the configured layout.
+			err := proxyManager.AddDevice(proxyLayout.proxySource(d))
+			if err != nil {
 				logging.S(c).Errorf("Could not create proxy for device %s: %s", d, err)
 			}
+			proxyErrs.record(d.ID(), err)
 			return nil
 		})
 	})
@@ -361,13 +643,53 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 		Router:            &router,
 		DiscoveryRegistry: &discoveryReg,
 		ProxyManager:      &proxyManager,
-		Snapshots:         snapshots,
 		Storage:           &storage,
 		ShutdownFunc:      cancelFunc,
 		PlaybackMaxLagAge: playbackMaxLagAge,
 		AutoResumeDelay:   playbackAutoResumeDelay,
+		DeviceIDFormat:    deviceIDFormat,
+
+		MaxConcurrentJobs:        storageMaxConcurrentJobs,
+		SafeMode:                 safeMode,
+		SnapshotStaleThreshold:   snapshotStaleThreshold,
+		IdleBlackoutTimeout:      idleBlackoutTimeout,
+		RefuseUnroutablePlayback: playbackRequireDevices,
+		NoRoutePolicy:            playbackNoRoutePolicy,
+		NoRouteCatchAllDevice:    playbackNoRouteDevice,
+		DevicePacketBudget:       playbackDeviceBudget,
+		TotalPacketBudget:        playbackTotalBudget,
+		MaxRecordBytes:           recordMaxBytes,
+		MaxRecordDuration:        recordMaxDuration,
+		DeviceFailureThreshold:   deviceFailureThreshold,
+		SkipDownDevices:          deviceSkipDown,
+		MaxProxyDevices:          proxyMaxDevices,
+
+		snapshotSampler: sampler,
+		proxyErrors:     &proxyErrs,
 	}
 
+	// Broadcast discovery for our proxy devices. The Controller can pause this.
+	startOperation("Discovery broadcast", func() error {
+		return util.LoopUntil(c, proxyDiscoveryPeriod, func(c context.Context) error {
+			if ctrl.discoveryBroadcastPaused() {
+				logging.S(c).Debugf("Discovery broadcast is paused.")
+				return nil
+			}
+
+			devices := proxyManager.ProxyDevices()
+			logging.S(c).Debugf("Broadcasting discovery for %d proxy device(s)...", len(devices))
+			for _, d := range devices {
+				err := discoveryBroadcastRetry.Do(c, func() error {
+					return proxyTransmitter.Broadcast(&proxyTransmitterSender, d.DiscoveryHeaders())
+				})
+				if err != nil {
+					logging.S(c).Warnf("Failed to broadcast discovery for proxy device %q: %s", d, err)
+				}
+			}
+			return nil
+		})
+	})
+
 	// Start our HTTP server.
 	webMux := mux.NewRouter()
 
@@ -380,18 +702,27 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 	webController := web.Controller{
 		Proxy:                 &ctrl,
 		CacheAssets:           httpCacheAssets,
-		Logger:                logging.L(c),
+		Logger:                logging.L(logging.WithComponent(c, "web")),
 		RenderRefreshInterval: time.Duration(2.5 * float64(snapshotSampleRate)),
+		LandingPage:           httpLandingPage,
+		MaxUploadBytes:        httpMaxUploadBytes,
+		AllowTemplateReload:   httpTemplateReload,
+		TLS:                   httpTLSCertFile != "",
 	}
 	if err := webController.Install(c, webMux); err != nil {
 		logging.S(c).Errorf("Failed to install HTTP routes: %s", err)
 		return err
 	}
 
+	// A write timeout is deliberately not set, since it would also apply to
+	// long-lived streaming responses.
 	webServer := http.Server{
-		Addr:    httpAddr,
-		Handler: webMux,
+		Addr:              httpAddr,
+		Handler:           webMux,
+		IdleTimeout:       httpIdleTimeout,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
 	}
+	webServer.SetKeepAlivesEnabled(httpKeepAlive)
 
 	startOperation("web server", func() error {
 		// Shutdown our web server when our Context is cancelled.
@@ -402,8 +733,16 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 			}
 		}()
 
-		logging.S(c).Infof("Serving HTTP on %q", webServer.Addr)
-		if err := webServer.ListenAndServe(); err != nil {
+		var err error
+		if httpTLSCertFile != "" {
+			// ListenAndServeTLS enables HTTP/2 automatically.
+			logging.S(c).Infof("Serving HTTPS on %q", webServer.Addr)
+			err = webServer.ListenAndServeTLS(httpTLSCertFile, httpTLSKeyFile)
+		} else {
+			logging.S(c).Infof("Serving HTTP on %q", webServer.Addr)
+			err = webServer.ListenAndServe()
+		}
+		if err != nil {
 			if errors.Cause(err) != http.ErrServerClosed {
 				return err
 			}
@@ -412,7 +751,7 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 	})
 
 	// Run our Controller.
-	if err := ctrl.Run(c); err != nil {
+	if err := ctrl.Run(logging.WithComponent(c, "controller")); err != nil {
 		if errors.Cause(err) == context.Canceled {
 			logging.S(c).Debugf("Canceled while running Controller: %s", err)
 		} else {
@@ -424,3 +763,33 @@ func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) (appErr er
 
 	return nil
 }
+
+// waitForStartup waits for the configured startup delay, and then for our
+// network interface, if configured.
+func waitForStartup(c context.Context) error {
+	if startupDelay > 0 {
+		logging.S(c).Infof("Waiting %s before starting...", startupDelay)
+		var s util.Sleeper
+		defer s.Close()
+		if err := s.Sleep(c, startupDelay); err != nil {
+			return err
+		}
+	}
+
+	if startupWaitInterface > 0 {
+		names := interfacePreference
+		if interfaceName != "" {
+			names = []string{interfaceName}
+		}
+		if len(names) == 0 {
+			logging.S(c).Warnf("No interface is configured; not waiting for one.")
+			return nil
+		}
+
+		logging.S(c).Infof("Waiting up to %s for a usable interface...", startupWaitInterface)
+		if err := waitForInterface(c, names, startupWaitInterface); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package pixelproxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/storage"
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/pkg/errors"
+)
+
+// ExportConfig implements web.ControllerProxy.
+func (ctrl *Controller) ExportConfig(c context.Context) (*web.Config, error) {
+	var cfg web.Config
+
+	var err error
+	if cfg.DefaultFile, err = ctrl.Storage.GetDefault(); err != nil {
+		return nil, errors.Wrap(err, "getting default file")
+	}
+
+	ctrl.mu.Lock()
+	forwarding := !ctrl.hasProxyManagerLease
+	ctrl.mu.Unlock()
+	cfg.ProxyForwarding = &forwarding
+
+	zones, err := ctrl.Storage.GetZones()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting zones")
+	}
+	if len(zones) > 0 {
+		cfg.Zones = zones
+	}
+
+	periods, err := ctrl.Storage.GetUpdatePeriods()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting update periods")
+	}
+	if len(periods) > 0 {
+		cfg.UpdatePeriods = make(map[string]string, len(periods))
+		for id, period := range periods {
+			cfg.UpdatePeriods[id] = period.String()
+		}
+	}
+
+	return &cfg, nil
+}
+
+// ImportConfig implements web.ControllerProxy.
+//
+// The imported zones and update periods replace the current ones, and the
+// default file is set, or cleared, to match. cfg is validated in full before
+// anything is applied.
+func (ctrl *Controller) ImportConfig(c context.Context, cfg *web.Config) error {
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	// Validate.
+	zoneForDevice := make(map[string]string)
+	for name, ids := range cfg.Zones {
+		if err := validateZone(&web.Zone{Name: name, Devices: ids}); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if other, ok := zoneForDevice[id]; ok {
+				return errors.Wrapf(web.ErrInvalidRequest, "device %q belongs to zones %q and %q", id, other, name)
+			}
+			zoneForDevice[id] = name
+		}
+	}
+
+	periods := make(storage.UpdatePeriods, len(cfg.UpdatePeriods))
+	for id, v := range cfg.UpdatePeriods {
+		period, err := time.ParseDuration(v)
+		if err != nil {
+			return errors.Wrapf(web.ErrInvalidRequest, "invalid update period %q for device %q: %s", v, id, err)
+		}
+		if period < 0 {
+			return errors.Wrapf(web.ErrInvalidRequest, "update period for device %q must not be negative, got %s",
+				id, period)
+		}
+		if period > 0 {
+			periods[id] = period
+		}
+	}
+
+	// Apply.
+	logging.S(c).Infof("Importing configuration: %d zone(s), %d update period(s), default file %q.",
+		len(cfg.Zones), len(periods), cfg.DefaultFile)
+
+	err := ctrl.Storage.UpdateZones(func(zones storage.Zones) error {
+		for name := range zones {
+			delete(zones, name)
+		}
+		for name, ids := range cfg.Zones {
+			zones[name] = append([]string(nil), ids...)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "importing zones")
+	}
+
+	periods, err = ctrl.Storage.UpdateUpdatePeriods(func(up storage.UpdatePeriods) error {
+		for id := range up {
+			delete(up, id)
+		}
+		for id, period := range periods {
+			up[id] = period
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "importing update periods")
+	}
+	ctrl.playbackPacer.setPeriods(periods)
+
+	if err := ctrl.SetDefaultFile(c, cfg.DefaultFile); err != nil {
+		return errors.Wrap(err, "importing default file")
+	}
+
+	if cfg.ProxyForwarding != nil {
+		ctrl.mu.Lock()
+		changed := *cfg.ProxyForwarding == ctrl.hasProxyManagerLease
+		ctrl.mu.Unlock()
+
+		if changed {
+			if err := ctrl.SetProxyForwarding(c, *cfg.ProxyForwarding); err != nil {
+				return errors.Wrap(err, "importing proxy forwarding")
+			}
+		}
+	}
+	return nil
+}
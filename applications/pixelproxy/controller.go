@@ -3,6 +3,7 @@ package pixelproxy
 import (
 	"context"
 	"fmt"
+	"io"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -15,7 +16,9 @@ import (
 
 	"github.com/danjacques/gopushpixels/device"
 	"github.com/danjacques/gopushpixels/discovery"
+	"github.com/danjacques/gopushpixels/pixel"
 	"github.com/danjacques/gopushpixels/protocol"
+	"github.com/danjacques/gopushpixels/protocol/pixelpusher"
 	"github.com/danjacques/gopushpixels/proxy"
 	"github.com/danjacques/gopushpixels/replay"
 	"github.com/danjacques/gopushpixels/replay/streamfile"
@@ -41,21 +44,95 @@ type Controller struct {
 	// ProxyManager manages the device proxy state.
 	ProxyManager *proxy.Manager
 
-	// Snapshots, if not nil, is the snapshot manager for registered devices.
-	Snapshots *device.SnapshotManager
+	// SnapshotStaleThreshold, if >0, is the amount of time after which a strip
+	// that has not been updated is reported as stale.
+	SnapshotStaleThreshold time.Duration
 
 	// ShutdownFunc is a function that can be called to shutdown the system,
 	// cancelling its outer Context.
 	ShutdownFunc context.CancelFunc
 
 	// PlaybackMaxLagAge is the MaxLagAge value to provide to our Player.
+	//
+	// Once the Controller is running, it may be changed by
+	// SetPlaybackMaxLagAge, and is protected by the Controller's lock.
 	PlaybackMaxLagAge time.Duration
 
+	// DeviceIDFormat is the format of the device IDs that the Controller
+	// exposes. See the DeviceIDFormat constants. If empty, DeviceIDFormatRaw is
+	// used.
+	//
+	// Devices may always be referenced by their own IDs as well.
+	DeviceIDFormat string
+
+	// RefuseUnroutablePlayback, if true, causes PlayFile to fail if none of the
+	// devices referenced by the file are currently registered.
+	//
+	// This does not apply to the default file, which is played at startup
+	// before devices have had a chance to be discovered.
+	RefuseUnroutablePlayback bool
+
+	// DevicePacketBudget and TotalPacketBudget, if >0, are the number of
+	// packets per second that a single device, and all devices together, can
+	// be sent. They are used by AnalyzeFile.
+	DevicePacketBudget float64
+	TotalPacketBudget  float64
+
+	// NoRoutePolicy is the initial policy for playback packets that can't be
+	// routed to their device. See the NoRoutePolicy constants. If empty,
+	// NoRoutePolicyDrop is used.
+	NoRoutePolicy string
+	// NoRouteCatchAllDevice is the ID of the device that receives unroutable
+	// playback packets under NoRoutePolicyCatchAll.
+	NoRouteCatchAllDevice string
+
+	// MaxRecordBytes and MaxRecordDuration, if >0, are the default limits on
+	// the number of bytes of events and the duration of a recording. When
+	// either is reached, the recording is stopped and saved. RecordFileOpts may
+	// override them.
+	MaxRecordBytes    int64
+	MaxRecordDuration time.Duration
+
+	// DeviceFailureThreshold, if >0, is the number of consecutive failed sends
+	// after which a device is marked down. It is marked up again when a send
+	// succeeds.
+	DeviceFailureThreshold int
+	// SkipDownDevices, if true, causes packets for down devices to be dropped
+	// rather than sent, except for a periodic retry.
+	SkipDownDevices bool
+
+	// MaxProxyDevices, if >0, is the maximum number of proxy devices that will
+	// be created. It is enforced where devices are added to the ProxyManager,
+	// and is reported in the Controller's status.
+	MaxProxyDevices int
+
 	// AutoResumeDelay, if >0, is the amount of time after (a) the Controller has
 	// been paused, and (b) the ProxyManager has received a packet, after which
 	// the Controller will automatically resume.
 	AutoResumeDelay time.Duration
 
+	// MaxConcurrentJobs, if >0, is the maximum number of heavy storage jobs,
+	// such as merges and migrations, that may run at once.
+	MaxConcurrentJobs int
+
+	// SafeMode, if true, causes the Controller to start idle rather than
+	// playing the default file. The default file setting is not changed.
+	SafeMode bool
+
+	// IdleBlackoutTimeout, if >0, is the amount of time after which, if no
+	// packets have been forwarded and nothing has been played or recorded, the
+	// Controller will black out all devices. They are left dark until activity
+	// resumes.
+	IdleBlackoutTimeout time.Duration
+
+	// snapshotSampler, if not nil, keeps snapshots of registered devices. It
+	// also tracks when each strip was last updated.
+	snapshotSampler *snapshotSampler
+
+	// proxyErrors, if not nil, records failures to create proxies for
+	// discovered devices.
+	proxyErrors *proxyErrors
+
 	// ctx is this Controller's Context, passed to its Run method.
 	ctx context.Context
 
@@ -67,15 +144,73 @@ type Controller struct {
 	systemControl *SystemControl
 
 	player             *replay.Player
+	playbackLeaser     *proxyManagerPlaybackLeaser
 	playingName        string
+	playingDeviceIDs   []string
+	playingMarkers     []web.Marker
+	playbackMonitor    *playbackMonitor
 	autoResumeListener *proxy.AutoResumeListener
 
+	// playingScaled, if not nil, is the scaled copy of the file that is being
+	// played at another rate.
+	playingScaled *storage.ScaledFile
+
+	// playbackFilter filters the devices that receive playback packets.
+	playbackFilter playbackFilter
+
+	// counterBaselines are subtracted from reported device counters.
+	counterBaselines deviceCounterBaselines
+
+	// jobs limits the number of concurrent storage jobs.
+	jobs jobLimiter
+
+	// snapshotDownsampling holds per-device snapshot downsampling factors.
+	snapshotDownsampling snapshotDownsampling
+
+	// playbackPacer enforces per-device playback update periods.
+	playbackPacer playbackPacer
+
+	// noRoute holds the policy for unroutable playback packets.
+	noRoute noRouteHandler
+
+	// deviceHealth tracks devices' consecutive send failures.
+	deviceHealth deviceHealth
+
+	// recordDiskUsage caches the disk usage that is reported while recording.
+	recordDiskUsage diskUsageCache
+
+	// statusSubscribers are notified when the Controller's state changes.
+	statusSubscribers statusSubscribers
+
+	// testPattern, if not nil, is the running test pattern generator.
+	testPattern *testPatternGenerator
+
+	// playlist, if not nil, is the playlist that the current playback belongs
+	// to.
+	playlist *playlist
+
+	// playbackHeld is true if the playbackMonitor has paused the Player between
+	// loop rounds.
+	playbackHeld bool
+
 	recorder         *replay.Recorder
-	recorderListener proxy.Listener
+	recorderListener *recorderListener
 	recordingName    string
+	recordingStarted time.Time
+	recordingNote    string
+
+	// lastRecordStatus is the final status of the most recently stopped
+	// recording. Its Error is set if the recording could not be saved.
+	lastRecordStatus *web.RecordStatus
 
 	hasProxyManagerLease bool
 
+	// idleWatchdog, if not nil, is the running idle blackout watchdog.
+	idleWatchdog *idleWatchdog
+
+	// discoveryPaused is true if proxy device discovery broadcasts are paused.
+	discoveryPaused bool
+
 	// isRunning is a protected value that will be true if the Controller is
 	// currently running.
 	isRunning bool
@@ -97,6 +232,27 @@ func (ctrl *Controller) Run(c context.Context) error {
 		return errors.Wrap(err, "loading default file")
 	}
 
+	// If the default file has been deleted out from under us, clear it.
+	if defaultFileName != "" {
+		switch exists, err := ctrl.Storage.HasFile(defaultFileName); {
+		case err != nil:
+			logging.S(c).Warnf("Could not check for default file %q: %s", defaultFileName, err)
+		case !exists:
+			logging.S(c).Infof("Default file %q no longer exists; clearing default.", defaultFileName)
+			if err := ctrl.Storage.SetDefault(""); err != nil {
+				logging.S(c).Warnf("Failed to clear default file: %s", err)
+			}
+			defaultFileName = ""
+		}
+	}
+
+	ctrl.noRoute.set(ctrl.NoRoutePolicy, ctrl.NoRouteCatchAllDevice)
+
+	// Load playback update period overrides.
+	if err := ctrl.loadUpdatePeriods(); err != nil {
+		logging.S(c).Warnf("Failed to load playback update periods: %s", err)
+	}
+
 	// Mark that we're running.
 	func() {
 		ctrl.mu.Lock()
@@ -116,13 +272,16 @@ func (ctrl *Controller) Run(c context.Context) error {
 
 	// Before we quit, shut down any ongoing operations.
 	defer func() {
+		// Stop any ongoing operations.
+		ctrl.stopTask()
+
 		ctrl.mu.Lock()
 		defer ctrl.mu.Unlock()
 
 		// Remove any ProxyManager lease.
 		ctrl.ProxyManager.RemoveLease(ctrl)
 
-		// Stop any ongoing operations.
+		// Stop anything that started while we were waiting.
 		ctrl.stopTaskLocked()
 
 		// Mark that we're no longer running.
@@ -130,10 +289,31 @@ func (ctrl *Controller) Run(c context.Context) error {
 		ctrl.isRunning = false
 	}()
 
+	// Start our idle blackout watchdog, if configured.
+	if ctrl.IdleBlackoutTimeout > 0 {
+		w := &idleWatchdog{
+			ctrl:    ctrl,
+			timeout: ctrl.IdleBlackoutTimeout,
+		}
+		ctrl.ProxyManager.AddListener(w)
+		defer ctrl.ProxyManager.RemoveListener(w)
+
+		ctrl.mu.Lock()
+		ctrl.idleWatchdog = w
+		ctrl.mu.Unlock()
+
+		go w.run(c)
+	}
+
 	// If we have a default file, begin playback on it.
-	if defaultFileName != "" {
+	switch {
+	case defaultFileName == "":
+		// No default file.
+	case ctrl.SafeMode:
+		logging.S(c).Warnf("Safe mode: not playing default file %q.", defaultFileName)
+	default:
 		logging.S(c).Infof("Playing defualt file %q...", defaultFileName)
-		if err := ctrl.PlayFile(c, defaultFileName); err != nil {
+		if err := ctrl.playFile(c, defaultFileName, web.PlayFileOpts{}, false); err != nil {
 			logging.S(c).Warnf("Failed to play default file %q: %s", defaultFileName, err)
 		}
 	}
@@ -155,7 +335,23 @@ func (ctrl *Controller) HandlePacket(c context.Context, id string, packet []byte
 // Status implements web.ControllerProxy.
 func (ctrl *Controller) Status() web.ControllerStatus {
 	ctrl.mu.Lock()
-	defer ctrl.mu.Unlock()
+	status, measureDisk := ctrl.statusLocked()
+	ctrl.mu.Unlock()
+
+	// Measuring a recording's disk usage walks Storage's temporary directory,
+	// so it is done without holding our lock.
+	if measureDisk {
+		ctrl.fillRecordDiskStatus(status.RecordStatus)
+	}
+	return status
+}
+
+// statusLocked builds the Controller's status. It also returns true if the
+// status describes a recording whose disk usage should be filled in.
+//
+// ctrl.mu must be held.
+func (ctrl *Controller) statusLocked() (web.ControllerStatus, bool) {
+	measureDisk := false
 
 	// Build as much of status as we can without holding a lock.
 	status := web.ControllerStatus{
@@ -163,10 +359,41 @@ func (ctrl *Controller) Status() web.ControllerStatus {
 		Uptime:                   time.Now().Sub(ctrl.startTime),
 		ProxyForwarding:          ctrl.ProxyManager.Forwarding(),
 		DisablingProxyForwarding: ctrl.hasProxyManagerLease,
+		SafeMode:                 ctrl.SafeMode,
+		MaxConcurrentJobs:        ctrl.MaxConcurrentJobs,
+		DiscoveryPaused:          ctrl.discoveryPaused,
+		IdleBlackout:             ctrl.idleWatchdog != nil && ctrl.idleWatchdog.isDark(),
+		PlaybackMaxLagAge:        ctrl.PlaybackMaxLagAge,
+	}
+	status.JobsRunning, status.JobsPending = ctrl.jobs.counts()
+	status.NoRoutePolicy, status.NoRouteCatchAllDevice = ctrl.noRoute.get()
+	if solo := ctrl.playbackFilter.soloDevice(); solo != "" {
+		status.SoloDevice = solo
+		if d := ctrl.lookupDevice(solo); d != nil {
+			status.SoloDevice = ctrl.exposedDeviceID(d, false)
+		}
+	}
+	if ctrl.testPattern != nil {
+		status.TestPattern = ctrl.testPattern.pattern
+	}
+	if ctrl.playlist != nil {
+		status.PlaylistStatus = ctrl.playlist.status()
+	}
+	status.ProxyDevices = len(ctrl.ProxyManager.ProxyDevices())
+	status.MaxProxyDevices = ctrl.MaxProxyDevices
+	if ss := ctrl.snapshotSampler; ss != nil {
+		status.SnapshotBytes, status.SnapshotEvictions = ss.memoryUsage()
+		status.SnapshotMaxBytes = ss.MaxBytes
+	}
+	if !status.ProxyForwarding {
+		status.ForwardingBlockedReason = ctrl.forwardingBlockedReasonLocked()
 	}
 
 	if ctrl.player != nil {
 		if v := ctrl.player.Status(); v != nil {
+			if ctrl.playbackMonitor != nil {
+				v = ctrl.playbackMonitor.fileStatus(v)
+			}
 			status.PlaybackStatus = &web.PlaybackStatus{
 				Name:          filepath.Base(v.Path),
 				Rounds:        v.Rounds,
@@ -174,13 +401,27 @@ func (ctrl *Controller) Status() web.ControllerStatus {
 				Duration:      v.Duration,
 				TotalPlaytime: v.TotalPlaytime,
 				Paused:        v.Paused,
+				InLoopGap:     ctrl.playbackHeld,
+				Rate:          1,
+				MaxLagAge:     ctrl.player.MaxLagAge,
+				NoDevices:     !ctrl.anyDeviceRegisteredLocked(ctrl.playingDeviceIDs),
+				Markers:       ctrl.playingMarkers,
+			}
+			status.PlaybackStatus.CurrentMarker, status.PlaybackStatus.NextMarker = markersAround(ctrl.playingMarkers, v.Position)
+
+			if ctrl.playbackMonitor != nil {
+				status.PlaybackStatus.MaxRounds = ctrl.playbackMonitor.opts.MaxRounds
+				status.PlaybackStatus.Rate = ctrl.playbackMonitor.rate
+				status.PlaybackStatus.Drift = ctrl.playbackMonitor.drift
+				status.PlaybackStatus.NoRouteEvents = append([]web.NoRouteEvent(nil), ctrl.playbackMonitor.noRouteEvents...)
 			}
 
 			status.PlaybackStatus.NoRouteDevices = make([]string, len(v.NoRouteDevices))
 			for i, e := range v.NoRouteDevices {
 				var noRouteStr string
 				if e.Ordinal.IsValid() {
-					noRouteStr = fmt.Sprintf("{%d, %d} %s (%d)", e.Ordinal.Group, e.Ordinal.Controller, e.ID, e.Count)
+					noRouteStr = fmt.Sprintf("%s %s (%d)",
+						web.FormatOrdinal(int(e.Ordinal.Group), int(e.Ordinal.Controller)), e.ID, e.Count)
 				} else {
 					noRouteStr = fmt.Sprintf("%s (%d)", e.ID, e.Count)
 				}
@@ -199,25 +440,214 @@ func (ctrl *Controller) Status() web.ControllerStatus {
 		}
 	}
 
-	if ctrl.recorder != nil {
+	switch {
+	case ctrl.recorderListener != nil && ctrl.recorderListener.armed():
+		status.RecordStatus = &web.RecordStatus{
+			Name:      ctrl.recordingName,
+			StartTime: ctrl.recordingStarted,
+			Note:      ctrl.recordingNote,
+			Armed:     true,
+			Path:      ctrl.Storage.FilePath(ctrl.recordingName),
+		}
+
+	case ctrl.recorder != nil:
+		startTime := ctrl.recordingStarted
+		if rl := ctrl.recorderListener; rl != nil {
+			if t := rl.triggeredAt(); !t.IsZero() {
+				startTime = t
+			}
+		}
+
 		if v := ctrl.recorder.Status(); v != nil {
 			status.RecordStatus = &web.RecordStatus{
-				Name:     filepath.Base(v.Name),
-				Events:   v.Events,
-				Bytes:    v.Bytes,
-				Duration: v.Duration,
+				Name:      filepath.Base(v.Name),
+				StartTime: startTime,
+				Note:      ctrl.recordingNote,
+				Events:    v.Events,
+				Bytes:     v.Bytes,
+				Duration:  v.Duration,
+				Path:      ctrl.Storage.FilePath(ctrl.recordingName),
+			}
+			if rl := ctrl.recorderListener; rl != nil {
+				status.RecordStatus.Deduplicated = rl.deduplicatedPackets()
+				status.RecordStatus.Rejected = rl.rejectedPackets()
+				status.RecordStatus.MaxBytes = rl.limits.maxBytes
+				status.RecordStatus.MaxDuration = rl.limits.maxDuration
+				_, _, status.RecordStatus.Segment = rl.current()
+				status.RecordStatus.Segments = rl.segmentNames()
 			}
 			if v.Error != nil {
 				status.RecordStatus.Error = v.Error.Error()
 			}
+			measureDisk = true
 		} else {
 			// Recorder is not nil, but also not returning a status. Mark that we're
 			// recording.
-			status.RecordStatus = &web.RecordStatus{}
+			status.RecordStatus = &web.RecordStatus{
+				Name:      ctrl.recordingName,
+				StartTime: startTime,
+				Note:      ctrl.recordingNote,
+				Path:      ctrl.Storage.FilePath(ctrl.recordingName),
+			}
 		}
 	}
+	if rs := status.RecordStatus; rs != nil {
+		rs.ProxyForwarding = status.ProxyForwarding
+		rs.ForwardingBlockedReason = status.ForwardingBlockedReason
+	}
 
-	return status
+	if ctrl.lastRecordStatus != nil {
+		rs := *ctrl.lastRecordStatus
+		status.LastRecordStatus = &rs
+	}
+
+	return status, measureDisk
+}
+
+// fillRecordDiskStatus populates rs's disk usage projections.
+//
+// An in-progress recording lives in Storage's temporary directory, so its
+// on-disk size is measured from there. Measurements are shared between status
+// requests for up to recordDiskUsageMaxAge. Failures are logged and leave the
+// affected fields empty.
+func (ctrl *Controller) fillRecordDiskStatus(rs *web.RecordStatus) {
+	du := ctrl.recordDiskUsage.get(ctrl.Storage)
+	if rs.DiskBytes = du.tempBytes; du.tempErr != nil {
+		logging.S(ctrl.ctx).Debugf("Could not measure recording size: %s", du.tempErr)
+		return
+	}
+	if rs.Bytes > 0 {
+		rs.CompressionRatio = float64(rs.DiskBytes) / float64(rs.Bytes)
+	}
+	if rs.Duration > 0 {
+		rs.DiskBytesPerSecond = int64(float64(rs.DiskBytes) / rs.Duration.Seconds())
+	}
+
+	if rs.DiskFreeBytes = du.freeBytes; du.freeErr != nil {
+		logging.S(ctrl.ctx).Debugf("Could not determine free disk space: %s", du.freeErr)
+		return
+	}
+	if rs.DiskBytesPerSecond > 0 {
+		rs.TimeRemaining = time.Duration(float64(rs.DiskFreeBytes) / float64(rs.DiskBytesPerSecond) * float64(time.Second))
+	}
+}
+
+// recordDiskUsageMaxAge is the longest that a measurement of a recording's
+// disk usage is reused for.
+const recordDiskUsageMaxAge = time.Second
+
+// diskUsage is a measurement of Storage's disk usage.
+type diskUsage struct {
+	tempBytes int64
+	tempErr   error
+	freeBytes int64
+	freeErr   error
+}
+
+// diskUsageCache caches measurements of Storage's disk usage, so that frequent
+// status requests don't each walk the temporary directory.
+//
+// It has its own lock, so that measuring doesn't hold the Controller's.
+type diskUsageCache struct {
+	mu       sync.Mutex
+	measured time.Time
+	usage    diskUsage
+}
+
+// get returns a measurement of st's disk usage that is no older than
+// recordDiskUsageMaxAge, taking a new one if necessary.
+func (dc *diskUsageCache) get(st *storage.S) diskUsage {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if now := time.Now(); now.Sub(dc.measured) >= recordDiskUsageMaxAge {
+		var du diskUsage
+		du.tempBytes, du.tempErr = st.TemporaryBytes()
+		du.freeBytes, du.freeErr = st.FreeBytes()
+		dc.usage, dc.measured = du, now
+	}
+	return dc.usage
+}
+
+// Capabilities implements web.ControllerProxy.
+func (ctrl *Controller) Capabilities(c context.Context) *web.Capabilities {
+	ctrl.mu.Lock()
+	sc := ctrl.systemControl
+	ctrl.mu.Unlock()
+
+	format := ctrl.DeviceIDFormat
+	if format == "" {
+		format = DeviceIDFormatRaw
+	}
+
+	return &web.Capabilities{
+		Snapshots:      ctrl.snapshotSampler != nil,
+		SystemControl:  sc != nil && sc.ValidateAccess(c) == nil,
+		IdleBlackout:   ctrl.IdleBlackoutTimeout > 0,
+		SafeMode:       ctrl.SafeMode,
+		DeviceIDFormat: format,
+		TestPatterns:   web.TestPatterns,
+	}
+}
+
+// StorageStatus implements web.ControllerProxy.
+func (ctrl *Controller) StorageStatus(c context.Context) *web.StorageStatus {
+	ss := web.StorageStatus{
+		Root:    ctrl.Storage.Root,
+		TempDir: ctrl.Storage.TempDir(),
+	}
+
+	var err error
+	if ss.FreeBytes, err = ctrl.Storage.FreeBytes(); err != nil {
+		ss.Errors = append(ss.Errors, err.Error())
+	}
+	if ss.TempBytes, err = ctrl.Storage.TemporaryBytes(); err != nil {
+		ss.Errors = append(ss.Errors, err.Error())
+	}
+	if ss.TempFreeBytes, err = ctrl.Storage.TempFreeBytes(); err != nil {
+		ss.Errors = append(ss.Errors, err.Error())
+	}
+	return &ss
+}
+
+// SnapshotMemory implements web.ControllerProxy.
+func (ctrl *Controller) SnapshotMemory(c context.Context) *web.SnapshotMemory {
+	ss := ctrl.snapshotSampler
+	if ss == nil {
+		return nil
+	}
+
+	sm := web.SnapshotMemory{MaxBytes: ss.MaxBytes}
+	sm.Bytes, sm.Evictions = ss.memoryUsage()
+	for _, dm := range ss.deviceMemoryUsage() {
+		id := dm.id
+		if d := ctrl.lookupDevice(id); d != nil {
+			id = ctrl.exposedDeviceID(d, false)
+		}
+		sm.Devices = append(sm.Devices, &web.SnapshotDeviceMemory{
+			ID:        id,
+			Bytes:     dm.bytes,
+			Previewed: dm.previewed,
+			Evicted:   dm.evicted,
+		})
+	}
+	return &sm
+}
+
+// CheckStorage implements web.ControllerProxy.
+func (ctrl *Controller) CheckStorage(c context.Context) (*web.StorageCheck, error) {
+	cr, err := ctrl.Storage.Check(c)
+	if err != nil {
+		return nil, err
+	}
+	return &web.StorageCheck{
+		Root:         cr.Root,
+		Healthy:      cr.Healthy(),
+		Writable:     cr.Writable,
+		MissingDirs:  cr.MissingDirs,
+		InvalidFiles: cr.InvalidFiles,
+		Problems:     cr.Problems,
+	}, nil
 }
 
 // ListFiles implements web.ControllerProxy.
@@ -239,51 +669,87 @@ func (ctrl *Controller) ListFiles(c context.Context) (*web.FileList, error) {
 
 	webFiles := make([]*web.File, len(files))
 	for i, f := range files {
-		var maxStrips, maxPixelsPerStrip int64
-		for _, d := range f.Metadata.Devices {
-			if d.PixelsPerStrip > maxPixelsPerStrip {
-				maxPixelsPerStrip = d.PixelsPerStrip
-			}
-			if v := int64(len(d.Strip)); v > maxStrips {
-				maxStrips = v
-			}
-		}
+		webFiles[i] = webFileFromStorage(f, defaultFileName)
+	}
+	sort.Slice(webFiles, func(i, j int) bool { return webFiles[i].Name < webFiles[j].Name })
+
+	return &web.FileList{
+		DefaultFileName: defaultFileName,
+		Files:           webFiles,
+	}, nil
+}
+
+// DefaultFile implements web.ControllerProxy.
+func (ctrl *Controller) DefaultFile(c context.Context) (*web.File, error) {
+	defaultFileName, err := ctrl.Storage.GetDefault()
+	if err != nil || defaultFileName == "" {
+		return nil, err
+	}
 
-		// Determine compression.
-		comps := make(map[streamfile.Compression]struct{})
-		for _, efi := range f.Metadata.EventFileInfo {
-			comps[efi.Compression] = struct{}{}
+	// The default file may have been deleted out from under us.
+	switch exists, err := ctrl.Storage.HasFile(defaultFileName); {
+	case err != nil:
+		return nil, err
+	case !exists:
+		return nil, nil
+	}
+
+	f, err := ctrl.Storage.GetFile(defaultFileName)
+	if err != nil {
+		return nil, err
+	}
+	return webFileFromStorage(f, defaultFileName), nil
+}
+
+// webFileFromStorage converts a stored File into a web.File.
+func webFileFromStorage(f *storage.File, defaultFileName string) *web.File {
+	var maxStrips, maxPixelsPerStrip int64
+	for _, d := range f.Metadata.Devices {
+		if d.PixelsPerStrip > maxPixelsPerStrip {
+			maxPixelsPerStrip = d.PixelsPerStrip
 		}
-		allComps := make([]string, 0, len(comps))
-		for k := range comps {
-			allComps = append(allComps, k.String())
+		if v := int64(len(d.Strip)); v > maxStrips {
+			maxStrips = v
 		}
-		sort.Strings(allComps)
+	}
 
-		wf := web.File{
-			Name:              f.DisplayName,
-			NumDevices:        len(f.Metadata.Devices),
-			MaxStrips:         int(maxStrips),
-			MaxPixelsPerStrip: int(maxPixelsPerStrip),
-			DiskBytes:         f.Size,
-			NumBytes:          f.Metadata.NumBytes,
-			NumEvents:         f.Metadata.NumEvents,
-			Compression:       strings.Join(allComps, " "),
-			IsDefault:         f.DisplayName == defaultFileName,
-		}
+	// Determine compression.
+	comps := make(map[streamfile.Compression]struct{})
+	for _, efi := range f.Metadata.EventFileInfo {
+		comps[efi.Compression] = struct{}{}
+	}
+	allComps := make([]string, 0, len(comps))
+	for k := range comps {
+		allComps = append(allComps, k.String())
+	}
+	sort.Strings(allComps)
+
+	wf := web.File{
+		Name:              f.DisplayName,
+		NumDevices:        len(f.Metadata.Devices),
+		MaxStrips:         int(maxStrips),
+		MaxPixelsPerStrip: int(maxPixelsPerStrip),
+		DiskBytes:         f.Size,
+		NumBytes:          f.Metadata.NumBytes,
+		NumEvents:         f.Metadata.NumEvents,
+		Compression:       strings.Join(allComps, " "),
+		IsDefault:         f.DisplayName == defaultFileName,
+		Note:              f.Annotations.Note,
+		Protected:         f.Annotations.Protected,
+	}
 
-		wf.Created, _ = ptypes.Timestamp(f.Metadata.Created)
-		wf.Created = wf.Created.Local()
-		wf.Duration, _ = ptypes.Duration(f.Metadata.Duration)
+	if len(f.Annotations.Markers) > 0 {
+		wf.Markers = webMarkersFromStorage(f.Annotations.Markers)
+	}
 
-		webFiles[i] = &wf
+	wf.Created, _ = ptypes.Timestamp(f.Metadata.Created)
+	wf.Created = wf.Created.Local()
+	wf.Duration, _ = ptypes.Duration(f.Metadata.Duration)
+	if secs := wf.Duration.Seconds(); secs > 0 {
+		wf.BytesPerSecond = int64(float64(wf.NumBytes) / secs)
 	}
-	sort.Slice(webFiles, func(i, j int) bool { return webFiles[i].Name < webFiles[j].Name })
 
-	return &web.FileList{
-		DefaultFileName: defaultFileName,
-		Files:           webFiles,
-	}, nil
+	return &wf
 }
 
 // Devices implements web.ControllerProxy.
@@ -292,20 +758,30 @@ func (ctrl *Controller) Devices() []*web.DeviceInfo {
 	proxyDevices := ctrl.ProxyManager.ProxyDevices()
 	allInfo := make([]*web.DeviceInfo, 0, len(discoveredDevices)+len(proxyDevices))
 
+	// Zones are informational here. If they can't be loaded, we return our
+	// devices without them; the error will be reported by the zones API.
+	zones, _ := ctrl.Storage.GetZones()
+
 	commonInfo := func(d device.D, t string) *web.DeviceInfo {
 		dh := d.DiscoveryHeaders()
-		info := d.Info()
+		info := ctrl.counterBaselines.apply(d, d.Info())
 
 		di := web.DeviceInfo{
 			Type:            t,
-			ID:              d.ID(),
+			ID:              ctrl.exposedDeviceID(d, t == "proxy"),
 			BytesReceived:   info.BytesReceived,
 			PacketsReceived: info.PacketsReceived,
 			BytesSent:       info.BytesSent,
 			PacketsSent:     info.PacketsSent,
 			Created:         info.Created,
 			LastObserved:    info.Observed,
-			HasSnapshot:     ctrl.Snapshots != nil && ctrl.Snapshots.HasSnapshotForDevice(d),
+			HasSnapshot:     ctrl.snapshotSampler != nil && ctrl.snapshotSampler.HasSnapshotForDevice(d),
+		}
+		if factor := ctrl.snapshotDownsampling.get(d.ID()); factor > 1 {
+			di.SnapshotDownsample = factor
+		}
+		if di.Zone = zones.ZoneForDevice(di.ID); di.Zone == "" {
+			di.Zone = zones.ZoneForDevice(d.ID())
 		}
 
 		if addr := d.Addr(); addr != nil {
@@ -313,23 +789,43 @@ func (ctrl *Controller) Devices() []*web.DeviceInfo {
 			di.Address = addr.String()
 		}
 
+		di.DeviceType = dh.DeviceType.String()
+		di.Down, di.SendFailures = ctrl.deviceHealth.status(d.ID())
+		di.Muted = ctrl.playbackFilter.isMuted(d.ID())
 		if pp := dh.PixelPusher; pp != nil {
 			di.Strips = int(pp.StripsAttached)
 			di.Pixels = int(pp.PixelsPerStrip)
 			di.Group = int(pp.GroupOrdinal)
 			di.Controller = int(pp.ControllerOrdinal)
+		} else {
+			di.Unsupported = true
 		}
 
 		return &di
 	}
 
-	// Discovered device info.
+	// Discovered device info. Note which discovered devices have proxies.
+	proxied := make(map[string]struct{}, len(proxyDevices))
+	for _, d := range proxyDevices {
+		proxied[d.Proxied().ID()] = struct{}{}
+	}
 	for _, d := range discoveredDevices {
-		allInfo = append(allInfo, commonInfo(d, "discovered"))
+		di := commonInfo(d, "discovered")
+		_, di.Proxied = proxied[d.ID()]
+		if !di.Proxied {
+			di.ProxyError = ctrl.proxyErrors.get(d.ID())
+		}
+		allInfo = append(allInfo, di)
 	}
 	for _, d := range proxyDevices {
 		di := commonInfo(d, "proxy")
-		di.ProxiedID = d.Proxied().ID()
+		proxied := d.Proxied()
+		di.ProxiedID = ctrl.exposedDeviceID(proxied, false)
+		if di.Zone == "" {
+			if di.Zone = zones.ZoneForDevice(di.ProxiedID); di.Zone == "" {
+				di.Zone = zones.ZoneForDevice(proxied.ID())
+			}
+		}
 		allInfo = append(allInfo, di)
 	}
 
@@ -366,135 +862,629 @@ func (ctrl *Controller) Devices() []*web.DeviceInfo {
 }
 
 // RecordFile implements web.ControllerProxy.
-func (ctrl *Controller) RecordFile(c context.Context, name string) error {
+func (ctrl *Controller) RecordFile(c context.Context, name string, opts web.RecordFileOpts) error {
 	logging.S(c).Infof("Begininning recording for: %q", name)
 	if !ctrl.running() {
 		return errNotRunning
 	}
 
+	// Resolve any writer overrides before we disrupt the current operation.
+	cfg, err := ctrl.writerConfig(opts.Compression, opts.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	limits := recordLimits{
+		maxBytes:    ctrl.MaxRecordBytes,
+		maxDuration: ctrl.MaxRecordDuration,
+	}
+	if opts.MaxBytes > 0 {
+		limits.maxBytes = opts.MaxBytes
+	}
+	if opts.MaxDuration > 0 {
+		limits.maxDuration = opts.MaxDuration
+	}
+	segments := recordSegments{
+		maxBytes:    opts.SegmentBytes,
+		maxDuration: opts.SegmentDuration,
+	}
+
 	ctrl.mu.Lock()
 	defer ctrl.mu.Unlock()
 
+	// The Controller may have stopped since we checked; if so, its Context is
+	// gone and we must not start anything.
+	if !ctrl.isRunning {
+		return errNotRunning
+	}
+
 	// Stop the current operation, if one is running.
 	ctrl.stopTaskLocked()
 
+	// If the recording is split into segments, its first file is the first
+	// segment.
+	baseName := name
+	if segments.enabled() {
+		name = storage.SegmentName(baseName, 1)
+	}
+
 	// Open our output file.
-	sw, err := ctrl.Storage.OpenWriter(name)
+	sw, err := ctrl.Storage.OpenWriter(name, cfg)
 	if err != nil {
 		logging.S(c).Errorf("could not open output file %q: %s", name, err)
-		return err
+		return webStorageError(err)
 	}
 
 	// Create a Recorder and have it receive proxied data.
 	ctrl.recorder = &replay.Recorder{}
-	ctrl.recorderListener = proxy.ListenerFunc(func(d device.D, pkt *protocol.Packet, forwarding bool) {
-		switch err := ctrl.recorder.RecordPacket(d, pkt); errors.Cause(err) {
-		case nil:
-
-		case streamfile.ErrEncodingNotSupported:
-			// We are tolerant of unsupported encoding errors.
-			logging.S(c).Warnf("Unsupported encoding for packet from device %q: %s", d.ID(), pkt)
-
-		default:
-			logging.S(c).Warnf("Error recording packet %s for device %q: %s", d.ID(), pkt, err)
-			// Detach our Listener, since there's no point in receiving more packets.
-			ctrl.ProxyManager.RemoveListener(ctrl.recorderListener)
-
-			// Try and stop our recorder. This will provide a more accurate user
-			// experience, since the recorder state will be shown to be stopped.
-			_ = ctrl.Stop(ctrl.ctx)
-		}
-	})
+	ctrl.recorderListener = &recorderListener{
+		ctx:      ctrl.ctx,
+		ctrl:     ctrl,
+		recorder: ctrl.recorder,
+		name:     name,
+		limits:   limits,
+		segments: segments,
+		baseName: baseName,
+		cfg:      cfg,
+		filter: packetFilter{
+			maxBytes: opts.MaxPacketBytes,
+			strict:   opts.StrictPackets,
+		},
+	}
+	if segments.enabled() {
+		ctrl.recorderListener.segment = 1
+	}
+	if opts.Deduplicate {
+		ctrl.recorderListener.dedup = &packetDeduplicator{}
+	}
 	ctrl.recordingName = name
-
-	// Start our recorder. It will take ownership of sw.
-	ctrl.recorder.Start(sw)
+	ctrl.recordingStarted = time.Now()
+	ctrl.recordingNote = opts.Note
+	ctrl.writeRecordAnnotations(c, name, opts.Note, ctrl.recordingStarted)
+
+	// Start our recorder. It will take ownership of sw. If we're armed, the
+	// listener will start it when the first packet arrives.
+	if opts.Armed {
+		logging.S(c).Infof("Arming recording for %q.", name)
+		ctrl.recorderListener.arm(sw)
+	} else {
+		ctrl.recorder.Start(sw)
+	}
 	// Hook our recorder up to our proxy manager so it can record packets that the
-	// proxy receives.
+	// proxy receives. The listener only observes packets; it never takes a
+	// lease, so recording does not interrupt forwarding.
 	ctrl.ProxyManager.AddListener(ctrl.recorderListener)
+	ctrl.notifyStatusChanged()
 	return nil
 }
 
-// MergeFiles implements web.ControllerProxy.
-func (ctrl *Controller) MergeFiles(c context.Context, name string, srcs ...string) error {
-	logging.S(c).Infof("Merging %d file(s) into %q: %v", len(srcs), name, srcs)
-
-	if len(srcs) == 0 {
-		return errors.New("no source files")
+// writeRecordAnnotations records a recording's start time and note alongside
+// the named file. The file's other annotations are kept. Failures are logged.
+func (ctrl *Controller) writeRecordAnnotations(c context.Context, name, note string, started time.Time) {
+	err := ctrl.Storage.UpdateAnnotations(name, func(a *storage.Annotations) error {
+		a.Note = note
+		a.RecordStarted = started
+		return nil
+	})
+	if err != nil {
+		logging.S(c).Warnf("Failed to write annotations for %q: %s", name, err)
 	}
-
-	// Merging is actually independent, so we can do it without stopping any
-	// operations or locking. Of course, it could fail, but...
-	return ctrl.Storage.MergeFiles(name, srcs)
 }
 
-// Stop implements web.ControllerProxy.
-func (ctrl *Controller) Stop(c context.Context) error {
-	if !ctrl.running() {
-		return errNotRunning
+// writerConfig returns a storage writer configuration that uses the named
+// compression scheme and level.
+//
+// If compression is empty, writerConfig returns nil, instructing Storage to
+// use its defaults.
+func (ctrl *Controller) writerConfig(compression string, level int) (*streamfile.EventStreamConfig, error) {
+	if compression == "" {
+		return nil, nil
 	}
 
-	logging.S(c).Info("Received stop command.")
-
-	ctrl.mu.Lock()
-	defer ctrl.mu.Unlock()
+	var comp streamfile.CompressionFlag
+	if err := comp.Set(compression); err != nil {
+		return nil, errors.Wrapf(web.ErrInvalidRequest, "invalid compression %q: %s", compression, err)
+	}
 
-	// Stop the current operation, if one is running.
-	ctrl.stopTaskLocked()
-	return nil
+	cfg := ctrl.Storage.EventStreamConfig()
+	cfg.WriterCompression = comp.Value()
+	cfg.WriterCompressionLevel = level
+	return cfg, nil
 }
 
-// PlayFile implements web.ControllerProxy.
-func (ctrl *Controller) PlayFile(c context.Context, name string) error {
-	logging.S(c).Infof("Playing file: %q", name)
-	if !ctrl.running() {
-		return errNotRunning
+// webStorageError returns err, translated into its web equivalent if it is a
+// storage error that the web interface reports distinctly.
+func webStorageError(err error) error {
+	switch errors.Cause(err) {
+	case storage.ErrNameCollision:
+		return errors.Wrap(web.ErrFileExists, err.Error())
+	case storage.ErrFileProtected:
+		return errors.Wrap(web.ErrFileProtected, err.Error())
+	case storage.ErrInsufficientSpace:
+		return errors.Wrap(web.ErrInsufficientSpace, err.Error())
+	default:
+		return err
 	}
+}
 
-	ctrl.mu.Lock()
-	defer ctrl.mu.Unlock()
+// MergeFiles implements web.ControllerProxy.
+func (ctrl *Controller) MergeFiles(c context.Context, name string, opts web.MergeFilesOpts, srcs ...string) error {
+	logging.S(c).Infof("Merging %d file(s) into %q: %v", len(srcs), name, srcs)
 
-	// Stop any current operation, if one is running.
-	ctrl.stopTaskLocked()
+	if len(srcs) == 0 {
+		return errors.Wrap(web.ErrInvalidRequest, "no source files")
+	}
+	if err := checkMergeOffsets(opts.Offsets, len(srcs)); err != nil {
+		return err
+	}
 
-	sr, err := ctrl.Storage.OpenReader(name)
+	cfg, err := ctrl.writerConfig(opts.Compression, opts.CompressionLevel)
 	if err != nil {
-		logging.S(c).Errorf("Could not open %q for playback: %s", name, err)
 		return err
 	}
 
-	// Create a player and run it.
-	ctrl.player = &replay.Player{
-		SendPacket: func(ord device.Ordinal, id string, pkt *protocol.Packet) error {
-			return ctrl.Router.Route(ord, id, pkt)
-		},
-		PlaybackLeaser: &proxyManagerPlaybackLeaser{ctrl.ProxyManager},
-		MaxLagAge:      ctrl.PlaybackMaxLagAge,
-		Logger:         logging.S(ctrl.ctx),
+	done, err := ctrl.startJob(c)
+	if err != nil {
+		return err
 	}
-	ctrl.playingName = name
+	defer done()
 
-	// Start playback.
-	ctrl.player.Play(ctrl.ctx, sr)
-
-	return nil
-}
+	// Merging is actually independent, so we can do it without stopping any
+	// operations or locking. Of course, it could fail, but...
+	if !opts.Interleave && !hasMergeOffsets(opts.Offsets) {
+		return webStorageError(ctrl.Storage.MergeFiles(name, srcs, cfg))
+	}
 
-// PauseFile implements web.ControllerProxy.
-func (ctrl *Controller) PauseFile(c context.Context) error {
-	logging.S(c).Infof("Pausing file...")
-	if !ctrl.running() {
-		return errNotRunning
+	// Interleaving and offsets both move the sources' events, so place each
+	// source on the merged timeline and rewrite its events there.
+	durations := make([]time.Duration, len(srcs))
+	for i, src := range srcs {
+		f, err := ctrl.Storage.GetFile(src)
+		if err != nil {
+			return errors.Wrapf(err, "loading source %q", src)
+		}
+		durations[i], _ = ptypes.Duration(f.Metadata.Duration)
 	}
+	starts, _ := mergeTimeline(durations, opts)
 
-	ctrl.mu.Lock()
-	defer ctrl.mu.Unlock()
+	sources := make([]storage.MergeSource, len(srcs))
+	for i, src := range srcs {
+		sources[i] = storage.MergeSource{Name: src, Start: starts[i]}
+	}
+	return webStorageError(ctrl.Storage.MergeTimeline(c, name, sources, cfg))
+}
 
-	if ctrl.player != nil {
-		ctrl.player.Pause()
+// checkMergeOffsets returns an error wrapping web.ErrInvalidRequest if offsets
+// are not valid offsets for a merge of n sources.
+func checkMergeOffsets(offsets []time.Duration, n int) error {
+	if len(offsets) != 0 && len(offsets) != n {
+		return errors.Wrapf(web.ErrInvalidRequest, "%d offset(s) for %d source(s)", len(offsets), n)
+	}
+	for i, offset := range offsets {
+		if offset < 0 {
+			return errors.Wrapf(web.ErrInvalidRequest, "source #%d has negative offset %s", i, offset)
+		}
 	}
+	return nil
+}
 
-	// Add an auto-resume listener, if we don't already have one.
+// hasMergeOffsets returns true if any of offsets is non-zero.
+func hasMergeOffsets(offsets []time.Duration) bool {
+	for _, offset := range offsets {
+		if offset != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeTimeline places merge sources with the specified durations on the
+// merged file's timeline, according to opts. It returns the offset at which
+// each source starts, and the duration of the merged file.
+//
+// Normally, sources are concatenated in order, and each source's offset is a
+// gap between the end of the previous source and its start. If opts.Interleave
+// is true, each source starts at its offset from the start of the merged file,
+// so the sources overlap, and the merged file lasts until the last source ends.
+func mergeTimeline(durations []time.Duration, opts web.MergeFilesOpts) ([]time.Duration, time.Duration) {
+	starts := make([]time.Duration, len(durations))
+	var end time.Duration
+	if opts.Interleave {
+		for i, d := range durations {
+			if i < len(opts.Offsets) {
+				starts[i] = opts.Offsets[i]
+			}
+			if e := starts[i] + d; e > end {
+				end = e
+			}
+		}
+		return starts, end
+	}
+
+	for i, d := range durations {
+		if i < len(opts.Offsets) {
+			end += opts.Offsets[i]
+		}
+		starts[i] = end
+		end += d
+	}
+	return starts, end
+}
+
+// PlanMerge implements web.ControllerProxy.
+func (ctrl *Controller) PlanMerge(c context.Context, req *web.MergeRequest) (*web.MergePlan, error) {
+	if !ctrl.running() {
+		return nil, errNotRunning
+	}
+
+	invalid := func(format string, args ...interface{}) error {
+		return errors.Wrapf(web.ErrInvalidRequest, format, args...)
+	}
+
+	if req.Name == "" {
+		return nil, invalid("missing destination name")
+	}
+	if len(req.Sources) == 0 {
+		return nil, invalid("no source files")
+	}
+	if _, err := ctrl.writerConfig(req.Compression, -1); err != nil {
+		return nil, err
+	}
+
+	plan := web.MergePlan{
+		Name:       req.Name,
+		Sources:    make([]string, len(req.Sources)),
+		Interleave: req.Interleave,
+	}
+	durations := make([]time.Duration, len(req.Sources))
+
+	type deviceLayout struct {
+		strips         int
+		pixelsPerStrip int64
+		source         string
+	}
+	devices := make(map[string]deviceLayout)
+	seen := make(map[string]struct{}, len(req.Sources))
+
+	for i, src := range req.Sources {
+		if src == nil || src.Name == "" {
+			return nil, invalid("source #%d has no name", i)
+		}
+		if src.Offset < 0 {
+			return nil, invalid("source %q has negative offset %s", src.Name, src.Offset)
+		}
+		if src.Name == req.Name {
+			return nil, invalid("source %q is also the destination", src.Name)
+		}
+		if _, ok := seen[src.Name]; ok {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("source %q is listed more than once", src.Name))
+		}
+		seen[src.Name] = struct{}{}
+		plan.Sources[i] = src.Name
+
+		f, err := ctrl.Storage.GetFile(src.Name)
+		if err != nil {
+			return nil, invalid("could not load source %q: %s", src.Name, err)
+		}
+
+		durations[i], _ = ptypes.Duration(f.Metadata.Duration)
+		plan.NumEvents += f.Metadata.NumEvents
+		plan.NumBytes += f.Metadata.NumBytes
+		plan.EstimatedSize += f.Size
+
+		for _, d := range f.Metadata.Devices {
+			layout := deviceLayout{len(d.Strip), d.PixelsPerStrip, src.Name}
+			if prev, ok := devices[d.Id]; ok {
+				if prev.strips != layout.strips || prev.pixelsPerStrip != layout.pixelsPerStrip {
+					plan.Warnings = append(plan.Warnings, fmt.Sprintf(
+						"device %q has %d strip(s) x %d pixel(s) in %q, but %d x %d in %q",
+						d.Id, prev.strips, prev.pixelsPerStrip, prev.source,
+						layout.strips, layout.pixelsPerStrip, layout.source))
+				}
+				if req.Interleave && prev.source != layout.source {
+					plan.Warnings = append(plan.Warnings, fmt.Sprintf(
+						"device %q appears in both %q and %q; their frames will be interleaved",
+						d.Id, prev.source, layout.source))
+				}
+				continue
+			}
+			devices[d.Id] = layout
+		}
+	}
+
+	_, plan.Duration = mergeTimeline(durations, req.Opts())
+
+	plan.Devices = make([]string, 0, len(devices))
+	for id := range devices {
+		plan.Devices = append(plan.Devices, id)
+	}
+	sort.Strings(plan.Devices)
+
+	if _, err := ctrl.Storage.GetFile(req.Name); err == nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("destination %q already exists", req.Name))
+	}
+
+	return &plan, nil
+}
+
+// Stop implements web.ControllerProxy.
+func (ctrl *Controller) Stop(c context.Context) error {
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	logging.S(c).Info("Received stop command.")
+
+	// Stop the current operation, if one is running. If this ends a recording
+	// that could not be saved, the caller needs to know.
+	return ctrl.stopTask()
+}
+
+// stopTask stops the current operation, like stopTaskLocked. If that stops a
+// recording, stopTask waits for any segment that it completed to be committed.
+func (ctrl *Controller) stopTask() error {
+	ctrl.mu.Lock()
+	rl := ctrl.recorderListener
+	err := ctrl.stopTaskLocked()
+	ctrl.mu.Unlock()
+
+	if rl != nil {
+		if serr := rl.waitForSegments(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// AbortRecording implements web.ControllerProxy.
+func (ctrl *Controller) AbortRecording(c context.Context) error {
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	if ctrl.recorder == nil {
+		logging.S(c).Info("Received abort recording command, but nothing is recording.")
+		return nil
+	}
+
+	// Capture the name before stopping, since stopping clears it.
+	name := ctrl.recordingName
+	logging.S(c).Infof("Aborting recording %q.", name)
+	if err := ctrl.stopTaskLocked(); err != nil {
+		logging.S(c).Infof("Aborted recording %q was not committed: %s", name, err)
+	}
+
+	// The recording is being discarded deliberately, so it should not be
+	// reported as the last recording.
+	ctrl.lastRecordStatus = nil
+
+	// An armed recording that never started is discarded when it is stopped,
+	// so there may be nothing left to delete.
+	switch exists, err := ctrl.Storage.HasFile(name); {
+	case err != nil:
+		return err
+	case !exists:
+		return nil
+	}
+
+	// The recording is ours to discard, so purge it even if it was somehow
+	// protected. It does not belong in the trash.
+	if err := ctrl.Storage.PurgeFile(name); err != nil {
+		return errors.Wrapf(err, "deleting aborted recording %q", name)
+	}
+	return nil
+}
+
+// PlayFile implements web.ControllerProxy.
+func (ctrl *Controller) PlayFile(c context.Context, name string, opts web.PlayFileOpts) error {
+	return webStorageError(ctrl.playFile(c, name, opts, ctrl.RefuseUnroutablePlayback))
+}
+
+func (ctrl *Controller) playFile(c context.Context, name string, opts web.PlayFileOpts, requireDevices bool) error {
+	logging.S(c).Infof("Playing file: %q", name)
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	// Playing at another rate plays a scaled copy of the file. Writing it reads
+	// the whole file, so it is done before taking the lock.
+	var from playbackStart
+	if rate := playbackRate(opts); rate != 1 {
+		var err error
+		if from.scaled, err = ctrl.Storage.ScaleFile(c, name, rate); err != nil {
+			return err
+		}
+	}
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	// The Controller may have stopped since we checked; if so, its Context is
+	// gone and we must not start anything.
+	if !ctrl.isRunning {
+		ctrl.removeScaledFile(from.scaled)
+		return errNotRunning
+	}
+
+	return ctrl.playFileLocked(c, name, opts, requireDevices, from)
+}
+
+// playbackStart describes how playFileLocked starts playback.
+type playbackStart struct {
+	// offset is the offset in the file at which playback starts.
+	offset time.Duration
+	// rounds is the number of rounds of the file that have already been played.
+	rounds int64
+
+	// scaled, if not nil, is the scaled copy of the file to play at opts'
+	// playback rate. If nil and one is needed, playFileLocked creates it.
+	scaled *storage.ScaledFile
+}
+
+// playFileLocked stops any current operation and begins playback of the named
+// file, starting at from.
+//
+// The file is opened and positioned before the current operation is stopped,
+// so if it can't be played, the current operation continues.
+//
+// ctrl.mu must be held by the caller.
+func (ctrl *Controller) playFileLocked(c context.Context, name string, opts web.PlayFileOpts, requireDevices bool, from playbackStart) error {
+	// A file that is being recorded is only complete once the recording stops.
+	if ctrl.recorder != nil && ctrl.recordingName == name {
+		ctrl.stopTaskLocked()
+	}
+
+	rate := playbackRate(opts)
+	sr, scaled, err := ctrl.openPlaybackLocked(c, name, rate, from.scaled)
+	if err != nil {
+		logging.S(c).Errorf("Could not open %q for playback: %s", name, err)
+		return err
+	}
+
+	// abort closes sr, and removes scaled unless it belongs to the current
+	// playback, when playback can't start.
+	abort := func() {
+		if err := sr.Close(); err != nil {
+			logging.S(c).Warnf("Failed to close reader for %q: %s", name, err)
+		}
+		if scaled != ctrl.playingScaled {
+			ctrl.removeScaledFile(scaled)
+		}
+	}
+
+	// If we're starting part-way through the file, consume the events that
+	// precede the offset. The event at the offset is consumed too, so it is
+	// kept to be replayed once the Player exists.
+	var cue *streamfile.Event
+	if from.offset > 0 {
+		if cue, err = skipToOffset(sr, scalePlaybackOffset(from.offset, rate)); err != nil {
+			abort()
+			return errors.Wrapf(err, "seeking %q to %s", name, from.offset)
+		}
+	}
+
+	// Warn if none of the file's devices are registered, since playback will
+	// not reach anything.
+	var deviceIDs []string
+	if md := sr.Metadata(); md != nil {
+		deviceIDs = make([]string, len(md.Devices))
+		for i, d := range md.Devices {
+			deviceIDs[i] = d.Id
+		}
+	}
+	if !ctrl.anyDeviceRegisteredLocked(deviceIDs) {
+		if requireDevices {
+			abort()
+			return errors.Errorf("none of the devices referenced by %q are registered", name)
+		}
+		logging.S(c).Warnf("None of the devices referenced by %q are registered; playback will not "+
+			"reach any device until they are.", name)
+	}
+
+	// Stop any current operation, if one is running. If we're reusing the
+	// current playback's scaled copy, it must survive.
+	if scaled != nil && scaled == ctrl.playingScaled {
+		ctrl.playingScaled = nil
+	}
+	ctrl.stopTaskLocked()
+	ctrl.playingScaled = scaled
+
+	// Create a player and run it.
+	ctrl.playbackPacer.reset()
+	ctrl.playbackLeaser = &proxyManagerPlaybackLeaser{pm: ctrl.ProxyManager}
+	ctrl.player = &replay.Player{
+		SendPacket: func(ord device.Ordinal, id string, pkt *protocol.Packet) error {
+			if !ctrl.playbackFilter.allows(ctrl.playbackTargetID(ord, id)) || !ctrl.playbackPacer.allows(id, pkt) {
+				return nil
+			}
+			return ctrl.routePlaybackPacket(ord, id, pkt)
+		},
+		PlaybackLeaser: ctrl.playbackLeaser,
+		MaxLagAge:      ctrl.PlaybackMaxLagAge,
+		Logger:         logging.S(ctrl.ctx),
+	}
+	ctrl.playingName = name
+	ctrl.playingDeviceIDs = deviceIDs
+	if a, err := ctrl.Storage.GetAnnotations(name); err == nil {
+		ctrl.playingMarkers = webMarkersFromStorage(a.Markers)
+		sort.SliceStable(ctrl.playingMarkers, func(i, j int) bool {
+			return ctrl.playingMarkers[i].Offset < ctrl.playingMarkers[j].Offset
+		})
+	} else {
+		logging.S(c).Warnf("Failed to load markers for %q: %s", name, err)
+	}
+
+	// Start playback, showing the frame at the offset first.
+	if cue != nil {
+		ctrl.replayCueEventLocked(sr, cue)
+	}
+	ctrl.player.Play(ctrl.ctx, sr)
+
+	ctrl.playbackMonitor = &playbackMonitor{
+		ctrl:       ctrl,
+		player:     ctrl.player,
+		name:       name,
+		opts:       opts,
+		baseRounds: from.rounds,
+		rate:       rate,
+	}
+	ctrl.playbackMonitor.start(ctrl.ctx)
+
+	ctrl.notifyStatusChanged()
+	return nil
+}
+
+// SetPlaybackMaxLagAge implements web.ControllerProxy.
+func (ctrl *Controller) SetPlaybackMaxLagAge(c context.Context, maxLagAge time.Duration) error {
+	if maxLagAge < 0 {
+		return errors.Wrapf(web.ErrInvalidRequest, "max lag age must not be negative (%s)", maxLagAge)
+	}
+
+	logging.S(c).Infof("Setting playback max lag age to %s.", maxLagAge)
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+	ctrl.PlaybackMaxLagAge = maxLagAge
+
+	// A Player's MaxLagAge is fixed when it is created, so restart the current
+	// playback, if any, from its current position in order to apply it.
+	if ctrl.player == nil || ctrl.playbackMonitor == nil || ctrl.player.MaxLagAge == maxLagAge {
+		return nil
+	}
+	var from playbackStart
+	if st := ctrl.player.Status(); st != nil {
+		from.offset = ctrl.playbackMonitor.fileStatus(st).Position
+	}
+	return ctrl.restartPlaybackLocked(c, ctrl.playbackMonitor.opts, from)
+}
+
+// PauseFile implements web.ControllerProxy.
+func (ctrl *Controller) PauseFile(c context.Context) error {
+	logging.S(c).Infof("Pausing file...")
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	ctrl.pauseLocked()
+	return nil
+}
+
+// pauseLocked pauses the current playback, if any, and starts the auto-resume
+// listener.
+//
+// ctrl.mu must be held by the caller.
+func (ctrl *Controller) pauseLocked() {
+	if ctrl.player != nil {
+		ctrl.player.Pause()
+	}
+	ctrl.playbackHeld = false
+
+	// Add an auto-resume listener, if we don't already have one.
 	if ctrl.autoResumeListener == nil && ctrl.AutoResumeDelay > 0 {
 		ctrl.autoResumeListener = &proxy.AutoResumeListener{
 			ProxyManager: ctrl.ProxyManager,
@@ -509,7 +1499,7 @@ func (ctrl *Controller) PauseFile(c context.Context) error {
 		ctrl.autoResumeListener.Start(ctrl.ctx)
 	}
 
-	return nil
+	ctrl.notifyStatusChanged()
 }
 
 // ResumeFile implements web.ControllerProxy.
@@ -522,6 +1512,14 @@ func (ctrl *Controller) ResumeFile(c context.Context) error {
 	ctrl.mu.Lock()
 	defer ctrl.mu.Unlock()
 
+	ctrl.resumeLocked()
+	return nil
+}
+
+// resumeLocked resumes the current playback, if any.
+//
+// ctrl.mu must be held by the caller.
+func (ctrl *Controller) resumeLocked() {
 	// If we have an auto-resume listener, stop it now, since we're manually
 	// resuming.
 	if ctrl.autoResumeListener != nil {
@@ -532,13 +1530,37 @@ func (ctrl *Controller) ResumeFile(c context.Context) error {
 	if ctrl.player != nil {
 		ctrl.player.Resume()
 	}
+	ctrl.playbackHeld = false
 
-	return nil
+	ctrl.notifyStatusChanged()
+}
+
+// TogglePause implements web.ControllerProxy.
+func (ctrl *Controller) TogglePause(c context.Context) (bool, error) {
+	if !ctrl.running() {
+		return false, errNotRunning
+	}
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	if ctrl.player == nil {
+		return false, errors.Wrap(web.ErrInvalidRequest, "nothing is playing")
+	}
+
+	if st := ctrl.player.Status(); st != nil && st.Paused {
+		logging.S(c).Infof("Toggling file: resuming.")
+		ctrl.resumeLocked()
+		return false, nil
+	}
+	logging.S(c).Infof("Toggling file: pausing.")
+	ctrl.pauseLocked()
+	return true, nil
 }
 
 // DeleteFile implements web.ControllerProxy.
-func (ctrl *Controller) DeleteFile(c context.Context, name string) error {
-	logging.S(c).Infof("Deleting file: %q", name)
+func (ctrl *Controller) DeleteFile(c context.Context, name string, force bool) error {
+	logging.S(c).Infof("Deleting file: %q (force=%v)", name, force)
 	if !ctrl.running() {
 		return errNotRunning
 	}
@@ -546,6 +1568,18 @@ func (ctrl *Controller) DeleteFile(c context.Context, name string) error {
 	ctrl.mu.Lock()
 	defer ctrl.mu.Unlock()
 
+	// Check protection before stopping anything, so that a refused deletion
+	// doesn't interrupt playback.
+	if !force {
+		a, err := ctrl.Storage.GetAnnotations(name)
+		if err != nil {
+			return err
+		}
+		if a.Protected {
+			return errors.Wrapf(web.ErrFileProtected, "cannot delete %q", name)
+		}
+	}
+
 	// If we're currently recording or playing this file, stop.
 	if ctrl.recorder != nil && ctrl.recordingName == name {
 		ctrl.stopTaskLocked()
@@ -554,52 +1588,380 @@ func (ctrl *Controller) DeleteFile(c context.Context, name string) error {
 		ctrl.stopTaskLocked()
 	}
 
-	return ctrl.Storage.DeleteFile(name)
+	return webStorageError(ctrl.Storage.DeleteFile(name, force))
 }
 
-// Strips implements web.ControllerProxy.
-func (ctrl *Controller) Strips(c context.Context, deviceName string) ([]web.Strip, error) {
-	if ctrl.Snapshots == nil {
-		return nil, nil
+// ListTrash implements web.ControllerProxy.
+func (ctrl *Controller) ListTrash(c context.Context) ([]*web.TrashedFile, error) {
+	trashed, err := ctrl.Storage.ListTrash(c)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*web.TrashedFile, len(trashed))
+	for i, tf := range trashed {
+		files[i] = &web.TrashedFile{
+			Name:      tf.DisplayName,
+			DiskBytes: tf.Size,
+			Deleted:   tf.Deleted,
+		}
+		if ctrl.Storage.TrashRetention > 0 {
+			files[i].Expires = tf.Deleted.Add(ctrl.Storage.TrashRetention)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Deleted.After(files[j].Deleted) })
+	return files, nil
+}
+
+// RestoreFile implements web.ControllerProxy.
+func (ctrl *Controller) RestoreFile(c context.Context, name string) error {
+	logging.S(c).Infof("Restoring file %q from the trash.", name)
+
+	switch err := ctrl.Storage.RestoreFile(name); errors.Cause(err) {
+	case storage.ErrNotInTrash:
+		return errors.Wrap(web.ErrFileNotFound, err.Error())
+	case storage.ErrNameCollision:
+		return errors.Wrap(web.ErrFileExists, err.Error())
+	default:
+		return err
+	}
+}
+
+// DeleteAllFiles implements web.ControllerProxy.
+func (ctrl *Controller) DeleteAllFiles(c context.Context) (*web.DeleteAllResult, error) {
+	logging.S(c).Infof("Deleting all files.")
+	if !ctrl.running() {
+		return nil, errNotRunning
+	}
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	// Stop the current operation first, so that a recording is committed (and
+	// deleted) and nothing is reading from a file that we delete.
+	if err := ctrl.stopTaskLocked(); err != nil {
+		logging.S(c).Warnf("Failed to cleanly stop current operation: %s", err)
+	}
+
+	defaultFileName, err := ctrl.Storage.GetDefault()
+	if err != nil {
+		return nil, errors.Wrap(err, "loading default file")
+	}
+	files, err := ctrl.Storage.ListFiles(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing files")
 	}
 
-	var d device.D
-	for _, rd := range ctrl.DiscoveryRegistry.Devices() {
-		if rd.ID() == deviceName {
-			d = rd
-			break
+	var result web.DeleteAllResult
+	for _, f := range files {
+		if f.DisplayName == defaultFileName || f.Annotations.Protected {
+			result.Kept = append(result.Kept, f.DisplayName)
+			continue
+		}
+
+		if err := ctrl.Storage.DeleteFile(f.DisplayName, false); err != nil {
+			logging.S(c).Warnf("Failed to delete %q: %s", f.DisplayName, err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", f.DisplayName, err))
+			continue
 		}
+		result.Deleted++
+		result.ReclaimedBytes += f.Size
+	}
+
+	logging.S(c).Infof("Deleted %d file(s), reclaiming %d byte(s); kept %d.",
+		result.Deleted, result.ReclaimedBytes, len(result.Kept))
+	return &result, nil
+}
+
+// SetFileProtected implements web.ControllerProxy.
+func (ctrl *Controller) SetFileProtected(c context.Context, name string, protected bool) error {
+	switch exists, err := ctrl.Storage.HasFile(name); {
+	case err != nil:
+		return err
+	case !exists:
+		return web.ErrFileNotFound
+	}
+
+	logging.S(c).Infof("Setting protection of file %q to %v.", name, protected)
+	return ctrl.Storage.UpdateAnnotations(name, func(a *storage.Annotations) error {
+		a.Protected = protected
+		return nil
+	})
+}
+
+// MigrateFile implements web.ControllerProxy.
+func (ctrl *Controller) MigrateFile(c context.Context, name string) error {
+	logging.S(c).Infof("Migrating file: %q", name)
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	// Wait for a job slot before taking the lock, so that a queued migration
+	// doesn't block the Controller.
+	done, err := ctrl.startJob(c)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	// We can't replace a file that is being recorded or played.
+	if ctrl.recorder != nil && ctrl.recordingName == name {
+		return errors.Errorf("cannot migrate %q while it is being recorded", name)
+	}
+	if ctrl.player != nil && ctrl.playingName == name {
+		return errors.Errorf("cannot migrate %q while it is being played", name)
+	}
+
+	return webStorageError(ctrl.Storage.MigrateFile(name))
+}
+
+// RenameFile implements web.ControllerProxy.
+func (ctrl *Controller) RenameFile(c context.Context, oldName, newName string) error {
+	if strings.TrimSpace(newName) == "" {
+		return errors.Wrap(web.ErrInvalidRequest, "new name must not be empty")
+	}
+	switch exists, err := ctrl.Storage.HasFile(oldName); {
+	case err != nil:
+		return err
+	case !exists:
+		return errors.Wrapf(web.ErrFileNotFound, "cannot rename %q", oldName)
+	}
+
+	logging.S(c).Infof("Renaming file %q to %q.", oldName, newName)
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	// Renaming modifies the file, so it takes a job slot like MigrateFile.
+	done, err := ctrl.startJob(c)
+	if err != nil {
+		return err
 	}
+	defer done()
+
+	if err := ctrl.checkRenameFile(oldName, newName); err != nil {
+		return err
+	}
+	return webStorageError(ctrl.Storage.RenameFile(oldName, newName))
+}
+
+// checkRenameFile returns an error if oldName can't currently be renamed to
+// newName.
+func (ctrl *Controller) checkRenameFile(oldName, newName string) error {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	// We can't move a file that is being recorded or played, or that the
+	// current playlist will play. We also can't take the name being recorded,
+	// since the recording will replace it when it finishes.
+	if ctrl.recorder != nil {
+		switch ctrl.recordingName {
+		case oldName:
+			return errors.Wrapf(web.ErrInvalidRequest, "cannot rename %q while it is being recorded", oldName)
+		case newName:
+			return errors.Wrapf(web.ErrInvalidRequest, "cannot rename to %q while it is being recorded", newName)
+		}
+	}
+	if ctrl.player != nil && ctrl.playingName == oldName {
+		return errors.Wrapf(web.ErrInvalidRequest, "cannot rename %q while it is being played", oldName)
+	}
+	if ctrl.playlist != nil {
+		for _, name := range ctrl.playlist.names {
+			if name == oldName {
+				return errors.Wrapf(web.ErrInvalidRequest, "cannot rename %q while it is in the playlist", oldName)
+			}
+		}
+	}
+	return nil
+}
+
+// OpenFile implements web.ControllerProxy.
+func (ctrl *Controller) OpenFile(c context.Context, name string) (io.ReadCloser, string, error) {
+	switch exists, err := ctrl.Storage.HasFile(name); {
+	case err != nil:
+		return nil, "", err
+	case !exists:
+		return nil, "", web.ErrFileNotFound
+	}
+
+	// A recording replaces its file when it finishes, so refuse rather than
+	// serving the previous content under the name being recorded.
+	ctrl.mu.Lock()
+	recording := ctrl.recorder != nil && ctrl.recordingName == name
+	ctrl.mu.Unlock()
+	if recording {
+		return nil, "", errors.Wrapf(web.ErrInvalidRequest, "%q is being recorded", name)
+	}
+
+	// The archive is downloaded as it is stored, so it is named after the
+	// stored file rather than the name in the request.
+	displayName := strings.TrimSpace(name)
+	if f, err := ctrl.Storage.GetFile(name); err == nil {
+		displayName = f.DisplayName
+	} else {
+		logging.S(c).Warnf("Failed to load %q; naming its download after the request: %s", name, err)
+	}
+
+	logging.S(c).Infof("Opening %q for download.", displayName)
+	rc, err := ctrl.Storage.OpenArchive(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return rc, displayName, nil
+}
+
+// ImportFile implements web.ControllerProxy.
+func (ctrl *Controller) ImportFile(c context.Context, r io.Reader, name string) (string, error) {
+	if !ctrl.running() {
+		return "", errNotRunning
+	}
+
+	done, err := ctrl.startJob(c)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	// Importing never replaces an existing file, but it must not take the name
+	// being recorded, which the recording will replace when it finishes. The
+	// name is chosen and installed with ctrl.mu held, so a recording can't start
+	// in between.
+	stored, err := ctrl.Storage.ImportArchive(r, name, &ctrl.mu, func(name string) bool {
+		return ctrl.recorder != nil && ctrl.Storage.FilePath(name) == ctrl.Storage.FilePath(ctrl.recordingName)
+	})
+	switch errors.Cause(err) {
+	case nil:
+		logging.S(c).Infof("Imported file %q.", stored)
+		return stored, nil
+	case storage.ErrInvalidArchive:
+		return "", errors.Wrap(web.ErrInvalidRequest, err.Error())
+	default:
+		return "", webStorageError(err)
+	}
+}
+
+// CopyFile implements web.ControllerProxy.
+func (ctrl *Controller) CopyFile(c context.Context, src, dest string) error {
+	if strings.TrimSpace(dest) == "" {
+		return errors.Wrap(web.ErrInvalidRequest, "destination name must not be empty")
+	}
+	switch exists, err := ctrl.Storage.HasFile(src); {
+	case err != nil:
+		return err
+	case !exists:
+		return errors.Wrapf(web.ErrFileNotFound, "cannot copy %q", src)
+	}
+
+	logging.S(c).Infof("Copying file %q to %q.", src, dest)
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	// Copying writes a new file, so it takes a job slot like MigrateFile.
+	done, err := ctrl.startJob(c)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	// A file that is being recorded is incomplete, and its name will be replaced
+	// when it finishes. The copy itself can take a while, so it is made without
+	// holding the lock.
+	ctrl.mu.Lock()
+	recordingName := ""
+	if ctrl.recorder != nil {
+		recordingName = ctrl.recordingName
+	}
+	ctrl.mu.Unlock()
+	switch recordingName {
+	case src:
+		return errors.Wrapf(web.ErrInvalidRequest, "cannot copy %q while it is being recorded", src)
+	case dest:
+		return errors.Wrapf(web.ErrInvalidRequest, "cannot copy to %q while it is being recorded", dest)
+	}
+
+	return webStorageError(ctrl.Storage.CopyFile(c, src, dest))
+}
+
+// Strips implements web.ControllerProxy.
+func (ctrl *Controller) Strips(c context.Context, deviceName string, fresh bool) ([]web.Strip, error) {
+	ss := ctrl.snapshotSampler
+	if ss == nil {
+		return nil, nil
+	}
+
+	d := ctrl.lookupDevice(deviceName)
 	if d == nil {
 		logging.S(c).Infof("No device registered for: %q", deviceName)
 		return nil, nil
 	}
 
-	// Get the snapshot for this device.
-	snapshot := ctrl.Snapshots.SnapshotForDevice(d)
-	if snapshot == nil {
-		return nil, nil
+	// Get the snapshot for this device, and convert it into web strips.
+	var strips []web.Strip
+	if snapshot := ss.SnapshotForDevice(d); snapshot != nil {
+		strips = make([]web.Strip, len(snapshot.Strips))
+		for i, strip := range snapshot.Strips {
+			strips[i] = webStripFromState(strip)
+		}
 	}
 
-	// Convert it into a web snapshot.
-	strips := make([]web.Strip, len(snapshot.Strips))
-	for i, strip := range snapshot.Strips {
-		ws := &strips[i]
-		ws.Number = int(strip.StripNumber)
-		ws.Pixels = make([]web.Pixel, 0, strip.Pixels.Len())
+	ss.markPreviewed(d.ID())
+
+	// If a fresh snapshot was requested, replace sampled strips with their
+	// latest observed state.
+	if fresh {
+		strips = ss.latestStrips(d.ID(), strips)
+	}
 
-		for i := 0; i < strip.Pixels.Len(); i++ {
-			pixel := strip.Pixels.Pixel(i)
-			ws.Pixels = append(ws.Pixels, web.Pixel{
-				R: pixel.Red,
-				G: pixel.Green,
-				B: pixel.Blue,
-			})
+	factor := ctrl.snapshotDownsampling.get(d.ID())
+	now := time.Now()
+	for i, ws := range strips {
+		if ctrl.SnapshotStaleThreshold > 0 {
+			if updated := ss.stripUpdated(d.ID(), ws.Number); !updated.IsZero() {
+				ws.Stale = now.Sub(updated) > ctrl.SnapshotStaleThreshold
+			}
 		}
+		strips[i] = downsampleStrip(ws, factor)
 	}
 	return strips, nil
 }
 
+// webStripFromState converts a PixelPusher strip state into a web.Strip.
+func webStripFromState(ss *pixelpusher.StripState) web.Strip {
+	ws := web.Strip{
+		Number: int(ss.StripNumber),
+		Pixels: make([]web.Pixel, 0, ss.Pixels.Len()),
+	}
+	for i := 0; i < ss.Pixels.Len(); i++ {
+		pixel := ss.Pixels.Pixel(i)
+		ws.Pixels = append(ws.Pixels, web.Pixel{
+			R: pixel.Red,
+			G: pixel.Green,
+			B: pixel.Blue,
+		})
+	}
+	return ws
+}
+
+// TestDevice implements web.ControllerProxy.
+func (ctrl *Controller) TestDevice(c context.Context, id string, color web.Pixel) error {
+	logging.S(c).Infof("Sending test frame (%v) to device %q.", color, id)
+
+	d := ctrl.lookupDevice(id)
+	if d == nil {
+		return web.ErrDeviceNotFound
+	}
+
+	return ctrl.sendSolidColor(d, pixel.P{
+		Red:   color.R,
+		Green: color.G,
+		Blue:  color.B,
+	})
+}
+
 // SetDefaultFile implements web.ControllerProxy.
 func (ctrl *Controller) SetDefaultFile(c context.Context, name string) error {
 	if !ctrl.running() {
@@ -634,6 +1996,28 @@ func (ctrl *Controller) SetProxyForwarding(c context.Context, forward bool) erro
 	return nil
 }
 
+// SetDiscoveryBroadcast implements web.ControllerProxy.
+func (ctrl *Controller) SetDiscoveryBroadcast(c context.Context, broadcast bool) error {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	if broadcast {
+		logging.S(c).Infof("Resuming proxy discovery broadcast...")
+	} else {
+		logging.S(c).Infof("Pausing proxy discovery broadcast...")
+	}
+	ctrl.discoveryPaused = !broadcast
+	return nil
+}
+
+// discoveryBroadcastPaused returns true if proxy discovery broadcasts should
+// not be sent.
+func (ctrl *Controller) discoveryBroadcastPaused() bool {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+	return ctrl.discoveryPaused
+}
+
 // SystemState implements web.ControllerProxy.
 func (ctrl *Controller) SystemState(c context.Context) *web.SystemState {
 	if err := ctrl.systemControl.ValidateAccess(c); err != nil {
@@ -662,13 +2046,31 @@ func (ctrl *Controller) Shutdown(c context.Context, restart bool) error {
 }
 
 // stopTaskLocked shuts down the current Recorder, ending its operation.
-func (ctrl *Controller) stopTaskLocked() {
+//
+// If a recording is stopped and could not be committed to storage,
+// stopTaskLocked returns the error.
+func (ctrl *Controller) stopTaskLocked() error {
+	defer ctrl.notifyStatusChanged()
+
+	ctrl.stopTestPatternLocked()
+	ctrl.playlist = nil
+
+	if ctrl.playbackMonitor != nil {
+		ctrl.playbackMonitor.stop()
+		ctrl.playbackMonitor = nil
+	}
 	if ctrl.player != nil {
 		logging.S(ctrl.ctx).Infof("Stopping player.")
 		ctrl.player.Stop()
 		ctrl.player = nil
+		ctrl.playbackLeaser = nil
 		ctrl.playingName = ""
+		ctrl.playingDeviceIDs = nil
+		ctrl.playingMarkers = nil
+		ctrl.playbackHeld = false
 	}
+	ctrl.removeScaledFile(ctrl.playingScaled)
+	ctrl.playingScaled = nil
 
 	if ctrl.autoResumeListener != nil {
 		logging.S(ctrl.ctx).Infof("Stopping auto-resume listener.")
@@ -676,26 +2078,260 @@ func (ctrl *Controller) stopTaskLocked() {
 		ctrl.autoResumeListener = nil
 	}
 
-	if ctrl.recorderListener != nil {
-		ctrl.ProxyManager.RemoveListener(ctrl.recorderListener)
+	recorderStarted := true
+	var limits recordLimits
+	var limitReached string
+	var rejected int64
+	var segments []string
+	rl := ctrl.recorderListener
+	if rl != nil {
+		limits, limitReached = rl.limits, rl.limitReached()
+		rejected = rl.rejectedPackets()
+		segments = rl.segmentNames()
+		ctrl.ProxyManager.RemoveListener(rl)
 		ctrl.recorderListener = nil
+
+		// If the recording is still armed, it never started, so there is nothing
+		// worth keeping.
+		if sw := rl.disarm(); sw != nil {
+			logging.S(ctrl.ctx).Infof("Discarding armed recording %q, which never started.", ctrl.recordingName)
+			if err := sw.Close(); err != nil {
+				logging.S(ctrl.ctx).Warnf("Failed to close armed recording: %s", err)
+			}
+			if err := ctrl.Storage.PurgeFile(ctrl.recordingName); err != nil {
+				logging.S(ctrl.ctx).Warnf("Failed to delete armed recording %q: %s", ctrl.recordingName, err)
+			}
+			recorderStarted = false
+		}
 	}
+	var err error
 	if ctrl.recorder != nil {
-		logging.S(ctrl.ctx).Infof("Stopping recorder.")
-		if err := ctrl.recorder.Stop(); err != nil {
-			logging.S(ctrl.ctx).Warnf("Failed to stop recorder.")
+		if recorderStarted {
+			logging.S(ctrl.ctx).Infof("Stopping recorder.")
+			rs := web.RecordStatus{
+				Name:      ctrl.recordingName,
+				StartTime: ctrl.recordingStarted,
+				Note:      ctrl.recordingNote,
+				Path:      ctrl.Storage.FilePath(ctrl.recordingName),
+				Rejected:  rejected,
+				Segments:  segments,
+
+				MaxBytes:     limits.maxBytes,
+				MaxDuration:  limits.maxDuration,
+				LimitReached: limitReached,
+			}
+
+			// Frames that were skipped as duplicates at the end of the recording
+			// still belong in it.
+			if rl != nil {
+				if _, dedup := rl.currentSegment(); dedup != nil {
+					if err := dedup.recordHeld(ctrl.recorder); err != nil {
+						logging.S(ctrl.ctx).Warnf("Failed to record held frames: %s", err)
+					}
+				}
+			}
+
+			// Stopping the recorder closes its writer, which commits the recording
+			// into storage. If that fails, the recording was not saved.
+			if err = ctrl.recorder.Stop(); err != nil {
+				err = errors.Wrapf(err, "recording %q was not saved", ctrl.recordingName)
+				logging.S(ctrl.ctx).Errorf("Failed to stop recorder: %s", err)
+				rs.Error = err.Error()
+			}
+			if v := ctrl.recorder.Status(); v != nil {
+				rs.Events = v.Events
+				rs.Bytes = v.Bytes
+				rs.Duration = v.Duration
+			}
+			ctrl.lastRecordStatus = &rs
+
+			if rl != nil && rl.segments.enabled() {
+				rl.removeStaleSegments(ctrl.ctx)
+			}
 		}
 
 		ctrl.recorder = nil
 		ctrl.recordingName = ""
+		ctrl.recordingStarted = time.Time{}
+		ctrl.recordingNote = ""
+	}
+	return err
+}
+
+// ForgetDevice implements web.ControllerProxy.
+func (ctrl *Controller) ForgetDevice(c context.Context, id string) error {
+	logging.S(c).Infof("Forgetting device %q.", id)
+
+	d := ctrl.lookupDevice(id)
+	if d == nil {
+		return web.ErrDeviceNotFound
+	}
+
+	rd, ok := d.(*device.Remote)
+	if !ok {
+		return errors.Errorf("device %q is not a discovered device (%T)", id, d)
+	}
+
+	// Marking the device done removes it from the discovery registry and tears
+	// down its proxy, just as if it had expired. If the device is still
+	// broadcasting, it will be rediscovered.
+	rd.MarkDone()
+	return nil
+}
+
+// DeviceHeaders implements web.ControllerProxy.
+func (ctrl *Controller) DeviceHeaders(c context.Context, id string) (interface{}, error) {
+	d := ctrl.lookupDevice(id)
+	if d == nil {
+		return nil, web.ErrDeviceNotFound
 	}
+	return d.DiscoveryHeaders(), nil
+}
+
+// SoloDevice implements web.ControllerProxy.
+func (ctrl *Controller) SoloDevice(c context.Context, id string, blackout bool) error {
+	logging.S(c).Infof("Soloing device %q (blackout=%v).", id, blackout)
+
+	if id == "" {
+		ctrl.playbackFilter.setSolo("")
+		return nil
+	}
+
+	solo := ctrl.lookupDevice(id)
+	if solo == nil {
+		return web.ErrDeviceNotFound
+	}
+	// Playback packets are addressed by devices' own IDs.
+	ctrl.playbackFilter.setSolo(solo.ID())
+
+	if blackout {
+		for _, d := range ctrl.DiscoveryRegistry.Devices() {
+			if d.ID() == solo.ID() {
+				continue
+			}
+			if err := ctrl.sendSolidColor(d, pixel.P{}); err != nil {
+				logging.S(c).Warnf("Failed to black out %q: %s", d.ID(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// SetDeviceMuted implements web.ControllerProxy.
+func (ctrl *Controller) SetDeviceMuted(c context.Context, id string, muted bool) error {
+	logging.S(c).Infof("Setting device %q muted=%v.", id, muted)
+
+	d := ctrl.lookupDevice(id)
+	if d == nil {
+		return web.ErrDeviceNotFound
+	}
+	// Playback packets are addressed by devices' own IDs.
+	ctrl.playbackFilter.setMuted(d.ID(), muted)
+
+	// Black out a newly-muted device, so it doesn't keep showing its last
+	// frame.
+	if muted {
+		if err := ctrl.sendSolidColor(d, pixel.P{}); err != nil {
+			logging.S(c).Warnf("Failed to black out %q: %s", d.ID(), err)
+		}
+	}
+	return nil
+}
+
+// sendSolidColor routes a frame to d which sets all of its pixels to p.
+func (ctrl *Controller) sendSolidColor(d device.D, p pixel.P) error {
+	packets, err := solidColorPackets(d.DiscoveryHeaders(), p)
+	if err != nil {
+		return err
+	}
+
+	for _, pkt := range packets {
+		if err := ctrl.route(device.InvalidOrdinal(), d.ID(), pkt); err != nil {
+			return errors.Wrapf(err, "routing packet to %q", d.ID())
+		}
+	}
+	return nil
+}
+
+// anyDeviceRegisteredLocked returns true if any of the devices in ids is
+// registered. If ids is empty, anyDeviceRegisteredLocked returns true if any
+// device is registered.
+//
+// Devices are matched by ID only.
+func (ctrl *Controller) anyDeviceRegisteredLocked(ids []string) bool {
+	devices := ctrl.DiscoveryRegistry.Devices()
+	if len(ids) == 0 {
+		return len(devices) > 0
+	}
+
+	for _, d := range devices {
+		for _, id := range ids {
+			if d.ID() == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupDevice returns the discovered device with the specified ID, or nil if
+// no such device is registered. id may be the device's own ID or its exposed
+// ID.
+func (ctrl *Controller) lookupDevice(id string) device.D {
+	for _, d := range ctrl.DiscoveryRegistry.Devices() {
+		if ctrl.deviceHasID(d, id) {
+			return d
+		}
+	}
+	return nil
+}
+
+// forwardingBlockedReasonLocked returns a description of why the ProxyManager
+// is not forwarding, based on the leases that the Controller knows about.
+func (ctrl *Controller) forwardingBlockedReasonLocked() string {
+	var reasons []string
+	if ctrl.hasProxyManagerLease {
+		reasons = append(reasons, web.ForwardingBlockedManual)
+	}
+	if ctrl.playbackLeaser != nil && ctrl.playbackLeaser.isHeld() {
+		reasons = append(reasons, web.ForwardingBlockedPlayback)
+	}
+	if ctrl.testPattern != nil {
+		reasons = append(reasons, web.ForwardingBlockedTestPattern)
+	}
+	if len(reasons) == 0 {
+		return web.ForwardingBlockedUnknown
+	}
+	return strings.Join(reasons, ",")
 }
 
 // proxyManagerPlaybackLeaser is a replay.PlaybackLeaser implementation that
 // suppresses the ProxyManager's routing.
 type proxyManagerPlaybackLeaser struct {
 	pm *proxy.Manager
+
+	mu   sync.Mutex
+	held bool
 }
 
-func (l *proxyManagerPlaybackLeaser) AcquirePlaybackLease() { l.pm.AddLease(l) }
-func (l *proxyManagerPlaybackLeaser) ReleasePlaybackLease() { l.pm.RemoveLease(l) }
+func (l *proxyManagerPlaybackLeaser) AcquirePlaybackLease() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pm.AddLease(l)
+	l.held = true
+}
+
+func (l *proxyManagerPlaybackLeaser) ReleasePlaybackLease() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pm.RemoveLease(l)
+	l.held = false
+}
+
+func (l *proxyManagerPlaybackLeaser) isHeld() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.held
+}
@@ -0,0 +1,312 @@
+package pixelproxy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/storage"
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/discovery"
+	"github.com/danjacques/gopushpixels/proxy"
+
+	"github.com/pkg/errors"
+)
+
+// runTestController starts a Controller backed by storage in a temporary
+// directory. It returns the Controller once it is running, and a function that
+// stops it and removes its storage.
+func runTestController(t *testing.T) (*Controller, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "pixelproxy_controller_test")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+
+	c, cancelFunc := context.WithCancel(context.Background())
+	st := &storage.S{Root: dir}
+	if err := st.Prepare(c); err != nil {
+		cancelFunc()
+		_ = os.RemoveAll(dir)
+		t.Fatalf("could not prepare storage: %s", err)
+	}
+
+	var reg device.Registry
+	ctrl := &Controller{
+		Storage:           st,
+		Router:            &device.Router{Registry: &reg},
+		DiscoveryRegistry: &discovery.Registry{DeviceRegistry: &reg},
+		ProxyManager:      &proxy.Manager{},
+		ShutdownFunc:      cancelFunc,
+	}
+
+	doneC := make(chan error)
+	go func() {
+		doneC <- ctrl.Run(c)
+	}()
+	for !ctrl.running() {
+		time.Sleep(time.Millisecond)
+	}
+
+	return ctrl, func() {
+		cancelFunc()
+		if err := <-doneC; err != nil {
+			t.Errorf("Controller.Run returned an error: %s", err)
+		}
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// writeTestFile writes an empty file called name to ctrl's storage.
+func writeTestFile(t *testing.T, ctrl *Controller, name string) {
+	t.Helper()
+
+	sw, err := ctrl.Storage.OpenWriter(name, nil)
+	if err != nil {
+		t.Fatalf("could not open writer for %q: %s", name, err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("could not write %q: %s", name, err)
+	}
+}
+
+// assertIdle asserts that ctrl is neither playing nor recording.
+func assertIdle(t *testing.T, ctrl *Controller) {
+	t.Helper()
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	if ctrl.player != nil || ctrl.playbackMonitor != nil || ctrl.playingName != "" {
+		t.Errorf("Controller is still playing %q", ctrl.playingName)
+	}
+	if ctrl.recorder != nil || ctrl.recorderListener != nil || ctrl.recordingName != "" {
+		t.Errorf("Controller is still recording %q", ctrl.recordingName)
+	}
+}
+
+func TestControllerConcurrentOperations(t *testing.T) {
+	t.Parallel()
+
+	ctrl, cleanup := runTestController(t)
+	defer cleanup()
+
+	const (
+		playName   = "playback"
+		recordName = "recording"
+		iterations = 50
+	)
+	writeTestFile(t, ctrl, playName)
+
+	c := context.Background()
+	ops := []func() error{
+		func() error { return ctrl.PlayFile(c, playName, web.PlayFileOpts{}) },
+		func() error { return ctrl.RecordFile(c, recordName, web.RecordFileOpts{}) },
+		func() error { return ctrl.Stop(c) },
+		func() error { return ctrl.DeleteFile(c, recordName, true) },
+		func() error {
+			_ = ctrl.Status()
+			return nil
+		},
+	}
+
+	// Each operation must either succeed or fail cleanly, no matter what the
+	// others are doing. The race detector checks the rest.
+	var wg sync.WaitGroup
+	errC := make(chan error, len(ops)*iterations)
+	for _, op := range ops {
+		op := op
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				if err := op(); err != nil {
+					errC <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errC)
+
+	// Operations may legitimately fail, e.g. deleting a recording that another
+	// operation already deleted.
+	for err := range errC {
+		t.Logf("Operation failed: %s", err)
+	}
+
+	// Stopping leaves the Controller idle, regardless of what was running.
+	if err := ctrl.Stop(c); err != nil {
+		t.Logf("Final stop reported: %s", err)
+	}
+	assertIdle(t, ctrl)
+
+	// The played file is never deleted, and must have survived.
+	if has, err := ctrl.Storage.HasFile(playName); err != nil || !has {
+		t.Errorf("file %q is missing (err=%v)", playName, err)
+	}
+
+	// The Controller must still work: a fresh recording is saved when stopped.
+	if err := ctrl.RecordFile(c, recordName, web.RecordFileOpts{}); err != nil {
+		t.Fatalf("could not record after concurrent operations: %s", err)
+	}
+	if err := ctrl.Stop(c); err != nil {
+		t.Fatalf("could not stop recording: %s", err)
+	}
+	assertIdle(t, ctrl)
+	if has, err := ctrl.Storage.HasFile(recordName); err != nil || !has {
+		t.Errorf("recording %q was not saved (err=%v)", recordName, err)
+	}
+
+	status := ctrl.Status()
+	if rs := status.LastRecordStatus; rs == nil || rs.Name != recordName || rs.Error != "" {
+		t.Errorf("last record status is %+v, want a successful recording of %q", rs, recordName)
+	}
+}
+
+// assertRecordingForwards asserts that ctrl is recording, and that the proxy
+// is forwarding, both overall and as reported by the recording's status.
+func assertRecordingForwards(t *testing.T, ctrl *Controller) {
+	t.Helper()
+
+	status := ctrl.Status()
+	if !status.ProxyForwarding {
+		t.Errorf("proxy is not forwarding while recording (blocked by %q)", status.ForwardingBlockedReason)
+	}
+	rs := status.RecordStatus
+	if rs == nil {
+		t.Fatalf("no record status while recording")
+	}
+	if !rs.ProxyForwarding || rs.ForwardingBlockedReason != "" {
+		t.Errorf("record status reports forwarding=%v (blocked by %q), want forwarding",
+			rs.ProxyForwarding, rs.ForwardingBlockedReason)
+	}
+}
+
+func TestRecordingKeepsProxyForwarding(t *testing.T) {
+	t.Parallel()
+
+	c := context.Background()
+	for _, tc := range []struct {
+		name string
+		opts web.RecordFileOpts
+	}{
+		{"Immediate", web.RecordFileOpts{}},
+		{"Armed", web.RecordFileOpts{Armed: true}},
+		{"Segmented", web.RecordFileOpts{SegmentBytes: 1024}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl, cleanup := runTestController(t)
+			defer cleanup()
+
+			if !ctrl.Status().ProxyForwarding {
+				t.Fatalf("proxy is not forwarding before recording")
+			}
+
+			if err := ctrl.RecordFile(c, "recording", tc.opts); err != nil {
+				t.Fatalf("could not start recording: %s", err)
+			}
+			assertRecordingForwards(t, ctrl)
+
+			if err := ctrl.Stop(c); err != nil {
+				t.Fatalf("could not stop recording: %s", err)
+			}
+			if !ctrl.Status().ProxyForwarding {
+				t.Errorf("proxy is not forwarding after recording")
+			}
+		})
+	}
+}
+
+func TestRecordingResumesProxyForwardingBlockedByPlayback(t *testing.T) {
+	t.Parallel()
+
+	ctrl, cleanup := runTestController(t)
+	defer cleanup()
+
+	c := context.Background()
+	writeTestFile(t, ctrl, "playback")
+	if err := ctrl.PlayFile(c, "playback", web.PlayFileOpts{}); err != nil {
+		t.Fatalf("could not start playback: %s", err)
+	}
+
+	// Playback blocks forwarding while it holds its lease. The Player acquires
+	// it once it starts sending, so acquire it now rather than racing that.
+	ctrl.mu.Lock()
+	ctrl.playbackLeaser.AcquirePlaybackLease()
+	ctrl.mu.Unlock()
+	if status := ctrl.Status(); status.ProxyForwarding {
+		t.Fatalf("proxy is forwarding during playback")
+	}
+
+	// Recording stops the playback, and with it, the block.
+	if err := ctrl.RecordFile(c, "recording", web.RecordFileOpts{}); err != nil {
+		t.Fatalf("could not start recording: %s", err)
+	}
+	assertRecordingForwards(t, ctrl)
+}
+
+func TestRecordFileRejectsProtectedFile(t *testing.T) {
+	ctrl, stop := runTestController(t)
+	defer stop()
+
+	c := context.Background()
+	writeTestFile(t, ctrl, "show")
+	if err := ctrl.SetFileProtected(c, "show", true); err != nil {
+		t.Fatalf("could not protect file: %s", err)
+	}
+
+	if err := ctrl.RecordFile(c, "show", web.RecordFileOpts{}); errors.Cause(err) != web.ErrFileProtected {
+		t.Fatalf("RecordFile of protected file returned %v, want web.ErrFileProtected", err)
+	}
+	assertIdle(t, ctrl)
+}
+
+func TestRecordingRemovesStaleSegments(t *testing.T) {
+	ctrl, stop := runTestController(t)
+	defer stop()
+
+	c := context.Background()
+	for i := 1; i <= 4; i++ {
+		writeTestFile(t, ctrl, storage.SegmentName("show", i))
+	}
+	if err := ctrl.SetFileProtected(c, storage.SegmentName("show", 4), true); err != nil {
+		t.Fatalf("could not protect segment: %s", err)
+	}
+
+	rl := &recorderListener{ctrl: ctrl, baseName: "show", segment: 2}
+	rl.removeStaleSegments(c)
+
+	for i, want := range []bool{true, true, false, true} {
+		name := storage.SegmentName("show", i+1)
+		if has, err := ctrl.Storage.HasFile(name); err != nil || has != want {
+			t.Errorf("HasFile(%q) = %v, %v; want %v", name, has, err, want)
+		}
+	}
+}
+
+func TestMergeFilesRejectsUnknownCompression(t *testing.T) {
+	ctrl, stop := runTestController(t)
+	defer stop()
+
+	c := context.Background()
+	writeTestFile(t, ctrl, "show")
+
+	opts := web.MergeFilesOpts{Compression: "unknown", CompressionLevel: -1}
+	if err := ctrl.MergeFiles(c, "merged", opts, "show"); errors.Cause(err) != web.ErrInvalidRequest {
+		t.Errorf("MergeFiles with unknown compression returned %v, want web.ErrInvalidRequest", err)
+	}
+	if has, err := ctrl.Storage.HasFile("merged"); err != nil || has {
+		t.Errorf("merged file exists after rejected merge (err=%v)", err)
+	}
+}
@@ -0,0 +1,85 @@
+package pixelproxy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+)
+
+// deviceCounterBaselines holds per-device counter values that are subtracted
+// from the counters that devices report.
+//
+// device.D does not allow its counters to be reset, so resetting a device's
+// counters records its current counters as a baseline instead.
+type deviceCounterBaselines struct {
+	mu        sync.Mutex
+	baselines map[string]device.Info
+}
+
+// reset records d's current counters as its baseline.
+func (b *deviceCounterBaselines) reset(d device.D) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.baselines == nil {
+		b.baselines = make(map[string]device.Info)
+	}
+	b.baselines[d.ID()] = d.Info()
+}
+
+// apply returns info, d's counters, relative to d's baseline.
+//
+// A baseline applies only to the device instance that it was recorded from. If
+// a device with the same ID has since been rediscovered, its counters started
+// over, and its stale baseline is discarded.
+func (b *deviceCounterBaselines) apply(d device.D, info device.Info) device.Info {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	base, ok := b.baselines[d.ID()]
+	if !ok {
+		return info
+	}
+	if !base.Created.Equal(info.Created) {
+		delete(b.baselines, d.ID())
+		return info
+	}
+
+	info.BytesReceived -= base.BytesReceived
+	info.PacketsReceived -= base.PacketsReceived
+	info.BytesSent -= base.BytesSent
+	info.PacketsSent -= base.PacketsSent
+	return info
+}
+
+// ResetDeviceCounters implements web.ControllerProxy.
+func (ctrl *Controller) ResetDeviceCounters(c context.Context, id string) error {
+	found := false
+	reset := func(d device.D, isProxy bool) {
+		if id == "" || d.ID() == id || ctrl.exposedDeviceID(d, isProxy) == id {
+			ctrl.counterBaselines.reset(d)
+			found = true
+		}
+	}
+
+	for _, d := range ctrl.DiscoveryRegistry.Devices() {
+		reset(d, false)
+	}
+	for _, d := range ctrl.ProxyManager.ProxyDevices() {
+		reset(d, true)
+	}
+
+	if id == "" {
+		logging.S(c).Infof("Reset counters for all devices.")
+		return nil
+	}
+	if !found {
+		return web.ErrDeviceNotFound
+	}
+	logging.S(c).Infof("Reset counters for device %q.", id)
+	return nil
+}
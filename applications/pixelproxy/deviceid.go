@@ -0,0 +1,80 @@
+package pixelproxy
+
+import (
+	"fmt"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/protocol/pixelpusher"
+
+	"github.com/pkg/errors"
+)
+
+// Device ID formats, for Controller.DeviceIDFormat.
+const (
+	// DeviceIDFormatRaw exposes each device's own ID.
+	DeviceIDFormatRaw = "raw"
+
+	// DeviceIDFormatOrdinal exposes an ID derived from each device's group and
+	// controller ordinals (e.g., "g1-c2"). Proxy device IDs are prefixed with
+	// "proxy-". Devices that don't advertise ordinals, or that share their
+	// ordinals with another discovered device, keep their own IDs, so that each
+	// exposed ID identifies a single device.
+	//
+	// Ordinals are configured on the device, so these IDs are stable across
+	// restarts and hardware replacement.
+	DeviceIDFormatOrdinal = "ordinal"
+)
+
+// ValidateDeviceIDFormat returns an error if v is not a supported device ID
+// format.
+func ValidateDeviceIDFormat(v string) error {
+	switch v {
+	case DeviceIDFormatRaw, DeviceIDFormatOrdinal:
+		return nil
+	default:
+		return errors.Errorf("unknown device ID format %q (must be one of: %s, %s)",
+			v, DeviceIDFormatRaw, DeviceIDFormatOrdinal)
+	}
+}
+
+// exposedDeviceID returns the ID that d is presented as, according to the
+// Controller's DeviceIDFormat. If isProxy is true, d is a proxy device.
+func (ctrl *Controller) exposedDeviceID(d device.D, isProxy bool) string {
+	if ctrl.DeviceIDFormat != DeviceIDFormatOrdinal {
+		return d.ID()
+	}
+
+	pp := d.DiscoveryHeaders().PixelPusher
+	if pp == nil {
+		return d.ID()
+	}
+
+	if ctrl.devicesWithOrdinals(pp) > 1 {
+		return d.ID()
+	}
+
+	id := fmt.Sprintf("g%d-c%d", pp.GroupOrdinal, pp.ControllerOrdinal)
+	if isProxy {
+		id = "proxy-" + id
+	}
+	return id
+}
+
+// devicesWithOrdinals returns the number of discovered devices that advertise
+// the same group and controller ordinals as pp.
+func (ctrl *Controller) devicesWithOrdinals(pp *pixelpusher.Device) int {
+	count := 0
+	for _, d := range ctrl.DiscoveryRegistry.Devices() {
+		opp := d.DiscoveryHeaders().PixelPusher
+		if opp != nil && opp.GroupOrdinal == pp.GroupOrdinal && opp.ControllerOrdinal == pp.ControllerOrdinal {
+			count++
+		}
+	}
+	return count
+}
+
+// deviceHasID returns true if id identifies the discovered device d, either by
+// its own ID or by its exposed ID.
+func (ctrl *Controller) deviceHasID(d device.D, id string) bool {
+	return d.ID() == id || ctrl.exposedDeviceID(d, false) == id
+}
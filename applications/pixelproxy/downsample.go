@@ -0,0 +1,97 @@
+package pixelproxy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotDownsampling holds per-device snapshot downsampling factors.
+//
+// A device without a factor is previewed at full resolution.
+type snapshotDownsampling struct {
+	mu      sync.Mutex
+	factors map[string]int
+}
+
+// set sets the downsampling factor for the device with the specified ID. A
+// factor <= 1 restores full resolution.
+func (sd *snapshotDownsampling) set(id string, factor int) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if factor <= 1 {
+		delete(sd.factors, id)
+		return
+	}
+	if sd.factors == nil {
+		sd.factors = make(map[string]int)
+	}
+	sd.factors[id] = factor
+}
+
+// get returns the downsampling factor for the device with the specified ID. If
+// the device has no factor, get returns 1.
+func (sd *snapshotDownsampling) get(id string) int {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if factor, ok := sd.factors[id]; ok {
+		return factor
+	}
+	return 1
+}
+
+// downsampleStrip returns a copy of ws in which each consecutive group of
+// factor pixels is replaced by a single pixel, their average color.
+//
+// If the number of pixels is not divisible by factor, the last group is
+// averaged over the remaining pixels.
+func downsampleStrip(ws web.Strip, factor int) web.Strip {
+	if factor <= 1 {
+		return ws
+	}
+
+	ds := ws
+	ds.Pixels = make([]web.Pixel, 0, (len(ws.Pixels)+factor-1)/factor)
+	for i := 0; i < len(ws.Pixels); i += factor {
+		group := ws.Pixels[i:]
+		if len(group) > factor {
+			group = group[:factor]
+		}
+
+		var r, g, b int
+		for _, p := range group {
+			r += int(p.R)
+			g += int(p.G)
+			b += int(p.B)
+		}
+		n := len(group)
+		ds.Pixels = append(ds.Pixels, web.Pixel{
+			R: uint8(r / n),
+			G: uint8(g / n),
+			B: uint8(b / n),
+		})
+	}
+	return ds
+}
+
+// SetSnapshotDownsample implements web.ControllerProxy.
+func (ctrl *Controller) SetSnapshotDownsample(c context.Context, id string, factor int) error {
+	if factor < 1 {
+		return errors.Wrapf(web.ErrInvalidRequest, "downsample factor must be >= 1, got %d", factor)
+	}
+
+	d := ctrl.lookupDevice(id)
+	if d == nil {
+		return web.ErrDeviceNotFound
+	}
+
+	logging.S(c).Infof("Setting snapshot downsample factor for device %q to %d.", id, factor)
+	ctrl.snapshotDownsampling.set(d.ID(), factor)
+	return nil
+}
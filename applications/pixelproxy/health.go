@@ -0,0 +1,150 @@
+package pixelproxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/protocol"
+
+	"github.com/pkg/errors"
+)
+
+// deviceDownRetryInterval is how often a down device that is being skipped is
+// sent a packet anyway, so that it can be observed to recover.
+const deviceDownRetryInterval = time.Second
+
+// errDeviceDown is returned when a packet is not sent to a device because it
+// is down.
+var errDeviceDown = errors.New("device is down")
+
+// deviceHealth tracks consecutive send failures for each device.
+//
+// It is consulted for every routed packet, so it has its own lock rather than
+// using the Controller's.
+type deviceHealth struct {
+	mu      sync.Mutex
+	devices map[string]*deviceHealthState
+}
+
+type deviceHealthState struct {
+	// failures is the number of consecutive failed sends.
+	failures int
+	// down is true if failures has reached the failure threshold.
+	down bool
+	// lastAttempt is the last time that a send to a down device was allowed.
+	lastAttempt time.Time
+}
+
+// shouldSkip returns true if a packet for the device with the specified ID
+// should not be sent because the device is down. One packet is allowed through
+// every deviceDownRetryInterval.
+func (h *deviceHealth) shouldSkip(id string, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st := h.devices[id]
+	if st == nil || !st.down {
+		return false
+	}
+	if now.Sub(st.lastAttempt) < deviceDownRetryInterval {
+		return true
+	}
+	st.lastAttempt = now
+	return false
+}
+
+// recordSuccess records a successful send to the device with the specified
+// ID, clearing its failures. It returns true if the device was down.
+func (h *deviceHealth) recordSuccess(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st := h.devices[id]
+	if st == nil {
+		return false
+	}
+	delete(h.devices, id)
+	return st.down
+}
+
+// recordFailure records a failed send to the device with the specified ID. It
+// returns true if the device has just reached threshold consecutive failures,
+// and is now down.
+func (h *deviceHealth) recordFailure(id string, threshold int, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.devices == nil {
+		h.devices = make(map[string]*deviceHealthState)
+	}
+	st := h.devices[id]
+	if st == nil {
+		st = &deviceHealthState{}
+		h.devices[id] = st
+	}
+	st.failures++
+	if st.down || st.failures < threshold {
+		return false
+	}
+	st.down = true
+	st.lastAttempt = now
+	return true
+}
+
+// status returns whether the device with the specified ID is down, and its
+// number of consecutive failed sends.
+func (h *deviceHealth) status(id string) (down bool, failures int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if st := h.devices[id]; st != nil {
+		return st.down, st.failures
+	}
+	return false, 0
+}
+
+// route routes pkt through the Controller's Router, tracking the health of the
+// device that the Router resolves ord and id to.
+//
+// Health is tracked under the resolved device's own ID, which is how Devices
+// reports it, rather than under id, which may be a playback file's device ID.
+//
+// If DeviceFailureThreshold is >0, a device that fails that many consecutive
+// sends is marked down until a send succeeds. If SkipDownDevices is also true,
+// packets for a down device are not sent, and route returns an error wrapping
+// errDeviceDown.
+//
+// Failures to route to a device that isn't registered are not send failures,
+// and are not counted.
+func (ctrl *Controller) route(ord device.Ordinal, id string, pkt *protocol.Packet) error {
+	if ctrl.DeviceFailureThreshold <= 0 {
+		return ctrl.Router.Route(ord, id, pkt)
+	}
+
+	d := ctrl.Router.Registry.GetUnique(id, ord)
+	if d == nil {
+		// Not registered, so any failure is a routing failure rather than a send
+		// failure.
+		return ctrl.Router.Route(ord, id, pkt)
+	}
+	healthID := d.ID()
+
+	now := time.Now()
+	if ctrl.SkipDownDevices && ctrl.deviceHealth.shouldSkip(healthID, now) {
+		return errors.Wrapf(errDeviceDown, "skipping packet for %q", healthID)
+	}
+
+	err := ctrl.Router.Route(ord, id, pkt)
+	if err == nil {
+		if ctrl.deviceHealth.recordSuccess(healthID) {
+			logging.S(ctrl.ctx).Infof("Device %q has recovered.", healthID)
+		}
+	} else if ctrl.deviceHealth.recordFailure(healthID, ctrl.DeviceFailureThreshold, now) {
+		logging.S(ctrl.ctx).Warnf("Device %q failed %d consecutive sends; marking it down: %s",
+			healthID, ctrl.DeviceFailureThreshold, err)
+	}
+	return err
+}
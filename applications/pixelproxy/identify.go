@@ -0,0 +1,129 @@
+package pixelproxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/pixel"
+	"github.com/danjacques/gopushpixels/protocol/pixelpusher"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// identifyDuration is the length of time that a device blinks when it is
+	// identified.
+	identifyDuration = 3 * time.Second
+	// identifyBlinkPeriod is the amount of time that each identify color is
+	// shown.
+	identifyBlinkPeriod = 250 * time.Millisecond
+)
+
+// identifyColors are the colors that an identified device cycles through.
+var identifyColors = []pixel.P{
+	{Red: 0xFF},
+	{Green: 0xFF},
+	{Blue: 0xFF},
+	{Red: 0xFF, Green: 0xFF, Blue: 0xFF},
+}
+
+// IdentifyDevice implements web.ControllerProxy.
+func (ctrl *Controller) IdentifyDevice(c context.Context, id string) error {
+	d := ctrl.lookupDevice(id)
+	if d == nil {
+		return web.ErrDeviceNotFound
+	}
+
+	ctrl.mu.Lock()
+	ctx := ctrl.ctx
+	ctrl.mu.Unlock()
+	if ctx == nil {
+		return errNotRunning
+	}
+
+	if !ctrl.playbackFilter.hold(d.ID()) {
+		return errors.Wrapf(web.ErrInvalidRequest, "device %q is already being identified", id)
+	}
+
+	logging.S(c).Infof("Identifying device %q.", id)
+	go func() {
+		defer ctrl.playbackFilter.release(d.ID())
+		ctrl.identify(ctx, d)
+	}()
+	return nil
+}
+
+// identify blinks d through identifyColors for identifyDuration, then restores
+// the snapshot that it had before blinking. If d has no snapshot, it is blacked
+// out.
+func (ctrl *Controller) identify(c context.Context, d device.D) {
+	// The blink frames are routed like any other packet, so they are sampled
+	// into d's snapshot. Copy the strips to restore before sending them.
+	strips, err := ctrl.snapshotStrips(d)
+
+	ticker := time.NewTicker(identifyBlinkPeriod)
+	defer ticker.Stop()
+
+	deadline := time.Now().Add(identifyDuration)
+	for i := 0; time.Now().Before(deadline); i++ {
+		if err := ctrl.sendSolidColor(d, identifyColors[i%len(identifyColors)]); err != nil {
+			logging.S(c).Warnf("Failed to send identify frame to %q: %s", d.ID(), err)
+			break
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.Done():
+			return
+		}
+	}
+
+	if err == nil {
+		err = ctrl.restoreStrips(d, strips)
+	}
+	if err != nil {
+		logging.S(c).Debugf("Could not restore snapshot of %q (%s); blacking out.", d.ID(), err)
+		if err := ctrl.sendSolidColor(d, pixel.P{}); err != nil {
+			logging.S(c).Warnf("Failed to black out %q after identifying: %s", d.ID(), err)
+		}
+	}
+}
+
+// snapshotStrips returns a copy of the strips in d's most recent snapshot.
+func (ctrl *Controller) snapshotStrips(d device.D) ([]*pixelpusher.StripState, error) {
+	if ctrl.snapshotSampler == nil {
+		return nil, errors.New("snapshots are disabled")
+	}
+	snapshot := ctrl.snapshotSampler.SnapshotForDevice(d)
+	if snapshot == nil {
+		return nil, errors.New("no snapshot")
+	}
+
+	strips := make([]*pixelpusher.StripState, len(snapshot.Strips))
+	for i, s := range snapshot.Strips {
+		strips[i] = &pixelpusher.StripState{StripNumber: s.StripNumber}
+		strips[i].Pixels.Reset(s.Pixels.Len())
+		for j := 0; j < s.Pixels.Len(); j++ {
+			strips[i].Pixels.SetPixel(j, s.Pixels.Pixel(j))
+		}
+	}
+	return strips, nil
+}
+
+// restoreStrips sends strips, taken from a snapshot of d, back to it.
+func (ctrl *Controller) restoreStrips(d device.D, strips []*pixelpusher.StripState) error {
+	packets, err := stripStatePackets(d.DiscoveryHeaders(), strips)
+	if err != nil {
+		return err
+	}
+	for _, pkt := range packets {
+		if err := ctrl.route(device.InvalidOrdinal(), d.ID(), pkt); err != nil {
+			return errors.Wrapf(err, "routing packet to %q", d.ID())
+		}
+	}
+	return nil
+}
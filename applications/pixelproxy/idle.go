@@ -0,0 +1,98 @@
+package pixelproxy
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/danjacques/pixelproxy/util"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/pixel"
+	"github.com/danjacques/gopushpixels/protocol"
+	"github.com/danjacques/gopushpixels/proxy"
+)
+
+// idleWatchdogInterval is the interval at which an idleWatchdog checks for
+// inactivity.
+const idleWatchdogInterval = time.Second
+
+// idleWatchdog blacks out all devices once nothing has been forwarded or
+// played for a period of time.
+//
+// After blacking out, the devices are left dark until activity resumes.
+//
+// idleWatchdog implements proxy.Listener, and must be registered with the
+// ProxyManager to observe forwarded packets.
+type idleWatchdog struct {
+	ctrl    *Controller
+	timeout time.Duration
+
+	// lastActivity is the time of the last observed activity, in Unix
+	// nanoseconds. It is accessed atomically.
+	lastActivity int64
+	// dark is non-zero if the watchdog has blacked out the devices. It is
+	// accessed atomically.
+	dark int32
+}
+
+var _ proxy.Listener = (*idleWatchdog)(nil)
+
+// ReceivePacket implements proxy.Listener.
+func (w *idleWatchdog) ReceivePacket(d device.D, pkt *protocol.Packet, forwarded bool) {
+	if forwarded {
+		w.touch(time.Now())
+	}
+}
+
+func (w *idleWatchdog) touch(now time.Time) {
+	atomic.StoreInt64(&w.lastActivity, now.UnixNano())
+}
+
+// isDark returns true if the watchdog has blacked out the devices, and no
+// activity has been observed since.
+func (w *idleWatchdog) isDark() bool { return atomic.LoadInt32(&w.dark) != 0 }
+
+// run checks for inactivity until c is cancelled.
+func (w *idleWatchdog) run(c context.Context) {
+	w.touch(time.Now())
+
+	_ = util.LoopUntil(c, idleWatchdogInterval, func(c context.Context) error {
+		now := time.Now()
+		if w.ctrl.active() {
+			w.touch(now)
+		}
+
+		last := time.Unix(0, atomic.LoadInt64(&w.lastActivity))
+		if now.Sub(last) < w.timeout {
+			if atomic.CompareAndSwapInt32(&w.dark, 1, 0) {
+				logging.S(c).Infof("Activity resumed; leaving idle blackout.")
+			}
+			return nil
+		}
+
+		if atomic.CompareAndSwapInt32(&w.dark, 0, 1) {
+			logging.S(c).Infof("No activity for %s; blacking out all devices.", w.timeout)
+			w.ctrl.sendSolidColorToAll(c, pixel.P{})
+		}
+		return nil
+	})
+}
+
+// active returns true if the Controller is currently playing, recording, or
+// generating a test pattern.
+func (ctrl *Controller) active() bool {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	if ctrl.recorder != nil || ctrl.testPattern != nil {
+		return true
+	}
+	if ctrl.player != nil {
+		if st := ctrl.player.Status(); st != nil && !st.Paused {
+			return true
+		}
+	}
+	return false
+}
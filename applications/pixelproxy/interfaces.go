@@ -0,0 +1,48 @@
+package pixelproxy
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// selectInterface returns the first of the named network interfaces that is
+// usable: it exists, is up, and has an IPv4 address.
+//
+// If none of the interfaces are usable, selectInterface returns an error
+// describing why each was rejected.
+func selectInterface(names []string) (string, error) {
+	reasons := make([]string, 0, len(names))
+	for _, name := range names {
+		err := checkInterface(name)
+		if err == nil {
+			return name, nil
+		}
+		reasons = append(reasons, err.Error())
+	}
+	return "", errors.Errorf("no usable interface (%s)", strings.Join(reasons, "; "))
+}
+
+// checkInterface returns nil if the named interface is usable, or an error
+// explaining why it is not.
+func checkInterface(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return errors.Wrapf(err, "%q", name)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return errors.Errorf("%q is down", name)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return errors.Wrapf(err, "%q: could not get addresses", name)
+	}
+	for _, addr := range addrs {
+		if ipn, ok := addr.(*net.IPNet); ok && ipn.IP.To4() != nil {
+			return nil
+		}
+	}
+	return errors.Errorf("%q has no IPv4 address", name)
+}
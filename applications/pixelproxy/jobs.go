@@ -0,0 +1,66 @@
+package pixelproxy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// jobLimiter bounds the number of heavy storage jobs, such as merges and
+// migrations, that run at the same time. Jobs beyond the limit wait until a
+// running job finishes.
+type jobLimiter struct {
+	initOnce sync.Once
+	// slots has one entry per running job. It is nil if jobs are unlimited.
+	slots chan struct{}
+
+	// running and pending are the number of running and waiting jobs. They are
+	// accessed atomically.
+	running int32
+	pending int32
+}
+
+// acquire blocks until a job may run, then returns a function that must be
+// called when the job finishes.
+//
+// The limit is established by the first call to acquire. If it is <= 0, jobs
+// are unlimited.
+//
+// If c is cancelled before the job can run, acquire returns c's error.
+func (jl *jobLimiter) acquire(c context.Context, limit int) (func(), error) {
+	jl.initOnce.Do(func() {
+		if limit > 0 {
+			jl.slots = make(chan struct{}, limit)
+		}
+	})
+
+	if jl.slots != nil {
+		atomic.AddInt32(&jl.pending, 1)
+		select {
+		case jl.slots <- struct{}{}:
+			atomic.AddInt32(&jl.pending, -1)
+		case <-c.Done():
+			atomic.AddInt32(&jl.pending, -1)
+			return nil, c.Err()
+		}
+	}
+
+	atomic.AddInt32(&jl.running, 1)
+	return func() {
+		atomic.AddInt32(&jl.running, -1)
+		if jl.slots != nil {
+			<-jl.slots
+		}
+	}, nil
+}
+
+// counts returns the number of running and pending jobs.
+func (jl *jobLimiter) counts() (running, pending int) {
+	return int(atomic.LoadInt32(&jl.running)), int(atomic.LoadInt32(&jl.pending))
+}
+
+// startJob waits for the Controller's job limiter to allow a job to run. See
+// jobLimiter.acquire.
+func (ctrl *Controller) startJob(c context.Context) (func(), error) {
+	return ctrl.jobs.acquire(c, ctrl.MaxConcurrentJobs)
+}
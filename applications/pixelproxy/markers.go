@@ -0,0 +1,68 @@
+package pixelproxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/storage"
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/pkg/errors"
+)
+
+// webMarkersFromStorage converts stored markers into web markers.
+func webMarkersFromStorage(markers []storage.Marker) []web.Marker {
+	wm := make([]web.Marker, len(markers))
+	for i, m := range markers {
+		wm[i] = web.Marker{
+			Label:  m.Label,
+			Offset: m.Offset,
+		}
+	}
+	return wm
+}
+
+// AddMarker implements web.ControllerProxy.
+func (ctrl *Controller) AddMarker(c context.Context, label string) (*web.Marker, error) {
+	if label == "" {
+		return nil, errors.Wrap(web.ErrInvalidRequest, "a marker must have a label")
+	}
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	switch {
+	case ctrl.recorder == nil:
+		return nil, errors.Wrap(web.ErrInvalidRequest, "not recording")
+	case ctrl.recorderListener != nil && ctrl.recorderListener.armed():
+		return nil, errors.Wrap(web.ErrInvalidRequest, "recording has not started")
+	}
+
+	// The recording's offsets are measured from when it started receiving data,
+	// which for an armed recording is when it was triggered.
+	startTime := ctrl.recordingStarted
+	if rl := ctrl.recorderListener; rl != nil {
+		if t := rl.triggeredAt(); !t.IsZero() {
+			startTime = t
+		}
+	}
+	m := storage.Marker{
+		Label:  label,
+		Offset: time.Since(startTime),
+	}
+
+	logging.S(c).Infof("Adding marker %q to recording %q at %s.", m.Label, ctrl.recordingName, m.Offset)
+	err := ctrl.Storage.UpdateAnnotations(ctrl.recordingName, func(a *storage.Annotations) error {
+		a.Markers = append(a.Markers, m)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "adding marker to %q", ctrl.recordingName)
+	}
+
+	return &web.Marker{
+		Label:  m.Label,
+		Offset: m.Offset,
+	}, nil
+}
@@ -0,0 +1,54 @@
+package pixelproxy
+
+import (
+	"time"
+
+	"github.com/danjacques/gopushpixels/replay"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	playbackProgressRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "playback_progress_ratio",
+		Help: "Position within the currently-playing file, from 0 to 1.",
+	}, []string{"file"})
+
+	playbackRound = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "playback_round",
+		Help: "Number of completed playback rounds of the currently-playing file.",
+	}, []string{"file"})
+
+	playbackDriftSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "playback_drift_seconds",
+		Help: "Amount of time that playback of the currently-playing file is running behind the wall clock.",
+	}, []string{"file"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		playbackProgressRatio,
+		playbackRound,
+		playbackDriftSeconds,
+	)
+}
+
+// updatePlaybackMetrics updates the playback metrics for the named file from
+// st and the measured drift.
+func updatePlaybackMetrics(name string, st *replay.PlayerStatus, drift time.Duration) {
+	ratio := 0.0
+	if st.Duration > 0 && st.Position < st.Duration {
+		ratio = float64(st.Position) / float64(st.Duration)
+	}
+	playbackProgressRatio.WithLabelValues(name).Set(ratio)
+	playbackRound.WithLabelValues(name).Set(float64(st.Rounds))
+	playbackDriftSeconds.WithLabelValues(name).Set(drift.Seconds())
+}
+
+// clearPlaybackMetrics removes the playback metrics for the named file, so that
+// files that are no longer playing don't leave stale series behind.
+func clearPlaybackMetrics(name string) {
+	playbackProgressRatio.DeleteLabelValues(name)
+	playbackRound.DeleteLabelValues(name)
+	playbackDriftSeconds.DeleteLabelValues(name)
+}
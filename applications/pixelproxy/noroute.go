@@ -0,0 +1,163 @@
+package pixelproxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/protocol"
+
+	"github.com/pkg/errors"
+)
+
+// No-route policies, for Controller.NoRoutePolicy. They determine what happens
+// to a playback packet that can't be routed to its device.
+const (
+	// NoRoutePolicyDrop drops the packet. The Player tallies it in its
+	// no-route devices.
+	NoRoutePolicyDrop = "drop"
+
+	// NoRoutePolicyLog drops the packet, logging it. Logging is limited to
+	// one message per device every noRouteLogInterval.
+	NoRoutePolicyLog = "log"
+
+	// NoRoutePolicyCatchAll sends the packet to a configured catch-all device
+	// instead. The packet is still tallied as unroutable.
+	NoRoutePolicyCatchAll = "catchall"
+)
+
+// ValidateNoRoutePolicy returns an error if policy is not a supported no-route
+// policy, or if it requires a catch-all device and catchAll is empty.
+func ValidateNoRoutePolicy(policy, catchAll string) error {
+	switch policy {
+	case NoRoutePolicyDrop, NoRoutePolicyLog:
+		return nil
+	case NoRoutePolicyCatchAll:
+		if catchAll == "" {
+			return errors.Errorf("no-route policy %q requires a catch-all device", policy)
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown no-route policy %q (must be one of: %s, %s, %s)",
+			policy, NoRoutePolicyDrop, NoRoutePolicyLog, NoRoutePolicyCatchAll)
+	}
+}
+
+// noRouteHandler holds the current no-route policy.
+//
+// It is consulted by the Player's SendPacket, so it has its own lock rather
+// than using the Controller's.
+type noRouteHandler struct {
+	mu       sync.RWMutex
+	policy   string
+	catchAll string
+
+	// logMu protects logged.
+	logMu sync.Mutex
+	// logged tracks, for each device, when its dropped packets were last
+	// logged, and how many have been dropped since.
+	logged map[string]*noRouteLogState
+}
+
+// noRouteLogInterval is the minimum amount of time between log messages for a
+// single device's dropped packets.
+const noRouteLogInterval = 5 * time.Second
+
+// noRouteLogState is the logging state of a single device's dropped packets.
+type noRouteLogState struct {
+	last       time.Time
+	suppressed int
+}
+
+// shouldLog accounts for a dropped packet for the device with the specified ID.
+// It returns true if the packet should be logged, along with the number of
+// packets that were dropped without being logged since the last one that was.
+func (h *noRouteHandler) shouldLog(id string, now time.Time) (bool, int) {
+	h.logMu.Lock()
+	defer h.logMu.Unlock()
+
+	st := h.logged[id]
+	if st == nil {
+		if h.logged == nil {
+			h.logged = make(map[string]*noRouteLogState)
+		}
+		st = &noRouteLogState{}
+		h.logged[id] = st
+	} else if now.Sub(st.last) < noRouteLogInterval {
+		st.suppressed++
+		return false, 0
+	}
+
+	suppressed := st.suppressed
+	st.last, st.suppressed = now, 0
+	return true, suppressed
+}
+
+func (h *noRouteHandler) set(policy, catchAll string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.policy, h.catchAll = policy, catchAll
+}
+
+// get returns the current policy and catch-all device. If no policy has been
+// set, get returns NoRoutePolicyDrop.
+func (h *noRouteHandler) get() (policy, catchAll string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.policy == "" {
+		return NoRoutePolicyDrop, ""
+	}
+	return h.policy, h.catchAll
+}
+
+// routePlaybackPacket routes a playback packet, applying the no-route policy if
+// it can't be routed.
+//
+// The routing error is always returned, so that the Player continues to
+// account for unroutable devices regardless of policy.
+func (ctrl *Controller) routePlaybackPacket(ord device.Ordinal, id string, pkt *protocol.Packet) error {
+	err := ctrl.route(ord, id, pkt)
+	if err == nil || errors.Cause(err) == errDeviceDown {
+		// A down device is registered, so its packets are not redirected.
+		return err
+	}
+
+	switch policy, catchAll := ctrl.noRoute.get(); policy {
+	case NoRoutePolicyLog:
+		if ok, suppressed := ctrl.noRoute.shouldLog(id, time.Now()); ok {
+			logging.S(ctrl.ctx).Infof("Dropping playback packet for device %q %v (%d more dropped since last logged): %s",
+				id, ord, suppressed, err)
+		}
+
+	case NoRoutePolicyCatchAll:
+		if catchAll == id {
+			break
+		}
+		if cerr := ctrl.route(device.InvalidOrdinal(), catchAll, pkt); cerr != nil {
+			logging.S(ctrl.ctx).Debugf("Failed to route packet for device %q to catch-all %q: %s",
+				id, catchAll, cerr)
+		}
+	}
+	return err
+}
+
+// SetNoRoutePolicy implements web.ControllerProxy.
+func (ctrl *Controller) SetNoRoutePolicy(c context.Context, policy, catchAll string) error {
+	if err := ValidateNoRoutePolicy(policy, catchAll); err != nil {
+		return errors.Wrap(web.ErrInvalidRequest, err.Error())
+	}
+	if policy != NoRoutePolicyCatchAll {
+		catchAll = ""
+	} else if d := ctrl.lookupDevice(catchAll); d != nil {
+		// Packets are routed by the devices' own IDs.
+		catchAll = d.ID()
+	}
+
+	logging.S(c).Infof("Setting no-route policy to %q (catch-all %q).", policy, catchAll)
+	ctrl.noRoute.set(policy, catchAll)
+	return nil
+}
@@ -0,0 +1,132 @@
+package pixelproxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/storage"
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/protocol"
+
+	"github.com/pkg/errors"
+)
+
+// playbackPacer enforces per-device minimum update periods on playback
+// packets.
+//
+// A device with an update period will not be sent a strip more often than
+// once per period; packets whose strips were all sent more recently are
+// dropped. This keeps recordings captured at a faster rate from overrunning a
+// slower device.
+//
+// It is consulted by the Player's SendPacket on every packet, so it has its own
+// lock rather than using the Controller's.
+type playbackPacer struct {
+	mu      sync.Mutex
+	periods storage.UpdatePeriods
+	// last is when each strip of a device with an update period was last sent.
+	// It is cleared when the periods change and when playback starts, so it
+	// only holds strips of the current playback's devices.
+	last map[snapshotStripKey]time.Time
+}
+
+// setPeriods replaces the pacer's update periods. Strips' send times are
+// forgotten, so that the new periods apply from now.
+func (pp *playbackPacer) setPeriods(periods storage.UpdatePeriods) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.periods = periods
+	pp.last = nil
+}
+
+// reset forgets when strips were last sent.
+func (pp *playbackPacer) reset() {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.last = nil
+}
+
+// allows returns true if pkt should be sent to the device with the specified
+// ID. If so, pkt's strips are marked as sent.
+func (pp *playbackPacer) allows(id string, pkt *protocol.Packet) bool {
+	if pkt.PixelPusher == nil {
+		return true
+	}
+
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	period := pp.periods[id]
+	if period <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	due := false
+	for _, s := range pkt.PixelPusher.StripStates {
+		key := snapshotStripKey{id, int(s.StripNumber)}
+		if last, ok := pp.last[key]; !ok || now.Sub(last) >= period {
+			due = true
+			break
+		}
+	}
+	if !due {
+		return false
+	}
+
+	if pp.last == nil {
+		pp.last = make(map[snapshotStripKey]time.Time)
+	}
+	for _, s := range pkt.PixelPusher.StripStates {
+		pp.last[snapshotStripKey{id, int(s.StripNumber)}] = now
+	}
+	return true
+}
+
+// loadUpdatePeriods loads the persisted device update periods into the
+// Controller's pacer.
+func (ctrl *Controller) loadUpdatePeriods() error {
+	periods, err := ctrl.Storage.GetUpdatePeriods()
+	if err != nil {
+		return err
+	}
+	ctrl.playbackPacer.setPeriods(periods)
+	return nil
+}
+
+// DeviceUpdatePeriods implements web.ControllerProxy.
+func (ctrl *Controller) DeviceUpdatePeriods(c context.Context) (map[string]time.Duration, error) {
+	return ctrl.Storage.GetUpdatePeriods()
+}
+
+// SetDeviceUpdatePeriod implements web.ControllerProxy.
+func (ctrl *Controller) SetDeviceUpdatePeriod(c context.Context, id string, period time.Duration) error {
+	if period < 0 {
+		return errors.Wrapf(web.ErrInvalidRequest, "update period must not be negative, got %s", period)
+	}
+
+	// Playback addresses devices by their own IDs. If the device is registered,
+	// resolve any exposed ID to its own; otherwise, the device may be offline,
+	// and we use the ID as supplied.
+	if d := ctrl.lookupDevice(id); d != nil {
+		id = d.ID()
+	}
+
+	logging.S(c).Infof("Setting playback update period for device %q to %s.", id, period)
+	periods, err := ctrl.Storage.UpdateUpdatePeriods(func(up storage.UpdatePeriods) error {
+		if period == 0 {
+			delete(up, id)
+		} else {
+			up[id] = period
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ctrl.playbackPacer.setPeriods(periods)
+	return nil
+}
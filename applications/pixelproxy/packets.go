@@ -0,0 +1,78 @@
+package pixelproxy
+
+import (
+	"math"
+
+	"github.com/danjacques/gopushpixels/pixel"
+	"github.com/danjacques/gopushpixels/protocol"
+	"github.com/danjacques/gopushpixels/protocol/pixelpusher"
+
+	"github.com/pkg/errors"
+)
+
+// globalBrightnessPacket generates a packet that sets a device's global
+// brightness to level, a percentage between 0 and 100.
+func globalBrightnessPacket(level int) *protocol.Packet {
+	return &protocol.Packet{
+		PixelPusher: &pixelpusher.Packet{
+			Command: &pixelpusher.GlobalBrightnessSetCommand{
+				Parameter: uint16(level * math.MaxUint16 / 100),
+			},
+		},
+	}
+}
+
+// solidColorPackets generates packets that set every pixel on every strip of
+// the device described by dh to p.
+//
+// Strips are grouped into packets according to the device's
+// MaxStripsPerPacket.
+func solidColorPackets(dh *protocol.DiscoveryHeaders, p pixel.P) ([]*protocol.Packet, error) {
+	pp := dh.PixelPusher
+	if pp == nil {
+		return nil, errors.New("device is not a PixelPusher")
+	}
+
+	states := make([]*pixelpusher.StripState, pp.StripsAttached)
+	for i := range states {
+		ss := pixelpusher.StripState{
+			StripNumber: pixelpusher.StripNumber(i),
+		}
+		ss.Pixels.Reset(int(pp.PixelsPerStrip))
+		for j := 0; j < ss.Pixels.Len(); j++ {
+			ss.Pixels.SetPixel(j, p)
+		}
+		states[i] = &ss
+	}
+	return stripStatePackets(dh, states)
+}
+
+// stripStatePackets generates packets that send states to the device
+// described by dh.
+//
+// Strips are grouped into packets according to the device's
+// MaxStripsPerPacket.
+func stripStatePackets(dh *protocol.DiscoveryHeaders, states []*pixelpusher.StripState) ([]*protocol.Packet, error) {
+	pp := dh.PixelPusher
+	if pp == nil {
+		return nil, errors.New("device is not a PixelPusher")
+	}
+
+	stripsPerPacket := int(pp.MaxStripsPerPacket)
+	if stripsPerPacket <= 0 {
+		stripsPerPacket = 1
+	}
+
+	var (
+		packets []*protocol.Packet
+		current *pixelpusher.Packet
+	)
+	for _, ss := range states {
+		if current == nil || len(current.StripStates) >= stripsPerPacket {
+			current = &pixelpusher.Packet{}
+			packets = append(packets, &protocol.Packet{PixelPusher: current})
+		}
+		current.StripStates = append(current.StripStates, ss)
+	}
+	return packets, nil
+}
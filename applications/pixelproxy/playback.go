@@ -0,0 +1,364 @@
+package pixelproxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/pixel"
+	"github.com/danjacques/gopushpixels/replay"
+)
+
+// playbackMonitorInterval is the interval at which a playbackMonitor polls its
+// Player's status.
+const playbackMonitorInterval = 25 * time.Millisecond
+
+// playbackMonitor watches a Player's status and applies Controller-level
+// playback behavior that the Player doesn't implement itself.
+//
+// A playbackMonitor acts on its Controller only while its Player is still the
+// Controller's current Player, so a stale monitor is harmless.
+type playbackMonitor struct {
+	ctrl   *Controller
+	player *replay.Player
+	name   string
+	opts   web.PlayFileOpts
+
+	// baseRounds is the number of rounds that were completed before player
+	// started, when playback was restarted by a seek. It counts towards
+	// opts.MaxRounds.
+	baseRounds int64
+	// rate is the rate at which player plays the file. If it is not 1, player
+	// plays a scaled copy of the file.
+	rate float64
+
+	cancelFunc context.CancelFunc
+
+	// drift is the most recently measured playback drift. It is protected by
+	// the Controller's lock.
+	drift time.Duration
+
+	// noRouteEvents records when each unroutable device was first observed in
+	// the Player's status. It is protected by the Controller's lock.
+	noRouteEvents []web.NoRouteEvent
+}
+
+func (m *playbackMonitor) start(c context.Context) {
+	c, m.cancelFunc = context.WithCancel(c)
+	go m.run(c)
+}
+
+// stop stops the monitor and clears its playback metrics. It does not block,
+// and must be called while holding the Controller's lock.
+func (m *playbackMonitor) stop() {
+	m.cancelFunc()
+	clearPlaybackMetrics(m.name)
+}
+
+func (m *playbackMonitor) run(c context.Context) {
+	ticker := time.NewTicker(playbackMonitorInterval)
+	defer ticker.Stop()
+
+	var (
+		rounds = m.baseRounds
+		dt     playbackDriftTracker
+	)
+	for {
+		select {
+		case <-c.Done():
+			return
+		case <-ticker.C:
+		}
+
+		st := m.player.Status()
+		if st == nil {
+			continue
+		}
+
+		// Drift is measured against the Player's own timeline, which runs at
+		// the playback rate; everything else is in terms of the file.
+		now := time.Now()
+		drift := dt.sample(now, st)
+		st = m.fileStatus(st)
+
+		// Metrics are updated under the Controller's lock so that they can't
+		// race with stop() clearing them.
+		m.withCurrentPlayer(func() {
+			m.drift = drift
+			m.recordNoRouteEvents(now, st)
+			updatePlaybackMetrics(m.name, st, drift)
+		})
+
+		if st.Rounds > rounds {
+			rounds = st.Rounds
+			if m.opts.MaxRounds > 0 && rounds >= m.opts.MaxRounds {
+				m.finish(c)
+				return
+			}
+			if m.opts.LoopGap > 0 {
+				m.holdLoopGap(c)
+			}
+		}
+	}
+}
+
+// fileStatus returns st, a status of the monitor's Player, in terms of the
+// file being played: the rounds that were completed before the Player started
+// are added to its Rounds, and its Position and Duration are scaled from the
+// Player's timeline to the file's.
+func (m *playbackMonitor) fileStatus(st *replay.PlayerStatus) *replay.PlayerStatus {
+	if m.baseRounds == 0 && m.rate == 1 {
+		return st
+	}
+	fst := *st
+	fst.Rounds += m.baseRounds
+	fst.Position = time.Duration(float64(st.Position) * m.rate)
+	fst.Duration = time.Duration(float64(st.Duration) * m.rate)
+	return &fst
+}
+
+// finish stops playback once it has completed its configured rounds.
+func (m *playbackMonitor) finish(c context.Context) {
+	m.withCurrentPlayer(func() {
+		if m.ctrl.playlist != nil {
+			logging.S(c).Infof("Playback of %q completed %d round(s).", m.name, m.opts.MaxRounds)
+			m.ctrl.advancePlaylistLocked(c)
+			return
+		}
+
+		logging.S(c).Infof("Playback of %q completed %d round(s); stopping.", m.name, m.opts.MaxRounds)
+		if err := m.ctrl.stopTaskLocked(); err != nil {
+			logging.S(c).Warnf("Error stopping playback: %s", err)
+		}
+	})
+}
+
+// recordNoRouteEvents records a NoRouteEvent for each of st's no-route devices
+// that has not been seen before. Events are timestamped at now, so they are
+// accurate to within playbackMonitorInterval.
+//
+// It must be called while holding the Controller's lock.
+func (m *playbackMonitor) recordNoRouteEvents(now time.Time, st *replay.PlayerStatus) {
+	for _, e := range st.NoRouteDevices {
+		var ordinal string
+		if e.Ordinal.IsValid() {
+			ordinal = web.FormatOrdinal(int(e.Ordinal.Group), int(e.Ordinal.Controller))
+		}
+		if m.hasNoRouteEvent(e.ID, ordinal) {
+			continue
+		}
+
+		m.noRouteEvents = append(m.noRouteEvents, web.NoRouteEvent{
+			Device:    e.ID,
+			Ordinal:   ordinal,
+			FirstSeen: now,
+			Position:  st.Position,
+			Round:     st.Rounds,
+		})
+	}
+}
+
+func (m *playbackMonitor) hasNoRouteEvent(id, ordinal string) bool {
+	for _, e := range m.noRouteEvents {
+		if e.Device == id && e.Ordinal == ordinal {
+			return true
+		}
+	}
+	return false
+}
+
+// holdLoopGap pauses the Player for the configured loop gap, then resumes it.
+//
+// If playback is paused or resumed by someone else during the gap, the
+// monitor will not resume it.
+func (m *playbackMonitor) holdLoopGap(c context.Context) {
+	held := m.withCurrentPlayer(func() {
+		m.player.Pause()
+		m.ctrl.playbackHeld = true
+	})
+	if !held {
+		return
+	}
+
+	if m.opts.LoopGapBlackout {
+		m.ctrl.sendSolidColorToAll(c, pixel.P{})
+	}
+
+	t := time.NewTimer(m.opts.LoopGap)
+	defer t.Stop()
+	select {
+	case <-c.Done():
+		return
+	case <-t.C:
+	}
+
+	m.withCurrentPlayer(func() {
+		if m.ctrl.playbackHeld {
+			m.ctrl.playbackHeld = false
+			m.player.Resume()
+		}
+	})
+}
+
+// playbackDriftTracker measures the difference between the wall-clock time that
+// a Player has spent playing and the amount of file time that it has played.
+//
+// Time is only counted between consecutive samples in which the Player was not
+// paused, so the measurement is accurate to within the sampling interval for
+// each pause and resume.
+type playbackDriftTracker struct {
+	started     bool
+	lastSample  time.Time
+	lastPlaying bool
+
+	// wall is the wall-clock time spent playing since the first sample.
+	wall time.Duration
+	// baseline is the file time that had been played at the first sample.
+	baseline time.Duration
+}
+
+// sample records the Player status st, observed at now, and returns the
+// current drift. A positive drift means that playback is running behind the
+// wall clock.
+func (dt *playbackDriftTracker) sample(now time.Time, st *replay.PlayerStatus) time.Duration {
+	played := time.Duration(st.Rounds)*st.Duration + st.Position
+	playing := !st.Paused
+
+	if !dt.started {
+		dt.started = true
+		dt.baseline = played
+	} else if dt.lastPlaying && playing {
+		dt.wall += now.Sub(dt.lastSample)
+	}
+	dt.lastSample, dt.lastPlaying = now, playing
+
+	return dt.wall - (played - dt.baseline)
+}
+
+// withCurrentPlayer calls fn while holding the Controller's lock, if the
+// monitor's Player is still the Controller's current Player. It returns true
+// if fn was called.
+func (m *playbackMonitor) withCurrentPlayer(fn func()) bool {
+	m.ctrl.mu.Lock()
+	defer m.ctrl.mu.Unlock()
+
+	if m.ctrl.player != m.player {
+		return false
+	}
+	fn()
+	return true
+}
+
+// sendSolidColorToAll sends a frame setting every pixel on every discovered
+// device to p. Failures are logged, but otherwise ignored.
+func (ctrl *Controller) sendSolidColorToAll(c context.Context, p pixel.P) {
+	for _, d := range ctrl.DiscoveryRegistry.Devices() {
+		if err := ctrl.sendSolidColor(d, p); err != nil {
+			logging.S(c).Warnf("Failed to send solid color to %q: %s", d.ID(), err)
+		}
+	}
+}
+
+// playbackTargetID returns the ID of the registered device that playback
+// packets for the file's device with the specified ordinal and ID are routed to.
+// If there is no such device, id is returned.
+//
+// A file's device IDs need not match the devices' own IDs, which are what the
+// playbackFilter is keyed by.
+func (ctrl *Controller) playbackTargetID(ord device.Ordinal, id string) string {
+	if d := ctrl.Router.Registry.GetUnique(id, ord); d != nil {
+		return d.ID()
+	}
+	return id
+}
+
+// playbackFilter decides which devices receive playback packets.
+//
+// It is consulted by the Player's SendPacket on every packet, so it has its own
+// lock rather than using the Controller's.
+type playbackFilter struct {
+	mu   sync.RWMutex
+	solo string
+
+	// held is the set of device IDs whose playback output is temporarily
+	// overridden (e.g., while identifying).
+	held map[string]struct{}
+	// muted is the set of device IDs that have been muted by the user.
+	muted map[string]struct{}
+}
+
+// allows returns true if playback packets should be sent to the device with
+// the specified ID.
+func (pf *playbackFilter) allows(id string) bool {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	if _, ok := pf.held[id]; ok {
+		return false
+	}
+	if _, ok := pf.muted[id]; ok {
+		return false
+	}
+	return pf.solo == "" || pf.solo == id
+}
+
+// hold withholds playback packets from the device with the specified ID until
+// release is called. It returns false if the device is already held.
+func (pf *playbackFilter) hold(id string) bool {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	if _, ok := pf.held[id]; ok {
+		return false
+	}
+	if pf.held == nil {
+		pf.held = make(map[string]struct{})
+	}
+	pf.held[id] = struct{}{}
+	return true
+}
+
+// release resumes playback packets to a device that was held.
+func (pf *playbackFilter) release(id string) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	delete(pf.held, id)
+}
+
+// setSolo sets the soloed device ID. If id is empty, solo is cleared.
+func (pf *playbackFilter) setSolo(id string) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	pf.solo = id
+}
+
+func (pf *playbackFilter) soloDevice() string {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	return pf.solo
+}
+
+// setMuted mutes or unmutes the device with the specified ID. Unlike hold,
+// muting is idempotent.
+func (pf *playbackFilter) setMuted(id string, muted bool) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	if !muted {
+		delete(pf.muted, id)
+		return
+	}
+	if pf.muted == nil {
+		pf.muted = make(map[string]struct{})
+	}
+	pf.muted[id] = struct{}{}
+}
+
+// isMuted returns true if the device with the specified ID is muted.
+func (pf *playbackFilter) isMuted(id string) bool {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	_, ok := pf.muted[id]
+	return ok
+}
@@ -0,0 +1,124 @@
+package pixelproxy
+
+import (
+	"context"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/pkg/errors"
+)
+
+// playlist is a sequence of files that are played one after another.
+//
+// Each file plays with its own options, for a limited number of rounds. When
+// it finishes, its playbackMonitor advances the Controller to the next file.
+type playlist struct {
+	// names are the files to play, and opts[i] are the options that names[i]
+	// is played with.
+	names []string
+	opts  []web.PlayFileOpts
+	index int
+	loop  bool
+}
+
+// status returns the web representation of the playlist.
+func (pl *playlist) status() *web.PlaylistStatus {
+	return &web.PlaylistStatus{
+		Files:     append([]string(nil), pl.names...),
+		Index:     pl.index,
+		Remaining: append([]string(nil), pl.names[pl.index+1:]...),
+		Loop:      pl.loop,
+	}
+}
+
+// PlayPlaylist implements web.ControllerProxy.
+func (ctrl *Controller) PlayPlaylist(c context.Context, entries []web.PlaylistEntry, loop bool) error {
+	if len(entries) == 0 {
+		return errors.Wrap(web.ErrInvalidRequest, "playlist is empty")
+	}
+
+	pl := playlist{
+		names: make([]string, len(entries)),
+		opts:  make([]web.PlayFileOpts, len(entries)),
+		loop:  loop,
+	}
+	for i := range entries {
+		e := &entries[i]
+		if e.LoopGap < 0 || e.Rounds < 0 || e.Rate < 0 {
+			return errors.Wrapf(web.ErrInvalidRequest, "invalid options for playlist entry #%d (%q)", i, e.Name)
+		}
+		switch exists, err := ctrl.Storage.HasFile(e.Name); {
+		case err != nil:
+			return err
+		case !exists:
+			return errors.Wrapf(web.ErrFileNotFound, "playlist file %q", e.Name)
+		}
+		pl.names[i], pl.opts[i] = e.Name, e.Opts()
+	}
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	logging.S(c).Infof("Playing playlist of %d file(s) (loop=%v): %q", len(pl.names), loop, pl.names)
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	// The Controller may have stopped since we checked; if so, its Context is
+	// gone and we must not start anything.
+	if !ctrl.isRunning {
+		return errNotRunning
+	}
+
+	return webStorageError(ctrl.playPlaylistEntryLocked(c, &pl, ctrl.RefuseUnroutablePlayback))
+}
+
+// playPlaylistEntryLocked begins playback of pl's current entry, and makes pl
+// the Controller's playlist.
+//
+// Starting playback stops the current operation, which clears any existing
+// playlist, so pl is installed afterwards.
+//
+// ctrl.mu must be held by the caller.
+func (ctrl *Controller) playPlaylistEntryLocked(c context.Context, pl *playlist, requireDevices bool) error {
+	if err := ctrl.playFileLocked(c, pl.names[pl.index], pl.opts[pl.index], requireDevices, playbackStart{}); err != nil {
+		return err
+	}
+	ctrl.playlist = pl
+	return nil
+}
+
+// advancePlaylistLocked moves on to the next entry of the current playlist. If
+// there are no more entries, and the playlist doesn't loop, the playlist ends
+// and the Controller is stopped.
+//
+// Entries that fail to play are skipped. If no entry can be played, the
+// playlist ends.
+//
+// ctrl.mu must be held by the caller.
+func (ctrl *Controller) advancePlaylistLocked(c context.Context) {
+	pl := ctrl.playlist
+	for attempt := 0; attempt < len(pl.names); attempt++ {
+		pl.index++
+		if pl.index >= len(pl.names) {
+			if !pl.loop {
+				break
+			}
+			pl.index = 0
+		}
+
+		name := pl.names[pl.index]
+		logging.S(c).Infof("Advancing playlist to #%d: %q", pl.index, name)
+		err := ctrl.playPlaylistEntryLocked(c, pl, false)
+		if err == nil {
+			return
+		}
+		logging.S(c).Warnf("Failed to play playlist entry #%d (%q); skipping: %s", pl.index, name, err)
+	}
+
+	logging.S(c).Infof("Playlist finished; stopping.")
+	if err := ctrl.stopTaskLocked(); err != nil {
+		logging.S(c).Warnf("Error stopping playback: %s", err)
+	}
+}
@@ -0,0 +1,53 @@
+package pixelproxy
+
+import (
+	"sync"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/proxy"
+)
+
+// hasProxyFor returns true if pm has a proxy device for d.
+func hasProxyFor(pm *proxy.Manager, d device.D) bool {
+	for _, pd := range pm.ProxyDevices() {
+		if pd.Proxied().ID() == d.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyErrors records, for each discovered device, the error from the last
+// attempt to create a proxy for it.
+type proxyErrors struct {
+	mu     sync.Mutex
+	errors map[string]string
+}
+
+// record records the result of creating a proxy for the device with the
+// specified ID. A nil err clears any earlier error.
+func (pe *proxyErrors) record(id string, err error) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	if err == nil {
+		delete(pe.errors, id)
+		return
+	}
+	if pe.errors == nil {
+		pe.errors = make(map[string]string)
+	}
+	pe.errors[id] = err.Error()
+}
+
+// get returns the error text for the device with the specified ID, or an empty
+// string if creating its proxy did not fail.
+func (pe *proxyErrors) get(id string) string {
+	if pe == nil {
+		return ""
+	}
+
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	return pe.errors[id]
+}
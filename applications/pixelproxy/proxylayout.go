@@ -0,0 +1,86 @@
+package pixelproxy
+
+import (
+	"math"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/protocol"
+	"github.com/danjacques/gopushpixels/protocol/pixelpusher"
+
+	"github.com/pkg/errors"
+)
+
+// ProxyLayout overrides the strip layout that proxy devices advertise.
+//
+// By default, a proxy device mirrors its source device's layout exactly.
+// Overriding it lets downstream software be tested against geometries that
+// the real devices don't have. Packets are still forwarded to the source
+// device unmodified.
+type ProxyLayout struct {
+	// Strips, if >0, is the number of strips that proxy devices advertise.
+	Strips int
+	// PixelsPerStrip, if >0, is the number of pixels per strip that proxy
+	// devices advertise.
+	PixelsPerStrip int
+}
+
+// Validate returns an error if pl can't be represented in discovery headers.
+func (pl *ProxyLayout) Validate() error {
+	if pl.Strips < 0 || pl.Strips > math.MaxUint8 {
+		return errors.Errorf("strip count %d must be between 0 and %d", pl.Strips, math.MaxUint8)
+	}
+	if pl.PixelsPerStrip < 0 || pl.PixelsPerStrip > math.MaxUint16 {
+		return errors.Errorf("pixels per strip %d must be between 0 and %d", pl.PixelsPerStrip, math.MaxUint16)
+	}
+	return nil
+}
+
+// mirrors returns true if pl does not override anything.
+func (pl *ProxyLayout) mirrors() bool { return pl.Strips <= 0 && pl.PixelsPerStrip <= 0 }
+
+// proxySource returns the device that a proxy device should be created for in
+// place of d. If pl mirrors its source, this is d itself.
+func (pl *ProxyLayout) proxySource(d device.D) device.D {
+	if pl.mirrors() {
+		return d
+	}
+	return &layoutOverrideDevice{D: d, layout: *pl}
+}
+
+// layoutOverrideDevice is a device.D that advertises an overridden strip
+// layout. All other methods are those of the wrapped device.
+type layoutOverrideDevice struct {
+	device.D
+	layout ProxyLayout
+}
+
+// DiscoveryHeaders implements device.D.
+//
+// The wrapped device's headers may change as it is rediscovered, so the
+// override is applied to a copy of them on each call.
+func (d *layoutOverrideDevice) DiscoveryHeaders() *protocol.DiscoveryHeaders {
+	dh := d.D.DiscoveryHeaders()
+	if dh == nil || dh.PixelPusher == nil {
+		return dh
+	}
+
+	dhCopy := *dh
+	pp := *dh.PixelPusher
+	dhCopy.PixelPusher = &pp
+
+	if d.layout.Strips > 0 {
+		pp.StripsAttached = uint8(d.layout.Strips)
+		if int(pp.MaxStripsPerPacket) > d.layout.Strips {
+			pp.MaxStripsPerPacket = uint8(d.layout.Strips)
+		}
+
+		// Keep one set of flags per strip. Added strips have no flags.
+		flags := make([]pixelpusher.StripFlags, d.layout.Strips)
+		copy(flags, dh.PixelPusher.StripFlags)
+		pp.StripFlags = flags
+	}
+	if d.layout.PixelsPerStrip > 0 {
+		pp.PixelsPerStrip = uint16(d.layout.PixelsPerStrip)
+	}
+	return &dhCopy
+}
@@ -0,0 +1,126 @@
+package pixelproxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/storage"
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/replay/streamfile"
+
+	"github.com/pkg/errors"
+)
+
+// playbackRate returns the rate at which opts plays a file.
+func playbackRate(opts web.PlayFileOpts) float64 {
+	if opts.PlaybackRate > 0 {
+		return opts.PlaybackRate
+	}
+	return 1
+}
+
+// scalePlaybackOffset converts offset, an offset in a file, into the
+// equivalent offset in a copy of the file scaled to play at rate.
+func scalePlaybackOffset(offset time.Duration, rate float64) time.Duration {
+	return time.Duration(float64(offset) / rate)
+}
+
+// openPlaybackLocked opens a reader to play the named file at rate.
+//
+// The replay.Player plays events at their recorded offsets, so a file is
+// played at another rate by playing a copy of it with scaled offsets. If
+// scaled is not nil, it is that copy. Otherwise, the current playback's copy
+// is reused if it matches, or a new one is written. The copy that is read, if
+// any, is returned alongside the reader; if it isn't the current playback's
+// copy, the caller owns it.
+//
+// ctrl.mu must be held by the caller.
+func (ctrl *Controller) openPlaybackLocked(c context.Context, name string, rate float64, scaled *storage.ScaledFile) (
+	*streamfile.EventStreamReader, *storage.ScaledFile, error) {
+
+	if rate == 1 {
+		sr, err := ctrl.Storage.OpenReader(name)
+		return sr, nil, err
+	}
+
+	if scaled == nil {
+		if sf := ctrl.playingScaled; sf != nil && ctrl.playingName == name && sf.Rate == rate {
+			scaled = sf
+		} else {
+			logging.S(c).Infof("Scaling %q to play at rate %v.", name, rate)
+
+			var err error
+			if scaled, err = ctrl.Storage.ScaleFile(c, name, rate); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	sr, err := scaled.OpenReader()
+	if err != nil {
+		if scaled != ctrl.playingScaled {
+			ctrl.removeScaledFile(scaled)
+		}
+		return nil, nil, err
+	}
+	return sr, scaled, nil
+}
+
+// removeScaledFile removes sf, if it is not nil. Failures are logged.
+func (ctrl *Controller) removeScaledFile(sf *storage.ScaledFile) {
+	if sf == nil {
+		return
+	}
+	if err := sf.Remove(); err != nil {
+		logging.S(ctrl.ctx).Warnf("Failed to remove scaled file: %s", err)
+	}
+}
+
+// SetPlaybackRate implements web.ControllerProxy.
+func (ctrl *Controller) SetPlaybackRate(c context.Context, rate float64) error {
+	if rate <= 0 {
+		rate = 1
+	}
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	// Writing a scaled copy reads the whole file, so it is done without
+	// holding the lock. If playback changes in the meantime, the copy is
+	// discarded.
+	ctrl.mu.Lock()
+	player, name := ctrl.player, ctrl.playingName
+	ctrl.mu.Unlock()
+	if player == nil {
+		return errors.Wrap(web.ErrInvalidRequest, "nothing is playing")
+	}
+
+	var scaled *storage.ScaledFile
+	if rate != 1 {
+		var err error
+		if scaled, err = ctrl.Storage.ScaleFile(c, name, rate); err != nil {
+			return webStorageError(err)
+		}
+	}
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	if ctrl.player != player || ctrl.playbackMonitor == nil {
+		ctrl.removeScaledFile(scaled)
+		return errors.Wrap(web.ErrInvalidRequest, "playback changed while its rate was being set")
+	}
+
+	// Continue from the current position in the file.
+	from := playbackStart{scaled: scaled}
+	if st := ctrl.player.Status(); st != nil {
+		from.offset = ctrl.playbackMonitor.fileStatus(st).Position
+	}
+	opts := ctrl.playbackMonitor.opts
+	opts.PlaybackRate = rate
+
+	logging.S(c).Infof("Changing playback rate of %q to %v at %s.", name, rate, from.offset)
+	return ctrl.restartPlaybackLocked(c, opts, from)
+}
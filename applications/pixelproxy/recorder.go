@@ -0,0 +1,702 @@
+package pixelproxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/storage"
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/protocol"
+	"github.com/danjacques/gopushpixels/protocol/pixelpusher"
+	"github.com/danjacques/gopushpixels/replay"
+	"github.com/danjacques/gopushpixels/replay/streamfile"
+
+	"github.com/pkg/errors"
+)
+
+// recorderListener is a proxy.Listener that feeds proxied packets to a single
+// Recorder.
+//
+// The ProxyManager may still deliver packets to a recorderListener after it
+// has been removed, so the listener is bound to its own Recorder rather than
+// to whatever the Controller is currently recording. Once it has failed, or
+// its recording has been replaced, it discards packets.
+//
+// A recorderListener may be armed, in which case its Recorder is not started
+// until the first packet carrying pixel data arrives. This keeps sporadic
+// activity from being preceded by a long silence in the recording.
+//
+// If its recording is split into segments, the listener moves on to a new
+// Recorder for each segment.
+type recorderListener struct {
+	ctx  context.Context
+	ctrl *Controller
+
+	// recMu protects recorder, name, segment, dedup, priorBytes,
+	// priorDuration, rotateAfter, and segmentErr.
+	recMu sync.Mutex
+	// recorder is the Recorder for the current segment, and name is its file
+	// name.
+	recorder *replay.Recorder
+	name     string
+	// segment is the 1-based index of the current segment, or 0 if the
+	// recording is not split into segments.
+	segment int
+	// priorBytes and priorDuration are the combined size and duration of the
+	// completed segments.
+	priorBytes    int64
+	priorDuration time.Duration
+
+	// segments, if enabled, determines when the recording moves on to a new
+	// segment. Segments are named after baseName, and are written using cfg.
+	segments recordSegments
+	baseName string
+	cfg      *streamfile.EventStreamConfig
+	// rotating is non-zero while the next segment is being started.
+	rotating int32
+	// rotations tracks nextSegment, which may still be committing the previous
+	// segment after the recording has stopped.
+	rotations sync.WaitGroup
+	// rotateAfter, if not zero, is the time before which the next segment is
+	// not attempted, because opening it failed.
+	rotateAfter time.Time
+	// segmentErr is the error that committing a completed segment returned,
+	// if any.
+	segmentErr error
+
+	// failed is non-zero once the listener stops recording packets, because
+	// recording a packet failed or the recording reached one of its limits.
+	failed int32
+
+	// limits are the recording's size and duration limits.
+	limits recordLimits
+	// limited holds the web.RecordLimit constant for the limit that the
+	// recording reached, if any.
+	limited atomic.Value
+
+	// dedup, if not nil, is used to skip packets that would not change their
+	// devices' state. It is replaced along with recorder, so that each segment
+	// starts with its devices' full state.
+	dedup *packetDeduplicator
+	// deduplicated is the number of packets skipped by dedup. It is accessed
+	// atomically.
+	deduplicated int64
+
+	// filter rejects packets that are too large or malformed to record.
+	filter packetFilter
+	// rejected is the number of packets rejected by filter. It is accessed
+	// atomically.
+	rejected int64
+
+	// armMu protects pending and started.
+	armMu sync.Mutex
+	// pending, if not nil, is the writer that recorder will be started with
+	// when it is triggered. The listener is armed while pending is not nil.
+	pending *streamfile.EventStreamWriter
+	// started is the time when an armed listener was triggered.
+	started time.Time
+}
+
+// arm arms rl, so that its Recorder will be started with sw on the first
+// packet that carries pixel data.
+//
+// arm must be called before rl is added to the ProxyManager.
+func (rl *recorderListener) arm(sw *streamfile.EventStreamWriter) { rl.pending = sw }
+
+// disarm disarms rl. If rl was still armed, its Recorder was never started,
+// and disarm returns the writer that it would have been started with.
+func (rl *recorderListener) disarm() *streamfile.EventStreamWriter {
+	rl.armMu.Lock()
+	defer rl.armMu.Unlock()
+
+	sw := rl.pending
+	rl.pending = nil
+	return sw
+}
+
+// armed returns true if rl is armed and has not been triggered.
+func (rl *recorderListener) armed() bool {
+	rl.armMu.Lock()
+	defer rl.armMu.Unlock()
+	return rl.pending != nil
+}
+
+// triggeredAt returns the time when an armed listener was triggered. It returns
+// the zero time if rl was never armed, or has not been triggered.
+func (rl *recorderListener) triggeredAt() time.Time {
+	rl.armMu.Lock()
+	defer rl.armMu.Unlock()
+	return rl.started
+}
+
+// current returns rl's current Recorder, its file name, and its segment index.
+func (rl *recorderListener) current() (*replay.Recorder, string, int) {
+	rl.recMu.Lock()
+	defer rl.recMu.Unlock()
+	return rl.recorder, rl.name, rl.segment
+}
+
+// currentSegment returns rl's current Recorder, and the packetDeduplicator for
+// its segment.
+func (rl *recorderListener) currentSegment() (*replay.Recorder, *packetDeduplicator) {
+	rl.recMu.Lock()
+	defer rl.recMu.Unlock()
+	return rl.recorder, rl.dedup
+}
+
+// totals returns the size and duration of the whole recording, given st, the
+// status of its current segment.
+func (rl *recorderListener) totals(st *replay.RecorderStatus) (int64, time.Duration) {
+	rl.recMu.Lock()
+	defer rl.recMu.Unlock()
+	return rl.priorBytes + st.Bytes, rl.priorDuration + st.Duration
+}
+
+// deduplicatedPackets returns the number of packets that rl has skipped because
+// they duplicated their devices' state.
+func (rl *recorderListener) deduplicatedPackets() int64 { return atomic.LoadInt64(&rl.deduplicated) }
+
+// rejectedPackets returns the number of packets that rl has not recorded
+// because they were rejected by its filter.
+func (rl *recorderListener) rejectedPackets() int64 { return atomic.LoadInt64(&rl.rejected) }
+
+// reject counts pkt, from device d, as rejected for the specified reason.
+func (rl *recorderListener) reject(d device.D, pkt *protocol.Packet, reason string) {
+	atomic.AddInt64(&rl.rejected, 1)
+	logging.S(rl.ctx).Debugf("Not recording packet from device %q (%s): %s", d.ID(), reason, pkt)
+}
+
+// trigger starts rl's Recorder if rl is armed and pkt carries pixel data. It
+// returns false if pkt should not be recorded.
+func (rl *recorderListener) trigger(pkt *protocol.Packet) bool {
+	rl.armMu.Lock()
+	defer rl.armMu.Unlock()
+
+	if rl.pending == nil {
+		return true
+	}
+	if pkt.PixelPusher == nil || len(pkt.PixelPusher.StripStates) == 0 {
+		return false
+	}
+
+	recorder, name, _ := rl.current()
+	recorder.Start(rl.pending)
+	rl.pending = nil
+	rl.started = time.Now()
+	logging.S(rl.ctx).Infof("Armed recording %q triggered.", name)
+
+	// Our recording effectively starts now. We're called by the ProxyManager,
+	// so update our annotations asynchronously.
+	started := rl.started
+	go func() {
+		err := rl.ctrl.Storage.UpdateAnnotations(name, func(a *storage.Annotations) error {
+			a.RecordStarted = started
+			return nil
+		})
+		if err != nil {
+			logging.S(rl.ctx).Warnf("Failed to update annotations for %q: %s", name, err)
+		}
+	}()
+	return true
+}
+
+// ReceivePacket implements proxy.Listener.
+func (rl *recorderListener) ReceivePacket(d device.D, pkt *protocol.Packet, forwarded bool) {
+	if atomic.LoadInt32(&rl.failed) != 0 {
+		return
+	}
+	// Reject bad packets before they can trigger an armed recording.
+	if reason := rl.filter.check(d, pkt); reason != "" {
+		rl.reject(d, pkt, reason)
+		return
+	}
+	if !rl.trigger(pkt) {
+		return
+	}
+
+	// A packet is deduplicated against the state of the segment that it is
+	// recorded into.
+	var (
+		recorder *replay.Recorder
+		err      error
+	)
+	for {
+		var dedup *packetDeduplicator
+		recorder, dedup = rl.currentSegment()
+		if dedup != nil && dedup.duplicate(d, pkt) {
+			atomic.AddInt64(&rl.deduplicated, 1)
+			return
+		}
+
+		err = recorder.RecordPacket(d, pkt)
+		if err == nil {
+			if dedup != nil {
+				dedup.recorded(d.ID(), pkt)
+			}
+			break
+		}
+		if errors.Cause(err) == streamfile.ErrEncodingNotSupported {
+			break
+		}
+		if cur, _ := rl.currentSegment(); cur == recorder {
+			break
+		}
+		// The segment was completed while this packet was being recorded, so
+		// record it into the next one.
+	}
+
+	c := rl.ctx
+	switch errors.Cause(err) {
+	case nil:
+		st := recorder.Status()
+		if st == nil {
+			break
+		}
+		if !rl.checkLimits(c, st) && rl.segments.due(st) && rl.rotationAllowed() &&
+			atomic.CompareAndSwapInt32(&rl.rotating, 0, 1) {
+			// Starting a segment opens a file and commits the last one, so we
+			// don't do it on the ProxyManager's time. Packets continue to be
+			// recorded into the current segment until the next one is ready.
+			rl.rotations.Add(1)
+			go rl.nextSegment(c)
+		}
+
+	case streamfile.ErrEncodingNotSupported:
+		// We are tolerant of unsupported encoding errors, unless we are strict.
+		if rl.filter.strict {
+			rl.reject(d, pkt, "unsupported encoding")
+			break
+		}
+		logging.S(c).Warnf("Unsupported encoding for packet from device %q: %s", d.ID(), pkt)
+
+	default:
+		if !atomic.CompareAndSwapInt32(&rl.failed, 0, 1) {
+			return
+		}
+		logging.S(c).Warnf("Error recording packet %s for device %q: %s", pkt, d.ID(), err)
+
+		// Stop our recording. This will provide a more accurate user experience,
+		// since the recorder state will be shown to be stopped.
+		//
+		// We are called by the ProxyManager, so we do this asynchronously to
+		// avoid re-entering it.
+		go rl.stopIfCurrent(c)
+	}
+}
+
+// packetFilter identifies packets that should not be recorded, because they
+// are larger than expected or are inconsistent with their device.
+//
+// The zero value accepts every packet.
+type packetFilter struct {
+	// maxBytes, if >0, is the maximum number of pixel bytes that a packet may
+	// carry.
+	maxBytes int
+	// strict, if true, rejects packets whose strips don't match their device's
+	// advertised layout, as well as packets whose encoding is not supported.
+	strict bool
+}
+
+// check returns the reason that pkt, from device d, should not be recorded, or
+// an empty string if it should be.
+func (pf packetFilter) check(d device.D, pkt *protocol.Packet) string {
+	if pf.maxBytes <= 0 && !pf.strict {
+		return ""
+	}
+
+	var states []*pixelpusher.StripState
+	if pkt.PixelPusher != nil {
+		states = pkt.PixelPusher.StripStates
+	}
+
+	if pf.maxBytes > 0 {
+		size := 0
+		for _, s := range states {
+			size += len(s.Pixels.Bytes())
+		}
+		if size > pf.maxBytes {
+			return fmt.Sprintf("%d byte(s) exceeds the limit of %d", size, pf.maxBytes)
+		}
+	}
+
+	if pf.strict && len(states) > 0 {
+		pp := d.DiscoveryHeaders().PixelPusher
+		if pp == nil {
+			return "device is not a PixelPusher"
+		}
+		seen := make(map[pixelpusher.StripNumber]struct{}, len(states))
+		for _, s := range states {
+			switch _, ok := seen[s.StripNumber]; {
+			case int(s.StripNumber) >= int(pp.StripsAttached):
+				return fmt.Sprintf("strip %d is out of range (%d strip(s))", s.StripNumber, pp.StripsAttached)
+			case ok:
+				return fmt.Sprintf("strip %d appears more than once", s.StripNumber)
+			case s.Pixels.Len() > int(pp.PixelsPerStrip):
+				return fmt.Sprintf("strip %d has %d pixel(s), more than %d", s.StripNumber, s.Pixels.Len(), pp.PixelsPerStrip)
+			}
+			seen[s.StripNumber] = struct{}{}
+		}
+	}
+	return ""
+}
+
+// recordLimits are the limits on a recording's size and duration. A limit
+// that is <= 0 is not enforced.
+type recordLimits struct {
+	maxBytes    int64
+	maxDuration time.Duration
+}
+
+// exceeded returns the web.RecordLimit constant for the first limit that a
+// recording of the specified size and duration has reached, or an empty string
+// if it is within its limits.
+func (l recordLimits) exceeded(bytes int64, d time.Duration) string {
+	switch {
+	case l.maxBytes > 0 && bytes >= l.maxBytes:
+		return web.RecordLimitBytes
+	case l.maxDuration > 0 && d >= l.maxDuration:
+		return web.RecordLimitDuration
+	default:
+		return ""
+	}
+}
+
+// recordSegments are the thresholds at which a recording moves on to a new
+// segment. A threshold that is <= 0 is not used.
+type recordSegments struct {
+	maxBytes    int64
+	maxDuration time.Duration
+}
+
+// enabled returns true if the recording is split into segments.
+func (s recordSegments) enabled() bool { return s.maxBytes > 0 || s.maxDuration > 0 }
+
+// due returns true if a segment with status st has reached a threshold.
+func (s recordSegments) due(st *replay.RecorderStatus) bool {
+	return (s.maxBytes > 0 && st.Bytes >= s.maxBytes) ||
+		(s.maxDuration > 0 && st.Duration >= s.maxDuration)
+}
+
+// limitReached returns the web.RecordLimit constant for the limit that rl's
+// recording reached, or an empty string if it hasn't reached one.
+func (rl *recorderListener) limitReached() string {
+	v, _ := rl.limited.Load().(string)
+	return v
+}
+
+// checkLimits stops rl's recording if it has reached one of its limits, given
+// st, the status of its current segment. It returns true if the recording is
+// being stopped.
+func (rl *recorderListener) checkLimits(c context.Context, st *replay.RecorderStatus) bool {
+	if rl.limits == (recordLimits{}) {
+		return false
+	}
+	bytes, d := rl.totals(st)
+	limit := rl.limits.exceeded(bytes, d)
+	if limit == "" || !atomic.CompareAndSwapInt32(&rl.failed, 0, 1) {
+		return limit != ""
+	}
+
+	rl.limited.Store(limit)
+	_, name, _ := rl.current()
+	logging.S(c).Warnf("Recording %q reached its %s limit (%d byte(s), %s); stopping.",
+		name, limit, bytes, d)
+
+	// Stopping the recording commits it. As with failures, we are called by the
+	// ProxyManager, so stop asynchronously.
+	go rl.stopIfCurrent(c)
+	return true
+}
+
+// segmentRetryDelay is the amount of time that a recording waits before
+// retrying a segment that it failed to open.
+const segmentRetryDelay = 5 * time.Second
+
+// rotationAllowed returns true unless opening the next segment recently failed.
+func (rl *recorderListener) rotationAllowed() bool {
+	rl.recMu.Lock()
+	defer rl.recMu.Unlock()
+	return rl.rotateAfter.IsZero() || time.Now().After(rl.rotateAfter)
+}
+
+// waitForSegments waits for rl's segment changes to finish committing their
+// completed segments. It returns the error that committing a completed segment
+// returned, if any.
+func (rl *recorderListener) waitForSegments() error {
+	rl.rotations.Wait()
+
+	rl.recMu.Lock()
+	defer rl.recMu.Unlock()
+	return rl.segmentErr
+}
+
+// nextSegment commits rl's current segment, and continues the recording in the
+// next one.
+//
+// If the next segment can't be opened, recording continues in the current
+// segment, and the next segment is retried after segmentRetryDelay.
+//
+// Opening the next segment and committing the current one both touch the
+// disk, so they are done without holding the Controller's lock.
+func (rl *recorderListener) nextSegment(c context.Context) {
+	defer rl.rotations.Done()
+	defer atomic.StoreInt32(&rl.rotating, 0)
+	ctrl := rl.ctrl
+
+	// Only nextSegment changes the segment, and rotating ensures that it isn't
+	// run concurrently. Segments are opened like any other recording, so a
+	// segment can't replace a protected file or one with a colliding name.
+	prev, prevName, segment := rl.current()
+	_, prevDedup := rl.currentSegment()
+	name := storage.SegmentName(rl.baseName, segment+1)
+	sw, err := ctrl.Storage.OpenWriter(name, rl.cfg)
+	if err != nil {
+		logging.S(c).Errorf("Failed to open recording segment %q; continuing in %q: %s", name, prevName, err)
+		rl.recMu.Lock()
+		rl.rotateAfter = time.Now().Add(segmentRetryDelay)
+		rl.recMu.Unlock()
+		return
+	}
+
+	if !rl.startSegment(c, sw, name, segment+1) {
+		// The recording was stopped while the segment was being opened.
+		if err := sw.Close(); err != nil {
+			logging.S(c).Warnf("Failed to close unused recording segment %q: %s", name, err)
+		}
+		if err := ctrl.Storage.PurgeFile(name); err != nil {
+			logging.S(c).Warnf("Failed to delete unused recording segment %q: %s", name, err)
+		}
+		return
+	}
+
+	// Packets that were already being recorded into prev may still reach it
+	// while it is stopped. ReceivePacket records them into the new segment.
+	if prevDedup != nil {
+		if err := prevDedup.recordHeld(prev); err != nil {
+			logging.S(c).Warnf("Failed to record held frames at the end of segment %q: %s", prevName, err)
+		}
+	}
+	err = prev.Stop()
+	rl.recMu.Lock()
+	if err != nil {
+		rl.segmentErr = errors.Wrapf(err, "recording segment %q was not saved", prevName)
+		logging.S(c).Errorf("%s", rl.segmentErr)
+	}
+	if st := prev.Status(); st != nil {
+		rl.priorBytes += st.Bytes
+		rl.priorDuration += st.Duration
+	}
+	rl.recMu.Unlock()
+	logging.S(c).Infof("Recording segment %q complete; continuing in %q.", prevName, name)
+}
+
+// segmentNames returns the names of rl's segments so far, in order, ending with
+// the current segment. If rl's recording is not split into segments, it
+// returns nil.
+func (rl *recorderListener) segmentNames() []string {
+	_, _, segment := rl.current()
+	if segment == 0 {
+		return nil
+	}
+
+	names := make([]string, segment)
+	for i := range names {
+		names[i] = storage.SegmentName(rl.baseName, i+1)
+	}
+	return names
+}
+
+// removeStaleSegments deletes the segments that follow rl's last segment, left
+// by an earlier, longer recording with the same base name, so that they aren't
+// mistaken for part of rl's recording. Protected segments are kept.
+func (rl *recorderListener) removeStaleSegments(c context.Context) {
+	_, _, segment := rl.current()
+	for i := segment + 1; ; i++ {
+		name := storage.SegmentName(rl.baseName, i)
+		switch exists, err := rl.ctrl.Storage.HasFile(name); {
+		case err != nil:
+			logging.S(c).Warnf("Failed to check for stale recording segment %q: %s", name, err)
+			return
+		case !exists:
+			return
+		}
+
+		logging.S(c).Infof("Deleting stale recording segment %q.", name)
+		if err := rl.ctrl.Storage.DeleteFile(name, false); err != nil {
+			logging.S(c).Warnf("Failed to delete stale recording segment %q: %s", name, err)
+		}
+	}
+}
+
+// startSegment starts recording into sw, the writer for the segment with the
+// specified name and index, in place of the current segment. It returns false
+// if rl is no longer the Controller's recording, in which case sw is left to
+// the caller.
+func (rl *recorderListener) startSegment(c context.Context, sw *streamfile.EventStreamWriter, name string, segment int) bool {
+	ctrl := rl.ctrl
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	if ctrl.recorderListener != rl {
+		return false
+	}
+
+	next := &replay.Recorder{}
+	next.Start(sw)
+	started := time.Now()
+
+	rl.recMu.Lock()
+	rl.recorder, rl.name, rl.segment = next, name, segment
+	if rl.dedup != nil {
+		rl.dedup = &packetDeduplicator{}
+	}
+	rl.recMu.Unlock()
+
+	// Offsets in the new segment are measured from its own start.
+	rl.armMu.Lock()
+	rl.started = time.Time{}
+	rl.armMu.Unlock()
+
+	ctrl.recorder = next
+	ctrl.recordingName = name
+	ctrl.recordingStarted = started
+	ctrl.writeRecordAnnotations(c, name, ctrl.recordingNote, started)
+	return true
+}
+
+// stopIfCurrent stops the Controller's current task if it is still rl's
+// recording. If another operation has since replaced it, that operation is
+// left alone.
+func (rl *recorderListener) stopIfCurrent(c context.Context) {
+	rl.ctrl.mu.Lock()
+	defer rl.ctrl.mu.Unlock()
+
+	if rl.ctrl.recorderListener != rl {
+		return
+	}
+	_, name, _ := rl.current()
+	if limit := rl.limitReached(); limit != "" {
+		logging.S(c).Infof("Stopping recording %q, which reached its %s limit.", name, limit)
+	} else {
+		logging.S(c).Infof("Stopping failed recording %q.", name)
+	}
+	if err := rl.ctrl.stopTaskLocked(); err != nil {
+		logging.S(c).Warnf("Error stopping recording %q: %s", name, err)
+	}
+}
+
+// packetDeduplicator identifies packets whose strips are all byte-identical to
+// the previous state of those strips.
+//
+// Skipping such a packet leaves the device showing the same frame, and the
+// packets that follow keep their own offsets, so playback is unchanged. The
+// last packets that were skipped, after which nothing was recorded for their
+// devices, are held, and recorded by recordHeld when the recording stops, so
+// that the recording's duration still extends to them.
+type packetDeduplicator struct {
+	mu sync.Mutex
+	// last is a copy of the last recorded state of each strip.
+	last map[snapshotStripKey]*pixelpusher.StripState
+	// held is, for each device, the last packet that was skipped since the
+	// device's last recorded packet.
+	held map[string]*heldFrame
+}
+
+// heldFrame is a packet that a packetDeduplicator skipped. Its strips' states
+// are the last recorded states of those strips.
+type heldFrame struct {
+	d      device.D
+	strips []int
+}
+
+// duplicate returns true if every strip in pkt, from device d, matches the last
+// state recorded for it, in which case pkt is held as d's latest frame.
+func (pd *packetDeduplicator) duplicate(d device.D, pkt *protocol.Packet) bool {
+	if pkt.PixelPusher == nil || len(pkt.PixelPusher.StripStates) == 0 {
+		return false
+	}
+
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	id := d.ID()
+	for _, s := range pkt.PixelPusher.StripStates {
+		if last, ok := pd.last[snapshotStripKey{id, int(s.StripNumber)}]; !ok || !bytes.Equal(last.Pixels.Bytes(), s.Pixels.Bytes()) {
+			return false
+		}
+	}
+
+	if pd.held == nil {
+		pd.held = make(map[string]*heldFrame)
+	}
+	hf := pd.held[id]
+	if hf == nil {
+		hf = &heldFrame{}
+		pd.held[id] = hf
+	}
+	hf.d, hf.strips = d, hf.strips[:0]
+	for _, s := range pkt.PixelPusher.StripStates {
+		hf.strips = append(hf.strips, int(s.StripNumber))
+	}
+	return true
+}
+
+// recorded records pkt's strips as the latest state of the device with the
+// specified ID, once pkt has been recorded.
+func (pd *packetDeduplicator) recorded(id string, pkt *protocol.Packet) {
+	if pkt.PixelPusher == nil || len(pkt.PixelPusher.StripStates) == 0 {
+		return
+	}
+
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	if pd.last == nil {
+		pd.last = make(map[snapshotStripKey]*pixelpusher.StripState)
+	}
+	for _, s := range pkt.PixelPusher.StripStates {
+		key := snapshotStripKey{id, int(s.StripNumber)}
+
+		// pkt's buffers aren't ours to keep, so copy its pixels.
+		last := pd.last[key]
+		if last == nil {
+			last = &pixelpusher.StripState{StripNumber: s.StripNumber}
+			pd.last[key] = last
+		}
+		last.Pixels.Reset(s.Pixels.Len())
+		for i := 0; i < s.Pixels.Len(); i++ {
+			last.Pixels.SetPixel(i, s.Pixels.Pixel(i))
+		}
+	}
+	delete(pd.held, id)
+}
+
+// recordHeld records the held frame of each device into recorder, so that a
+// recording that ends with skipped packets isn't cut short. It should be called
+// just before recorder is stopped.
+func (pd *packetDeduplicator) recordHeld(recorder *replay.Recorder) error {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	var err error
+	for id, hf := range pd.held {
+		states := make([]*pixelpusher.StripState, 0, len(hf.strips))
+		for _, strip := range hf.strips {
+			states = append(states, pd.last[snapshotStripKey{id, strip}])
+		}
+		pkt := &protocol.Packet{PixelPusher: &pixelpusher.Packet{StripStates: states}}
+		if rerr := recorder.RecordPacket(hf.d, pkt); rerr != nil && err == nil {
+			err = errors.Wrapf(rerr, "recording held frame for device %q", id)
+		}
+	}
+	pd.held = nil
+	return err
+}
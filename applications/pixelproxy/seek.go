@@ -0,0 +1,193 @@
+package pixelproxy
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/replay/streamfile"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+)
+
+// errOffsetPastEnd is returned by skipToOffset if the stream ends before the
+// requested offset.
+var errOffsetPastEnd = errors.New("offset is past the end of the file")
+
+// skipToOffset reads and discards events from sr until it reaches one at or
+// beyond offset, which it returns.
+//
+// An event can't be pushed back into sr, so the returned event has been
+// consumed; the caller must replay it, or playback will resume with the event
+// that follows.
+func skipToOffset(sr *streamfile.EventStreamReader, offset time.Duration) (*streamfile.Event, error) {
+	for {
+		e, err := sr.ReadEvent()
+		switch {
+		case err == io.EOF:
+			return nil, errOffsetPastEnd
+		case err != nil:
+			return nil, err
+		}
+
+		eventOffset, err := ptypes.Duration(e.Offset)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid event offset")
+		}
+		if eventOffset >= offset {
+			return e, nil
+		}
+	}
+}
+
+// replayCueEventLocked sends e, an event read from sr by skipToOffset, through
+// the current Player's SendPacket, so that the frame at the seek offset is
+// shown rather than skipped.
+//
+// ctrl.mu must be held by the caller.
+func (ctrl *Controller) replayCueEventLocked(sr *streamfile.EventStreamReader, e *streamfile.Event) {
+	pkt := e.GetPacket()
+	if pkt == nil {
+		return
+	}
+	d := sr.ResolveDeviceForIndex(pkt.Device)
+	if d == nil {
+		return
+	}
+	decoded, err := pkt.Decode(d)
+	if err != nil {
+		logging.S(ctrl.ctx).Debugf("Not replaying undecodable cue event for %q: %s", d.Id, err)
+		return
+	}
+
+	// The file records device IDs, not ordinals, so the packet is routed by ID.
+	if err := ctrl.player.SendPacket(device.InvalidOrdinal(), d.Id, decoded); err != nil {
+		logging.S(ctrl.ctx).Debugf("Failed to replay cue event for %q: %s", d.Id, err)
+	}
+}
+
+// seekLocked restarts the current playback at offset. Playback options, the
+// number of rounds played, and the current playlist are retained, and if the
+// Player was paused, it remains paused.
+//
+// If nothing is playing, seekLocked returns an error wrapping
+// ErrInvalidRequest. If offset is past the end of the file, it returns an
+// error wrapping errOffsetPastEnd, and the current playback continues.
+//
+// ctrl.mu must be held by the caller.
+func (ctrl *Controller) seekLocked(c context.Context, offset time.Duration) error {
+	if ctrl.player == nil || ctrl.playbackMonitor == nil {
+		return errors.Wrap(web.ErrInvalidRequest, "nothing is playing")
+	}
+
+	logging.S(c).Infof("Seeking %q to %s.", ctrl.playingName, offset)
+	return ctrl.restartPlaybackLocked(c, ctrl.playbackMonitor.opts, playbackStart{offset: offset})
+}
+
+// restartPlaybackLocked restarts the current playback with opts, starting at
+// from. The number of rounds played and the current playlist are retained,
+// and if the Player was paused, it remains paused.
+//
+// ctrl.mu must be held by the caller, and something must be playing.
+func (ctrl *Controller) restartPlaybackLocked(c context.Context, opts web.PlayFileOpts, from playbackStart) error {
+	name, pl := ctrl.playingName, ctrl.playlist
+	paused := false
+	if st := ctrl.player.Status(); st != nil {
+		paused = st.Paused
+		from.rounds = ctrl.playbackMonitor.fileStatus(st).Rounds
+	}
+
+	if err := ctrl.playFileLocked(c, name, opts, false, from); err != nil {
+		return err
+	}
+	ctrl.playlist = pl
+	if paused {
+		ctrl.player.Pause()
+	}
+	return nil
+}
+
+// SeekToMarker implements web.ControllerProxy.
+func (ctrl *Controller) SeekToMarker(c context.Context, label string) error {
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	if ctrl.player == nil {
+		return errors.Wrap(web.ErrInvalidRequest, "nothing is playing")
+	}
+	for _, m := range ctrl.playingMarkers {
+		if m.Label == label {
+			return ctrl.seekLocked(c, m.Offset)
+		}
+	}
+	return errors.Wrapf(web.ErrMarkerNotFound, "no marker %q in %q", label, ctrl.playingName)
+}
+
+// SeekFile implements web.ControllerProxy.
+func (ctrl *Controller) SeekFile(c context.Context, pos time.Duration) error {
+	if pos < 0 {
+		return errors.Wrapf(web.ErrInvalidRequest, "position must not be negative (%s)", pos)
+	}
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	if ctrl.player == nil {
+		return errors.Wrap(web.ErrInvalidRequest, "nothing is playing")
+	}
+
+	// Seeking to or beyond the end of the file clamps to the end, which
+	// finishes the file: playback stops, or a playlist advances.
+	pl := ctrl.playlist
+	st := ctrl.player.Status()
+	if st != nil && ctrl.playbackMonitor != nil {
+		st = ctrl.playbackMonitor.fileStatus(st)
+	}
+	if st != nil && st.Duration > 0 && pos >= st.Duration {
+		logging.S(c).Infof("Seek position %s is at or past the end of %q (%s).",
+			pos, ctrl.playingName, st.Duration)
+		if pl != nil {
+			ctrl.advancePlaylistLocked(c)
+			return nil
+		}
+		return ctrl.stopTaskLocked()
+	}
+
+	switch err := ctrl.seekLocked(c, pos); errors.Cause(err) {
+	case errOffsetPastEnd:
+		// The file ended before pos, so this is the same as seeking to the end.
+		logging.S(c).Infof("Seek position %s is past the end of the file.", pos)
+		if pl != nil {
+			ctrl.advancePlaylistLocked(c)
+			return nil
+		}
+		return ctrl.stopTaskLocked()
+	default:
+		return err
+	}
+}
+
+// markersAround returns the last marker at or before pos, and the first marker
+// after it. Either may be nil. markers must be ordered by offset.
+func markersAround(markers []web.Marker, pos time.Duration) (current, next *web.Marker) {
+	for i := range markers {
+		m := &markers[i]
+		if m.Offset > pos {
+			return current, m
+		}
+		current = m
+	}
+	return current, nil
+}
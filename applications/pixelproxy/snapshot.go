@@ -0,0 +1,457 @@
+package pixelproxy
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/protocol"
+	"github.com/danjacques/gopushpixels/protocol/pixelpusher"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotSampler offers packets to per-device SnapshotManagers, throttling
+// them on a per-device basis.
+//
+// The SnapshotManager samples all devices at the same rate. snapshotSampler
+// allows individual (e.g., very large) devices to be sampled less often.
+//
+// Each device's snapshots are kept by their own SnapshotManager, so that they
+// can be evicted, and their buffers released, to stay within MaxBytes.
+type snapshotSampler struct {
+	// SampleRate is the sample rate of each device's SnapshotManager.
+	SampleRate time.Duration
+
+	// DeviceIntervals maps device IDs to the minimum interval between samples
+	// of each of that device's strips. Devices without an entry are not
+	// throttled beyond the SnapshotManager's own sample rate.
+	DeviceIntervals map[string]time.Duration
+
+	// MaxBytes, if >0, is the maximum number of bytes of pixel data that are
+	// retained across all devices, both in their SnapshotManagers and in latest.
+	// When it is exceeded, the least-recently-previewed devices are evicted.
+	MaxBytes int64
+
+	mu   sync.Mutex
+	last map[snapshotStripKey]time.Time
+
+	// updated is the last time that each strip was observed in a packet,
+	// regardless of whether that packet was sampled.
+	updated map[snapshotStripKey]time.Time
+
+	// snapshots is the SnapshotManager that holds each device's sampled
+	// snapshot.
+	snapshots map[string]*device.SnapshotManager
+	// sampledBytes is the number of bytes of pixel data offered to a device's
+	// SnapshotManager for each strip, and so the size of its buffer.
+	sampledBytes map[snapshotStripKey]int64
+
+	// latest is a copy of the most recently observed state of each strip,
+	// regardless of whether it was sampled. It is used to serve previews that
+	// must not lag behind the SnapshotManager's sample rate.
+	latest map[snapshotStripKey]*pixelpusher.StripState
+
+	// deviceBytes is the number of bytes of pixel data retained for each
+	// device, in both its SnapshotManager and latest. totalBytes is their sum.
+	deviceBytes map[string]int64
+	totalBytes  int64
+
+	// previewed is the last time that each device was previewed. Devices that
+	// have never been previewed are absent.
+	previewed map[string]time.Time
+	// evictionOrder is the devices with retained state, least-recently-previewed
+	// first. It may also hold devices that have since been evicted. It is
+	// rebuilt when evictionOrderStale is set, which happens when a device
+	// starts retaining state or is previewed.
+	evictionOrder      []string
+	evictionOrderStale bool
+	// evicted is the set of devices that have been evicted. Nothing is retained
+	// for them until they are next previewed.
+	evicted map[string]struct{}
+	// evictions is the number of times that a device has been evicted to stay
+	// within MaxBytes.
+	evictions int64
+}
+
+type snapshotStripKey struct {
+	id    string
+	strip int
+}
+
+// HandlePacket implements device.Listener.
+func (ss *snapshotSampler) HandlePacket(d device.D, pkt *protocol.Packet) {
+	if !ss.markUpdated(d.ID(), pkt) {
+		return
+	}
+
+	if interval := ss.DeviceIntervals[d.ID()]; interval > 0 && !ss.shouldSample(d.ID(), pkt, interval) {
+		return
+	}
+	if sm := ss.sampleSnapshot(d.ID(), pkt); sm != nil {
+		sm.HandlePacket(d, pkt)
+	}
+}
+
+// markUpdated records that each of the strips in pkt was updated now, and
+// retains a copy of their state.
+//
+// If the device has been evicted, its state is not retained, and markUpdated
+// returns false.
+func (ss *snapshotSampler) markUpdated(id string, pkt *protocol.Packet) bool {
+	if pkt.PixelPusher == nil {
+		return !ss.isEvicted(id)
+	}
+
+	now := time.Now()
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.updated == nil {
+		ss.updated = make(map[snapshotStripKey]time.Time)
+		ss.latest = make(map[snapshotStripKey]*pixelpusher.StripState)
+	}
+	for _, s := range pkt.PixelPusher.StripStates {
+		ss.updated[snapshotStripKey{id, int(s.StripNumber)}] = now
+	}
+	if _, ok := ss.evicted[id]; ok {
+		return false
+	}
+
+	for _, s := range pkt.PixelPusher.StripStates {
+		key := snapshotStripKey{id, int(s.StripNumber)}
+
+		// Packet buffers are reused once the packet has been handled, so copy
+		// the strip's pixels, reusing our previous copy's buffer. Strips are
+		// often resent unchanged, so only copy them when they change.
+		latest := ss.latest[key]
+		if latest == nil {
+			latest = &pixelpusher.StripState{StripNumber: s.StripNumber}
+			ss.latest[key] = latest
+		} else if bytes.Equal(latest.Pixels.Bytes(), s.Pixels.Bytes()) {
+			continue
+		}
+		size := int64(len(latest.Pixels.Bytes()))
+		latest.Pixels.Reset(s.Pixels.Len())
+		for i := 0; i < s.Pixels.Len(); i++ {
+			latest.Pixels.SetPixel(i, s.Pixels.Pixel(i))
+		}
+		ss.addBytesLocked(id, int64(len(latest.Pixels.Bytes()))-size)
+	}
+
+	return ss.withinBudgetLocked(id)
+}
+
+// sampleSnapshot accounts for pkt being offered to the device's
+// SnapshotManager, and returns that SnapshotManager. If the device has been
+// evicted, sampleSnapshot returns nil.
+func (ss *snapshotSampler) sampleSnapshot(id string, pkt *protocol.Packet) *device.SnapshotManager {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, ok := ss.evicted[id]; ok {
+		return nil
+	}
+
+	sm := ss.snapshots[id]
+	if sm == nil {
+		if ss.snapshots == nil {
+			ss.snapshots = make(map[string]*device.SnapshotManager)
+			ss.sampledBytes = make(map[snapshotStripKey]int64)
+		}
+		sm = &device.SnapshotManager{SampleRate: ss.SampleRate}
+		ss.snapshots[id] = sm
+	}
+
+	if pkt.PixelPusher != nil {
+		for _, s := range pkt.PixelPusher.StripStates {
+			key := snapshotStripKey{id, int(s.StripNumber)}
+			size := int64(len(s.Pixels.Bytes()))
+			ss.addBytesLocked(id, size-ss.sampledBytes[key])
+			ss.sampledBytes[key] = size
+		}
+	}
+
+	if !ss.withinBudgetLocked(id) {
+		return nil
+	}
+	return sm
+}
+
+// addBytesLocked adds delta to the number of bytes retained for the device.
+//
+// ss.mu must be held by the caller.
+func (ss *snapshotSampler) addBytesLocked(id string, delta int64) {
+	if delta == 0 {
+		return
+	}
+	if ss.deviceBytes == nil {
+		ss.deviceBytes = make(map[string]int64)
+	}
+	if _, ok := ss.deviceBytes[id]; !ok {
+		ss.evictionOrderStale = true
+	}
+	ss.deviceBytes[id] += delta
+	ss.totalBytes += delta
+}
+
+// withinBudgetLocked evicts devices, least-recently-previewed first, until
+// totalBytes is within MaxBytes. It returns false if the device with the
+// specified ID was among them.
+//
+// Only devices that were previewed less recently than id are evicted in its
+// favor. If that isn't enough, id itself is evicted. Evicted devices stay
+// evicted until they are next previewed, so devices which are not being
+// previewed don't continually evict each other.
+//
+// ss.mu must be held by the caller.
+func (ss *snapshotSampler) withinBudgetLocked(id string) bool {
+	if ss.MaxBytes <= 0 || ss.totalBytes <= ss.MaxBytes {
+		return true
+	}
+
+	// Order the devices with retained state by when they were last previewed.
+	// This is called for every packet while over budget, so the order is only
+	// rebuilt when it changes.
+	if ss.evictionOrderStale {
+		ss.evictionOrder = ss.evictionOrder[:0]
+		for cid := range ss.deviceBytes {
+			ss.evictionOrder = append(ss.evictionOrder, cid)
+		}
+		sort.Slice(ss.evictionOrder, func(i, j int) bool {
+			return ss.previewed[ss.evictionOrder[i]].Before(ss.previewed[ss.evictionOrder[j]])
+		})
+		ss.evictionOrderStale = false
+	}
+
+	threshold := ss.previewed[id]
+	for _, cid := range ss.evictionOrder {
+		if ss.totalBytes <= ss.MaxBytes || !ss.previewed[cid].Before(threshold) {
+			break
+		}
+		if _, ok := ss.deviceBytes[cid]; !ok || cid == id {
+			continue
+		}
+		ss.evictLocked(cid)
+	}
+	if ss.totalBytes > ss.MaxBytes {
+		ss.evictLocked(id)
+		return false
+	}
+	return true
+}
+
+// evictLocked discards the device's SnapshotManager and the latest state of
+// each of its strips, and stops retaining them until it is next previewed.
+//
+// ss.mu must be held by the caller.
+func (ss *snapshotSampler) evictLocked(id string) {
+	for key := range ss.latest {
+		if key.id == id {
+			delete(ss.latest, key)
+		}
+	}
+	for key := range ss.sampledBytes {
+		if key.id == id {
+			delete(ss.sampledBytes, key)
+		}
+	}
+	delete(ss.snapshots, id)
+
+	ss.totalBytes -= ss.deviceBytes[id]
+	delete(ss.deviceBytes, id)
+
+	if ss.evicted == nil {
+		ss.evicted = make(map[string]struct{})
+	}
+	ss.evicted[id] = struct{}{}
+	ss.evictions++
+}
+
+// isEvicted returns true if the device with the specified ID has been evicted,
+// and not previewed since.
+func (ss *snapshotSampler) isEvicted(id string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	_, ok := ss.evicted[id]
+	return ok
+}
+
+// markPreviewed records that the device with the specified ID was just
+// previewed, making it the last candidate for eviction. If it was evicted, it
+// is retained again from its next packet.
+func (ss *snapshotSampler) markPreviewed(id string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.previewed == nil {
+		ss.previewed = make(map[string]time.Time)
+	}
+	ss.previewed[id] = time.Now()
+	ss.evictionOrderStale = true
+	delete(ss.evicted, id)
+}
+
+// HasSnapshotForDevice returns true if d has a sampled snapshot.
+//
+// An evicted device is retained again once it is previewed, so it is reported
+// as having a snapshot, so that it continues to be offered for preview.
+func (ss *snapshotSampler) HasSnapshotForDevice(d device.D) bool {
+	ss.mu.Lock()
+	sm := ss.snapshots[d.ID()]
+	_, evicted := ss.evicted[d.ID()]
+	ss.mu.Unlock()
+
+	return evicted || (sm != nil && sm.HasSnapshotForDevice(d))
+}
+
+// SnapshotForDevice returns d's sampled snapshot, or nil if it has none.
+func (ss *snapshotSampler) SnapshotForDevice(d device.D) *device.DeviceSnapshot {
+	ss.mu.Lock()
+	sm := ss.snapshots[d.ID()]
+	ss.mu.Unlock()
+
+	if sm == nil {
+		return nil
+	}
+	return sm.SnapshotForDevice(d)
+}
+
+// memoryUsage returns the number of bytes of pixel data retained across all
+// devices, and the number of evictions that have occurred.
+func (ss *snapshotSampler) memoryUsage() (bytes, evictions int64) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.totalBytes, ss.evictions
+}
+
+// deviceMemory describes the memory retained for a single device.
+type deviceMemory struct {
+	id        string
+	bytes     int64
+	previewed time.Time
+	evicted   bool
+}
+
+// deviceMemoryUsage returns the memory retained for each device that has
+// retained state or has been evicted, most-recently-previewed first.
+func (ss *snapshotSampler) deviceMemoryUsage() []deviceMemory {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	usage := make([]deviceMemory, 0, len(ss.deviceBytes)+len(ss.evicted))
+	for id, bytes := range ss.deviceBytes {
+		usage = append(usage, deviceMemory{id: id, bytes: bytes, previewed: ss.previewed[id]})
+	}
+	for id := range ss.evicted {
+		usage = append(usage, deviceMemory{id: id, previewed: ss.previewed[id], evicted: true})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if !usage[i].previewed.Equal(usage[j].previewed) {
+			return usage[i].previewed.After(usage[j].previewed)
+		}
+		return usage[i].id < usage[j].id
+	})
+	return usage
+}
+
+// latestStrips overlays the most recently observed state of each of the
+// device's strips onto strips, which are typically built from a sampled
+// snapshot. Strips that were observed but are not in strips are added. The
+// result is ordered by strip number.
+func (ss *snapshotSampler) latestStrips(id string, strips []web.Strip) []web.Strip {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	byNumber := make(map[int]int, len(strips))
+	for i, ws := range strips {
+		byNumber[ws.Number] = i
+	}
+
+	for key, state := range ss.latest {
+		if key.id != id {
+			continue
+		}
+
+		ws := webStripFromState(state)
+		if i, ok := byNumber[key.strip]; ok {
+			strips[i] = ws
+		} else {
+			byNumber[key.strip] = len(strips)
+			strips = append(strips, ws)
+		}
+	}
+
+	sort.Slice(strips, func(i, j int) bool { return strips[i].Number < strips[j].Number })
+	return strips
+}
+
+// stripUpdated returns the last time that the specified strip of the device
+// with the specified ID was observed. If it has never been observed,
+// stripUpdated returns the zero time.
+func (ss *snapshotSampler) stripUpdated(id string, strip int) time.Time {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.updated[snapshotStripKey{id, strip}]
+}
+
+// shouldSample returns true if any of the strips in pkt has not been sampled
+// within interval. If so, all of pkt's strips are marked as sampled.
+//
+// Devices may spread their strips across several packets, so strips are
+// tracked independently.
+func (ss *snapshotSampler) shouldSample(id string, pkt *protocol.Packet, interval time.Duration) bool {
+	if pkt.PixelPusher == nil {
+		return true
+	}
+
+	now := time.Now()
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	due := false
+	for _, s := range pkt.PixelPusher.StripStates {
+		key := snapshotStripKey{id, int(s.StripNumber)}
+		if last, ok := ss.last[key]; !ok || now.Sub(last) >= interval {
+			due = true
+			break
+		}
+	}
+	if !due {
+		return false
+	}
+
+	if ss.last == nil {
+		ss.last = make(map[snapshotStripKey]time.Time)
+	}
+	for _, s := range pkt.PixelPusher.StripStates {
+		ss.last[snapshotStripKey{id, int(s.StripNumber)}] = now
+	}
+	return true
+}
+
+// parseDeviceIntervals parses a list of "ID=DURATION" values into a map.
+func parseDeviceIntervals(values []string) (map[string]time.Duration, error) {
+	intervals := make(map[string]time.Duration, len(values))
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid device interval %q, expected ID=DURATION", v)
+		}
+
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid duration in %q", v)
+		}
+		intervals[parts[0]] = d
+	}
+	return intervals, nil
+}
@@ -0,0 +1,91 @@
+package pixelproxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/danjacques/pixelproxy/util"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/pkg/errors"
+)
+
+// startupInterfacePollInterval is the interval at which waitForInterface
+// checks its interfaces.
+const startupInterfacePollInterval = 500 * time.Millisecond
+
+// waitForInterface blocks until one of the named network interfaces is usable
+// (see selectInterface), or until timeout has elapsed.
+func waitForInterface(c context.Context, names []string, timeout time.Duration) error {
+	c, cancelFunc := context.WithTimeout(c, timeout)
+	defer cancelFunc()
+
+	var s util.Sleeper
+	defer s.Close()
+
+	for {
+		_, err := selectInterface(names)
+		if err == nil {
+			return nil
+		}
+		logging.S(c).Debugf("Waiting for a usable interface: %s", err)
+
+		if serr := s.Sleep(c, startupInterfacePollInterval); serr != nil {
+			if serr == context.DeadlineExceeded {
+				return errors.Wrapf(err, "timed out after %s", timeout)
+			}
+			return serr
+		}
+	}
+}
+
+// initializingServer is a placeholder HTTP server that reports that
+// PixelProxy is still initializing. It answers every request with a
+// StatusServiceUnavailable JSON status.
+type initializingServer struct {
+	server http.Server
+	doneC  chan struct{}
+}
+
+// startInitializingServer starts an initializingServer on addr. If certFile
+// and keyFile are not empty, it serves HTTPS.
+func startInitializingServer(c context.Context, addr, certFile, keyFile string) *initializingServer {
+	is := initializingServer{
+		server: http.Server{
+			Addr: addr,
+			Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				_ = json.NewEncoder(rw).Encode(struct {
+					Status string `json:"status"`
+				}{"initializing"})
+			}),
+		},
+		doneC: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(is.doneC)
+
+		var err error
+		if certFile != "" {
+			err = is.server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = is.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logging.S(c).Warnf("Initializing HTTP server on %q failed: %s", addr, err)
+		}
+	}()
+	return &is
+}
+
+// stop shuts down the server, blocking until its listener has been released.
+func (is *initializingServer) stop(c context.Context) {
+	if err := is.server.Shutdown(c); err != nil {
+		logging.S(c).Warnf("Error during initializing HTTP server shutdown: %s", err)
+	}
+	<-is.doneC
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danjacques/pixelproxy/util"
+
+	"github.com/pkg/errors"
+)
+
+const annotationsExt = ".json"
+
+// Annotations are PixelProxy-specific properties of a stored File.
+//
+// A File's stream metadata is owned by the streamfile package, so Annotations
+// are stored alongside it in S's annotations directory, keyed by File ID.
+type Annotations struct {
+	// Note is a free-form note supplied by the operator.
+	Note string `json:"note,omitempty"`
+
+	// RecordStarted is the wall-clock time when the File's recording began.
+	RecordStarted time.Time `json:"record_started"`
+
+	// Protected, if true, prevents the File from being deleted unless the
+	// deletion is forced.
+	Protected bool `json:"protected,omitempty"`
+
+	// Markers are labeled cue points within the File, in the order that they
+	// were added.
+	Markers []Marker `json:"markers,omitempty"`
+}
+
+// Marker is a labeled offset within a File.
+type Marker struct {
+	// Label is the operator-supplied name of the marker.
+	Label string `json:"label"`
+	// Offset is the marker's offset from the beginning of the File.
+	Offset time.Duration `json:"offset"`
+}
+
+// GetAnnotations returns the Annotations for the named file.
+//
+// If the file has no Annotations, an empty Annotations will be returned.
+func (st *S) GetAnnotations(name string) (*Annotations, error) {
+	f := st.makeFileForName(name)
+
+	st.annotationsMu.Lock()
+	defer st.annotationsMu.Unlock()
+	return st.loadAnnotations(f.ID)
+}
+
+// UpdateAnnotations loads the Annotations for the named file, calls fn to
+// modify them, and then writes them back.
+//
+// If fn returns an error, the Annotations will not be written, and that error
+// will be returned.
+func (st *S) UpdateAnnotations(name string, fn func(*Annotations) error) error {
+	f := st.makeFileForName(name)
+
+	st.annotationsMu.Lock()
+	defer st.annotationsMu.Unlock()
+
+	a, err := st.loadAnnotations(f.ID)
+	if err != nil {
+		return err
+	}
+	if err := fn(a); err != nil {
+		return err
+	}
+
+	return util.CreateViaTempMove(st.annotationsPath(f.ID), st.tempDir, "annotations", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(a)
+	})
+}
+
+// deleteAnnotations deletes the Annotations for the file with the specified
+// ID. If the file has no Annotations, deleteAnnotations does nothing.
+func (st *S) deleteAnnotations(id string) error {
+	st.annotationsMu.Lock()
+	defer st.annotationsMu.Unlock()
+
+	if err := os.Remove(st.annotationsPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// moveAnnotations moves the Annotations for the file with ID from to the file
+// with ID to. If the file has no Annotations, moveAnnotations does nothing.
+func (st *S) moveAnnotations(from, to string) error {
+	st.annotationsMu.Lock()
+	defer st.annotationsMu.Unlock()
+
+	if err := os.Rename(st.annotationsPath(from), st.annotationsPath(to)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// copyAnnotations copies the Annotations for the file with ID from to the file
+// with ID to. The copy is never protected. If the file has no Annotations,
+// copyAnnotations does nothing.
+func (st *S) copyAnnotations(from, to string) error {
+	st.annotationsMu.Lock()
+	defer st.annotationsMu.Unlock()
+
+	if _, err := os.Stat(st.annotationsPath(from)); os.IsNotExist(err) {
+		return nil
+	}
+	a, err := st.loadAnnotations(from)
+	if err != nil {
+		return err
+	}
+	a.Protected = false
+
+	return util.CreateViaTempMove(st.annotationsPath(to), st.tempDir, "annotations", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(a)
+	})
+}
+
+// loadAnnotations loads the Annotations for the file with the specified ID.
+//
+// annotationsMu must be held by the caller.
+func (st *S) loadAnnotations(id string) (*Annotations, error) {
+	var a Annotations
+
+	path := st.annotationsPath(id)
+	fd, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &a, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = fd.Close()
+	}()
+
+	if err := json.NewDecoder(fd).Decode(&a); err != nil {
+		return nil, errors.Wrapf(err, "decoding annotations from %q", path)
+	}
+	return &a, nil
+}
+
+func (st *S) annotationsPath(id string) string {
+	return filepath.Join(st.annotationsDir, id+annotationsExt)
+}
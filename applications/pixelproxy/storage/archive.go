@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/danjacques/gopushpixels/replay/streamfile"
+
+	"github.com/pkg/errors"
+)
+
+// archiveFile is a single file in a stream file's directory, opened for
+// archiving.
+type archiveFile struct {
+	// name is the file's path in the archive.
+	name string
+	fi   os.FileInfo
+	fd   *os.File
+}
+
+// OpenArchive opens the stored data of the file with the specified name for
+// reading as a tar archive, e.g. to copy it elsewhere.
+//
+// A File is a directory of stream data, so the archive contains that directory
+// and its contents, exactly as stored. Every file in it is opened before
+// OpenArchive returns. Files are only ever replaced by moving a new directory
+// into place, so the archive is a consistent snapshot even if the File is
+// replaced or deleted while it is being read.
+func (st *S) OpenArchive(name string) (io.ReadCloser, error) {
+	f := st.makeFileForName(name)
+	base := filepath.Dir(f.Path)
+
+	var files []*archiveFile
+	closeAll := func() {
+		for _, af := range files {
+			if af.fd != nil {
+				_ = af.fd.Close()
+			}
+		}
+	}
+
+	err := filepath.Walk(f.Path, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+
+		af := archiveFile{
+			name: filepath.ToSlash(rel),
+			fi:   fi,
+		}
+		switch {
+		case fi.IsDir():
+		case fi.Mode().IsRegular():
+			if af.fd, err = os.Open(path); err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("%q is not a regular file", path)
+		}
+		files = append(files, &af)
+		return nil
+	})
+	if err != nil {
+		closeAll()
+		return nil, errors.Wrapf(err, "opening %q", f.DisplayName)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer closeAll()
+		_ = pw.CloseWithError(writeArchive(pw, files))
+	}()
+	return pr, nil
+}
+
+// writeArchive writes files to w as a tar archive.
+func writeArchive(w io.Writer, files []*archiveFile) error {
+	tw := tar.NewWriter(w)
+	for _, af := range files {
+		hdr, err := tar.FileInfoHeader(af.fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = af.name
+		if af.fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if af.fd != nil {
+			if _, err := io.CopyN(tw, af.fd, hdr.Size); err != nil {
+				return errors.Wrapf(err, "archiving %q", af.name)
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// maxImportNameAttempts is the number of numbered names that ImportArchive
+// will try before giving up on finding one that is not in use.
+const maxImportNameAttempts = 1000
+
+// ImportArchive imports a file previously read with OpenArchive from r, and
+// returns the name that it was stored as.
+//
+// The file is stored as name. If name is empty, the name in the file's
+// metadata is used. If that name's file ID is already in use, a numbered name
+// (e.g., "name-2") is chosen instead, so an import never replaces an existing
+// file.
+//
+// If reserved is not nil, names for which it returns true are treated as in
+// use. The name is chosen and the file is moved into place with mu held, if mu
+// is not nil, so that reserved's answers hold until the import is complete.
+//
+// The archive is unpacked into S's temporary directory and validated before
+// the file is moved into place. If it is not a valid stream file,
+// ImportArchive returns an error wrapping ErrInvalidArchive. If unpacking it
+// would leave less than MinFreeBytes free, ImportArchive returns an error
+// wrapping ErrInsufficientSpace.
+func (st *S) ImportArchive(r io.Reader, name string, mu sync.Locker, reserved func(name string) bool) (string, error) {
+	budget, err := st.writeBudget()
+	if err != nil {
+		return "", err
+	}
+
+	tempDir, err := ioutil.TempDir(st.tempDir, "import")
+	if err != nil {
+		return "", errors.Wrap(err, "creating import directory")
+	}
+	defer func() {
+		_ = os.RemoveAll(tempDir)
+	}()
+
+	// Unpack the archive, then make sure that it is a stream file that we can
+	// read.
+	uploadDir := filepath.Join(tempDir, "upload")
+	uploadPath, err := extractArchive(r, uploadDir, budget)
+	if err != nil {
+		return "", err
+	}
+	if err := streamfile.Validate(uploadPath); err != nil {
+		return "", errors.Wrapf(ErrInvalidArchive, "validating archive: %s", err)
+	}
+	md, _, err := streamfile.LoadMetadataAndSize(uploadPath)
+	if err != nil {
+		return "", errors.Wrapf(ErrInvalidArchive, "loading archive metadata: %s", err)
+	}
+
+	if name = sanitizeDisplayName(name); name == "" {
+		name = sanitizeDisplayName(md.Name)
+	}
+	if name == "" {
+		return "", errors.Wrap(ErrInvalidArchive, "archive has no name")
+	}
+
+	if mu != nil {
+		mu.Lock()
+		defer mu.Unlock()
+	}
+	f, err := st.unusedFileForName(name, reserved)
+	if err != nil {
+		return "", err
+	}
+
+	// The name is part of the stream's metadata. If we are storing it under a
+	// different name, rewrite it.
+	if md.Name != f.DisplayName {
+		if err := setStreamName(uploadPath, f.DisplayName); err != nil {
+			return "", errors.Wrapf(ErrInvalidArchive, "renaming archive: %s", err)
+		}
+	}
+
+	if err := os.Rename(uploadPath, f.Path); err != nil {
+		return "", errors.Wrapf(err, "installing imported file %q", f.Path)
+	}
+	return f.DisplayName, nil
+}
+
+// unusedFileForName returns a File for name, or for a numbered variant of it
+// if name's file ID is already in use, or if reserved returns true for it.
+func (st *S) unusedFileForName(name string, reserved func(name string) bool) (*File, error) {
+	candidate := name
+	for i := 2; i < maxImportNameAttempts; i++ {
+		f := st.makeFileForName(candidate)
+		switch _, err := os.Stat(f.Path); {
+		case os.IsNotExist(err):
+			if reserved == nil || !reserved(f.DisplayName) {
+				return f, nil
+			}
+		case err != nil:
+			return nil, errors.Wrapf(err, "checking for existing file %q", f.Path)
+		}
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+	return nil, errors.Wrapf(ErrNameCollision, "no unused name for %q", name)
+}
+
+// extractArchive unpacks the tar archive read from r into dir, and returns the
+// path of the stream file directory that it contains.
+//
+// The archive must contain exactly one top-level directory with the stream
+// file extension, and only directories and regular files within it. If it does
+// not, extractArchive returns an error wrapping ErrInvalidArchive.
+//
+// If budget is >=0, it is the number of bytes of file content that may be
+// unpacked. If the archive contains more, extractArchive returns an error
+// wrapping ErrInsufficientSpace.
+func extractArchive(r io.Reader, dir string, budget int64) (string, error) {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "creating archive directory")
+	}
+
+	invalid := func(format string, args ...interface{}) error {
+		return errors.Wrapf(ErrInvalidArchive, format, args...)
+	}
+
+	top := ""
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		switch {
+		case err == io.EOF:
+			if top == "" {
+				return "", invalid("archive is empty")
+			}
+			return filepath.Join(dir, top), nil
+		case err != nil:
+			return "", invalid("reading archive: %s", err)
+		}
+
+		// Only accept relative paths that stay within a single top-level stream
+		// file directory.
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return "", invalid("entry %q is outside of the archive", hdr.Name)
+		}
+		entryTop := strings.SplitN(name, "/", 2)[0]
+		switch {
+		case filepath.Ext(entryTop) != fileDataExt:
+			return "", invalid("entry %q is not in a stream file", hdr.Name)
+		case top == "":
+			top = entryTop
+		case entryTop != top:
+			return "", invalid("archive contains more than one stream file")
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return "", errors.Wrapf(err, "creating %q", target)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if budget >= 0 {
+				if hdr.Size > budget {
+					return "", errors.Wrapf(ErrInsufficientSpace, "archive does not fit in the %d byte(s) available", budget)
+				}
+				budget -= hdr.Size
+			}
+			if err := extractArchiveFile(tr, target); err != nil {
+				return "", errors.Wrapf(err, "extracting %q", hdr.Name)
+			}
+		default:
+			return "", invalid("entry %q is not a regular file or directory", hdr.Name)
+		}
+	}
+}
+
+// extractArchiveFile writes the content of r to a new file at target.
+func extractArchiveFile(r io.Reader, target string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	fd, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := fd.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	_, err = io.Copy(fd, r)
+	return err
+}
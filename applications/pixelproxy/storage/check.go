@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/danjacques/gopushpixels/replay/streamfile"
+	"github.com/danjacques/pixelproxy/util"
+
+	"github.com/pkg/errors"
+)
+
+// CheckResult is the result of checking a storage filesystem.
+type CheckResult struct {
+	// Root is the storage root directory that was checked.
+	Root string
+
+	// Writable is true if a file could be created in Root.
+	Writable bool
+
+	// MissingDirs lists the storage subdirectories that do not exist. Prepare
+	// creates them.
+	MissingDirs []string
+	// InvalidFiles lists the paths of entries in the files directory that are
+	// not valid stream files.
+	InvalidFiles []string
+
+	// Problems describes any other issues that were found.
+	Problems []string
+}
+
+// Healthy returns true if the check found no problems.
+func (cr *CheckResult) Healthy() bool {
+	return cr.Writable && len(cr.MissingDirs) == 0 && len(cr.InvalidFiles) == 0 && len(cr.Problems) == 0
+}
+
+// Check examines the filesystem at Root without modifying it.
+//
+// Unlike Prepare, Check does not create directories or clear the temporary
+// directory, and it may be used before Prepare has been called. The only
+// write that it performs is a probe file, created and removed in Root, to
+// confirm that Root is writable.
+//
+// Check returns an error if Root is not specified. Problems with the
+// filesystem itself are reported in the CheckResult.
+func (st *S) Check(c context.Context) (*CheckResult, error) {
+	if st.Root == "" {
+		return nil, errors.New("no Root specified")
+	}
+
+	root := filepath.Clean(st.Root)
+	cr := CheckResult{
+		Root: root,
+	}
+
+	switch fi, err := os.Stat(root); {
+	case os.IsNotExist(err):
+		cr.Problems = append(cr.Problems, "root directory does not exist")
+		return &cr, nil
+	case err != nil:
+		cr.Problems = append(cr.Problems, errors.Wrap(err, "failed to stat root directory").Error())
+		return &cr, nil
+	case !fi.IsDir():
+		cr.Problems = append(cr.Problems, "root is not a directory")
+		return &cr, nil
+	}
+
+	// Confirm that we can create files in Root.
+	if f, err := ioutil.TempFile(root, ".check"); err != nil {
+		cr.Problems = append(cr.Problems, errors.Wrap(err, "root directory is not writable").Error())
+	} else {
+		cr.Writable = true
+		if err := f.Close(); err != nil {
+			cr.Problems = append(cr.Problems, errors.Wrap(err, "failed to close probe file").Error())
+		}
+		if err := os.Remove(f.Name()); err != nil {
+			cr.Problems = append(cr.Problems, errors.Wrapf(err, "failed to remove probe file %q", f.Name()).Error())
+		}
+	}
+
+	for _, name := range []string{tempDirName, fileDirName, annotationsDirName, trashDirName} {
+		path := filepath.Join(root, name)
+		switch fi, err := os.Stat(path); {
+		case os.IsNotExist(err):
+			cr.MissingDirs = append(cr.MissingDirs, path)
+		case err != nil:
+			cr.Problems = append(cr.Problems, errors.Wrapf(err, "failed to stat %q", path).Error())
+		case !fi.IsDir():
+			cr.Problems = append(cr.Problems, errors.Errorf("%q is not a directory", path).Error())
+		}
+	}
+
+	// Validate the files directory's contents, if it's there.
+	fileDir := filepath.Join(root, fileDirName)
+	if _, err := os.Stat(fileDir); err == nil {
+		defaultFilePath := filepath.Join(fileDir, defaultFileName)
+		err := util.ForEachFile(fileDir, func(fi os.FileInfo) error {
+			if err := c.Err(); err != nil {
+				return err
+			}
+
+			path := filepath.Join(fileDir, fi.Name())
+			if path == defaultFilePath {
+				return nil
+			}
+			if err := streamfile.Validate(path); err != nil {
+				cr.InvalidFiles = append(cr.InvalidFiles, path)
+			}
+			return nil
+		})
+		if err != nil {
+			if c.Err() != nil {
+				return nil, err
+			}
+			cr.Problems = append(cr.Problems, errors.Wrapf(err, "scanning files in %q", fileDir).Error())
+		}
+	}
+
+	return &cr, nil
+}
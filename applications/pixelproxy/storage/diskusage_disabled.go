@@ -0,0 +1,11 @@
+// +build !linux
+
+package storage
+
+import (
+	"github.com/pkg/errors"
+)
+
+var errDiskUsageNotSupported = errors.New("disk usage not supported for this system")
+
+func diskFreeBytes(path string) (int64, error) { return 0, errDiskUsageNotSupported }
@@ -0,0 +1,19 @@
+// +build linux
+
+package storage
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// diskFreeBytes returns the number of bytes available to unprivileged users on
+// the filesystem containing path.
+func diskFreeBytes(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, errors.Wrapf(err, "statfs %q", path)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
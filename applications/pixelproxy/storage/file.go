@@ -27,6 +27,10 @@ type File struct {
 
 	// Metadata is this File's metadata block.
 	Metadata *streamfile.Metadata
+
+	// Annotations are this File's PixelProxy-specific annotations. They are
+	// populated by S.ListFiles.
+	Annotations *Annotations
 }
 
 func loadFileFromPath(path, id string) (*File, error) {
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/protocol"
+	"github.com/danjacques/gopushpixels/protocol/pixelpusher"
+	"github.com/danjacques/gopushpixels/replay/streamfile"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+)
+
+// MergeSource is a source file for MergeTimeline.
+type MergeSource struct {
+	// Name is the name of the source file.
+	Name string
+	// Start is the offset in the merged file at which the source's first
+	// event offset (zero) falls.
+	Start time.Duration
+	// Rate, if >0, is the rate at which the source plays in the merged file,
+	// relative to its recorded speed: its event offsets are divided by Rate.
+	// 0 is the same as 1.
+	Rate float64
+}
+
+// MergeTimeline merges the event streams in srcs together into a single event
+// stream called dest, placing each source's events at their offsets from its
+// Start, scaled by its Rate.
+//
+// Events from all of the sources are written in offset order, so sources whose
+// spans overlap are interleaved. Devices are identified by ID, so the merged
+// file's devices are the union of the sources' devices. Events that can't be
+// decoded are skipped.
+//
+// Unlike MergeFiles, MergeTimeline decodes and rewrites every event. The merged
+// file is written in S's temporary directory and moved into place when it is
+// complete, so a failed merge leaves nothing behind.
+//
+// If cfg is not nil, it will be used in place of S's default writer
+// configuration for the merged file, as in OpenWriter. If dest collides with
+// an existing file, MergeTimeline returns an error wrapping ErrNameCollision,
+// and if it would replace a protected file, an error wrapping ErrFileProtected.
+func (st *S) MergeTimeline(c context.Context, dest string, srcs []MergeSource, cfg *streamfile.EventStreamConfig) error {
+	if err := st.checkFreeSpace(); err != nil {
+		return err
+	}
+
+	cfg = st.resolveEventStreamConfig(cfg)
+
+	destF := st.makeFileForName(dest)
+	if err := st.checkOverwrite(destF); err != nil {
+		return err
+	}
+
+	tempDir, err := ioutil.TempDir(st.tempDir, "merge")
+	if err != nil {
+		return errors.Wrap(err, "creating merge directory")
+	}
+	defer func() {
+		_ = os.RemoveAll(tempDir)
+	}()
+
+	mergePath := filepath.Join(tempDir, destF.ID+fileDataExt)
+	if err := st.writeTimeline(c, mergePath, destF.DisplayName, srcs, cfg); err != nil {
+		return err
+	}
+
+	if err := os.Rename(mergePath, destF.Path); err != nil {
+		return errors.Wrapf(err, "installing merged file %q", destF.Path)
+	}
+	return nil
+}
+
+// writeTimeline writes the events of srcs, placed as described by
+// MergeTimeline, into a new event stream at path called displayName.
+func (st *S) writeTimeline(c context.Context, path, displayName string, srcs []MergeSource,
+	cfg *streamfile.EventStreamConfig) error {
+
+	// Open each source, and load its first event.
+	cursors := make([]*mergeCursor, 0, len(srcs))
+	defer func() {
+		for _, mc := range cursors {
+			_ = mc.sr.Close()
+		}
+	}()
+	for _, src := range srcs {
+		sr, err := st.OpenReader(src.Name)
+		if err != nil {
+			return errors.Wrapf(err, "opening source %q", src.Name)
+		}
+		mc := &mergeCursor{src: src, sr: sr}
+		cursors = append(cursors, mc)
+		if err := mc.advance(); err != nil {
+			return err
+		}
+	}
+
+	sw, err := cfg.MakeEventStreamWriter(path, displayName)
+	if err != nil {
+		return errors.Wrapf(err, "creating merged file %q", displayName)
+	}
+
+	mw := mergeWriter{
+		sw:      sw,
+		devices: make(map[string]*device.Remote),
+	}
+	defer mw.release()
+
+	for {
+		mc := earliestMergeCursor(cursors)
+		if mc == nil {
+			break
+		}
+		if err := mw.write(mc); err != nil {
+			_ = sw.Close()
+			return err
+		}
+		if err := mc.advance(); err != nil {
+			_ = sw.Close()
+			return err
+		}
+	}
+
+	if err := sw.Close(); err != nil {
+		return errors.Wrapf(err, "writing merged file %q", displayName)
+	}
+	if mw.skipped > 0 {
+		logging.S(c).Warnf("Skipped %d undecodable event(s) merging %q.", mw.skipped, displayName)
+	}
+	return nil
+}
+
+// mergeCursor is a source's position in a MergeTimeline merge.
+type mergeCursor struct {
+	src MergeSource
+	sr  *streamfile.EventStreamReader
+
+	// event is the source's next event, or nil if it has no more events.
+	// offset is its offset in the merged file.
+	event  *streamfile.Event
+	offset time.Duration
+}
+
+// advance loads the source's next event.
+func (mc *mergeCursor) advance() error {
+	e, err := mc.sr.ReadEvent()
+	switch {
+	case err == io.EOF:
+		mc.event = nil
+		return nil
+	case err != nil:
+		return errors.Wrapf(err, "reading source %q", mc.src.Name)
+	}
+
+	offset, err := ptypes.Duration(e.Offset)
+	if err != nil {
+		return errors.Wrapf(err, "invalid event offset in source %q", mc.src.Name)
+	}
+	if mc.src.Rate > 0 && mc.src.Rate != 1 {
+		offset = time.Duration(float64(offset) / mc.src.Rate)
+	}
+	mc.event, mc.offset = e, mc.src.Start+offset
+	return nil
+}
+
+// earliestMergeCursor returns the cursor whose next event has the lowest
+// offset, or nil if no cursor has any events left. Ties go to the source that
+// is listed first.
+func earliestMergeCursor(cursors []*mergeCursor) *mergeCursor {
+	var earliest *mergeCursor
+	for _, mc := range cursors {
+		if mc.event != nil && (earliest == nil || mc.offset < earliest.offset) {
+			earliest = mc
+		}
+	}
+	return earliest
+}
+
+// mergeWriter writes events from merge sources into a merged file.
+type mergeWriter struct {
+	sw *streamfile.EventStreamWriter
+
+	// devices are stand-ins for the devices in the merged file, keyed on ID.
+	// The writer builds its device table from them.
+	devices map[string]*device.Remote
+	// skipped is the number of events that were not written because they
+	// could not be decoded or encoded.
+	skipped int
+}
+
+// write writes mc's current event.
+func (mw *mergeWriter) write(mc *mergeCursor) error {
+	pkt := mc.event.GetPacket()
+	if pkt == nil {
+		return nil
+	}
+	md := mc.sr.ResolveDeviceForIndex(pkt.Device)
+	if md == nil {
+		mw.skipped++
+		return nil
+	}
+	decoded, err := pkt.Decode(md)
+	if err != nil {
+		mw.skipped++
+		return nil
+	}
+
+	d := mw.devices[md.Id]
+	if d == nil {
+		d = device.MakeRemote(md.Id, metadataDeviceHeaders(md))
+		mw.devices[md.Id] = d
+	}
+
+	switch err := mw.sw.WritePacket(d, mc.offset, decoded); errors.Cause(err) {
+	case nil:
+		return nil
+	case streamfile.ErrEncodingNotSupported:
+		mw.skipped++
+		return nil
+	default:
+		return errors.Wrapf(err, "writing event from source %q", mc.src.Name)
+	}
+}
+
+// release releases mw's stand-in devices.
+func (mw *mergeWriter) release() {
+	for _, d := range mw.devices {
+		d.MarkDone()
+	}
+}
+
+// metadataDeviceHeaders returns discovery headers for a PixelPusher with the
+// strip layout that md records.
+func metadataDeviceHeaders(md *streamfile.Metadata_Device) *protocol.DiscoveryHeaders {
+	strips := len(md.Strip)
+	dh := protocol.DiscoveryHeaders{
+		DeviceHeader: protocol.DeviceHeader{
+			DeviceType:       protocol.PixelPusherDeviceType,
+			ProtocolVersion:  protocol.DefaultProtocolVersion,
+			SoftwareRevision: pixelpusher.MinAcceptableSoftwareRevision,
+		},
+		PixelPusher: &pixelpusher.Device{
+			DeviceHeader: pixelpusher.DeviceHeader{
+				StripsAttached:     uint8(strips),
+				MaxStripsPerPacket: uint8(strips),
+				PixelsPerStrip:     uint16(md.PixelsPerStrip),
+			},
+		},
+	}
+	dh.PixelPusher.StripFlags = make([]pixelpusher.StripFlags, strips)
+	return &dh
+}
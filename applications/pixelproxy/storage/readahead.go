@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// readAhead reads up to limit bytes of the file data at path and discards
+// them, loading that data into the operating system's file cache ahead of the
+// reader that will consume it. If path is a directory, its regular files are
+// read in lexical order until limit is exhausted.
+//
+// readAhead is best-effort: errors are ignored, since the real reader will
+// encounter and report them.
+func readAhead(path string, limit int64) {
+	_ = filepath.Walk(path, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return nil
+		}
+		if limit <= 0 {
+			return filepath.SkipDir
+		}
+
+		fd, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer func() {
+			_ = fd.Close()
+		}()
+
+		n, _ := io.CopyN(ioutil.Discard, fd, limit)
+		limit -= n
+		return nil
+	})
+}
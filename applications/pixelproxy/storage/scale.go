@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/danjacques/gopushpixels/replay/streamfile"
+
+	"github.com/pkg/errors"
+)
+
+// ScaledFile is a temporary copy of a stored file that plays at a different
+// rate. It is not listed alongside S's files, and must be removed by its owner
+// when it is no longer needed.
+type ScaledFile struct {
+	// Name is the name of the file that was copied.
+	Name string
+	// Rate is the rate at which the copy plays, relative to the original.
+	Rate float64
+
+	dir  string
+	path string
+}
+
+// ScaleFile writes a copy of the named file whose event offsets are divided by
+// rate, so that it plays rate times as fast. The copy is written in its own
+// subdirectory of S's temporary directory, using S's default writer
+// configuration.
+//
+// If rate is not >0, ScaleFile returns an error.
+func (st *S) ScaleFile(c context.Context, name string, rate float64) (*ScaledFile, error) {
+	if rate <= 0 {
+		return nil, errors.Errorf("invalid rate %v", rate)
+	}
+	if err := st.checkFreeSpace(); err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir(st.scaledDir, "scale")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating scale directory")
+	}
+
+	f := st.makeFileForName(name)
+	sf := ScaledFile{
+		Name: f.DisplayName,
+		Rate: rate,
+		dir:  dir,
+		path: filepath.Join(dir, f.ID+fileDataExt),
+	}
+	srcs := []MergeSource{{Name: name, Rate: rate}}
+	if err := st.writeTimeline(c, sf.path, f.DisplayName, srcs, st.EventStreamConfig()); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, errors.Wrapf(err, "scaling %q by %v", f.DisplayName, rate)
+	}
+	return &sf, nil
+}
+
+// OpenReader opens a StreamReader for the scaled copy.
+func (sf *ScaledFile) OpenReader() (*streamfile.EventStreamReader, error) {
+	return streamfile.MakeEventStreamReader(sf.path)
+}
+
+// Remove removes the scaled copy.
+func (sf *ScaledFile) Remove() error {
+	if err := os.RemoveAll(sf.dir); err != nil {
+		return errors.Wrapf(err, "removing scaled copy of %q", sf.Name)
+	}
+	return nil
+}
@@ -2,11 +2,14 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/danjacques/gopushpixels/replay/streamfile"
 	"github.com/danjacques/pixelproxy/util"
@@ -17,6 +20,32 @@ import (
 
 const fileDataExt = ".protostream"
 
+// Names of the directories and files that S maintains underneath of its Root.
+const (
+	tempDirName        = "temporary"
+	scaledDirName      = "scaled"
+	fileDirName        = "files"
+	annotationsDirName = "annotations"
+	trashDirName       = "trash"
+	defaultFileName    = "default"
+)
+
+// ErrNameCollision is returned when writing a file whose name maps to the same
+// file ID as an existing file with a different name.
+var ErrNameCollision = errors.New("file name collides with an existing file")
+
+// ErrFileProtected is returned when deleting a protected file without forcing
+// the deletion, or when writing a file in place of a protected file.
+var ErrFileProtected = errors.New("file is protected")
+
+// ErrInvalidArchive is returned when importing data that is not a valid stored
+// file.
+var ErrInvalidArchive = errors.New("invalid archive")
+
+// ErrInsufficientSpace is returned when starting a write operation while free
+// space is below S's MinFreeBytes.
+var ErrInsufficientSpace = errors.New("insufficient space")
+
 // S manages filesystem storage.
 //
 // The filesystem consists of a Root directory. It is assumed that S owns
@@ -37,9 +66,41 @@ type S struct {
 	// <0 means that a default compresison level should be used.
 	WriterCompressionLevel int
 
+	// ReadAheadBytes, if >0, is the number of bytes of a file to read ahead in
+	// the background when it is opened for reading. This warms the operating
+	// system's file cache so that playback of large files starts promptly and
+	// is not stalled by disk reads.
+	ReadAheadBytes int64
+
+	// MinFreeBytes, if >0, is the minimum amount of free space required to
+	// start a write operation. Writes are staged through the temporary
+	// directory, so its filesystem is the one that is checked.
+	MinFreeBytes int64
+
+	// UseTrash, if true, causes deleted files to be moved into S's trash
+	// directory, from which they can be restored, rather than being removed.
+	UseTrash bool
+	// TrashRetention, if >0, is how long a file is kept in the trash before it
+	// is permanently deleted.
+	TrashRetention time.Duration
+
 	tempDir         string
+	scaledDir       string
 	fileDir         string
+	annotationsDir  string
+	trashDir        string
 	defaultFilePath string
+
+	// annotationsMu serializes reads and writes of file Annotations.
+	annotationsMu sync.Mutex
+
+	// zonesMu protects zones, the cached zone definitions. zones is nil until
+	// it has been loaded.
+	zonesMu sync.Mutex
+	zones   Zones
+
+	// updatePeriodsMu serializes reads and writes of device update periods.
+	updatePeriodsMu sync.Mutex
 }
 
 // Prepare initializes the filesystem. This includes:
@@ -51,9 +112,12 @@ func (st *S) Prepare(c context.Context) error {
 
 	// Construct directories.
 	st.Root = filepath.Clean(st.Root)
-	st.tempDir = filepath.Join(st.Root, "temporary")
-	st.fileDir = filepath.Join(st.Root, "files")
-	st.defaultFilePath = filepath.Join(st.fileDir, "default")
+	st.tempDir = filepath.Join(st.Root, tempDirName)
+	st.scaledDir = filepath.Join(st.tempDir, scaledDirName)
+	st.fileDir = filepath.Join(st.Root, fileDirName)
+	st.annotationsDir = filepath.Join(st.Root, annotationsDirName)
+	st.trashDir = filepath.Join(st.Root, trashDirName)
+	st.defaultFilePath = filepath.Join(st.fileDir, defaultFileName)
 
 	if err := os.MkdirAll(st.Root, 0755); err != nil {
 		return errors.Wrapf(err, "failed to create root directory %q", st.Root)
@@ -74,9 +138,10 @@ func (st *S) Prepare(c context.Context) error {
 		return errors.Wrapf(err, "failed to stat temporary directory %q", st.tempDir)
 	}
 
-	// Create a new, empty temporary directory.
-	if err := os.MkdirAll(st.tempDir, 0755); err != nil {
-		return errors.Wrapf(err, "failed to create temporary directory %q", st.tempDir)
+	// Create a new, empty temporary directory, with a subdirectory for scaled
+	// copies.
+	if err := os.MkdirAll(st.scaledDir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create temporary directory %q", st.scaledDir)
 	}
 
 	// Create our files directory.
@@ -84,6 +149,19 @@ func (st *S) Prepare(c context.Context) error {
 		return errors.Wrapf(err, "failed to create temporary directory %q", st.fileDir)
 	}
 
+	// Create our annotations directory.
+	if err := os.MkdirAll(st.annotationsDir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create annotations directory %q", st.annotationsDir)
+	}
+
+	// Create our trash directory, and purge anything that has expired.
+	if err := os.MkdirAll(st.trashDir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create trash directory %q", st.trashDir)
+	}
+	if err := st.PurgeTrash(c); err != nil {
+		return errors.Wrap(err, "failed to purge trash")
+	}
+
 	// Clear any files that are invalid.
 	if err := st.deleteInvalidFiles(c); err != nil {
 		return errors.Wrap(err, "failed to delete invalid files")
@@ -155,6 +233,14 @@ func (st *S) ListFiles(c context.Context) ([]*File, error) {
 			return nil
 		}
 
+		st.annotationsMu.Lock()
+		file.Annotations, err = st.loadAnnotations(id)
+		st.annotationsMu.Unlock()
+		if err != nil {
+			logging.S(c).Warnf("Ignoring invalid annotations for %q: %s", path, err)
+			file.Annotations = &Annotations{}
+		}
+
 		files = append(files, file)
 		return nil
 	})
@@ -165,6 +251,46 @@ func (st *S) ListFiles(c context.Context) ([]*File, error) {
 	return files, nil
 }
 
+// HasFile returns true if a file with the specified name exists.
+func (st *S) HasFile(name string) (bool, error) {
+	f := st.makeFileForName(name)
+	switch _, err := os.Stat(f.Path); {
+	case err == nil:
+		return true, nil
+	case os.IsNotExist(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// FilePath returns the path that the named file is, or will be, stored at.
+func (st *S) FilePath(name string) string {
+	return st.makeFileForName(name).Path
+}
+
+// GetFile loads the named File, including its Annotations.
+func (st *S) GetFile(name string) (*File, error) {
+	f := st.makeFileForName(name)
+	file, err := loadFileFromPath(f.Path, f.ID)
+	if err != nil {
+		return nil, wrapStreamFormatError(err, f)
+	}
+
+	st.annotationsMu.Lock()
+	defer st.annotationsMu.Unlock()
+	if file.Annotations, err = st.loadAnnotations(f.ID); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// SegmentName returns the name of the segment with the specified 1-based index
+// of a recording that is split into segments, e.g. "name-002".
+func SegmentName(base string, index int) string {
+	return fmt.Sprintf("%s-%03d", base, index)
+}
+
 func (st *S) makeFileForName(name string) *File {
 	name = sanitizeDisplayName(name)
 	id := fileIDFromDisplayName(name)
@@ -178,34 +304,102 @@ func (st *S) makeFileForName(name string) *File {
 
 // OpenWriter opens a StreamWriter for a file with the specified name.
 //
+// If cfg is not nil, it will be used in place of S's default writer
+// configuration. It should be derived from EventStreamConfig. Its TempDir is
+// always replaced with S's temporary directory, since S commits files from
+// there.
+//
+// Different names may sanitize to the same file ID. If name's ID is already
+// used by a file with a different name, OpenWriter returns an error wrapping
+// ErrNameCollision rather than overwriting it. If the file that it would
+// replace is protected, OpenWriter returns an error wrapping ErrFileProtected.
+//
 // The StreamWriter will commit the file when the stream is closed.
-func (st *S) OpenWriter(name string) (*streamfile.EventStreamWriter, error) {
-	cfg := st.eventStreamConfig()
+func (st *S) OpenWriter(name string, cfg *streamfile.EventStreamConfig) (*streamfile.EventStreamWriter, error) {
+	if err := st.checkFreeSpace(); err != nil {
+		return nil, err
+	}
+
+	cfg = st.resolveEventStreamConfig(cfg)
 	f := st.makeFileForName(name)
+	if err := st.checkOverwrite(f); err != nil {
+		return nil, err
+	}
 
 	return cfg.MakeEventStreamWriter(f.Path, f.DisplayName)
 }
 
 // OpenReader opens a StreamReader for a file with the specified name.
 //
-// The StreamReader will commit the file when the stream is closed.
+// If the file was written using a stream format that is not supported by this
+// version of PixelProxy, OpenReader will return an error whose cause is
+// streamfile.ErrEncodingNotSupported.
 func (st *S) OpenReader(name string) (*streamfile.EventStreamReader, error) {
 	f := st.makeFileForName(name)
-	return streamfile.MakeEventStreamReader(f.Path)
+	if st.ReadAheadBytes > 0 {
+		go readAhead(f.Path, st.ReadAheadBytes)
+	}
+
+	sr, err := streamfile.MakeEventStreamReader(f.Path)
+	if err != nil {
+		return nil, wrapStreamFormatError(err, f)
+	}
+	return sr, nil
+}
+
+// DeleteFile deletes the file with the specified name. If UseTrash is true,
+// the file is moved into the trash rather than being removed.
+//
+// If the file is protected and force is false, DeleteFile returns an error
+// wrapping ErrFileProtected.
+func (st *S) DeleteFile(name string, force bool) error {
+	f := st.makeFileForName(name)
+	if !force {
+		a, err := st.GetAnnotations(name)
+		if err != nil {
+			return err
+		}
+		if a.Protected {
+			return errors.Wrapf(ErrFileProtected, "cannot delete %q", name)
+		}
+	}
+
+	if st.UseTrash {
+		return st.trashFile(f)
+	}
+	return st.PurgeFile(name)
 }
 
-// DeleteFile deletes the file with the specified name.
-func (st *S) DeleteFile(name string) error {
+// PurgeFile permanently deletes the file with the specified name, bypassing
+// the trash. It does not check whether the file is protected.
+func (st *S) PurgeFile(name string) error {
 	f := st.makeFileForName(name)
-	return streamfile.Delete(f.Path)
+	if err := streamfile.Delete(f.Path); err != nil {
+		return err
+	}
+	return st.deleteAnnotations(f.ID)
 }
 
 // MergeFiles merges the event streams in srcs together into a single event
 // stream called name.
-func (st *S) MergeFiles(dest string, srcs []string) error {
-	cfg := st.eventStreamConfig()
+//
+// If cfg is not nil, it will be used in place of S's default writer
+// configuration for the merged file, as in OpenWriter.
+//
+// If name collides with an existing file, MergeFiles returns an error wrapping
+// ErrNameCollision, and if it would replace a protected file, an error
+// wrapping ErrFileProtected.
+func (st *S) MergeFiles(dest string, srcs []string, cfg *streamfile.EventStreamConfig) error {
+	if err := st.checkFreeSpace(); err != nil {
+		return err
+	}
+
+	cfg = st.resolveEventStreamConfig(cfg)
 
 	destF := st.makeFileForName(dest)
+	if err := st.checkOverwrite(destF); err != nil {
+		return err
+	}
 	srcPaths := make([]string, len(srcs))
 	for i, src := range srcs {
 		f := st.makeFileForName(src)
@@ -215,7 +409,282 @@ func (st *S) MergeFiles(dest string, srcs []string) error {
 	return cfg.Merge(destF.Path, destF.DisplayName, srcPaths...)
 }
 
-func (st *S) eventStreamConfig() *streamfile.EventStreamConfig {
+// MigrateFile rewrites the named file using the current stream format and S's
+// writer configuration, replacing the original.
+//
+// The file is rewritten in S's temporary directory and then swapped into
+// place, so a failed migration leaves the original file untouched. Migration
+// requires that the original file is readable by the current stream reader.
+func (st *S) MigrateFile(name string) error {
+	if err := st.checkFreeSpace(); err != nil {
+		return err
+	}
+
+	f := st.makeFileForName(name)
+
+	md, _, err := streamfile.LoadMetadataAndSize(f.Path)
+	if err != nil {
+		return wrapStreamFormatError(err, f)
+	}
+
+	tempDir, err := ioutil.TempDir(st.tempDir, "migrate")
+	if err != nil {
+		return errors.Wrap(err, "creating migration directory")
+	}
+	defer func() {
+		_ = os.RemoveAll(tempDir)
+	}()
+
+	// Rewrite the file by merging it, alone, into a new file.
+	migratedPath := filepath.Join(tempDir, f.ID+fileDataExt)
+	if err := st.EventStreamConfig().Merge(migratedPath, md.Name, f.Path); err != nil {
+		return wrapStreamFormatError(err, f)
+	}
+
+	return replaceFile(f.Path, migratedPath, tempDir)
+}
+
+// replaceFile replaces the file at path with the file at replacementPath.
+//
+// The original is moved into tempDir, then the replacement is moved into its
+// place. If that fails, the original is restored.
+func replaceFile(path, replacementPath, tempDir string) error {
+	originalPath := filepath.Join(tempDir, "original"+fileDataExt)
+	if err := os.Rename(path, originalPath); err != nil {
+		return errors.Wrapf(err, "moving original file %q", path)
+	}
+	if err := os.Rename(replacementPath, path); err != nil {
+		if rerr := os.Rename(originalPath, path); rerr != nil {
+			return errors.Wrapf(rerr, "restoring original file %q (after: %s)", path, err)
+		}
+		return errors.Wrapf(err, "installing replacement file %q", path)
+	}
+	return nil
+}
+
+// setStreamName rewrites the name in the metadata of the stream file at path,
+// leaving its stream data untouched.
+func setStreamName(path, name string) error {
+	md, _, err := streamfile.LoadMetadataAndSize(path)
+	if err != nil {
+		return err
+	}
+	md.Name = name
+	return streamfile.WriteMetadata(path, md)
+}
+
+// copyStreamFile copies the stream file directory at src, and everything in it,
+// to a new directory at dest.
+func copyStreamFile(src, dest string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		switch {
+		case fi.IsDir():
+			return os.Mkdir(target, 0755)
+		case fi.Mode().IsRegular():
+			return copyRegularFile(path, target)
+		default:
+			return errors.Errorf("%q is not a regular file", path)
+		}
+	})
+}
+
+// copyRegularFile copies the content of the file at src to a new file at dest.
+func copyRegularFile(src, dest string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// RenameFile renames the file oldName to newName. Its annotations, and the
+// default file setting, follow it.
+//
+// A file's name is stored in its stream metadata. The file is moved into S's
+// temporary directory, its metadata is rewritten with the new name, and it is
+// moved into its new place; its stream data is not rewritten. If that fails,
+// the original file is restored.
+//
+// If newName's file ID is already used by another file, RenameFile returns an
+// error wrapping ErrNameCollision.
+func (st *S) RenameFile(oldName, newName string) error {
+	oldF, newF := st.makeFileForName(oldName), st.makeFileForName(newName)
+	if newF.ID != oldF.ID {
+		switch _, err := os.Stat(newF.Path); {
+		case err == nil:
+			return errors.Wrapf(ErrNameCollision, "cannot rename %q to %q, whose file ID (%q) is in use",
+				oldF.DisplayName, newF.DisplayName, newF.ID)
+		case !os.IsNotExist(err):
+			return errors.Wrapf(err, "checking for existing file %q", newF.Path)
+		}
+	}
+
+	tempDir, err := ioutil.TempDir(st.tempDir, "rename")
+	if err != nil {
+		return errors.Wrap(err, "creating rename directory")
+	}
+	defer func() {
+		_ = os.RemoveAll(tempDir)
+	}()
+
+	// Rename the file in the temporary directory, so that a partially-rewritten
+	// file is never visible under either name.
+	renamedPath := filepath.Join(tempDir, newF.ID+fileDataExt)
+	if err := os.Rename(oldF.Path, renamedPath); err != nil {
+		return errors.Wrapf(err, "moving file %q", oldF.Path)
+	}
+	restore := func(err error) error {
+		if rerr := setStreamName(renamedPath, oldF.DisplayName); rerr != nil {
+			return errors.Wrapf(rerr, "restoring name of %q (after: %s)", oldF.DisplayName, err)
+		}
+		if rerr := os.Rename(renamedPath, oldF.Path); rerr != nil {
+			return errors.Wrapf(rerr, "restoring original file %q (after: %s)", oldF.Path, err)
+		}
+		return err
+	}
+
+	if err := setStreamName(renamedPath, newF.DisplayName); err != nil {
+		return restore(errors.Wrapf(wrapStreamFormatError(err, oldF), "renaming %q", oldF.DisplayName))
+	}
+	if err := os.Rename(renamedPath, newF.Path); err != nil {
+		return restore(errors.Wrapf(err, "installing renamed file %q", newF.Path))
+	}
+
+	// If the names map to the same file, its annotations are already in place.
+	if newF.ID != oldF.ID {
+		if err := st.moveAnnotations(oldF.ID, newF.ID); err != nil {
+			return errors.Wrapf(err, "moving annotations of %q", oldF.DisplayName)
+		}
+	}
+
+	switch def, err := st.GetDefault(); {
+	case err != nil:
+		return errors.Wrap(err, "loading default file")
+	case def == oldF.DisplayName:
+		return st.SetDefault(newF.DisplayName)
+	default:
+		return nil
+	}
+}
+
+// CopyFile copies the file src to a new file named dest. The copy's note,
+// markers, and record time are copied from src; it is not protected.
+//
+// The source's directory is copied into S's temporary directory, its metadata
+// is rewritten with the new name, and it is moved into place only once it is
+// complete, so a failed copy leaves nothing behind. The stream data is copied
+// as stored, keeping its encoding and compression.
+//
+// If dest's file ID is already in use, including by src, CopyFile returns an
+// error wrapping ErrNameCollision.
+func (st *S) CopyFile(c context.Context, src, dest string) error {
+	if err := st.checkFreeSpace(); err != nil {
+		return err
+	}
+
+	srcF, destF := st.makeFileForName(src), st.makeFileForName(dest)
+	switch _, err := os.Stat(destF.Path); {
+	case err == nil:
+		return errors.Wrapf(ErrNameCollision, "cannot copy %q to %q, whose file ID (%q) is in use",
+			srcF.DisplayName, destF.DisplayName, destF.ID)
+	case !os.IsNotExist(err):
+		return errors.Wrapf(err, "checking for existing file %q", destF.Path)
+	}
+
+	tempDir, err := ioutil.TempDir(st.tempDir, "copy")
+	if err != nil {
+		return errors.Wrap(err, "creating copy directory")
+	}
+	defer func() {
+		_ = os.RemoveAll(tempDir)
+	}()
+
+	copyPath := filepath.Join(tempDir, destF.ID+fileDataExt)
+	if err := copyStreamFile(srcF.Path, copyPath); err != nil {
+		return errors.Wrapf(err, "copying %q", srcF.DisplayName)
+	}
+	if err := setStreamName(copyPath, destF.DisplayName); err != nil {
+		return errors.Wrapf(wrapStreamFormatError(err, srcF), "renaming copy of %q", srcF.DisplayName)
+	}
+	if err := os.Rename(copyPath, destF.Path); err != nil {
+		return errors.Wrapf(err, "installing copied file %q", destF.Path)
+	}
+
+	// Copy the source's annotations. If that fails, remove the copy.
+	if err := st.copyAnnotations(srcF.ID, destF.ID); err != nil {
+		if derr := streamfile.Delete(destF.Path); derr != nil {
+			logging.S(c).Warnf("Failed to remove incomplete copy %q: %s", destF.Path, derr)
+		}
+		return errors.Wrapf(err, "copying annotations of %q", srcF.DisplayName)
+	}
+	return nil
+}
+
+// FreeBytes returns the number of bytes available on the filesystem that holds
+// S's Root.
+func (st *S) FreeBytes() (int64, error) { return diskFreeBytes(st.Root) }
+
+// TempDir returns S's temporary directory, in which all files are staged
+// before being committed. It is only valid after Prepare.
+func (st *S) TempDir() string { return st.tempDir }
+
+// TempFreeBytes returns the number of bytes available on the filesystem that
+// holds S's temporary directory.
+func (st *S) TempFreeBytes() (int64, error) { return diskFreeBytes(st.tempDir) }
+
+// TemporaryBytes returns the total size of the files in S's temporary
+// directory. This includes any recordings that are in progress, but not scaled
+// copies, which are kept until they are no longer played.
+func (st *S) TemporaryBytes() (int64, error) {
+	var total int64
+	err := filepath.Walk(st.tempDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == st.scaledDir {
+			return filepath.SkipDir
+		}
+		if fi.Mode().IsRegular() {
+			total += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "measuring temporary directory %q", st.tempDir)
+	}
+	return total, nil
+}
+
+// EventStreamConfig returns a new EventStreamConfig populated with S's default
+// writer settings.
+//
+// The returned config may be modified and supplied to OpenWriter in order to
+// override those settings for a single file.
+func (st *S) EventStreamConfig() *streamfile.EventStreamConfig {
 	return &streamfile.EventStreamConfig{
 		TempDir:                st.tempDir,
 		WriterCompression:      st.WriterCompression,
@@ -223,6 +692,88 @@ func (st *S) eventStreamConfig() *streamfile.EventStreamConfig {
 	}
 }
 
+// resolveEventStreamConfig returns a copy of cfg that is safe to write with,
+// or S's default config if cfg is nil.
+func (st *S) resolveEventStreamConfig(cfg *streamfile.EventStreamConfig) *streamfile.EventStreamConfig {
+	if cfg == nil {
+		return st.EventStreamConfig()
+	}
+
+	cfgCopy := *cfg
+	cfgCopy.TempDir = st.tempDir
+	return &cfgCopy
+}
+
+// checkFreeSpace returns an error wrapping ErrInsufficientSpace if the
+// temporary directory's filesystem has less than MinFreeBytes free.
+//
+// If free space can't be measured on this system, the check passes.
+func (st *S) checkFreeSpace() error {
+	_, err := st.writeBudget()
+	return err
+}
+
+// writeBudget returns the number of bytes that may be written to the
+// temporary directory's filesystem before it has less than MinFreeBytes free,
+// or -1 if there is no limit. If it already has less, writeBudget returns an
+// error wrapping ErrInsufficientSpace.
+//
+// If free space can't be measured on this system, there is no limit.
+func (st *S) writeBudget() (int64, error) {
+	if st.MinFreeBytes <= 0 {
+		return -1, nil
+	}
+
+	free, err := st.TempFreeBytes()
+	if err != nil {
+		return -1, nil
+	}
+	if free < st.MinFreeBytes {
+		return 0, errors.Wrapf(ErrInsufficientSpace, "%d byte(s) free in %q, need at least %d",
+			free, st.tempDir, st.MinFreeBytes)
+	}
+	return free - st.MinFreeBytes, nil
+}
+
+// checkOverwrite returns an error if f may not be written in place of the file
+// that is already stored at its path: one wrapping ErrNameCollision if that
+// file has a different display name, or ErrFileProtected if it is protected.
+func (st *S) checkOverwrite(f *File) error {
+	switch _, err := os.Stat(f.Path); {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return errors.Wrapf(err, "checking for existing file %q", f.Path)
+	}
+
+	md, _, err := streamfile.LoadMetadataAndSize(f.Path)
+	if err != nil {
+		return errors.Wrapf(err, "loading existing file at %q", f.Path)
+	}
+	if md.Name != f.DisplayName {
+		return errors.Wrapf(ErrNameCollision, "%q has the same file ID (%q) as existing file %q",
+			f.DisplayName, f.ID, md.Name)
+	}
+
+	a, err := st.GetAnnotations(f.DisplayName)
+	if err != nil {
+		return errors.Wrapf(err, "loading annotations of existing file %q", f.DisplayName)
+	}
+	if a.Protected {
+		return errors.Wrapf(ErrFileProtected, "cannot overwrite %q", f.DisplayName)
+	}
+	return nil
+}
+
+// wrapStreamFormatError annotates err with a clear message if it indicates
+// that f's stream format is not supported.
+func wrapStreamFormatError(err error, f *File) error {
+	if errors.Cause(err) == streamfile.ErrEncodingNotSupported {
+		return errors.Wrapf(err, "file %q uses an unsupported stream format version", f.DisplayName)
+	}
+	return err
+}
+
 func (st *S) deleteInvalidFiles(c context.Context) error {
 	err := util.ForEachFile(st.fileDir, func(fi os.FileInfo) error {
 		path := filepath.Join(st.fileDir, fi.Name())
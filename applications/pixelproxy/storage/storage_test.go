@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// prepareTestStorage returns an S rooted in a new temporary directory, and a
+// function that removes it.
+func prepareTestStorage(t *testing.T) (*S, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "pixelproxy_storage_test")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+
+	st := &S{Root: dir}
+	if err := st.Prepare(context.Background()); err != nil {
+		cleanup()
+		t.Fatalf("could not prepare storage: %s", err)
+	}
+	return st, cleanup
+}
+
+// writeTestFile writes an empty file called name to st.
+func writeTestFile(t *testing.T, st *S, name string) {
+	t.Helper()
+
+	sw, err := st.OpenWriter(name, nil)
+	if err != nil {
+		t.Fatalf("could not open writer for %q: %s", name, err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("could not write %q: %s", name, err)
+	}
+}
+
+// collideTestFiles stores a file called stored at the path that name maps to,
+// so that name's file ID is in use by a file with a different display name.
+func collideTestFiles(t *testing.T, st *S, stored, name string) {
+	t.Helper()
+
+	writeTestFile(t, st, stored)
+	if err := os.Rename(st.FilePath(stored), st.FilePath(name)); err != nil {
+		t.Fatalf("could not move %q into the place of %q: %s", stored, name, err)
+	}
+}
+
+func TestFileIDsForSimilarNamesAreDistinct(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range [][2]string{
+		{"My Show!", "My Show?"},
+		{"My Show", "My_Show"},
+		{"My_Show", "My_5f_Show"},
+		{"Show-1", "Show 1"},
+	} {
+		a, b := fileIDFromDisplayName(tc[0]), fileIDFromDisplayName(tc[1])
+		if a == b {
+			t.Errorf("%q and %q have the same file ID %q", tc[0], tc[1], a)
+		}
+	}
+}
+
+func TestOpenWriterRejectsCollidingName(t *testing.T) {
+	t.Parallel()
+
+	st, cleanup := prepareTestStorage(t)
+	defer cleanup()
+
+	collideTestFiles(t, st, "Show A", "Show B")
+
+	_, err := st.OpenWriter("Show B", nil)
+	if errors.Cause(err) != ErrNameCollision {
+		t.Fatalf("OpenWriter of colliding name returned %v, want ErrNameCollision", err)
+	}
+
+	// The existing file must be left alone.
+	f, err := st.GetFile("Show B")
+	if err != nil {
+		t.Fatalf("could not load existing file: %s", err)
+	}
+	if f.DisplayName != "Show A" {
+		t.Errorf("existing file's name is %q, want %q", f.DisplayName, "Show A")
+	}
+}
+
+func TestOpenWriterAllowsRewritingSameName(t *testing.T) {
+	t.Parallel()
+
+	st, cleanup := prepareTestStorage(t)
+	defer cleanup()
+
+	writeTestFile(t, st, "My Show")
+
+	// Surrounding whitespace is trimmed, so this is the same name.
+	if _, err := st.OpenWriter("  My Show ", nil); err != nil {
+		t.Fatalf("OpenWriter of existing name returned %v, want success", err)
+	}
+}
+
+func TestRenameFileRejectsCollidingName(t *testing.T) {
+	t.Parallel()
+
+	st, cleanup := prepareTestStorage(t)
+	defer cleanup()
+
+	writeTestFile(t, st, "Show A")
+	writeTestFile(t, st, "Show B")
+
+	err := st.RenameFile("Show A", "Show B")
+	if errors.Cause(err) != ErrNameCollision {
+		t.Fatalf("RenameFile onto existing name returned %v, want ErrNameCollision", err)
+	}
+	for _, name := range []string{"Show A", "Show B"} {
+		if has, err := st.HasFile(name); err != nil || !has {
+			t.Errorf("file %q is missing after rejected rename (err=%v)", name, err)
+		}
+	}
+}
+
+func TestWritesRejectProtectedFile(t *testing.T) {
+	t.Parallel()
+
+	st, cleanup := prepareTestStorage(t)
+	defer cleanup()
+
+	writeTestFile(t, st, "Source")
+	writeTestFile(t, st, "Show")
+	err := st.UpdateAnnotations("Show", func(a *Annotations) error {
+		a.Protected = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not protect file: %s", err)
+	}
+
+	if _, err := st.OpenWriter("Show", nil); errors.Cause(err) != ErrFileProtected {
+		t.Errorf("OpenWriter of protected file returned %v, want ErrFileProtected", err)
+	}
+	if err := st.MergeFiles("Show", []string{"Source"}, nil); errors.Cause(err) != ErrFileProtected {
+		t.Errorf("MergeFiles into protected file returned %v, want ErrFileProtected", err)
+	}
+	err = st.MergeTimeline(context.Background(), "Show", []MergeSource{{Name: "Source"}}, nil)
+	if errors.Cause(err) != ErrFileProtected {
+		t.Errorf("MergeTimeline into protected file returned %v, want ErrFileProtected", err)
+	}
+
+	a, err := st.GetAnnotations("Show")
+	if err != nil {
+		t.Fatalf("could not load annotations: %s", err)
+	}
+	if !a.Protected {
+		t.Errorf("protected file is no longer protected")
+	}
+}
+
+func TestCopyFileCopiesStoredData(t *testing.T) {
+	t.Parallel()
+
+	st, cleanup := prepareTestStorage(t)
+	defer cleanup()
+
+	writeTestFile(t, st, "Show")
+	data := []byte("stored stream data")
+	if err := ioutil.WriteFile(filepath.Join(st.FilePath("Show"), "test.data"), data, 0644); err != nil {
+		t.Fatalf("could not write stream data: %s", err)
+	}
+
+	if err := st.CopyFile(context.Background(), "Show", "Show Copy"); err != nil {
+		t.Fatalf("could not copy file: %s", err)
+	}
+
+	f, err := st.GetFile("Show Copy")
+	if err != nil {
+		t.Fatalf("could not load copy: %s", err)
+	}
+	if f.DisplayName != "Show Copy" {
+		t.Errorf("copy's name is %q, want %q", f.DisplayName, "Show Copy")
+	}
+	switch copied, err := ioutil.ReadFile(filepath.Join(f.Path, "test.data")); {
+	case err != nil:
+		t.Errorf("could not read copied stream data: %s", err)
+	case !bytes.Equal(copied, data):
+		t.Errorf("copied stream data is %q, want %q", copied, data)
+	}
+}
+
+func TestRenameFileMovesStoredData(t *testing.T) {
+	t.Parallel()
+
+	st, cleanup := prepareTestStorage(t)
+	defer cleanup()
+
+	writeTestFile(t, st, "Show")
+	data := []byte("stored stream data")
+	if err := ioutil.WriteFile(filepath.Join(st.FilePath("Show"), "test.data"), data, 0644); err != nil {
+		t.Fatalf("could not write stream data: %s", err)
+	}
+
+	if err := st.RenameFile("Show", "Renamed Show"); err != nil {
+		t.Fatalf("could not rename file: %s", err)
+	}
+
+	if has, err := st.HasFile("Show"); err != nil || has {
+		t.Errorf("original file still exists after rename (err=%v)", err)
+	}
+	f, err := st.GetFile("Renamed Show")
+	if err != nil {
+		t.Fatalf("could not load renamed file: %s", err)
+	}
+	if f.DisplayName != "Renamed Show" {
+		t.Errorf("renamed file's name is %q, want %q", f.DisplayName, "Renamed Show")
+	}
+	switch renamed, err := ioutil.ReadFile(filepath.Join(f.Path, "test.data")); {
+	case err != nil:
+		t.Errorf("could not read renamed stream data: %s", err)
+	case !bytes.Equal(renamed, data):
+		t.Errorf("renamed stream data is %q, want %q", renamed, data)
+	}
+}
+
+func TestExtractArchiveRespectsBudget(t *testing.T) {
+	t.Parallel()
+
+	st, cleanup := prepareTestStorage(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	data := []byte("stored stream data")
+	for _, hdr := range []*tar.Header{
+		{Name: "Show" + fileDataExt + "/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "Show" + fileDataExt + "/test.data", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("could not write archive header: %s", err)
+		}
+	}
+	if _, err := tw.Write(data); err != nil {
+		t.Fatalf("could not write archive data: %s", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("could not close archive: %s", err)
+	}
+
+	_, err := extractArchive(bytes.NewReader(buf.Bytes()), filepath.Join(st.TempDir(), "small"), int64(len(data)-1))
+	if errors.Cause(err) != ErrInsufficientSpace {
+		t.Errorf("extracting archive larger than budget returned %v, want ErrInsufficientSpace", err)
+	}
+	if _, err := extractArchive(bytes.NewReader(buf.Bytes()), filepath.Join(st.TempDir(), "large"), int64(len(data))); err != nil {
+		t.Errorf("extracting archive within budget returned %v, want success", err)
+	}
+}
+
+func TestTemporaryBytesExcludesScaledCopies(t *testing.T) {
+	t.Parallel()
+
+	st, cleanup := prepareTestStorage(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(filepath.Join(st.TempDir(), "recording"), make([]byte, 10), 0644); err != nil {
+		t.Fatalf("could not write temporary file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(st.scaledDir, "scaled"), make([]byte, 100), 0644); err != nil {
+		t.Fatalf("could not write scaled file: %s", err)
+	}
+
+	switch total, err := st.TemporaryBytes(); {
+	case err != nil:
+		t.Fatalf("could not measure temporary bytes: %s", err)
+	case total != 10:
+		t.Errorf("temporary bytes is %d, want 10", total)
+	}
+}
+
+func TestZonesFileWithNullIsEmpty(t *testing.T) {
+	t.Parallel()
+
+	st, cleanup := prepareTestStorage(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(st.zonesPath(), []byte("null\n"), 0644); err != nil {
+		t.Fatalf("could not write zones file: %s", err)
+	}
+
+	err := st.UpdateZones(func(z Zones) error {
+		z["stage"] = []string{"device"}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not update zones: %s", err)
+	}
+	z, err := st.GetZones()
+	if err != nil {
+		t.Fatalf("could not load zones: %s", err)
+	}
+	if zone := z.ZoneForDevice("device"); zone != "stage" {
+		t.Errorf("device is in zone %q, want %q", zone, "stage")
+	}
+}
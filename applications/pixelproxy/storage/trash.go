@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danjacques/pixelproxy/util"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/replay/streamfile"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotInTrash is returned when restoring a file that is not in the trash.
+var ErrNotInTrash = errors.New("file is not in the trash")
+
+// TrashedFile is a deleted File that is held in the trash.
+type TrashedFile struct {
+	// DisplayName is the display name of the file.
+	DisplayName string
+	// ID is the internal ID of the file.
+	ID string
+	// Size is the size of the file on disk, in bytes.
+	Size int64
+	// Deleted is when the file was moved into the trash.
+	Deleted time.Time
+}
+
+func (st *S) trashPath(id string) string { return filepath.Join(st.trashDir, id+fileDataExt) }
+
+func (st *S) trashAnnotationsPath(id string) string {
+	return filepath.Join(st.trashDir, id+annotationsExt)
+}
+
+// trashFile moves f and its Annotations into the trash. If a file with the same
+// ID is already in the trash, it is replaced.
+//
+// The file's modification time is set to the time of deletion, which is used
+// to expire it.
+func (st *S) trashFile(f *File) error {
+	path := st.trashPath(f.ID)
+	if err := st.removeFromTrash(f.ID); err != nil {
+		return errors.Wrapf(err, "removing earlier %q from the trash", f.DisplayName)
+	}
+	if err := os.Rename(f.Path, path); err != nil {
+		return errors.Wrapf(err, "moving %q to the trash", f.DisplayName)
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return errors.Wrapf(err, "marking deletion time of %q", f.DisplayName)
+	}
+
+	st.annotationsMu.Lock()
+	defer st.annotationsMu.Unlock()
+	if err := os.Rename(st.annotationsPath(f.ID), st.trashAnnotationsPath(f.ID)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "moving annotations of %q to the trash", f.DisplayName)
+	}
+	return nil
+}
+
+// removeFromTrash permanently deletes the trashed file with the specified ID,
+// if there is one.
+func (st *S) removeFromTrash(id string) error {
+	path := st.trashPath(id)
+	switch _, err := os.Stat(path); {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		if err := streamfile.Delete(path); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(st.trashAnnotationsPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ListTrash lists the files in the trash in no particular order. Files that
+// have outlived TrashRetention are purged first, and are not listed.
+func (st *S) ListTrash(c context.Context) ([]*TrashedFile, error) {
+	if err := st.PurgeTrash(c); err != nil {
+		return nil, err
+	}
+
+	var files []*TrashedFile
+	err := st.forEachTrashedFile(c, func(tf *TrashedFile) error {
+		files = append(files, tf)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// RestoreFile moves the named file, and its Annotations, out of the trash.
+//
+// If the file is not in the trash, RestoreFile returns an error wrapping
+// ErrNotInTrash. If its file ID is in use by a stored file, RestoreFile
+// returns an error wrapping ErrNameCollision.
+func (st *S) RestoreFile(name string) error {
+	f := st.makeFileForName(name)
+	path := st.trashPath(f.ID)
+	switch _, err := os.Stat(path); {
+	case os.IsNotExist(err):
+		return errors.Wrapf(ErrNotInTrash, "cannot restore %q", f.DisplayName)
+	case err != nil:
+		return errors.Wrapf(err, "checking for trashed file %q", path)
+	}
+	switch _, err := os.Stat(f.Path); {
+	case err == nil:
+		return errors.Wrapf(ErrNameCollision, "cannot restore %q, whose file ID (%q) is in use",
+			f.DisplayName, f.ID)
+	case !os.IsNotExist(err):
+		return errors.Wrapf(err, "checking for existing file %q", f.Path)
+	}
+
+	if err := os.Rename(path, f.Path); err != nil {
+		return errors.Wrapf(err, "restoring %q", f.DisplayName)
+	}
+
+	st.annotationsMu.Lock()
+	defer st.annotationsMu.Unlock()
+	if err := os.Rename(st.trashAnnotationsPath(f.ID), st.annotationsPath(f.ID)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "restoring annotations of %q", f.DisplayName)
+	}
+	return nil
+}
+
+// PurgeTrash permanently deletes files that have been in the trash for longer
+// than TrashRetention. If TrashRetention is <= 0, files are kept until they
+// are restored.
+func (st *S) PurgeTrash(c context.Context) error {
+	if st.TrashRetention <= 0 {
+		return nil
+	}
+
+	cutoff := time.Now().Add(-st.TrashRetention)
+	return st.forEachTrashedFile(c, func(tf *TrashedFile) error {
+		if !tf.Deleted.Before(cutoff) {
+			return nil
+		}
+
+		logging.S(c).Infof("Purging %q, deleted at %s, from the trash.", tf.DisplayName, tf.Deleted)
+		if err := st.removeFromTrash(tf.ID); err != nil {
+			return errors.Wrapf(err, "purging %q from the trash", tf.DisplayName)
+		}
+		return nil
+	})
+}
+
+// forEachTrashedFile calls fn for each valid file in the trash. Invalid
+// entries are ignored.
+func (st *S) forEachTrashedFile(c context.Context, fn func(*TrashedFile) error) error {
+	return util.ForEachFile(st.trashDir, func(fi os.FileInfo) error {
+		if !fi.IsDir() || filepath.Ext(fi.Name()) != fileDataExt {
+			return nil
+		}
+
+		path := filepath.Join(st.trashDir, fi.Name())
+		md, size, err := streamfile.LoadMetadataAndSize(path)
+		if err != nil {
+			logging.S(c).Debugf("Ignoring invalid trashed file %q: %s", path, err)
+			return nil
+		}
+
+		return fn(&TrashedFile{
+			DisplayName: md.Name,
+			ID:          strings.TrimSuffix(fi.Name(), fileDataExt),
+			Size:        size,
+			Deleted:     fi.ModTime(),
+		})
+	})
+}
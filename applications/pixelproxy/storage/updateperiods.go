@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danjacques/pixelproxy/util"
+
+	"github.com/pkg/errors"
+)
+
+const updatePeriodsFileName = "update_periods.json"
+
+// UpdatePeriods maps device IDs to the minimum period between playback updates
+// sent to each device.
+type UpdatePeriods map[string]time.Duration
+
+// GetUpdatePeriods returns the current device update period overrides.
+func (st *S) GetUpdatePeriods() (UpdatePeriods, error) {
+	st.updatePeriodsMu.Lock()
+	defer st.updatePeriodsMu.Unlock()
+	return st.loadUpdatePeriodsLocked()
+}
+
+// UpdateUpdatePeriods loads the current device update period overrides, calls
+// fn to modify them, and then writes them back. The written overrides are
+// returned.
+//
+// If fn returns an error, the overrides will not be written, and that error
+// will be returned.
+func (st *S) UpdateUpdatePeriods(fn func(UpdatePeriods) error) (UpdatePeriods, error) {
+	st.updatePeriodsMu.Lock()
+	defer st.updatePeriodsMu.Unlock()
+
+	up, err := st.loadUpdatePeriodsLocked()
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(up); err != nil {
+		return nil, err
+	}
+
+	path := st.updatePeriodsPath()
+	err = util.CreateViaTempMove(path, st.tempDir, "update_periods", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(up)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "writing update periods to %q", path)
+	}
+	return up, nil
+}
+
+// loadUpdatePeriodsLocked loads the device update period overrides from disk.
+//
+// updatePeriodsMu must be held by the caller.
+func (st *S) loadUpdatePeriodsLocked() (UpdatePeriods, error) {
+	up := make(UpdatePeriods)
+
+	path := st.updatePeriodsPath()
+	fd, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		// No overrides have been defined.
+		return up, nil
+	case err != nil:
+		return nil, err
+	}
+	defer func() {
+		_ = fd.Close()
+	}()
+
+	if err := json.NewDecoder(fd).Decode(&up); err != nil {
+		return nil, errors.Wrapf(err, "decoding update periods from %q", path)
+	}
+	if up == nil {
+		// The file held "null".
+		up = make(UpdatePeriods)
+	}
+	return up, nil
+}
+
+func (st *S) updatePeriodsPath() string {
+	return filepath.Join(st.Root, updatePeriodsFileName)
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/danjacques/pixelproxy/util"
+
+	"github.com/pkg/errors"
+)
+
+const zonesFileName = "zones.json"
+
+// Zones maps zone names to the IDs of the devices in each zone.
+type Zones map[string][]string
+
+// ZoneForDevice returns the name of the zone that the device with the
+// specified ID belongs to, or an empty string if it is not in a zone.
+func (z Zones) ZoneForDevice(id string) string {
+	for name, ids := range z {
+		for _, zid := range ids {
+			if zid == id {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func (z Zones) clone() Zones {
+	c := make(Zones, len(z))
+	for name, ids := range z {
+		c[name] = append([]string(nil), ids...)
+	}
+	return c
+}
+
+// GetZones returns the current zone definitions.
+//
+// The returned Zones is a copy, and may be modified by the caller.
+func (st *S) GetZones() (Zones, error) {
+	st.zonesMu.Lock()
+	defer st.zonesMu.Unlock()
+
+	z, err := st.loadZonesLocked()
+	if err != nil {
+		return nil, err
+	}
+	return z.clone(), nil
+}
+
+// UpdateZones loads the current zone definitions, calls fn to modify them, and
+// then writes them back.
+//
+// If fn returns an error, the zones will not be written, and that error will
+// be returned.
+func (st *S) UpdateZones(fn func(Zones) error) error {
+	st.zonesMu.Lock()
+	defer st.zonesMu.Unlock()
+
+	z, err := st.loadZonesLocked()
+	if err != nil {
+		return err
+	}
+	z = z.clone()
+	if err := fn(z); err != nil {
+		return err
+	}
+
+	path := st.zonesPath()
+	err = util.CreateViaTempMove(path, st.tempDir, "zones", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(z)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "writing zones to %q", path)
+	}
+	st.zones = z
+	return nil
+}
+
+// loadZonesLocked returns S's cached zones, loading them from disk if they have
+// not been loaded yet. The returned Zones must not be modified.
+//
+// zonesMu must be held by the caller.
+func (st *S) loadZonesLocked() (Zones, error) {
+	if st.zones != nil {
+		return st.zones, nil
+	}
+
+	z := make(Zones)
+
+	path := st.zonesPath()
+	fd, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		// No zones have been defined.
+	case err != nil:
+		return nil, err
+	default:
+		defer func() {
+			_ = fd.Close()
+		}()
+
+		if err := json.NewDecoder(fd).Decode(&z); err != nil {
+			return nil, errors.Wrapf(err, "decoding zones from %q", path)
+		}
+		if z == nil {
+			// The file held "null".
+			z = make(Zones)
+		}
+	}
+
+	st.zones = z
+	return z, nil
+}
+
+func (st *S) zonesPath() string {
+	return filepath.Join(st.Root, zonesFileName)
+}
@@ -0,0 +1,100 @@
+package pixelproxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+)
+
+// statusUpdateInterval is how often subscribers receive the Controller's
+// status when nothing has changed, so that continuously-changing values like
+// playback progress and recorded bytes advance.
+const statusUpdateInterval = time.Second
+
+// statusSubscribers is the set of status subscribers.
+//
+// It is notified while ctrl.mu is held, so it has its own lock rather than
+// using the Controller's.
+type statusSubscribers struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+func (ss *statusSubscribers) add() chan struct{} {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.subs == nil {
+		ss.subs = make(map[chan struct{}]struct{})
+	}
+	changed := make(chan struct{}, 1)
+	ss.subs[changed] = struct{}{}
+	return changed
+}
+
+func (ss *statusSubscribers) remove(changed chan struct{}) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.subs, changed)
+}
+
+// notify signals each subscriber that the status has changed. It does not
+// block; a subscriber that has not yet handled an earlier signal will handle
+// this change along with it.
+func (ss *statusSubscribers) notify() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for changed := range ss.subs {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe implements web.ControllerProxy.
+func (ctrl *Controller) Subscribe() (<-chan web.ControllerStatus, func()) {
+	changed := ctrl.statusSubscribers.add()
+	statusC := make(chan web.ControllerStatus, 1)
+	stopC := make(chan struct{})
+
+	go func() {
+		defer close(statusC)
+
+		t := time.NewTicker(statusUpdateInterval)
+		defer t.Stop()
+
+		for {
+			// Replace any status that the subscriber hasn't received yet, so that
+			// a slow subscriber always receives the latest one. We are the only
+			// sender, so there is then room for it.
+			st := ctrl.Status()
+			select {
+			case <-statusC:
+			default:
+			}
+			statusC <- st
+
+			select {
+			case <-stopC:
+				return
+			case <-changed:
+			case <-t.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return statusC, func() {
+		once.Do(func() {
+			ctrl.statusSubscribers.remove(changed)
+			close(stopC)
+		})
+	}
+}
+
+// notifyStatusChanged tells status subscribers that the Controller's state
+// has changed.
+func (ctrl *Controller) notifyStatusChanged() { ctrl.statusSubscribers.notify() }
@@ -0,0 +1,220 @@
+package pixelproxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/pixel"
+	"github.com/danjacques/gopushpixels/protocol/pixelpusher"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// testPatternFrameInterval is the interval between test pattern frames.
+	testPatternFrameInterval = 50 * time.Millisecond
+
+	// testPatternStripPeriod is the amount of time that each strip is lit by
+	// the TestPatternStrips pattern.
+	testPatternStripPeriod = time.Second
+)
+
+// testPatternFunc returns the state of strip n of a device with the layout pp
+// at frame.
+type testPatternFunc func(pp *pixelpusher.Device, n, frame int) *pixelpusher.StripState
+
+// testPatterns are the test patterns that can be generated, keyed by the names
+// in web.TestPatterns.
+var testPatterns = map[string]testPatternFunc{
+	web.TestPatternRed:     solidTestPattern(pixel.P{Red: 0xFF}),
+	web.TestPatternGreen:   solidTestPattern(pixel.P{Green: 0xFF}),
+	web.TestPatternBlue:    solidTestPattern(pixel.P{Blue: 0xFF}),
+	web.TestPatternWhite:   solidTestPattern(pixel.P{Red: 0xFF, Green: 0xFF, Blue: 0xFF}),
+	web.TestPatternRainbow: rainbowTestPattern,
+	web.TestPatternStrips:  stripsTestPattern,
+}
+
+func newTestPatternStrip(pp *pixelpusher.Device, n int, fn func(i int) pixel.P) *pixelpusher.StripState {
+	ss := pixelpusher.StripState{
+		StripNumber: pixelpusher.StripNumber(n),
+	}
+	ss.Pixels.Reset(int(pp.PixelsPerStrip))
+	for i := 0; i < ss.Pixels.Len(); i++ {
+		ss.Pixels.SetPixel(i, fn(i))
+	}
+	return &ss
+}
+
+// solidTestPattern sets every pixel to p.
+func solidTestPattern(p pixel.P) testPatternFunc {
+	return func(pp *pixelpusher.Device, n, frame int) *pixelpusher.StripState {
+		return newTestPatternStrip(pp, n, func(int) pixel.P { return p })
+	}
+}
+
+// rainbowTestPattern sweeps a rainbow along every strip.
+func rainbowTestPattern(pp *pixelpusher.Device, n, frame int) *pixelpusher.StripState {
+	pixels := int(pp.PixelsPerStrip)
+	return newTestPatternStrip(pp, n, func(i int) pixel.P {
+		return colorWheel(byte(i*256/pixels + frame*4))
+	})
+}
+
+// stripsTestPattern lights each strip of a device in turn, in strip order, so
+// that strip wiring can be verified. Each strip is lit in its own color.
+func stripsTestPattern(pp *pixelpusher.Device, n, frame int) *pixelpusher.StripState {
+	framesPerStrip := int(testPatternStripPeriod / testPatternFrameInterval)
+	lit := (frame / framesPerStrip) % int(pp.StripsAttached)
+
+	var p pixel.P
+	if n == lit {
+		p = identifyColors[n%len(identifyColors)]
+	}
+	return newTestPatternStrip(pp, n, func(int) pixel.P { return p })
+}
+
+// colorWheel returns a fully-saturated color for a position on the color
+// wheel, transitioning red to green to blue and back.
+func colorWheel(pos byte) pixel.P {
+	switch {
+	case pos < 85:
+		return pixel.P{Red: 255 - pos*3, Green: pos * 3}
+	case pos < 170:
+		pos -= 85
+		return pixel.P{Green: 255 - pos*3, Blue: pos * 3}
+	default:
+		pos -= 170
+		return pixel.P{Red: pos * 3, Blue: 255 - pos*3}
+	}
+}
+
+// testPatternGenerator sends a test pattern to every discovered device until it
+// is stopped.
+//
+// While it runs, it holds a lease on the ProxyManager so that proxied packets
+// don't interfere with the pattern.
+type testPatternGenerator struct {
+	ctrl    *Controller
+	pattern string
+	fn      testPatternFunc
+
+	cancelFunc context.CancelFunc
+	doneC      chan struct{}
+}
+
+func (g *testPatternGenerator) start(c context.Context) {
+	g.ctrl.ProxyManager.AddLease(g)
+
+	c, g.cancelFunc = context.WithCancel(c)
+	g.doneC = make(chan struct{})
+	go func() {
+		defer close(g.doneC)
+		g.run(c)
+	}()
+}
+
+// stop stops the generator, blocking until it has sent its last frame, and
+// releases its lease.
+func (g *testPatternGenerator) stop() {
+	g.cancelFunc()
+	<-g.doneC
+	g.ctrl.ProxyManager.RemoveLease(g)
+}
+
+func (g *testPatternGenerator) run(c context.Context) {
+	ticker := time.NewTicker(testPatternFrameInterval)
+	defer ticker.Stop()
+
+	for frame := 0; ; frame++ {
+		for _, d := range g.ctrl.DiscoveryRegistry.Devices() {
+			if !g.ctrl.playbackFilter.allows(d.ID()) {
+				continue
+			}
+			if err := g.sendFrame(d, frame); err != nil {
+				logging.S(c).Debugf("Failed to send test pattern to %q: %s", d.ID(), err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.Done():
+			return
+		}
+	}
+}
+
+func (g *testPatternGenerator) sendFrame(d device.D, frame int) error {
+	dh := d.DiscoveryHeaders()
+	pp := dh.PixelPusher
+	if pp == nil || pp.StripsAttached == 0 || pp.PixelsPerStrip == 0 {
+		return errors.New("device has no strips")
+	}
+
+	states := make([]*pixelpusher.StripState, pp.StripsAttached)
+	for i := range states {
+		states[i] = g.fn(pp, i, frame)
+	}
+	packets, err := stripStatePackets(dh, states)
+	if err != nil {
+		return err
+	}
+	for _, pkt := range packets {
+		if err := g.ctrl.route(device.InvalidOrdinal(), d.ID(), pkt); err != nil {
+			return errors.Wrapf(err, "routing packet to %q", d.ID())
+		}
+	}
+	return nil
+}
+
+// StartTestPattern implements web.ControllerProxy.
+func (ctrl *Controller) StartTestPattern(c context.Context, pattern string) error {
+	fn := testPatterns[pattern]
+	if fn == nil {
+		return errors.Wrapf(web.ErrInvalidRequest, "unknown test pattern %q", pattern)
+	}
+	if !ctrl.running() {
+		return errNotRunning
+	}
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	// Stop any current operation. The test pattern replaces it.
+	if err := ctrl.stopTaskLocked(); err != nil {
+		logging.S(c).Warnf("Error stopping current operation: %s", err)
+	}
+
+	logging.S(c).Infof("Starting test pattern %q.", pattern)
+	ctrl.testPattern = &testPatternGenerator{
+		ctrl:    ctrl,
+		pattern: pattern,
+		fn:      fn,
+	}
+	ctrl.testPattern.start(ctrl.ctx)
+	return nil
+}
+
+// StopTestPattern implements web.ControllerProxy.
+func (ctrl *Controller) StopTestPattern(c context.Context) error {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	ctrl.stopTestPatternLocked()
+	return nil
+}
+
+// stopTestPatternLocked stops the current test pattern, if one is running.
+//
+// ctrl.mu must be held by the caller.
+func (ctrl *Controller) stopTestPatternLocked() {
+	if ctrl.testPattern == nil {
+		return
+	}
+	logging.S(ctrl.ctx).Infof("Stopping test pattern %q.", ctrl.testPattern.pattern)
+	ctrl.testPattern.stop()
+	ctrl.testPattern = nil
+}
@@ -0,0 +1,122 @@
+package pixelproxy
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/pkg/errors"
+)
+
+// thumbnailMaxEvents is the maximum number of events that FileThumbnail will
+// read while looking for each device's first frame. This bounds the cost of a
+// thumbnail for files in which some strips never appear.
+const thumbnailMaxEvents = 4096
+
+// FileThumbnail implements web.ControllerProxy.
+//
+// The thumbnail is built from the first state observed for each strip of each
+// device in the file. Reading stops once every device described by the file's
+// metadata has a complete frame, or after thumbnailMaxEvents events.
+func (ctrl *Controller) FileThumbnail(c context.Context, name string) ([]web.Strip, error) {
+	switch exists, err := ctrl.Storage.HasFile(name); {
+	case err != nil:
+		return nil, err
+	case !exists:
+		return nil, web.ErrFileNotFound
+	}
+
+	sr, err := ctrl.Storage.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sr.Close(); err != nil {
+			logging.S(c).Warnf("Failed to close reader for %q: %s", name, err)
+		}
+	}()
+
+	md := sr.Metadata()
+	if md == nil {
+		return nil, nil
+	}
+
+	type deviceFrame struct {
+		want   int
+		strips map[int]web.Strip
+	}
+	frames := make(map[string]*deviceFrame, len(md.Devices))
+	remaining := 0
+	for _, d := range md.Devices {
+		if _, ok := frames[d.Id]; ok || len(d.Strip) == 0 {
+			continue
+		}
+		frames[d.Id] = &deviceFrame{
+			want:   len(d.Strip),
+			strips: make(map[int]web.Strip, len(d.Strip)),
+		}
+		remaining++
+	}
+
+	for i := 0; i < thumbnailMaxEvents && remaining > 0; i++ {
+		e, err := sr.ReadEvent()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrapf(err, "reading event #%d", i)
+		}
+
+		pkt := e.GetPacket()
+		if pkt == nil {
+			continue
+		}
+		d := sr.ResolveDeviceForIndex(pkt.Device)
+		if d == nil {
+			continue
+		}
+		df := frames[d.Id]
+		if df == nil || len(df.strips) >= df.want {
+			continue
+		}
+
+		decoded, err := pkt.Decode(d)
+		if err != nil {
+			logging.S(c).Debugf("Skipping undecodable event #%d in %q: %s", i, name, err)
+			continue
+		}
+		if decoded.PixelPusher == nil {
+			continue
+		}
+
+		for _, ss := range decoded.PixelPusher.StripStates {
+			if _, ok := df.strips[int(ss.StripNumber)]; !ok {
+				df.strips[int(ss.StripNumber)] = webStripFromState(ss)
+			}
+		}
+		if len(df.strips) >= df.want {
+			remaining--
+		}
+	}
+
+	// Assemble the strips in metadata device order, then strip order.
+	var strips []web.Strip
+	for _, d := range md.Devices {
+		df := frames[d.Id]
+		if df == nil {
+			continue
+		}
+		delete(frames, d.Id)
+
+		deviceStrips := make([]web.Strip, 0, len(df.strips))
+		for _, s := range df.strips {
+			deviceStrips = append(deviceStrips, s)
+		}
+		sort.Slice(deviceStrips, func(i, j int) bool { return deviceStrips[i].Number < deviceStrips[j].Number })
+		strips = append(strips, deviceStrips...)
+	}
+	return strips, nil
+}
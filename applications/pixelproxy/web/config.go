@@ -0,0 +1,20 @@
+package web
+
+// Config is the operational configuration that PixelProxy persists, in a form
+// that can be exported from one instance and imported into another.
+type Config struct {
+	// DefaultFile is the name of the default (auto-play) file. If empty, there
+	// is no default file.
+	DefaultFile string `yaml:"default_file,omitempty" json:"default_file,omitempty"`
+
+	// ProxyForwarding is whether proxy forwarding is enabled. If nil, an import
+	// leaves forwarding unchanged.
+	ProxyForwarding *bool `yaml:"proxy_forwarding,omitempty" json:"proxy_forwarding,omitempty"`
+
+	// Zones maps zone names to the IDs of the devices in each zone.
+	Zones map[string][]string `yaml:"zones,omitempty" json:"zones,omitempty"`
+
+	// UpdatePeriods maps device IDs to their playback update periods, written
+	// as durations (e.g., "50ms").
+	UpdatePeriods map[string]string `yaml:"update_periods,omitempty" json:"update_periods,omitempty"`
+}
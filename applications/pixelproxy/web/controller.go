@@ -2,9 +2,17 @@ package web
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"html"
 	"html/template"
+	"io"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,20 +23,123 @@ import (
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v2"
 )
 
+// ErrDeviceNotFound is returned by ControllerProxy methods when a referenced
+// device is not registered.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// ErrFileNotFound is returned by ControllerProxy methods when a referenced file
+// does not exist.
+var ErrFileNotFound = errors.New("file not found")
+
+// ErrFileProtected is returned by ControllerProxy methods when deleting a
+// protected file without forcing the deletion, or when a file would be written
+// in place of a protected file.
+var ErrFileProtected = errors.New("file is protected")
+
+// ErrFileExists is returned by ControllerProxy methods when a file would be
+// created with the name of, or in place of, an existing file.
+var ErrFileExists = errors.New("file already exists")
+
+// ErrMarkerNotFound is returned by ControllerProxy methods when a referenced
+// marker does not exist.
+var ErrMarkerNotFound = errors.New("marker not found")
+
+// ErrInsufficientSpace is returned by ControllerProxy methods when a file can't
+// be written because storage is low on free space.
+var ErrInsufficientSpace = errors.New("insufficient storage space")
+
+// DefaultLandingPage is the default page that "/" redirects to.
+const DefaultLandingPage = "/index.html"
+
+// landingPages is the set of page paths that may be used as a landing page.
+var landingPages = map[string]struct{}{
+	"/index.html":      {},
+	"/devices.html":    {},
+	"/render.html":     {},
+	"/system.html":     {},
+	"/all-logs.html":   {},
+	"/error-logs.html": {},
+}
+
+// ValidateLandingPage returns an error if v is not a page that can be used as
+// a Controller's LandingPage.
+//
+// A landing page may include a query string.
+func ValidateLandingPage(v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return errors.Wrapf(err, "invalid landing page %q", v)
+	}
+	if u.Scheme != "" || u.Host != "" {
+		return errors.Errorf("landing page %q must be a local path", v)
+	}
+	if _, ok := landingPages[u.Path]; !ok {
+		pages := make([]string, 0, len(landingPages))
+		for p := range landingPages {
+			pages = append(pages, p)
+		}
+		sort.Strings(pages)
+		return errors.Errorf("unknown landing page %q (must be one of: %s)", u.Path, strings.Join(pages, ", "))
+	}
+	return nil
+}
+
+// ErrInvalidRequest is returned by ControllerProxy methods when the supplied
+// parameters are invalid.
+var ErrInvalidRequest = errors.New("invalid request")
+
 // ControllerProxy defines a set of functions that the Controller can serve
 // from.
 type ControllerProxy interface {
 	// State returns the current state of the controller proxy.
 	Status() ControllerStatus
 
+	// Subscribe returns a channel that receives the controller proxy's status
+	// when its state changes, and periodically while it is unchanged. The
+	// channel holds only the latest status, so a slow receiver skips
+	// intermediate ones.
+	//
+	// The returned function cancels the subscription, after which the channel
+	// is closed. It must be called when the subscriber is finished.
+	Subscribe() (<-chan ControllerStatus, func())
+
 	// ListFiles returns a list of all of the files currently stored on disk.
 	ListFiles(c context.Context) (*FileList, error)
 
+	// Capabilities returns the optional features that the ControllerProxy
+	// supports. HTTP-level capabilities are filled in by the Controller.
+	Capabilities(c context.Context) *Capabilities
+
+	// StorageStatus returns the status of the storage filesystem.
+	StorageStatus(c context.Context) *StorageStatus
+
+	// SnapshotMemory returns the memory used by device snapshots. If snapshots
+	// are disabled, it returns nil.
+	SnapshotMemory(c context.Context) *SnapshotMemory
+
+	// CheckStorage checks the health of the storage filesystem without
+	// modifying it.
+	CheckStorage(c context.Context) (*StorageCheck, error)
+
+	// ExportConfig returns the current operational configuration.
+	ExportConfig(c context.Context) (*Config, error)
+
+	// ImportConfig applies an operational configuration, replacing the current
+	// one. If cfg is invalid, nothing is applied, and ImportConfig returns an
+	// error wrapping ErrInvalidRequest.
+	ImportConfig(c context.Context, cfg *Config) error
+
+	// DefaultFile returns the default (auto-play) file. If no default file is
+	// set, or if it no longer exists, DefaultFile returns nil.
+	DefaultFile(c context.Context) (*File, error)
+
 	// Devices returns a list of devices that are currently connected.
 	Devices() []*DeviceInfo
 
@@ -37,17 +148,105 @@ type ControllerProxy interface {
 
 	// Stop ends the current operation (recording or playback). If no operation
 	// is ongoing, Stop does nothing.
+	//
+	// If Stop ends a recording that could not be saved, it returns the error.
 	Stop(c context.Context) error
 
+	// AbortRecording stops the current recording and deletes the file that it
+	// was writing. If no recording is ongoing, AbortRecording does nothing.
+	AbortRecording(c context.Context) error
+
+	// AddMarker adds a marker with the specified label at the current offset of
+	// the ongoing recording, and returns it.
+	//
+	// If nothing is being recorded, or the recording is armed and has not yet
+	// started, AddMarker returns an error wrapping ErrInvalidRequest.
+	AddMarker(c context.Context, label string) (*Marker, error)
+
+	// SeekToMarker restarts the current playback at the offset of the marker
+	// with the specified label. If playback is paused, it remains paused.
+	//
+	// If nothing is playing, SeekToMarker returns an error wrapping
+	// ErrInvalidRequest. If the file has no such marker, SeekToMarker returns
+	// an error wrapping ErrMarkerNotFound.
+	SeekToMarker(c context.Context, label string) error
+
+	// SeekFile restarts the current playback at offset pos. If playback is
+	// paused, it remains paused. If pos is at or beyond the end of the file,
+	// the file is finished: playback stops, or the current playlist advances.
+	//
+	// If nothing is playing, or pos is negative, SeekFile returns an error
+	// wrapping ErrInvalidRequest.
+	SeekFile(c context.Context, pos time.Duration) error
+
+	// PlayPlaylist plays the files in entries in sequence, each with its own
+	// options. If loop is true, the playlist restarts after its last file;
+	// otherwise, playback stops. Starting any other operation ends the
+	// playlist.
+	//
+	// If entries is empty, or any of its options are invalid, PlayPlaylist
+	// returns an error wrapping ErrInvalidRequest. If any of the files does not
+	// exist, PlayPlaylist returns an error wrapping ErrFileNotFound.
+	PlayPlaylist(c context.Context, entries []PlaylistEntry, loop bool) error
+
 	// RecordFile begins recording proxied data to a File named "name".
-	RecordFile(c context.Context, name string) error
+	//
+	// Recording observes proxied traffic without blocking it: forwarding
+	// continues while recording. Any current operation is stopped first, so if
+	// playback or a test pattern was blocking forwarding, forwarding resumes.
+	//
+	// If opts.Compression is not a known compression scheme, RecordFile
+	// returns an error wrapping ErrInvalidRequest. If name is in use by a file
+	// with a different name, RecordFile returns an error wrapping
+	// ErrFileExists, and if it is protected, an error wrapping
+	// ErrFileProtected.
+	RecordFile(c context.Context, name string, opts RecordFileOpts) error
 
 	// MergeFiles merges the contents of srcs together into a new file called
-	// name.
-	MergeFiles(c context.Context, name string, srcs ...string) error
+	// name. The sources are played one after another, or, if opts.Interleave
+	// is true, together, with the merged file's devices being the union of
+	// theirs.
+	//
+	// If opts.Compression is not a known compression scheme, MergeFiles
+	// returns an error wrapping ErrInvalidRequest. If name is in use by a file
+	// with a different name, MergeFiles returns an error wrapping
+	// ErrFileExists, and if it is protected, an error wrapping
+	// ErrFileProtected.
+	MergeFiles(c context.Context, name string, opts MergeFilesOpts, srcs ...string) error
+
+	// PlanMerge validates req and returns a summary of the file that it would
+	// produce, without writing anything.
+	//
+	// If req is invalid, PlanMerge returns an error wrapping ErrInvalidRequest.
+	PlanMerge(c context.Context, req *MergeRequest) (*MergePlan, error)
+
+	// StartTestPattern stops any current operation, and begins sending the
+	// named test pattern (see TestPatterns) to all devices until it is
+	// stopped. If the pattern is unknown, StartTestPattern returns an error
+	// wrapping ErrInvalidRequest.
+	StartTestPattern(c context.Context, pattern string) error
+
+	// StopTestPattern stops the current test pattern. If no test pattern is
+	// running, StopTestPattern does nothing.
+	StopTestPattern(c context.Context) error
 
 	// PlayFile begins the playback of the named file through the proxy.
-	PlayFile(c context.Context, name string) error
+	PlayFile(c context.Context, name string, opts PlayFileOpts) error
+
+	// SetPlaybackRate changes the speed of the current playback, continuing
+	// from its current position. A rate that is not >0 is the same as 1.
+	//
+	// If nothing is playing, SetPlaybackRate returns an error wrapping
+	// ErrInvalidRequest.
+	SetPlaybackRate(c context.Context, rate float64) error
+
+	// SetPlaybackMaxLagAge sets the maximum lag that playback tolerates before
+	// dropping packets. If a file is playing, its playback is restarted from its
+	// current position in order to apply it.
+	//
+	// If maxLagAge is negative, SetPlaybackMaxLagAge returns an error wrapping
+	// ErrInvalidRequest.
+	SetPlaybackMaxLagAge(c context.Context, maxLagAge time.Duration) error
 
 	// PauseFile pauses the currently-playing file. If nothing is currently
 	// playing, PauseFile will return nil.
@@ -57,15 +256,213 @@ type ControllerProxy interface {
 	// playing, ResumeFile will return nil.
 	ResumeFile(c context.Context) error
 
-	// DeleteFile deletes the file with the specified name.
-	DeleteFile(c context.Context, name string) error
+	// TogglePause pauses the currently-playing file if it is playing, and
+	// resumes it if it is paused. It returns true if the file is now paused.
+	//
+	// If nothing is playing, TogglePause returns ErrInvalidRequest.
+	TogglePause(c context.Context) (bool, error)
+
+	// DeleteFile deletes the file with the specified name. If the trash is in
+	// use, the file is moved into it, and can be restored with RestoreFile.
+	//
+	// If the file is protected and force is false, DeleteFile returns
+	// ErrFileProtected.
+	DeleteFile(c context.Context, name string, force bool) error
+
+	// ListTrash lists the deleted files that are held in the trash. If the
+	// trash is not in use, it is empty.
+	ListTrash(c context.Context) ([]*TrashedFile, error)
+
+	// RestoreFile restores the named file from the trash.
+	//
+	// If the file is not in the trash, RestoreFile returns ErrFileNotFound. If
+	// its name is in use by a stored file, RestoreFile returns ErrFileExists.
+	RestoreFile(c context.Context, name string) error
+
+	// DeleteAllFiles stops the current operation, then deletes every file other
+	// than the default file and protected files.
+	//
+	// A file that fails to delete is recorded in the result, and the remaining
+	// files are still deleted.
+	DeleteAllFiles(c context.Context) (*DeleteAllResult, error)
+
+	// SetFileProtected protects or unprotects the file with the specified name.
+	// A protected file cannot be deleted unless the deletion is forced.
+	//
+	// If the file does not exist, SetFileProtected returns ErrFileNotFound.
+	SetFileProtected(c context.Context, name string, protected bool) error
+
+	// MigrateFile rewrites the file with the specified name using the current
+	// stream format.
+	MigrateFile(c context.Context, name string) error
+
+	// RenameFile renames the file oldName to newName. The file's annotations,
+	// and its default file status, are retained.
+	//
+	// If oldName does not exist, RenameFile returns ErrFileNotFound. If newName
+	// is already in use, RenameFile returns ErrFileExists. A file cannot be
+	// renamed while it is being recorded or played, nor to the name of the file
+	// being recorded.
+	RenameFile(c context.Context, oldName, newName string) error
+
+	// CopyFile copies the file src to a new file named dest.
+	//
+	// If src does not exist, CopyFile returns ErrFileNotFound. If dest is
+	// already in use, CopyFile returns ErrFileExists.
+	CopyFile(c context.Context, src, dest string) error
 
 	// Strips returns a snapshot of the strips for the specified device.
-	Strips(c context.Context, device string) ([]Strip, error)
+	//
+	// If the device has a snapshot downsample factor, the returned strips are
+	// downsampled accordingly.
+	//
+	// Snapshots are sampled periodically, so they may lag the device's state by
+	// up to the sample interval. If fresh is true, each strip instead reflects
+	// the latest packet forwarded to the device.
+	Strips(c context.Context, device string, fresh bool) ([]Strip, error)
+
+	// SetSnapshotDownsample sets the factor by which the specified device's
+	// snapshots are downsampled. Each group of factor pixels is averaged into a
+	// single pixel. A factor of 1 restores full resolution.
+	//
+	// If the device is not registered, SetSnapshotDownsample returns
+	// ErrDeviceNotFound. If factor is < 1, SetSnapshotDownsample returns an
+	// error wrapping ErrInvalidRequest.
+	SetSnapshotDownsample(c context.Context, device string, factor int) error
+
+	// DeviceUpdatePeriods returns the playback update period overrides, keyed
+	// by device ID.
+	DeviceUpdatePeriods(c context.Context) (map[string]time.Duration, error)
+
+	// SetDeviceUpdatePeriod sets the minimum period between playback updates
+	// sent to the specified device. A period of 0 removes the override.
+	//
+	// The device need not be registered. If period is negative,
+	// SetDeviceUpdatePeriod returns an error wrapping ErrInvalidRequest.
+	SetDeviceUpdatePeriod(c context.Context, device string, period time.Duration) error
+
+	// SetNoRoutePolicy sets the policy for playback packets that can't be
+	// routed to their device: "drop", "log", or "catchall". The "catchall"
+	// policy sends them to the catchAll device, which is otherwise ignored.
+	//
+	// If the policy is unknown, or catchAll is required and empty,
+	// SetNoRoutePolicy returns an error wrapping ErrInvalidRequest.
+	SetNoRoutePolicy(c context.Context, policy, catchAll string) error
+
+	// OpenFile opens the stored data of the named file for download, as a tar
+	// archive, and returns it along with the file's display name. The caller
+	// must close the returned reader.
+	//
+	// If the file does not exist, OpenFile returns ErrFileNotFound. A file
+	// cannot be opened while it is being recorded.
+	OpenFile(c context.Context, name string) (io.ReadCloser, string, error)
+
+	// ImportFile stores a file previously downloaded with OpenFile, read from
+	// r, and returns the name that it was stored as.
+	//
+	// If name is empty, the file's own name is used. If the name is in use, a
+	// numbered variant of it is chosen. If r is not a valid file, ImportFile
+	// returns ErrInvalidRequest.
+	ImportFile(c context.Context, r io.Reader, name string) (string, error)
+
+	// FileThumbnail returns the strips of the first frame of the named file,
+	// for all of the file's devices.
+	//
+	// If the file does not exist, FileThumbnail returns ErrFileNotFound.
+	FileThumbnail(c context.Context, name string) ([]Strip, error)
+
+	// AnalyzeFile reads the named file and reports its peak packet rates,
+	// and whether it can be played in realtime within the configured packet
+	// budgets.
+	//
+	// If the file does not exist, AnalyzeFile returns ErrFileNotFound.
+	AnalyzeFile(c context.Context, name string) (*FileAnalysis, error)
+
+	// TestDevice sends a single frame which sets every pixel on the specified
+	// device to color.
+	//
+	// If the device is not registered, TestDevice returns ErrDeviceNotFound.
+	TestDevice(c context.Context, device string, color Pixel) error
+
+	// IdentifyDevice blinks the specified device through a distinctive color
+	// pattern for a few seconds, then restores its last snapshot, if there is
+	// one. Playback output to the device is withheld while it blinks.
+	// IdentifyDevice returns immediately.
+	//
+	// If the device is not registered, IdentifyDevice returns
+	// ErrDeviceNotFound. If the device is already being identified,
+	// IdentifyDevice returns an error wrapping ErrInvalidRequest.
+	IdentifyDevice(c context.Context, device string) error
+
+	// ForgetDevice immediately removes the specified device, as if it had
+	// expired.
+	//
+	// If the device is not registered, ForgetDevice returns ErrDeviceNotFound.
+	ForgetDevice(c context.Context, device string) error
+
+	// DeviceHeaders returns the full discovery headers that the specified
+	// device is advertising. The returned value is suitable for JSON encoding.
+	//
+	// If the device is not registered, DeviceHeaders returns ErrDeviceNotFound.
+	DeviceHeaders(c context.Context, device string) (interface{}, error)
+
+	// ResetDeviceCounters resets the sent and received counters reported for
+	// the specified device. If device is empty, all devices' counters are
+	// reset.
+	//
+	// If the device is not registered, ResetDeviceCounters returns
+	// ErrDeviceNotFound.
+	ResetDeviceCounters(c context.Context, device string) error
+
+	// Zones returns all of the defined zones, ordered by name.
+	Zones(c context.Context) ([]*Zone, error)
+
+	// SetZone creates or replaces the zone with zone's name.
+	//
+	// If zone is invalid, or if any of its devices already belongs to a
+	// different zone, SetZone returns an error wrapping ErrInvalidRequest.
+	SetZone(c context.Context, zone *Zone) error
+
+	// DeleteZone deletes the named zone.
+	//
+	// If the zone is not defined, DeleteZone returns ErrZoneNotFound.
+	DeleteZone(c context.Context, name string) error
+
+	// SetZoneBrightness sets the global brightness of each registered device in
+	// the named zone to level, a percentage between 0 and 100.
+	//
+	// If the zone is not defined, SetZoneBrightness returns ErrZoneNotFound. If
+	// level is out of range, it returns an error wrapping ErrInvalidRequest.
+	SetZoneBrightness(c context.Context, name string, level int) (*ZoneCommandResult, error)
+
+	// BlackoutZone blacks out each registered device in the named zone.
+	//
+	// If the zone is not defined, BlackoutZone returns ErrZoneNotFound.
+	BlackoutZone(c context.Context, name string) (*ZoneCommandResult, error)
+
+	// SoloDevice causes playback packets to be sent only to the specified
+	// device. If blackout is true, all other devices are blacked out.
+	//
+	// If device is empty, solo is cleared and all devices receive playback.
+	// Otherwise, if the device is not registered, SoloDevice returns
+	// ErrDeviceNotFound.
+	SoloDevice(c context.Context, device string, blackout bool) error
+
+	// SetDeviceMuted mutes or unmutes playback to the specified device. Playback
+	// packets are not sent to a muted device, and it is blacked out when it is
+	// muted. Other devices are unaffected.
+	//
+	// If the device is not registered, SetDeviceMuted returns
+	// ErrDeviceNotFound.
+	SetDeviceMuted(c context.Context, device string, muted bool) error
 
 	// SetProxyorwarding enables or disables the proxy packet forwarding.
 	SetProxyForwarding(c context.Context, forward bool) error
 
+	// SetDiscoveryBroadcast pauses or resumes the discovery broadcast for proxy
+	// devices.
+	SetDiscoveryBroadcast(c context.Context, broadcast bool) error
+
 	// SetDefaultFile sets the default (auto-play) file name.
 	//
 	// If name is empty, this clears the default file if one is set.
@@ -95,9 +492,27 @@ type Controller struct {
 	Logger *zap.Logger
 
 	// RenderRefreshInterval, if > 0, is the automatic refresh interval that will
-	// be pushed to the device preview render page.
+	// be pushed to the device preview render page. Clients may override it
+	// with the page's "refresh" parameter.
 	RenderRefreshInterval time.Duration
 
+	// TLS is true if the Controller is being served over TLS. It is used only
+	// to report capabilities.
+	TLS bool
+
+	// AllowTemplateReload, if true, installs an API endpoint that rebuilds the
+	// site's templates, even if they are cached. This is intended for
+	// development of the site's templates.
+	AllowTemplateReload bool
+
+	// LandingPage, if not empty, is the page that "/" redirects to. It must pass
+	// ValidateLandingPage. If empty, DefaultLandingPage will be used.
+	LandingPage string
+
+	// MaxUploadBytes, if >0, is the maximum size of a file upload request.
+	// Larger uploads are rejected.
+	MaxUploadBytes int64
+
 	// site is the underlying site.
 	site *web.Site
 }
@@ -108,6 +523,14 @@ type Controller struct {
 //
 // The specified Context, c, will be used by each Request handler.
 func (cont *Controller) Install(c context.Context, r *mux.Router) error {
+	landingPage := cont.LandingPage
+	if landingPage == "" {
+		landingPage = DefaultLandingPage
+	}
+	if err := ValidateLandingPage(landingPage); err != nil {
+		return err
+	}
+
 	// Instantiate our base Site.
 	cont.site = &web.Site{
 		Logger: cont.Logger,
@@ -147,18 +570,21 @@ func (cont *Controller) Install(c context.Context, r *mux.Router) error {
 		monitorMW.Middleware,
 
 		// Compress our responses.
-		gziphandler.GzipHandler,
+		skipForStreams(gziphandler.GzipHandler),
 
 		// Minify our response data, if possible.
-		web.DefaultMinifier().Middleware,
+		skipForStreams(web.DefaultMinifier().Middleware),
 	)
 
 	// Set up API routes.
 	apiRouter := r.PathPrefix("/_api").Subrouter()
 	cont.addAPIRoutes(apiRouter)
+	if cont.AllowTemplateReload {
+		apiRouter.Path("/reloadTemplates").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIReloadTemplates))
+	}
 
 	r.Path("/").HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		http.Redirect(rw, req, "/index.html", http.StatusFound)
+		http.Redirect(rw, req, landingPage, http.StatusFound)
 	})
 	r.Path("/index.html").HandlerFunc(cont.handleIndexTemplate)
 	r.Path("/devices.html").HandlerFunc(cont.handleDevicesTemplate("templates/devices.html"))
@@ -167,6 +593,8 @@ func (cont *Controller) Install(c context.Context, r *mux.Router) error {
 	r.Path("/all-logs.html").HandlerFunc(cont.handleAllLogsTemplate)
 	r.Path("/error-logs.html").HandlerFunc(cont.handleErrorLogsTemplate)
 	r.Path("/strips/{device}.svg").Methods("GET").HandlerFunc(cont.handleStripSVG)
+	r.Path("/download/{name}").Methods("GET").HandlerFunc(cont.handleDownload)
+	r.Path("/upload").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleUpload))
 	r.PathPrefix("/bs").Handler(http.FileServer(bootstrap.Bundle.Box))
 	r.PathPrefix("/").Handler(http.FileServer(assets.WWW.Box))
 
@@ -175,22 +603,89 @@ func (cont *Controller) Install(c context.Context, r *mux.Router) error {
 
 func (cont *Controller) addAPIRoutes(r *mux.Router) {
 	r.Path("/status").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPIStatus))
+	r.Path("/status.min").Methods("GET").HandlerFunc(cont.handleAPIStatusMin)
+	r.Path("/statusStream").Methods("GET").HandlerFunc(cont.handleAPIStatusStream)
+	r.Path("/events").Methods("GET").HandlerFunc(cont.handleAPIEvents)
+	r.Path("/playback").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPIPlaybackTimeline))
 	r.Path("/listFiles").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPIListFiles))
+	r.Path("/capabilities").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPICapabilities))
+	r.Path("/storage").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPIStorageStatus))
+	r.Path("/snapshots").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPISnapshotMemory))
+	r.Path("/storage/check").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPICheckStorage))
+	r.Path("/exportConfig").Methods("GET").HandlerFunc(cont.handleAPIExportConfig)
+	r.Path("/importConfig").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIImportConfig))
+	r.Path("/default").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPIDefaultFile))
 	r.Path("/recordFile/{name}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIRecordFile))
+	r.Path("/merge").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIMerge))
+	r.Path("/previewMerge").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIPreviewMerge))
 	r.Path("/mergeFiles/{name}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIMergeFiles))
 	r.Path("/playFile/{name}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIPlayFile))
+	r.Path("/playlist").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIPlayPlaylist))
+	r.Path("/maxLagAge/{duration}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPISetMaxLagAge))
+	r.Path("/setRate").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPISetRate))
 	r.Path("/pause").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIPause))
 	r.Path("/resume").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIResume))
+	r.Path("/transport").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPITransport))
 	r.Path("/deleteFile/{name}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIDeleteFile))
+	r.Path("/deleteAll").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIDeleteAllFiles))
+	r.Path("/trash").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPIListTrash))
+	r.Path("/restore/{name}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIRestoreFile))
+	r.Path("/file/{name}/protect").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIProtectFile))
+	r.Path("/file/{name}/unprotect").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIUnprotectFile))
+	r.Path("/migrateFile/{name}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIMigrateFile))
+	r.Path("/renameFile/{name}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIRenameFile))
+	r.Path("/copyFile/{name}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPICopyFile))
 	r.Path("/setDefault/{name}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPISetDefaultFile))
 	r.Path("/clearDefault").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIClearDefaultFile))
+	r.Path("/abortRecording").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIAbortRecording))
+	r.Path("/marker").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIAddMarker))
+	r.Path("/seekMarker/{label}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPISeekMarker))
+	r.Path("/seek").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPISeek))
 	r.Path("/stop").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIStop))
+	// (Must be registered before "/testPattern/{pattern}".)
+	r.Path("/testPattern/stop").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIStopTestPattern))
+	r.Path("/testPattern/{pattern}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIStartTestPattern))
 	r.Path("/proxyForwarding/enable").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIEnableProxyForwarding))
 	r.Path("/proxyForwarding/disable").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIDisableProxyForwarding))
+	r.Path("/discovery/pause").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIPauseDiscovery))
+	r.Path("/discovery/resume").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIResumeDiscovery))
+	r.Path("/device/{id}/test").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPITestDevice))
+	r.Path("/device/{id}/identify").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIIdentifyDevice))
+	r.Path("/device/{id}/forget").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIForgetDevice))
+	r.Path("/device/{id}/solo").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPISoloDevice))
+	r.Path("/device/{id}/mute").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIMuteDevice))
+	r.Path("/device/{id}/snapshotDownsample/{factor}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPISetSnapshotDownsample))
+	r.Path("/device/{id}/updatePeriod/{duration}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPISetDeviceUpdatePeriod))
+	r.Path("/noRoutePolicy/{policy}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPISetNoRoutePolicy))
+	r.Path("/updatePeriods").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPIDeviceUpdatePeriods))
+	r.Path("/device/{id}/headers").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPIDeviceHeaders))
+	r.Path("/device/{id}/resetCounters").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIResetDeviceCounters))
+	r.Path("/devices/resetCounters").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIResetAllDeviceCounters))
+	r.Path("/zones").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPIListZones))
+	r.Path("/zone/{zone}").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPIGetZone))
+	r.Path("/zone/{zone}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPISetZone))
+	r.Path("/zone/{zone}/delete").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIDeleteZone))
+	r.Path("/zone/{zone}/brightness/{level}").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIZoneBrightness))
+	r.Path("/zone/{zone}/blackout").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIBlackoutZone))
+	r.Path("/solo/clear").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIClearSolo))
+	r.Path("/analyzeFile/{name}").Methods("GET").HandlerFunc(web.HandleJSON(cont.handleAPIAnalyzeFile))
+	r.Path("/fileThumbnail/{name}.png").Methods("GET").HandlerFunc(cont.handleAPIFileThumbnail)
+	r.Path("/logs/download").Methods("GET").HandlerFunc(cont.handleAPILogsDownload)
 	r.Path("/system/reboot").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIReboot))
 	r.Path("/system/shutdown").Methods("POST").HandlerFunc(web.HandleJSON(cont.handleAPIShutdown))
 }
 
+func (cont *Controller) handleAPIReloadTemplates(rw http.ResponseWriter, req *http.Request) interface{} {
+	if err := cont.site.ReloadTemplates(); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to reload templates: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	cont.Logger.Sugar().Infof("Reloaded web templates.")
+	return nil
+}
+
 func (cont *Controller) handleAPIStatus(rw http.ResponseWriter, req *http.Request) interface{} {
 	return Status{
 		Status:  cont.Proxy.Status(),
@@ -198,182 +693,1667 @@ func (cont *Controller) handleAPIStatus(rw http.ResponseWriter, req *http.Reques
 	}
 }
 
-func (cont *Controller) handleAPIListFiles(rw http.ResponseWriter, req *http.Request) interface{} {
+// statusStreamMinInterval is the minimum interval between statuses sent by
+// a status event stream. Updates that arrive more quickly are coalesced.
+const statusStreamMinInterval = 250 * time.Millisecond
+
+// statusStreamHeartbeat is how often a status stream sends a heartbeat, even
+// if there is nothing else to send, so that idle connections are not closed by
+// intermediate proxies.
+const statusStreamHeartbeat = 15 * time.Second
+
+// statusStreamWriteTimeout bounds each write to a status WebSocket, so that a
+// client that stops reading can't hold its connection open indefinitely.
+const statusStreamWriteTimeout = 10 * time.Second
+
+// statusStreamUpgrader upgrades status stream requests to WebSockets. Its
+// default origin check rejects cross-origin requests.
+var statusStreamUpgrader = websocket.Upgrader{}
+
+// streamingRoutes are the paths of routes that stream their responses. They
+// bypass buffering middleware.
+var streamingRoutes = map[string]struct{}{
+	"/_api/statusStream": {},
+	"/_api/events":       {},
+}
+
+// handleAPIStatusStream upgrades the request to a WebSocket, and sends the
+// client a JSON-encoded Status message as the ControllerProxy's state changes.
+func (cont *Controller) handleAPIStatusStream(rw http.ResponseWriter, req *http.Request) {
+	c := req.Context()
+	conn, err := statusStreamUpgrader.Upgrade(rw, req, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		logging.S(c).Debugf("Could not upgrade status stream: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	statusC, cancel := cont.Proxy.Subscribe()
+	defer cancel()
+
+	// The client has nothing to send, but the connection must be read to
+	// process control messages, and to notice when the client goes away.
+	conn.SetReadLimit(512)
+	closedC := make(chan struct{})
+	go func() {
+		defer close(closedC)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(statusStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Done():
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+				time.Now().Add(statusStreamWriteTimeout))
+			return
+
+		case <-closedC:
+			return
+
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(statusStreamWriteTimeout)); err != nil {
+				logging.S(c).Debugf("Status stream closed: %s", err)
+				return
+			}
+			continue
+
+		case st, ok := <-statusC:
+			if !ok {
+				return
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(statusStreamWriteTimeout))
+			err := conn.WriteJSON(Status{
+				Status:  st,
+				Devices: cont.Proxy.Devices(),
+			})
+			if err != nil {
+				logging.S(c).Debugf("Status stream closed: %s", err)
+				return
+			}
+		}
+
+		// Wait before taking the next status, so that any updates in the
+		// meantime are coalesced into it.
+		select {
+		case <-c.Done():
+			return
+		case <-closedC:
+			return
+		case <-time.After(statusStreamMinInterval):
+		}
+	}
+}
+
+// handleAPIEvents streams ControllerStatus snapshots to the client as
+// Server-Sent Events, periodically and on state transitions. It returns when
+// the client disconnects.
+func (cont *Controller) handleAPIEvents(rw http.ResponseWriter, req *http.Request) {
+	c := req.Context()
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	statusC, cancel := cont.Proxy.Subscribe()
+	defer cancel()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(statusStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Done():
+			return
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(rw, ": heartbeat\n\n"); err != nil {
+				logging.S(c).Debugf("Status stream closed: %s", err)
+				return
+			}
+			flusher.Flush()
+			continue
+
+		case st, ok := <-statusC:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(st)
+			if err != nil {
+				logging.S(c).Errorf("Failed to encode status: %s", err)
+				return
+			}
+			if _, err := fmt.Fprintf(rw, "data: %s\n\n", data); err != nil {
+				logging.S(c).Debugf("Status stream closed: %s", err)
+				return
+			}
+			flusher.Flush()
+		}
+
+		// Wait before taking the next status, so that any updates in the
+		// meantime are coalesced into it.
+		select {
+		case <-c.Done():
+			return
+		case <-time.After(statusStreamMinInterval):
+		}
+	}
+}
+
+// skipForStreams returns a middleware that applies mw, except to streaming
+// routes. Those must be flushed as they are written, or hijacked, both of
+// which buffering middleware prevents.
+func skipForStreams(mw mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if _, ok := streamingRoutes[req.URL.Path]; ok {
+				next.ServeHTTP(rw, req)
+				return
+			}
+			wrapped.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// handleAPIStatusMin serves a terse, line-oriented "key=value" status for
+// constrained clients. It contains the state ("idle", "playing", "paused", or
+// "recording"), the playback progress percentage, and the number of connected
+// devices.
+func (cont *Controller) handleAPIStatusMin(rw http.ResponseWriter, req *http.Request) {
+	st := cont.Proxy.Status()
+	tl := timelineFromStatus(&st)
+
+	// Count only discovered devices, not the proxies that we expose for them.
+	devices := 0
+	for _, d := range cont.Proxy.Devices() {
+		if d.ProxiedID == "" {
+			devices++
+		}
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-cache")
+	_, _ = fmt.Fprintf(rw, "state=%s\nprogress=%d\ndevices=%d\n", tl.State, tl.Progress, devices)
+}
+
+func (cont *Controller) handleAPIPlaybackTimeline(rw http.ResponseWriter, req *http.Request) interface{} {
+	st := cont.Proxy.Status()
+	return timelineFromStatus(&st)
+}
+
+func (cont *Controller) handleAPIListFiles(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	files, err := cont.Proxy.ListFiles(c)
+	if err != nil {
+		cont.Logger.Sugar().Errorf("Failed to list files: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return files
+}
+
+func (cont *Controller) handleAPIListTrash(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	files, err := cont.Proxy.ListTrash(c)
+	if err != nil {
+		cont.Logger.Sugar().Errorf("Failed to list trash: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return files
+}
+
+func (cont *Controller) handleAPIRestoreFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'name'")
+	}
+
+	switch err := cont.Proxy.RestoreFile(c, name); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrFileNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	case ErrFileExists:
+		rw.WriteHeader(http.StatusConflict)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to restore %q: %s", name, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPICapabilities(rw http.ResponseWriter, req *http.Request) interface{} {
+	caps := cont.Proxy.Capabilities(req.Context())
+	caps.HTTPS = cont.TLS
+	caps.TemplateReload = cont.AllowTemplateReload
+	return caps
+}
+
+func (cont *Controller) handleAPIStorageStatus(rw http.ResponseWriter, req *http.Request) interface{} {
+	return cont.Proxy.StorageStatus(req.Context())
+}
+
+func (cont *Controller) handleAPISnapshotMemory(rw http.ResponseWriter, req *http.Request) interface{} {
+	return cont.Proxy.SnapshotMemory(req.Context())
+}
+
+func (cont *Controller) handleAPICheckStorage(rw http.ResponseWriter, req *http.Request) interface{} {
+	sc, err := cont.Proxy.CheckStorage(req.Context())
+	if err != nil {
+		cont.Logger.Sugar().Errorf("Failed to check storage: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+	return sc
+}
+
+func (cont *Controller) handleAPIExportConfig(rw http.ResponseWriter, req *http.Request) {
+	c := req.Context()
+	cfg, err := cont.Proxy.ExportConfig(c)
+	if err != nil {
+		cont.Logger.Sugar().Errorf("Failed to export configuration: %s", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		cont.Logger.Sugar().Errorf("Failed to encode configuration: %s", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/x-yaml")
+	rw.Header().Set("Content-Disposition", `attachment; filename="pixelproxy-config.yaml"`)
+	if _, err := rw.Write(data); err != nil {
+		logging.S(c).Warnf("Failed to write configuration export: %s", err)
+	}
+}
+
+func (cont *Controller) handleAPIImportConfig(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+
+	var cfg Config
+	if err := yaml.NewDecoder(req.Body).Decode(&cfg); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.Wrap(err, "invalid configuration")
+	}
+
+	switch err := cont.Proxy.ImportConfig(c, &cfg); errors.Cause(err) {
+	case nil:
+		return &cfg
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to import configuration: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPIDefaultFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	f, err := cont.Proxy.DefaultFile(c)
+	if err != nil {
+		cont.Logger.Sugar().Errorf("Failed to load default file: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return f
+}
+
+func (cont *Controller) handleAPIRecordFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'name'")
+	}
+
+	opts := RecordFileOpts{
+		Compression:      req.FormValue("compression"),
+		CompressionLevel: -1,
+		Note:             req.FormValue("note"),
+	}
+	if v := req.FormValue("compression_level"); v != "" {
+		level, err := strconv.Atoi(v)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'compression_level' %q", v)
+		}
+		opts.CompressionLevel = level
+	}
+	if v := req.FormValue("armed"); v != "" {
+		var err error
+		if opts.Armed, err = strconv.ParseBool(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'armed' %q", v)
+		}
+	}
+	if v := req.FormValue("dedup"); v != "" {
+		var err error
+		if opts.Deduplicate, err = strconv.ParseBool(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'dedup' %q", v)
+		}
+	}
+	if v := req.FormValue("max_packet_bytes"); v != "" {
+		var err error
+		if opts.MaxPacketBytes, err = strconv.Atoi(v); err != nil || opts.MaxPacketBytes < 0 {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Errorf("invalid 'max_packet_bytes' %q", v)
+		}
+	}
+	if v := req.FormValue("strict"); v != "" {
+		var err error
+		if opts.StrictPackets, err = strconv.ParseBool(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'strict' %q", v)
+		}
+	}
+	if v := req.FormValue("max_bytes"); v != "" {
+		var err error
+		if opts.MaxBytes, err = strconv.ParseInt(v, 10, 64); err != nil || opts.MaxBytes < 0 {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Errorf("invalid 'max_bytes' %q", v)
+		}
+	}
+	if v := req.FormValue("max_duration"); v != "" {
+		var err error
+		if opts.MaxDuration, err = time.ParseDuration(v); err != nil || opts.MaxDuration < 0 {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Errorf("invalid 'max_duration' %q", v)
+		}
+	}
+	if v := req.FormValue("segment_bytes"); v != "" {
+		var err error
+		if opts.SegmentBytes, err = strconv.ParseInt(v, 10, 64); err != nil || opts.SegmentBytes < 0 {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Errorf("invalid 'segment_bytes' %q", v)
+		}
+	}
+	if v := req.FormValue("segment_duration"); v != "" {
+		var err error
+		if opts.SegmentDuration, err = time.ParseDuration(v); err != nil || opts.SegmentDuration < 0 {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Errorf("invalid 'segment_duration' %q", v)
+		}
+	}
+
+	switch err := cont.Proxy.RecordFile(c, name, opts); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	case ErrFileExists, ErrFileProtected:
+		rw.WriteHeader(http.StatusConflict)
+		return err
+	case ErrInsufficientSpace:
+		rw.WriteHeader(http.StatusInsufficientStorage)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to record: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPIMergeFiles(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+
+	// Destination file name (path parameter).
+	name := vars["name"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'name'")
+	}
+
+	// Grab source names from (potentially repeating) query string.
+	srcs := req.URL.Query()["src"]
+
+	opts := MergeFilesOpts{
+		Compression:      req.FormValue("compression"),
+		CompressionLevel: -1,
+	}
+	if v := req.FormValue("compression_level"); v != "" {
+		level, err := strconv.Atoi(v)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'compression_level' %q", v)
+		}
+		opts.CompressionLevel = level
+	}
+	if v := req.FormValue("interleave"); v != "" {
+		var err error
+		if opts.Interleave, err = strconv.ParseBool(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'interleave' %q", v)
+		}
+	}
+
+	switch err := cont.Proxy.MergeFiles(c, name, opts, srcs...); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	case ErrFileExists, ErrFileProtected:
+		rw.WriteHeader(http.StatusConflict)
+		return err
+	case ErrInsufficientSpace:
+		rw.WriteHeader(http.StatusInsufficientStorage)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to merge: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+// handleAPIMerge merges files described by a JSON MergeRequest body, and
+// returns the MergePlan. If the request is a dry run, the plan is returned
+// without merging.
+func (cont *Controller) handleAPIMerge(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+
+	mr, plan, err := cont.planMerge(rw, req)
+	if err != nil {
+		return err
+	}
+	if mr.DryRun {
+		return plan
+	}
+
+	switch err := cont.Proxy.MergeFiles(c, plan.Name, mr.Opts(), plan.Sources...); errors.Cause(err) {
+	case nil:
+		return plan
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	case ErrFileExists, ErrFileProtected:
+		rw.WriteHeader(http.StatusConflict)
+		return err
+	case ErrInsufficientSpace:
+		rw.WriteHeader(http.StatusInsufficientStorage)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to merge: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+// handleAPIPreviewMerge returns the MergePlan for a JSON MergeRequest body
+// without merging, regardless of the request's DryRun field.
+func (cont *Controller) handleAPIPreviewMerge(rw http.ResponseWriter, req *http.Request) interface{} {
+	_, plan, err := cont.planMerge(rw, req)
+	if err != nil {
+		return err
+	}
+	return plan
+}
+
+// planMerge decodes a JSON MergeRequest from req's body and plans it. On
+// error, the response status has been written.
+func (cont *Controller) planMerge(rw http.ResponseWriter, req *http.Request) (*MergeRequest, *MergePlan, error) {
+	var mr MergeRequest
+	if err := json.NewDecoder(req.Body).Decode(&mr); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return nil, nil, errors.Wrap(err, "invalid merge request")
+	}
+
+	plan, err := cont.Proxy.PlanMerge(req.Context(), &mr)
+	if err != nil {
+		if errors.Cause(err) == ErrInvalidRequest {
+			rw.WriteHeader(http.StatusBadRequest)
+			return nil, nil, err
+		}
+		cont.Logger.Sugar().Errorf("Failed to plan merge: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, err
+	}
+	return &mr, plan, nil
+}
+
+func (cont *Controller) handleAPIPlayFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'name'")
+	}
+
+	var opts PlayFileOpts
+	if v := req.FormValue("loop_gap"); v != "" {
+		var err error
+		if opts.LoopGap, err = time.ParseDuration(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'loop_gap' %q", v)
+		}
+	}
+	if v := req.FormValue("loop_gap_blackout"); v != "" {
+		var err error
+		if opts.LoopGapBlackout, err = strconv.ParseBool(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'loop_gap_blackout' %q", v)
+		}
+	}
+	if v := req.FormValue("rounds"); v != "" {
+		var err error
+		if opts.MaxRounds, err = strconv.ParseInt(v, 10, 64); err != nil || opts.MaxRounds < 0 {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Errorf("invalid 'rounds' %q", v)
+		}
+	}
+	if v := req.FormValue("rate"); v != "" {
+		var err error
+		if opts.PlaybackRate, err = parsePlaybackRate(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return err
+		}
+	}
+
+	switch err := cont.Proxy.PlayFile(c, name, opts); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInsufficientSpace:
+		rw.WriteHeader(http.StatusInsufficientStorage)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to play %q: %s", name, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPISetRate(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	v := req.FormValue("rate")
+	if v == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'rate'")
+	}
+
+	rate, err := parsePlaybackRate(v)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+
+	switch err := cont.Proxy.SetPlaybackRate(c, rate); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	case ErrInsufficientSpace:
+		rw.WriteHeader(http.StatusInsufficientStorage)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to set playback rate: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+// parsePlaybackRate parses a 'rate' parameter. A rate that is not >0 means
+// the recorded speed, and is returned as 1.
+func parsePlaybackRate(v string) (float64, error) {
+	rate, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return 0, errors.Errorf("invalid 'rate' %q", v)
+	}
+	if rate <= 0 {
+		rate = 1
+	}
+	return rate, nil
+}
+
+func (cont *Controller) handleAPISetMaxLagAge(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	v := vars["duration"]
+	if v == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'duration'")
+	}
+
+	maxLagAge, err := time.ParseDuration(v)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.Wrapf(err, "invalid 'duration' %q", v)
+	}
+
+	switch err := cont.Proxy.SetPlaybackMaxLagAge(c, maxLagAge); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to set max lag age: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPIPause(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+
+	if err := cont.Proxy.PauseFile(c); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to pause: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return nil
+}
+
+func (cont *Controller) handleAPIResume(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+
+	if err := cont.Proxy.ResumeFile(c); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to resume: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return nil
+}
+
+func (cont *Controller) handleAPIDeleteFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'name'")
+	}
+
+	force := false
+	if v := req.FormValue("force"); v != "" {
+		var err error
+		if force, err = strconv.ParseBool(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'force' %q", v)
+		}
+	}
+
+	switch err := cont.Proxy.DeleteFile(c, name, force); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrFileProtected:
+		rw.WriteHeader(http.StatusConflict)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to delete %q: %s", name, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPIDeleteAllFiles(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+
+	// This is destructive, so require explicit confirmation.
+	if v := req.FormValue("confirm"); v != "true" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("deleting all files requires 'confirm=true'")
+	}
+
+	result, err := cont.Proxy.DeleteAllFiles(c)
+	if err != nil {
+		cont.Logger.Sugar().Errorf("Failed to delete all files: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+	return result
+}
+
+func (cont *Controller) handleAPIProtectFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	return cont.setFileProtected(rw, req, true)
+}
+
+func (cont *Controller) handleAPIUnprotectFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	return cont.setFileProtected(rw, req, false)
+}
+
+func (cont *Controller) setFileProtected(rw http.ResponseWriter, req *http.Request, protected bool) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'name'")
+	}
+
+	switch err := cont.Proxy.SetFileProtected(c, name, protected); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrFileNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to set protection of %q to %v: %s", name, protected, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPIMigrateFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'name'")
+	}
+
+	switch err := cont.Proxy.MigrateFile(c, name); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInsufficientSpace:
+		rw.WriteHeader(http.StatusInsufficientStorage)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to migrate %q: %s", name, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPIRenameFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'name'")
+	}
+
+	if err := req.ParseForm(); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+	to := req.FormValue("to")
+	if to == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'to'")
+	}
+
+	switch err := cont.Proxy.RenameFile(c, name, to); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	case ErrFileNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	case ErrFileExists, ErrFileProtected:
+		rw.WriteHeader(http.StatusConflict)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to rename %q to %q: %s", name, to, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPICopyFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'name'")
+	}
+
+	if err := req.ParseForm(); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+	to := req.FormValue("to")
+	if to == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'to'")
+	}
+
+	switch err := cont.Proxy.CopyFile(c, name, to); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	case ErrFileNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	case ErrFileExists, ErrFileProtected:
+		rw.WriteHeader(http.StatusConflict)
+		return err
+	case ErrInsufficientSpace:
+		rw.WriteHeader(http.StatusInsufficientStorage)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to copy %q to %q: %s", name, to, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+// handleUpload imports the "file" part of a multipart upload. The "name"
+// parameter, if supplied, is the name to store it as.
+//
+// The file is streamed into storage rather than buffered by the form parser.
+func (cont *Controller) handleUpload(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	name := req.URL.Query().Get("name")
+
+	if cont.MaxUploadBytes > 0 {
+		if req.ContentLength > cont.MaxUploadBytes {
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+			return errors.Errorf("upload is larger than %d byte(s)", cont.MaxUploadBytes)
+		}
+		req.Body = http.MaxBytesReader(rw, req.Body, cont.MaxUploadBytes)
+	}
+
+	mr, err := req.MultipartReader()
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.Wrap(err, "invalid upload")
+	}
+	for {
+		part, err := mr.NextPart()
+		switch {
+		case err == io.EOF:
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.New("missing 'file'")
+		case err != nil:
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrap(err, "invalid upload")
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		stored, err := cont.Proxy.ImportFile(c, part, name)
+		switch errors.Cause(err) {
+		case nil:
+			return struct {
+				Name string `json:"name"`
+			}{stored}
+		case ErrInvalidRequest:
+			rw.WriteHeader(http.StatusBadRequest)
+			return err
+		case ErrInsufficientSpace:
+			rw.WriteHeader(http.StatusInsufficientStorage)
+			return err
+		default:
+			cont.Logger.Sugar().Errorf("Failed to import upload %q: %s", part.FileName(), err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return err
+		}
+	}
+}
+
+func (cont *Controller) handleAPISetDefaultFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'name'")
+	}
+
+	if err := cont.Proxy.SetDefaultFile(c, name); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to set default file to %q: %s", name, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return nil
+}
+
+func (cont *Controller) handleAPIClearDefaultFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+
+	if err := cont.Proxy.SetDefaultFile(c, ""); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to clear default file: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return nil
+}
+
+func (cont *Controller) handleAPIStop(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	if err := cont.Proxy.Stop(c); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to stop: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return nil
+}
+
+func (cont *Controller) handleAPIStartTestPattern(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	pattern := vars["pattern"]
+	if pattern == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'pattern'")
+	}
+
+	switch err := cont.Proxy.StartTestPattern(c, pattern); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to start test pattern %q: %s", pattern, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPIStopTestPattern(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	if err := cont.Proxy.StopTestPattern(c); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to stop test pattern: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
+
+func (cont *Controller) handleAPIAddMarker(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	label := req.FormValue("label")
+	if label == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'label'")
+	}
+
+	m, err := cont.Proxy.AddMarker(c, label)
+	switch errors.Cause(err) {
+	case nil:
+		return m
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to add marker %q: %s", label, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPISeekMarker(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	label := vars["label"]
+	if label == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'label'")
+	}
+
+	switch err := cont.Proxy.SeekToMarker(c, label); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrMarkerNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to seek to marker %q: %s", label, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPIPlayPlaylist(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+
+	var entries []PlaylistEntry
+	if err := json.NewDecoder(req.Body).Decode(&entries); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.Wrap(err, "invalid playlist")
+	}
+
+	var loop bool
+	if v := req.FormValue("loop"); v != "" {
+		var err error
+		if loop, err = strconv.ParseBool(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'loop' %q", v)
+		}
+	}
+
+	switch err := cont.Proxy.PlayPlaylist(c, entries, loop); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	case ErrFileNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	case ErrInsufficientSpace:
+		rw.WriteHeader(http.StatusInsufficientStorage)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to play playlist: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPISeek(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	v := req.FormValue("position")
+	if v == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'position'")
+	}
+	pos, err := time.ParseDuration(v)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.Wrapf(err, "invalid 'position' %q", v)
+	}
+
+	switch err := cont.Proxy.SeekFile(c, pos); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to seek to %s: %s", pos, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+// handleAPITransport performs the TransportIntent in the "intent" parameter.
+func (cont *Controller) handleAPITransport(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	v := req.FormValue("intent")
+	if v == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'intent'")
+	}
+	ti, err := ParseTransportIntent(v)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.Wrapf(err, "invalid 'intent' %q", v)
+	}
+
+	switch ti.Action {
+	case TransportPlay:
+		err = cont.Proxy.PlayFile(c, ti.Name, PlayFileOpts{})
+	case TransportPause:
+		err = cont.Proxy.PauseFile(c)
+	case TransportResume:
+		err = cont.Proxy.ResumeFile(c)
+	case TransportToggle:
+		_, err = cont.Proxy.TogglePause(c)
+	case TransportStop:
+		err = cont.Proxy.Stop(c)
+	case TransportSeek:
+		err = cont.Proxy.SeekFile(c, ti.Position)
+	}
+
+	switch errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	case ErrFileNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to perform transport intent %q: %s", v, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPIAbortRecording(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	if err := cont.Proxy.AbortRecording(c); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to abort recording: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return nil
+}
+
+func (cont *Controller) handleAPIEnableProxyForwarding(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+
+	if err := cont.Proxy.SetProxyForwarding(c, true); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to enable proxy forwarding: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return nil
+}
+
+func (cont *Controller) handleAPIDisableProxyForwarding(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+
+	if err := cont.Proxy.SetProxyForwarding(c, false); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to disable proxy forwarding: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return nil
+}
+
+func (cont *Controller) handleAPIPauseDiscovery(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+
+	if err := cont.Proxy.SetDiscoveryBroadcast(c, false); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to pause discovery broadcast: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return nil
+}
+
+func (cont *Controller) handleAPIResumeDiscovery(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+
+	if err := cont.Proxy.SetDiscoveryBroadcast(c, true); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to resume discovery broadcast: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	return nil
+}
+
+func (cont *Controller) handleAPITestDevice(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	id := vars["id"]
+	if id == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'id'")
+	}
+
+	color := Pixel{R: 0xFF, G: 0xFF, B: 0xFF}
+	if v := req.FormValue("color"); v != "" {
+		var err error
+		if color, err = parsePixelHex(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return err
+		}
+	}
+
+	switch err := cont.Proxy.TestDevice(c, id, color); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrDeviceNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to test device %q: %s", id, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPIIdentifyDevice(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	id := vars["id"]
+	if id == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'id'")
+	}
+
+	switch err := cont.Proxy.IdentifyDevice(c, id); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrDeviceNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to identify device %q: %s", id, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPIForgetDevice(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	id := vars["id"]
+	if id == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'id'")
+	}
+
+	switch err := cont.Proxy.ForgetDevice(c, id); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrDeviceNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to forget device %q: %s", id, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+func (cont *Controller) handleAPISoloDevice(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
-	files, err := cont.Proxy.ListFiles(c)
-	if err != nil {
-		cont.Logger.Sugar().Errorf("Failed to list files: %s", err)
+	vars := mux.Vars(req)
+	id := vars["id"]
+	if id == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'id'")
+	}
+
+	blackout := false
+	if v := req.FormValue("blackout"); v != "" {
+		var err error
+		if blackout, err = strconv.ParseBool(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'blackout' %q", v)
+		}
+	}
+
+	switch err := cont.Proxy.SoloDevice(c, id, blackout); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrDeviceNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to solo device %q: %s", id, err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
-
-	return files
 }
 
-func (cont *Controller) handleAPIRecordFile(rw http.ResponseWriter, req *http.Request) interface{} {
+func (cont *Controller) handleAPIMuteDevice(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
 	vars := mux.Vars(req)
-	name := vars["name"]
-	if name == "" {
+	id := vars["id"]
+	if id == "" {
 		rw.WriteHeader(http.StatusBadRequest)
-		return errors.New("missing 'name'")
+		return errors.New("missing 'id'")
 	}
 
-	if err := cont.Proxy.RecordFile(c, name); err != nil {
-		cont.Logger.Sugar().Errorf("Failed to record: %s", err)
+	muted := true
+	if v := req.FormValue("muted"); v != "" {
+		var err error
+		if muted, err = strconv.ParseBool(v); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return errors.Wrapf(err, "invalid 'muted' %q", v)
+		}
+	}
+
+	switch err := cont.Proxy.SetDeviceMuted(c, id, muted); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrDeviceNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to set device %q muted=%v: %s", id, muted, err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
+}
 
+func (cont *Controller) handleAPIClearSolo(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	if err := cont.Proxy.SoloDevice(c, "", false); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to clear solo: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
 	return nil
 }
 
-func (cont *Controller) handleAPIMergeFiles(rw http.ResponseWriter, req *http.Request) interface{} {
+func (cont *Controller) handleAPISetSnapshotDownsample(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
 	vars := mux.Vars(req)
-
-	// Destination file name (path parameter).
-	name := vars["name"]
-	if name == "" {
+	id := vars["id"]
+	if id == "" {
 		rw.WriteHeader(http.StatusBadRequest)
-		return errors.New("missing 'name'")
+		return errors.New("missing 'id'")
 	}
 
-	// Grab source names from (potentially repeating) query string.
-	srcs := req.URL.Query()["src"]
+	v := vars["factor"]
+	if v == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'factor'")
+	}
+	factor, err := strconv.Atoi(v)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.Wrapf(err, "invalid 'factor' %q", v)
+	}
 
-	if err := cont.Proxy.MergeFiles(c, name, srcs...); err != nil {
-		cont.Logger.Sugar().Errorf("Failed to merge: %s", err)
+	switch err := cont.Proxy.SetSnapshotDownsample(c, id, factor); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrDeviceNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to set snapshot downsample for device %q: %s", id, err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
+}
 
-	return nil
+func (cont *Controller) handleAPIDeviceUpdatePeriods(rw http.ResponseWriter, req *http.Request) interface{} {
+	periods, err := cont.Proxy.DeviceUpdatePeriods(req.Context())
+	if err != nil {
+		cont.Logger.Sugar().Errorf("Failed to get device update periods: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+	return periods
 }
 
-func (cont *Controller) handleAPIPlayFile(rw http.ResponseWriter, req *http.Request) interface{} {
+func (cont *Controller) handleAPISetDeviceUpdatePeriod(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
 	vars := mux.Vars(req)
-	name := vars["name"]
-	if name == "" {
+	id := vars["id"]
+	if id == "" {
 		rw.WriteHeader(http.StatusBadRequest)
-		return errors.New("missing 'name'")
+		return errors.New("missing 'id'")
 	}
 
-	if err := cont.Proxy.PlayFile(c, name); err != nil {
-		cont.Logger.Sugar().Errorf("Failed to play %q: %s", name, err)
+	v := vars["duration"]
+	if v == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'duration'")
+	}
+	period, err := time.ParseDuration(v)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.Wrapf(err, "invalid 'duration' %q", v)
+	}
+
+	switch err := cont.Proxy.SetDeviceUpdatePeriod(c, id, period); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to set update period for device %q: %s", id, err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
-
-	return nil
 }
 
-func (cont *Controller) handleAPIPause(rw http.ResponseWriter, req *http.Request) interface{} {
+func (cont *Controller) handleAPISetNoRoutePolicy(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
+	vars := mux.Vars(req)
+	policy := vars["policy"]
+	if policy == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'policy'")
+	}
 
-	if err := cont.Proxy.PauseFile(c); err != nil {
-		cont.Logger.Sugar().Errorf("Failed to pause: %s", err)
+	switch err := cont.Proxy.SetNoRoutePolicy(c, policy, req.FormValue("device")); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to set no-route policy %q: %s", policy, err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
-
-	return nil
 }
 
-func (cont *Controller) handleAPIResume(rw http.ResponseWriter, req *http.Request) interface{} {
+func (cont *Controller) handleAPIDeviceHeaders(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
+	vars := mux.Vars(req)
+	id := vars["id"]
+	if id == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'id'")
+	}
 
-	if err := cont.Proxy.ResumeFile(c); err != nil {
-		cont.Logger.Sugar().Errorf("Failed to resume: %s", err)
+	switch dh, err := cont.Proxy.DeviceHeaders(c, id); errors.Cause(err) {
+	case nil:
+		return dh
+	case ErrDeviceNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to get headers for device %q: %s", id, err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
-
-	return nil
 }
 
-func (cont *Controller) handleAPIDeleteFile(rw http.ResponseWriter, req *http.Request) interface{} {
+func (cont *Controller) handleAPIResetDeviceCounters(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
 	vars := mux.Vars(req)
-	name := vars["name"]
-	if name == "" {
+	id := vars["id"]
+	if id == "" {
 		rw.WriteHeader(http.StatusBadRequest)
-		return errors.New("missing 'name'")
+		return errors.New("missing 'id'")
 	}
 
-	if err := cont.Proxy.DeleteFile(c, name); err != nil {
-		cont.Logger.Sugar().Errorf("Failed to delete %q: %s", name, err)
+	switch err := cont.Proxy.ResetDeviceCounters(c, id); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrDeviceNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to reset counters for device %q: %s", id, err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
+}
 
+func (cont *Controller) handleAPIResetAllDeviceCounters(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	if err := cont.Proxy.ResetDeviceCounters(c, ""); err != nil {
+		cont.Logger.Sugar().Errorf("Failed to reset device counters: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
 	return nil
 }
 
-func (cont *Controller) handleAPISetDefaultFile(rw http.ResponseWriter, req *http.Request) interface{} {
+func (cont *Controller) handleAPIListZones(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	zones, err := cont.Proxy.Zones(c)
+	if err != nil {
+		cont.Logger.Sugar().Errorf("Failed to list zones: %s", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+	return zones
+}
+
+func (cont *Controller) handleAPIGetZone(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
 	vars := mux.Vars(req)
-	name := vars["name"]
+	name := vars["zone"]
 	if name == "" {
 		rw.WriteHeader(http.StatusBadRequest)
-		return errors.New("missing 'name'")
+		return errors.New("missing 'zone'")
 	}
 
-	if err := cont.Proxy.SetDefaultFile(c, name); err != nil {
-		cont.Logger.Sugar().Errorf("Failed to set default file to %q: %s", name, err)
+	zones, err := cont.Proxy.Zones(c)
+	if err != nil {
+		cont.Logger.Sugar().Errorf("Failed to list zones: %s", err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
+	for _, z := range zones {
+		if z.Name == name {
+			return z
+		}
+	}
 
-	return nil
+	rw.WriteHeader(http.StatusNotFound)
+	return ErrZoneNotFound
 }
 
-func (cont *Controller) handleAPIClearDefaultFile(rw http.ResponseWriter, req *http.Request) interface{} {
+func (cont *Controller) handleAPISetZone(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["zone"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'zone'")
+	}
 
-	if err := cont.Proxy.SetDefaultFile(c, ""); err != nil {
-		cont.Logger.Sugar().Errorf("Failed to clear default file: %s", err)
+	var zone Zone
+	if err := json.NewDecoder(req.Body).Decode(&zone); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.Wrap(err, "invalid zone")
+	}
+	zone.Name = name
+
+	switch err := cont.Proxy.SetZone(c, &zone); errors.Cause(err) {
+	case nil:
+		return &zone
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to set zone %q: %s", name, err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
-
-	return nil
 }
 
-func (cont *Controller) handleAPIStop(rw http.ResponseWriter, req *http.Request) interface{} {
+func (cont *Controller) handleAPIDeleteZone(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
-	if err := cont.Proxy.Stop(c); err != nil {
-		cont.Logger.Sugar().Errorf("Failed to stop: %s", err)
+	vars := mux.Vars(req)
+	name := vars["zone"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'zone'")
+	}
+
+	switch err := cont.Proxy.DeleteZone(c, name); errors.Cause(err) {
+	case nil:
+		return nil
+	case ErrZoneNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to delete zone %q: %s", name, err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
-
-	return nil
 }
 
-func (cont *Controller) handleAPIEnableProxyForwarding(rw http.ResponseWriter, req *http.Request) interface{} {
+func (cont *Controller) handleAPIZoneBrightness(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
-
-	if err := cont.Proxy.SetProxyForwarding(c, true); err != nil {
-		cont.Logger.Sugar().Errorf("Failed to enable proxy forwarding: %s", err)
-		rw.WriteHeader(http.StatusInternalServerError)
-		return err
+	vars := mux.Vars(req)
+	name := vars["zone"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'zone'")
+	}
+	v := vars["level"]
+	level, err := strconv.Atoi(v)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.Wrapf(err, "invalid 'level' %q", v)
 	}
 
-	return nil
+	result, err := cont.Proxy.SetZoneBrightness(c, name, level)
+	return cont.zoneCommandResponse(rw, name, result, err)
 }
 
-func (cont *Controller) handleAPIDisableProxyForwarding(rw http.ResponseWriter, req *http.Request) interface{} {
+func (cont *Controller) handleAPIBlackoutZone(rw http.ResponseWriter, req *http.Request) interface{} {
 	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["zone"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'zone'")
+	}
 
-	if err := cont.Proxy.SetProxyForwarding(c, false); err != nil {
-		cont.Logger.Sugar().Errorf("Failed to disable proxy forwarding: %s", err)
+	result, err := cont.Proxy.BlackoutZone(c, name)
+	return cont.zoneCommandResponse(rw, name, result, err)
+}
+
+func (cont *Controller) zoneCommandResponse(rw http.ResponseWriter, name string, result *ZoneCommandResult,
+	err error) interface{} {
+	switch errors.Cause(err) {
+	case nil:
+		return result
+	case ErrZoneNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	case ErrInvalidRequest:
+		rw.WriteHeader(http.StatusBadRequest)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to apply command to zone %q: %s", name, err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
-
-	return nil
 }
 
 func (cont *Controller) handleAPIReboot(rw http.ResponseWriter, req *http.Request) interface{} {
@@ -421,15 +2401,51 @@ func (cont *Controller) handleIndexTemplate(rw http.ResponseWriter, req *http.Re
 	})
 }
 
+// Bounds for the refresh interval that a client may request when loading a
+// devices template.
+const (
+	minClientRefreshInterval = 250 * time.Millisecond
+	maxClientRefreshInterval = 5 * time.Minute
+)
+
+// clientRefreshInterval returns the refresh interval requested by req's
+// "refresh" parameter, clamped to a sane range. If the parameter is absent,
+// clientRefreshInterval returns def.
+func clientRefreshInterval(req *http.Request, def time.Duration) (time.Duration, error) {
+	v := req.FormValue("refresh")
+	if v == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid 'refresh' %q", v)
+	}
+	switch {
+	case d < minClientRefreshInterval:
+		d = minClientRefreshInterval
+	case d > maxClientRefreshInterval:
+		d = maxClientRefreshInterval
+	}
+	return d, nil
+}
+
 func (cont *Controller) handleDevicesTemplate(name string) http.HandlerFunc {
 	const defaultRefreshInterval = 5 * time.Second
 
-	refreshInterval := cont.RenderRefreshInterval
-	if refreshInterval <= 0 {
-		refreshInterval = defaultRefreshInterval
+	serverRefreshInterval := cont.RenderRefreshInterval
+	if serverRefreshInterval <= 0 {
+		serverRefreshInterval = defaultRefreshInterval
 	}
 
 	return func(rw http.ResponseWriter, req *http.Request) {
+		// Clients may override the server's refresh interval.
+		refreshInterval, err := clientRefreshInterval(req, serverRefreshInterval)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Get the current list of devices.
 		now := time.Now()
 		devices := cont.Proxy.Devices()
@@ -450,9 +2466,9 @@ func (cont *Controller) handleDevicesTemplate(name string) http.HandlerFunc {
 				Now                   time.Time
 				RefreshIntervalMillis int64
 			}{
-				Devices:      devices,
-				HasSnapshots: hasSnapshots,
-				Now:          now,
+				Devices:               devices,
+				HasSnapshots:          hasSnapshots,
+				Now:                   now,
 				RefreshIntervalMillis: int64(refreshInterval / time.Millisecond),
 			})
 		})
@@ -474,20 +2490,22 @@ func (cont *Controller) handleSystemTemplate(rw http.ResponseWriter, req *http.R
 
 func (cont *Controller) handleAllLogsTemplate(rw http.ResponseWriter, req *http.Request) {
 	c := req.Context()
-	cont.handleLogsTemplate(c, rw, "All", logging.GetRecentLogs(c))
+	cont.handleLogsTemplate(c, rw, "All", zapcore.DebugLevel, logging.GetRecentLogs(c))
 }
 
 func (cont *Controller) handleErrorLogsTemplate(rw http.ResponseWriter, req *http.Request) {
 	c := req.Context()
-	cont.handleLogsTemplate(c, rw, "Escalated", logging.GetRecentEscalatedLogs(c))
+	cont.handleLogsTemplate(c, rw, "Escalated", zapcore.WarnLevel, logging.GetRecentEscalatedLogs(c))
 }
 
-func (cont *Controller) handleLogsTemplate(c context.Context, rw http.ResponseWriter, name string, logs []zapcore.Entry) {
+func (cont *Controller) handleLogsTemplate(c context.Context, rw http.ResponseWriter, name string,
+	level zapcore.Level, logs []zapcore.Entry) {
 	type LogEntry struct {
-		Time    time.Time
-		Caller  string
-		Level   string
-		Message template.HTML
+		Time      time.Time
+		Component string
+		Caller    string
+		Level     string
+		Message   template.HTML
 	}
 
 	formatMessage := func(v string) template.HTML {
@@ -501,24 +2519,102 @@ func (cont *Controller) handleLogsTemplate(c context.Context, rw http.ResponseWr
 	for i := range logs {
 		e := &logs[len(logs)-i-1]
 		entries[i] = LogEntry{
-			Time:    e.Time,
-			Caller:  e.Caller.TrimmedPath(),
-			Level:   e.Level.String(),
-			Message: formatMessage(e.Message),
+			Time:      e.Time,
+			Component: e.LoggerName,
+			Caller:    e.Caller.TrimmedPath(),
+			Level:     e.Level.String(),
+			Message:   formatMessage(e.Message),
 		}
 	}
 
 	cont.site.RenderWithError(rw, "text/html", func() error {
 		return cont.site.RenderTemplate(rw, "templates/logs.html", struct {
-			Name string
-			Logs []LogEntry
+			Name  string
+			Level string
+			Logs  []LogEntry
 		}{
-			Name: name,
-			Logs: entries,
+			Name:  name,
+			Level: level.String(),
+			Logs:  entries,
 		})
 	})
 }
 
+// handleAPILogsDownload serves the buffered logs as an attachment.
+//
+// The "format" parameter may be "txt" (default) or "json". The "level"
+// parameter, if supplied, is the minimum level of log to include.
+func (cont *Controller) handleAPILogsDownload(rw http.ResponseWriter, req *http.Request) {
+	c := req.Context()
+
+	level := zapcore.DebugLevel
+	if v := req.FormValue("level"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			http.Error(rw, fmt.Sprintf("invalid level %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The escalated log buffer retains more warnings and errors, so prefer it
+	// if it will hold everything that we want.
+	var logs []zapcore.Entry
+	if level >= zapcore.WarnLevel {
+		logs = logging.GetRecentEscalatedLogs(c)
+	} else {
+		logs = logging.GetRecentLogs(c)
+	}
+
+	type LogEntry struct {
+		Time      time.Time `json:"time"`
+		Level     string    `json:"level"`
+		Component string    `json:"component,omitempty"`
+		Caller    string    `json:"caller,omitempty"`
+		Message   string    `json:"message"`
+	}
+	entries := make([]LogEntry, 0, len(logs))
+	for i := range logs {
+		e := &logs[i]
+		if e.Level < level {
+			continue
+		}
+
+		le := LogEntry{
+			Time:      e.Time,
+			Level:     e.Level.CapitalString(),
+			Component: e.LoggerName,
+			Message:   e.Message,
+		}
+		if e.Caller.Defined {
+			le.Caller = e.Caller.TrimmedPath()
+		}
+		entries = append(entries, le)
+	}
+
+	filename := fmt.Sprintf("pixelproxy-logs-%s", time.Now().UTC().Format("20060102-150405"))
+	switch format := req.FormValue("format"); format {
+	case "", "txt":
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".txt"))
+		for _, le := range entries {
+			if _, err := fmt.Fprintf(rw, "%s\t%s\t%s\t%s\t%s\n",
+				le.Time.Format(time.RFC3339Nano), le.Level, le.Component, le.Caller, le.Message); err != nil {
+				logging.S(c).Warnf("Failed to write log download: %s", err)
+				return
+			}
+		}
+
+	case "json":
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		if err := json.NewEncoder(rw).Encode(entries); err != nil {
+			logging.S(c).Warnf("Failed to write log download: %s", err)
+		}
+
+	default:
+		http.Error(rw, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+	}
+}
+
 func (cont *Controller) handleStripSVG(rw http.ResponseWriter, req *http.Request) {
 	c := req.Context()
 	vars := mux.Vars(req)
@@ -528,7 +2624,16 @@ func (cont *Controller) handleStripSVG(rw http.ResponseWriter, req *http.Request
 		return
 	}
 
-	strips, err := cont.Proxy.Strips(c, device)
+	var fresh bool
+	if v := req.FormValue("fresh"); v != "" {
+		var err error
+		if fresh, err = strconv.ParseBool(v); err != nil {
+			http.Error(rw, fmt.Sprintf("invalid 'fresh' %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	strips, err := cont.Proxy.Strips(c, device, fresh)
 	if err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
 		logging.S(c).Errorf("Could not get strip data for %q: %s", device, err)
@@ -541,3 +2646,100 @@ func (cont *Controller) handleStripSVG(rw http.ResponseWriter, req *http.Request
 		return
 	}
 }
+
+func (cont *Controller) handleAPIAnalyzeFile(rw http.ResponseWriter, req *http.Request) interface{} {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return errors.New("missing 'name'")
+	}
+
+	fa, err := cont.Proxy.AnalyzeFile(c, name)
+	switch errors.Cause(err) {
+	case nil:
+		return fa
+	case ErrFileNotFound:
+		rw.WriteHeader(http.StatusNotFound)
+		return err
+	default:
+		cont.Logger.Sugar().Errorf("Failed to analyze %q: %s", name, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+}
+
+// downloadFileExt is the file extension given to downloaded files.
+const downloadFileExt = ".protostream.tar"
+
+func (cont *Controller) handleDownload(rw http.ResponseWriter, req *http.Request) {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		http.Error(rw, "missing 'name'", http.StatusBadRequest)
+		return
+	}
+
+	rc, displayName, err := cont.Proxy.OpenFile(c, name)
+	switch errors.Cause(err) {
+	case nil:
+	case ErrFileNotFound:
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	case ErrInvalidRequest:
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	default:
+		cont.Logger.Sugar().Errorf("Could not open %q for download: %s", name, err)
+		http.Error(rw, "could not open file", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			logging.S(c).Warnf("Failed to close download of %q: %s", name, err)
+		}
+	}()
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{
+		"filename": displayName + downloadFileExt,
+	})
+	if disposition == "" {
+		// The name can't be represented in the header, so leave it to the client.
+		disposition = "attachment"
+	}
+	rw.Header().Set("Content-Type", "application/x-tar")
+	rw.Header().Set("Content-Disposition", disposition)
+	if _, err := io.Copy(rw, rc); err != nil {
+		logging.S(c).Warnf("Failed to write download of %q: %s", name, err)
+	}
+}
+
+func (cont *Controller) handleAPIFileThumbnail(rw http.ResponseWriter, req *http.Request) {
+	c := req.Context()
+	vars := mux.Vars(req)
+	name := vars["name"]
+	if name == "" {
+		http.Error(rw, "missing 'name'", http.StatusBadRequest)
+		return
+	}
+
+	strips, err := cont.Proxy.FileThumbnail(c, name)
+	switch errors.Cause(err) {
+	case nil:
+	case ErrFileNotFound:
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	default:
+		logging.S(c).Errorf("Could not build thumbnail for %q: %s", name, err)
+		http.Error(rw, "could not build thumbnail", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "image/png")
+	if err := RenderStripPNG(strips, rw); err != nil {
+		http.Error(rw, "could not render PNG", http.StatusInternalServerError)
+		return
+	}
+}
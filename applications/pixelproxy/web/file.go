@@ -23,4 +23,81 @@ type File struct {
 	Duration          time.Duration `json:"duration"`
 	Compression       string        `json:"compression"`
 	IsDefault         bool          `json:"is_default"`
+	Note              string        `json:"note,omitempty"`
+	Protected         bool          `json:"protected,omitempty"`
+
+	// BytesPerSecond is the average rate of packet data in the file, NumBytes
+	// over Duration.
+	BytesPerSecond int64 `json:"bytes_per_second,omitempty"`
+
+	// Markers are the file's labeled cue points.
+	Markers []Marker `json:"markers,omitempty"`
+}
+
+// TrashedFile is a deleted file that is held in the trash.
+type TrashedFile struct {
+	Name      string    `json:"name"`
+	DiskBytes int64     `json:"diskBytes"`
+	Deleted   time.Time `json:"deleted"`
+	// Expires, if not zero, is when the file will be permanently deleted.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// DeleteAllResult is the result of deleting all deletable files.
+type DeleteAllResult struct {
+	// Deleted is the number of files that were deleted.
+	Deleted int `json:"deleted"`
+	// ReclaimedBytes is the disk space used by the deleted files. If deleted
+	// files go to the trash, it is reclaimed when they are purged.
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+	// Kept lists the files that were kept because they are the default file or
+	// are protected.
+	Kept []string `json:"kept,omitempty"`
+	// Errors lists the files that could not be deleted, and why.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// FileAnalysis describes how demanding a file is to play in realtime.
+type FileAnalysis struct {
+	Name     string        `json:"name"`
+	Events   int64         `json:"events"`
+	Duration time.Duration `json:"duration"`
+
+	// MinInterval is the smallest interval between consecutive packets.
+	MinInterval time.Duration `json:"min_interval"`
+	// MaxPacketsPerSecond is the largest number of packets, across all
+	// devices, within any one-second window.
+	MaxPacketsPerSecond int `json:"max_packets_per_second"`
+	// MaxDevicePacketsPerSecond is the largest number of packets for a single
+	// device within any one-second window, and MaxDevice is that device.
+	MaxDevicePacketsPerSecond int    `json:"max_device_packets_per_second"`
+	MaxDevice                 string `json:"max_device,omitempty"`
+
+	// DevicePacketBudget and TotalPacketBudget are the configured packet rate
+	// budgets, in packets per second. Zero means no budget.
+	DevicePacketBudget float64 `json:"device_packet_budget,omitempty"`
+	TotalPacketBudget  float64 `json:"total_packet_budget,omitempty"`
+	// MaxLagAge is the playback lag beyond which packets are dropped.
+	MaxLagAge time.Duration `json:"max_lag_age"`
+
+	// ExceedsBudget is true if the file's peak packet rates exceed a budget,
+	// or if any packets are likely to be dropped.
+	ExceedsBudget bool `json:"exceeds_budget"`
+	// LagSections are the sections of the file in which sending at the
+	// budgeted rate would fall more than MaxLagAge behind, so packets are
+	// likely to be dropped.
+	LagSections []LagSection `json:"lag_sections,omitempty"`
+}
+
+// LagSection is a section of a file in which playback is expected to lag.
+type LagSection struct {
+	Start  time.Duration `json:"start"`
+	End    time.Duration `json:"end"`
+	MaxLag time.Duration `json:"max_lag"`
+}
+
+// Marker is a labeled cue point within a file.
+type Marker struct {
+	Label  string        `json:"label"`
+	Offset time.Duration `json:"offset"`
 }
@@ -0,0 +1,91 @@
+package web
+
+import (
+	"time"
+)
+
+// MergeRequest is a request to merge an ordered list of source files into a
+// single destination file.
+type MergeRequest struct {
+	// Name is the name of the destination file.
+	Name string `json:"name"`
+
+	// Sources is the ordered list of source files to merge.
+	Sources []*MergeSource `json:"sources"`
+
+	// Compression, if not empty, is the name of the compression scheme to use
+	// for the destination file, overriding the storage default.
+	Compression string `json:"compression,omitempty"`
+	// CompressionLevel, if not nil, is the compression level to use alongside
+	// Compression. If nil, the compression scheme's default level is used.
+	CompressionLevel *int `json:"compression_level,omitempty"`
+
+	// Interleave, if true, merges the sources' events by their offsets into a
+	// single timeline rather than concatenating the sources.
+	Interleave bool `json:"interleave,omitempty"`
+
+	// DryRun, if true, plans the merge without writing anything.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Opts returns the MergeFilesOpts described by mr.
+func (mr *MergeRequest) Opts() MergeFilesOpts {
+	opts := MergeFilesOpts{
+		Compression:      mr.Compression,
+		CompressionLevel: -1,
+		Interleave:       mr.Interleave,
+	}
+	if mr.CompressionLevel != nil {
+		opts.CompressionLevel = *mr.CompressionLevel
+	}
+	for i, src := range mr.Sources {
+		if src == nil || src.Offset == 0 {
+			continue
+		}
+		if opts.Offsets == nil {
+			opts.Offsets = make([]time.Duration, len(mr.Sources))
+		}
+		opts.Offsets[i] = src.Offset
+	}
+	return opts
+}
+
+// MergeSource is a single source in a MergeRequest.
+type MergeSource struct {
+	// Name is the name of the source file.
+	Name string `json:"name"`
+
+	// Offset is the amount of time to shift this source by in the merged
+	// output: the gap between the end of the previous source and the start of
+	// this one, or, if the merge interleaves its sources, the time from the
+	// start of the merged file to the start of this one. It must not be
+	// negative.
+	Offset time.Duration `json:"offset,omitempty"`
+}
+
+// MergePlan is a summary of the output that a MergeRequest will produce.
+type MergePlan struct {
+	// Name is the name of the destination file.
+	Name string `json:"name"`
+	// Sources is the ordered list of source file names.
+	Sources []string `json:"sources"`
+	// Interleave is true if the sources' events will be interleaved.
+	Interleave bool `json:"interleave,omitempty"`
+
+	// Duration is the expected duration of the merged file, including the
+	// sources' offsets.
+	Duration time.Duration `json:"duration"`
+	// NumEvents is the total number of events in the merged file.
+	NumEvents int64 `json:"num_events"`
+	// NumBytes is the total number of event bytes in the merged file.
+	NumBytes int64 `json:"num_bytes"`
+	// EstimatedSize is the estimated size of the merged file on disk, in bytes.
+	// It is the sum of the sources' sizes, so it may differ if the merged file
+	// uses a different compression.
+	EstimatedSize int64 `json:"estimated_size"`
+	// Devices is the sorted union of device IDs referenced by the sources.
+	Devices []string `json:"devices"`
+
+	// Warnings are potential problems with the merge that don't prevent it.
+	Warnings []string `json:"warnings,omitempty"`
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"time"
+)
+
+// RecordFileOpts are optional parameters for a RecordFile operation.
+//
+// The zero value uses the Controller's defaults.
+type RecordFileOpts struct {
+	// Compression, if not empty, is the name of the compression scheme to use
+	// for this recording, overriding the storage default.
+	Compression string
+	// CompressionLevel is the compression level to use alongside Compression.
+	// It is ignored if Compression is empty.
+	//
+	// <0 means that the compression scheme's default level should be used.
+	CompressionLevel int
+
+	// Note, if not empty, is a free-form note to store alongside the recording.
+	Note string
+
+	// Armed, if true, opens the recording but does not begin recording events
+	// until the first packet carrying pixel data arrives. The recording's
+	// offsets start from that packet.
+	Armed bool
+
+	// Deduplicate, if true, skips recording packets whose strips are all
+	// identical to those strips' previously recorded state.
+	Deduplicate bool
+
+	// MaxPacketBytes, if >0, rejects packets that carry more than this many
+	// bytes of pixel data. StrictPackets, if true, also rejects packets that
+	// don't match their device's strip layout, or whose encoding isn't
+	// supported, rather than tolerating them. Rejected packets are counted, but
+	// not recorded.
+	MaxPacketBytes int
+	StrictPackets  bool
+
+	// MaxBytes and MaxDuration, if >0, limit the number of bytes of events and
+	// the duration of the recording, overriding the Controller's defaults. When
+	// either is reached, the recording is stopped and saved.
+	MaxBytes    int64
+	MaxDuration time.Duration
+
+	// SegmentBytes and SegmentDuration, if >0, split the recording into
+	// numbered segment files ("name-001", "name-002", ...). When the current
+	// segment reaches either threshold, it is committed and recording continues
+	// in the next one.
+	SegmentBytes    int64
+	SegmentDuration time.Duration
+}
+
+// MergeFilesOpts are optional parameters for a MergeFiles operation.
+//
+// The zero value merges the sources one after another, writing the merged file
+// with the storage's compression. Note that a CompressionLevel of 0 is a
+// literal level, not the default: callers that set Compression without
+// choosing a level must set CompressionLevel to -1.
+type MergeFilesOpts struct {
+	// Compression, if not empty, is the name of the compression scheme to use
+	// for the merged file, overriding the storage default.
+	Compression string
+	// CompressionLevel is the compression level to use alongside Compression.
+	// It is ignored if Compression is empty.
+	//
+	// <0 means that the compression scheme's default level should be used.
+	CompressionLevel int
+
+	// Interleave, if true, merges the sources' events by their offsets into a
+	// single timeline, as if the sources were captured together, rather than
+	// playing the sources one after another.
+	Interleave bool
+
+	// Offsets, if not empty, has an entry for each source file, which shifts
+	// that source's events in the merged file. Normally, it is the gap between
+	// the end of the previous source and the start of that source. If
+	// Interleave is true, it is the time from the start of the merged file to
+	// the start of that source. Offsets must not be negative.
+	Offsets []time.Duration
+}
+
+// PlayFileOpts are optional parameters for a PlayFile operation.
+//
+// The zero value uses the Controller's defaults.
+type PlayFileOpts struct {
+	// LoopGap, if >0, is the amount of time to hold between playback rounds.
+	// During the gap, the last frame is held.
+	LoopGap time.Duration
+	// LoopGapBlackout, if true, blacks out all devices during the loop gap
+	// instead of holding the last frame.
+	LoopGapBlackout bool
+	// MaxRounds, if >0, is the number of rounds to play before playback stops.
+	// Otherwise, playback loops until it is stopped.
+	MaxRounds int64
+	// PlaybackRate, if >0, is the speed to play at, relative to the speed at
+	// which the file was recorded: 0.5 plays at half speed, and 2 at double
+	// speed. Otherwise, the file plays at its recorded speed.
+	PlaybackRate float64
+}
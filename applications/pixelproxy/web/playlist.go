@@ -0,0 +1,54 @@
+package web
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// PlaylistEntry is a single file in a playlist, and the options to play it
+// with.
+//
+// In JSON, an entry may also be just the file's name, in which case it is
+// played with the default options.
+type PlaylistEntry struct {
+	// Name is the name of the file to play.
+	Name string `json:"name"`
+
+	// LoopGap, if >0, is the amount of time to hold between the entry's rounds.
+	LoopGap time.Duration `json:"loop_gap,omitempty"`
+	// LoopGapBlackout, if true, blacks out all devices during the loop gap
+	// instead of holding the last frame.
+	LoopGapBlackout bool `json:"loop_gap_blackout,omitempty"`
+	// Rounds is the number of rounds to play before the playlist advances. If
+	// it is 0, the entry plays once.
+	Rounds int64 `json:"rounds,omitempty"`
+	// Rate, if >0, is the speed to play the entry at, relative to the speed at
+	// which it was recorded.
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (pe *PlaylistEntry) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &pe.Name); err == nil {
+		return nil
+	}
+
+	// Decode into a type without our UnmarshalJSON method.
+	type entry PlaylistEntry
+	return json.Unmarshal(data, (*entry)(pe))
+}
+
+// Opts returns the PlayFileOpts that pe is played with.
+func (pe *PlaylistEntry) Opts() PlayFileOpts {
+	opts := PlayFileOpts{
+		LoopGap:         pe.LoopGap,
+		LoopGapBlackout: pe.LoopGapBlackout,
+		MaxRounds:       pe.Rounds,
+		PlaybackRate:    pe.Rate,
+	}
+	if opts.MaxRounds <= 0 {
+		// A playlist entry must finish for the playlist to advance.
+		opts.MaxRounds = 1
+	}
+	return opts
+}
@@ -10,6 +10,20 @@ type Status struct {
 	Devices []*DeviceInfo    `json:"devices,omitempty"`
 }
 
+// Reasons that may appear in ControllerStatus.ForwardingBlockedReason.
+const (
+	// ForwardingBlockedManual means that forwarding was disabled by an operator.
+	ForwardingBlockedManual = "manual"
+	// ForwardingBlockedPlayback means that forwarding is blocked by playback.
+	ForwardingBlockedPlayback = "playback"
+	// ForwardingBlockedTestPattern means that forwarding is blocked by a test
+	// pattern.
+	ForwardingBlockedTestPattern = "test_pattern"
+	// ForwardingBlockedUnknown means that forwarding is blocked by a lease that
+	// the Controller does not own.
+	ForwardingBlockedUnknown = "unknown"
+)
+
 // ControllerStatus provides the current state of the Controller.
 type ControllerStatus struct {
 	// If in Recording or Playing state, the file that is being operated on.
@@ -29,11 +43,84 @@ type ControllerStatus struct {
 	// proxy forwarding.
 	DisablingProxyForwarding bool `json:"disabling_proxy_forwarding"`
 
+	// ForwardingBlockedReason, if ProxyForwarding is false, is a
+	// comma-delimited list of reasons why. See the ForwardingBlocked constants.
+	ForwardingBlockedReason string `json:"forwarding_blocked_reason,omitempty"`
+
+	// SafeMode is true if the server was started in safe mode, and did not
+	// play its default file.
+	SafeMode bool `json:"safe_mode,omitempty"`
+
+	// NoRoutePolicy is the policy for playback packets that can't be routed to
+	// their device, and NoRouteCatchAllDevice is the device that receives them
+	// under the "catchall" policy.
+	NoRoutePolicy         string `json:"no_route_policy"`
+	NoRouteCatchAllDevice string `json:"no_route_catch_all_device,omitempty"`
+
+	// JobsRunning and JobsPending are the number of heavy storage jobs (merges
+	// and migrations) that are running and waiting to run.
+	JobsRunning int `json:"jobs_running,omitempty"`
+	JobsPending int `json:"jobs_pending,omitempty"`
+	// MaxConcurrentJobs, if >0, is the maximum number of storage jobs that can
+	// run at once.
+	MaxConcurrentJobs int `json:"max_concurrent_jobs,omitempty"`
+
+	// DiscoveryPaused is true if the discovery broadcast for proxy devices is
+	// paused.
+	DiscoveryPaused bool `json:"discovery_paused,omitempty"`
+
+	// IdleBlackout is true if all devices have been blacked out due to
+	// inactivity.
+	IdleBlackout bool `json:"idle_blackout,omitempty"`
+
+	// SoloDevice, if not empty, is the ID of the only device that receives
+	// playback packets.
+	SoloDevice string `json:"solo_device,omitempty"`
+
+	// PlaybackMaxLagAge is the maximum lag that will be applied to the next
+	// playback.
+	PlaybackMaxLagAge time.Duration `json:"playback_max_lag_age"`
+
+	// TestPattern, if not empty, is the test pattern that is being generated.
+	TestPattern string `json:"test_pattern,omitempty"`
+
+	// ProxyDevices is the number of proxy devices. If MaxProxyDevices is >0,
+	// no more than that many proxy devices will be created.
+	ProxyDevices    int `json:"proxy_devices"`
+	MaxProxyDevices int `json:"max_proxy_devices,omitempty"`
+
+	// SnapshotBytes is the amount of memory used by device snapshots and the
+	// latest observed strip states that back fresh previews. If
+	// SnapshotMaxBytes is >0, it bounds SnapshotBytes, and SnapshotEvictions is
+	// the number of times that a device has been evicted to stay within it.
+	SnapshotBytes     int64 `json:"snapshot_bytes,omitempty"`
+	SnapshotMaxBytes  int64 `json:"snapshot_max_bytes,omitempty"`
+	SnapshotEvictions int64 `json:"snapshot_evictions,omitempty"`
+
 	// PlaybackStatus, if not nil, is the status of the ongoing playback.
 	PlaybackStatus *PlaybackStatus `json:"playback_status,omitempty"`
+	// PlaylistStatus, if not nil, is the status of the playlist that the
+	// ongoing playback belongs to.
+	PlaylistStatus *PlaylistStatus `json:"playlist_status,omitempty"`
 
 	// RecordStatus, if not nil, is the status of the ongoing recording.
 	RecordStatus *RecordStatus `json:"record_status,omitempty"`
+
+	// LastRecordStatus, if not nil, is the final status of the most recently
+	// stopped recording. If it has an Error, the recording was not saved.
+	LastRecordStatus *RecordStatus `json:"last_record_status,omitempty"`
+}
+
+// PlaylistStatus is the status of a playlist.
+type PlaylistStatus struct {
+	// Files are the playlist's files, in order.
+	Files []string `json:"files"`
+	// Index is the index in Files of the file that is playing.
+	Index int `json:"index"`
+	// Remaining are the files that follow the one that is playing.
+	Remaining []string `json:"remaining,omitempty"`
+	// Loop is true if the playlist restarts after its last file.
+	Loop bool `json:"loop,omitempty"`
 }
 
 // DeviceInfo contains information for a proxy device.
@@ -47,6 +134,26 @@ type DeviceInfo struct {
 	// is not proxying for another device.
 	ProxiedID string `json:"proxiedId,omitempty"`
 
+	// Proxied is true if this is a discovered device that has a proxy. If it
+	// doesn't, ProxyError is the error from the last attempt to create one, if
+	// that failed.
+	Proxied    bool   `json:"proxied,omitempty"`
+	ProxyError string `json:"proxy_error,omitempty"`
+
+	// DeviceType is the device's hardware type, as reported in discovery.
+	DeviceType string `json:"device_type,omitempty"`
+	// Unsupported is true if the device is not a PixelPusher. Its strip and
+	// pixel layout, and the contents of its packets, can't be decoded.
+	Unsupported bool `json:"unsupported,omitempty"`
+
+	// Down is true if the device has been marked down after repeated send
+	// failures. SendFailures is its number of consecutive failed sends.
+	Down         bool `json:"down,omitempty"`
+	SendFailures int  `json:"send_failures,omitempty"`
+
+	// Muted is true if playback packets are not being sent to this device.
+	Muted bool `json:"muted,omitempty"`
+
 	// Strips is the number of strips.
 	Strips int `json:"strips,omitempty"`
 	// Pixels is the number of LEDs per strip.
@@ -80,6 +187,15 @@ type DeviceInfo struct {
 
 	// HasSnapshot is true if this device has a snapshot available.
 	HasSnapshot bool `json:"has_snapshot,omitempty"`
+
+	// Zone is the name of the zone that this device belongs to, if any. A proxy
+	// device belongs to the zone of the device that it proxies, unless it is
+	// assigned to a zone itself.
+	Zone string `json:"zone,omitempty"`
+
+	// SnapshotDownsample, if > 1, is the factor by which this device's
+	// snapshots are downsampled.
+	SnapshotDownsample int `json:"snapshot_downsample,omitempty"`
 }
 
 // ProxyInfo contains information for a proxy device.
@@ -94,22 +210,211 @@ type ProxyInfo struct {
 type PlaybackStatus struct {
 	Name          string        `json:"name"`
 	Rounds        int64         `json:"rounds"`
+	MaxRounds     int64         `json:"max_rounds,omitempty"`
 	Position      time.Duration `json:"position"`
 	Duration      time.Duration `json:"duration"`
 	TotalPlaytime time.Duration `json:"total_playtime"`
 	Progress      int           `json:"progress"`
 	Paused        bool          `json:"paused"`
+	InLoopGap     bool          `json:"in_loop_gap,omitempty"`
+
+	// Rate is the speed of playback, relative to the speed at which the file
+	// was recorded. Position and Duration are in the file's own time.
+	Rate float64 `json:"rate"`
+
+	// MaxLagAge is the maximum lag that this playback tolerates before dropping
+	// packets.
+	MaxLagAge time.Duration `json:"max_lag_age"`
+
+	// Drift is the difference between the wall-clock time spent playing and
+	// the amount of the file that has been played. A positive drift means that
+	// playback is running behind.
+	Drift time.Duration `json:"drift"`
+
+	// NoDevices is true if none of the devices referenced by the file are
+	// currently registered, so playback is not reaching anything.
+	NoDevices bool `json:"no_devices,omitempty"`
+
+	// Markers are the labeled cue points of the file being played, ordered by
+	// offset.
+	Markers []Marker `json:"markers,omitempty"`
+	// CurrentMarker, if not nil, is the last marker at or before Position.
+	CurrentMarker *Marker `json:"current_marker,omitempty"`
+	// NextMarker, if not nil, is the first marker after Position.
+	NextMarker *Marker `json:"next_marker,omitempty"`
 
 	NoRouteDevices []string `json:"no_route_devices,omitempty"`
+	// NoRouteEvents records when routing first failed for each device during
+	// this playback, ordered by time.
+	NoRouteEvents []NoRouteEvent `json:"no_route_events,omitempty"`
 }
 
+// NoRouteEvent records when playback first failed to route to a device.
+type NoRouteEvent struct {
+	// Device is the ID of the device.
+	Device string `json:"device"`
+	// Ordinal is the device's formatted ordinal, if it has one.
+	Ordinal string `json:"ordinal,omitempty"`
+
+	// FirstSeen is the time when the failure was first observed.
+	FirstSeen time.Time `json:"first_seen"`
+	// Position is the playback position within the file at FirstSeen, and
+	// Round is the number of times that playback had looped.
+	Position time.Duration `json:"position"`
+	Round    int64         `json:"round,omitempty"`
+}
+
+// Recording limits, for RecordStatus.LimitReached.
+const (
+	RecordLimitBytes    = "max_bytes"
+	RecordLimitDuration = "max_duration"
+)
+
 // RecordStatus is a description of an ongoing record operation.
 type RecordStatus struct {
-	Name     string        `json:"name"`
-	Error    string        `json:"error,omitempty"`
-	Events   int64         `json:"events"`
-	Bytes    int64         `json:"bytes"`
-	Duration time.Duration `json:"duration"`
+	Name      string        `json:"name"`
+	StartTime time.Time     `json:"start_time"`
+	Note      string        `json:"note,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Armed     bool          `json:"armed,omitempty"`
+	Events    int64         `json:"events"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"duration"`
+
+	// Deduplicated is the number of packets that were not recorded because
+	// they did not change their devices' state.
+	Deduplicated int64 `json:"deduplicated,omitempty"`
+	// Rejected is the number of packets that were not recorded because they
+	// were too large or malformed.
+	Rejected int64 `json:"rejected,omitempty"`
+
+	// ProxyForwarding is true if the proxy is forwarding while recording.
+	// Recording never blocks forwarding; if forwarding is blocked,
+	// ForwardingBlockedReason describes why.
+	ProxyForwarding         bool   `json:"proxy_forwarding"`
+	ForwardingBlockedReason string `json:"forwarding_blocked_reason,omitempty"`
+
+	// Segment, if >0, is the 1-based index of the segment being recorded, for
+	// recordings that are split into segments. Name is the segment's name, and
+	// Events, Bytes, and Duration describe the segment.
+	Segment int `json:"segment,omitempty"`
+	// Segments, for recordings that are split into segments, are the names of
+	// the segments recorded so far, in order. The last is the segment being
+	// recorded.
+	Segments []string `json:"segments,omitempty"`
+
+	// MaxBytes and MaxDuration, if >0, are the recording's limits. If the
+	// recording was stopped because it reached one of them, LimitReached
+	// identifies it (see the RecordLimit constants).
+	MaxBytes     int64         `json:"max_bytes,omitempty"`
+	MaxDuration  time.Duration `json:"max_duration,omitempty"`
+	LimitReached string        `json:"limit_reached,omitempty"`
+
+	// Path is the path that the recording is committed to when it is stopped.
+	Path string `json:"path,omitempty"`
+
+	// DiskBytes is the amount of disk that the recording is using so far.
+	DiskBytes int64 `json:"disk_bytes,omitempty"`
+	// DiskBytesPerSecond is the average rate at which the recording has been
+	// consuming disk.
+	DiskBytesPerSecond int64 `json:"disk_bytes_per_second,omitempty"`
+	// CompressionRatio is the ratio of DiskBytes to Bytes.
+	CompressionRatio float64 `json:"compression_ratio,omitempty"`
+	// DiskFreeBytes is the amount of free space remaining in storage, if known.
+	DiskFreeBytes int64 `json:"disk_free_bytes,omitempty"`
+	// TimeRemaining, if >0, is the estimated amount of time that the recording
+	// can continue at its current rate before storage is full.
+	TimeRemaining time.Duration `json:"time_remaining,omitempty"`
+}
+
+// Capabilities describes which optional features are enabled on this
+// instance, so that clients can adapt to them.
+type Capabilities struct {
+	// Snapshots is true if device snapshots, and therefore previews, are
+	// available.
+	Snapshots bool `json:"snapshots"`
+	// SystemControl is true if system commands (reboot, shutdown) are
+	// available.
+	SystemControl bool `json:"system_control"`
+	// IdleBlackout is true if devices are blacked out after a period of
+	// inactivity.
+	IdleBlackout bool `json:"idle_blackout"`
+	// SafeMode is true if the instance was started in safe mode.
+	SafeMode bool `json:"safe_mode"`
+	// DeviceIDFormat is the format of the device IDs that are exposed.
+	DeviceIDFormat string `json:"device_id_format"`
+	// TestPatterns lists the test patterns that can be generated.
+	TestPatterns []string `json:"test_patterns"`
+
+	// HTTPS is true if the web interface is served over TLS.
+	HTTPS bool `json:"https"`
+	// TemplateReload is true if the template reload endpoint is installed.
+	TemplateReload bool `json:"template_reload"`
+}
+
+// SnapshotMemory describes the memory used by device snapshots.
+type SnapshotMemory struct {
+	// Bytes is the amount of pixel data retained across all devices, both in
+	// sampled snapshots and in the latest observed strip states. If MaxBytes is
+	// >0, it bounds Bytes.
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// Evictions is the number of times that a device has been evicted to stay
+	// within MaxBytes.
+	Evictions int64 `json:"evictions"`
+
+	// Devices describes the memory used by each device, most recently previewed
+	// first.
+	Devices []*SnapshotDeviceMemory `json:"devices,omitempty"`
+}
+
+// SnapshotDeviceMemory describes the memory used by a single device's
+// snapshots.
+type SnapshotDeviceMemory struct {
+	ID    string `json:"id"`
+	Bytes int64  `json:"bytes"`
+	// Previewed is the last time that the device was previewed. It is zero if
+	// the device has never been previewed.
+	Previewed time.Time `json:"previewed"`
+	// Evicted is true if the device was evicted. Its snapshots are not retained
+	// until it is next previewed.
+	Evicted bool `json:"evicted,omitempty"`
+}
+
+// StorageStatus describes the storage filesystem.
+type StorageStatus struct {
+	// Root is the storage root directory.
+	Root string `json:"root"`
+	// FreeBytes is the amount of free space on Root's filesystem.
+	FreeBytes int64 `json:"free_bytes"`
+
+	// TempDir is the temporary directory that all writes are staged through.
+	TempDir string `json:"temp_dir"`
+	// TempBytes is the total size of the files in TempDir.
+	TempBytes int64 `json:"temp_bytes"`
+	// TempFreeBytes is the amount of free space on TempDir's filesystem.
+	TempFreeBytes int64 `json:"temp_free_bytes"`
+
+	// Errors lists any failures encountered while measuring storage. Fields
+	// that could not be measured are left empty.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// StorageCheck is the result of a non-destructive storage health check.
+type StorageCheck struct {
+	// Root is the storage root directory.
+	Root string `json:"root"`
+	// Healthy is true if no problems were found.
+	Healthy bool `json:"healthy"`
+	// Writable is true if files can be created in Root.
+	Writable bool `json:"writable"`
+
+	// MissingDirs lists the storage subdirectories that do not exist.
+	MissingDirs []string `json:"missing_dirs,omitempty"`
+	// InvalidFiles lists the stored files that are not valid.
+	InvalidFiles []string `json:"invalid_files,omitempty"`
+	// Problems describes any other issues that were found.
+	Problems []string `json:"problems,omitempty"`
 }
 
 // SystemState is the state of the system controls.
@@ -1,9 +1,14 @@
 package web
 
 import (
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
 
 	"github.com/ajstarks/svgo"
+	"github.com/pkg/errors"
 )
 
 // A Pixel is a single RGB pixel.
@@ -13,27 +18,51 @@ type Pixel struct {
 	B uint8
 }
 
+// parsePixelHex parses a Pixel from an "RRGGBB" hex string.
+func parsePixelHex(v string) (Pixel, error) {
+	b, err := hex.DecodeString(v)
+	if err != nil || len(b) != 3 {
+		return Pixel{}, errors.Errorf("invalid color %q, expected RRGGBB", v)
+	}
+	return Pixel{R: b[0], G: b[1], B: b[2]}, nil
+}
+
 // A Strip is a collection of Pixel.
 type Strip struct {
 	Number int
 	Pixels []Pixel
+
+	// Stale is true if the strip has not been updated recently, and its pixels
+	// may not reflect the device's current state.
+	Stale bool
 }
 
-// RenderStripSVG renders a SVG for the specified strips.
-func RenderStripSVG(strips []Strip, w io.Writer) error {
-	const (
-		pixelWidth   = 4
-		pixelHeight  = 8
-		stripPadding = 2
-	)
-
-	// Identify the longest strip (they should all be the same, but...)
-	longestStrip := 0
+// Rendered strip geometry, in image units.
+const (
+	pixelWidth   = 4
+	pixelHeight  = 8
+	stripPadding = 2
+)
+
+// staleStripOpacity is the opacity at which stale strips' pixels are rendered
+// in SVG.
+const staleStripOpacity = 0.3
+
+// longestStrip returns the number of pixels in the longest of strips. They
+// should all be the same, but...
+func longestStrip(strips []Strip) int {
+	longest := 0
 	for i := range strips {
-		if l := len(strips[i].Pixels); l > longestStrip {
-			longestStrip = l
+		if l := len(strips[i].Pixels); l > longest {
+			longest = l
 		}
 	}
+	return longest
+}
+
+// RenderStripSVG renders a SVG for the specified strips.
+func RenderStripSVG(strips []Strip, w io.Writer) error {
+	longestStrip := longestStrip(strips)
 
 	canvas := svg.New(w)
 	canvas.Start(pixelWidth*longestStrip, (pixelHeight+stripPadding)*len(strips))
@@ -44,13 +73,57 @@ func RenderStripSVG(strips []Strip, w io.Writer) error {
 
 		for p := range strip.Pixels {
 			pixel := &strip.Pixels[p]
-			color := canvas.RGB(int(pixel.R), int(pixel.G), int(pixel.B))
+			var color string
+			if strip.Stale {
+				color = canvas.RGBA(int(pixel.R), int(pixel.G), int(pixel.B), staleStripOpacity)
+			} else {
+				color = canvas.RGB(int(pixel.R), int(pixel.G), int(pixel.B))
+			}
 			canvas.Rect(p*pixelWidth, yOffset, pixelWidth, pixelHeight, color)
 		}
 
+		// Outline stale strips so that they stand out even when dark.
+		if strip.Stale && len(strip.Pixels) > 0 {
+			canvas.Rect(0, yOffset, len(strip.Pixels)*pixelWidth, pixelHeight,
+				"fill:none;stroke:red;stroke-width:1")
+		}
+
 		yOffset += pixelHeight + stripPadding
 	}
 
 	canvas.End()
 	return nil
 }
+
+// RenderStripPNG renders a PNG for the specified strips, using the same layout
+// as RenderStripSVG. Padding between strips is transparent.
+func RenderStripPNG(strips []Strip, w io.Writer) error {
+	width := pixelWidth * longestStrip(strips)
+	height := (pixelHeight + stripPadding) * len(strips)
+	if width == 0 || height == 0 {
+		// PNG images can't be empty.
+		width, height = 1, 1
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	yOffset := 0
+	for i := range strips {
+		strip := &strips[i]
+
+		for p := range strip.Pixels {
+			pixel := &strip.Pixels[p]
+			c := color.RGBA{R: pixel.R, G: pixel.G, B: pixel.B, A: 0xFF}
+
+			for y := yOffset; y < yOffset+pixelHeight; y++ {
+				for x := p * pixelWidth; x < (p+1)*pixelWidth; x++ {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+
+		yOffset += pixelHeight + stripPadding
+	}
+
+	return errors.Wrap(png.Encode(w, img), "encoding PNG")
+}
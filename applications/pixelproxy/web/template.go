@@ -1,6 +1,7 @@
 package web
 
 import (
+	"fmt"
 	"html/template"
 	"strconv"
 	"strings"
@@ -10,6 +11,12 @@ import (
 	"code.cloudfoundry.org/bytefmt"
 )
 
+// FormatOrdinal formats a device's group and controller ordinals. It is used
+// wherever an ordinal is presented, so that they can be cross-referenced.
+func FormatOrdinal(group, controller int) string {
+	return fmt.Sprintf("{%d, %d}", group, controller)
+}
+
 var defaultTemplateFuncs = template.FuncMap{
 	"timestr": func(t time.Time) string {
 		return t.Format("02 Jan 06 15:04:05.000 MST")
@@ -20,6 +27,7 @@ var defaultTemplateFuncs = template.FuncMap{
 	"durationstr": func(d time.Duration) string {
 		return d.Truncate(10 * time.Millisecond).String()
 	},
+	"ordinalstr": FormatOrdinal,
 	"bytefmt": func(v int64) string {
 		return bytefmt.ByteSize(uint64(v))
 	},
@@ -29,6 +37,12 @@ var defaultTemplateFuncs = template.FuncMap{
 		}
 		return "No"
 	},
+	"inc": func(v int) int {
+		return v + 1
+	},
+	"inc64": func(v int64) int64 {
+		return v + 1
+	},
 	"maybeplural": func(v int64) string {
 		if v == 1 {
 			return ""
@@ -0,0 +1,28 @@
+package web
+
+// Test patterns, for ControllerProxy.StartTestPattern.
+const (
+	// TestPatternRed, TestPatternGreen, TestPatternBlue, and TestPatternWhite
+	// set every pixel to a solid color.
+	TestPatternRed   = "red"
+	TestPatternGreen = "green"
+	TestPatternBlue  = "blue"
+	TestPatternWhite = "white"
+
+	// TestPatternRainbow sweeps a rainbow along every strip.
+	TestPatternRainbow = "rainbow"
+
+	// TestPatternStrips lights each strip of every device in turn, in strip
+	// order.
+	TestPatternStrips = "strips"
+)
+
+// TestPatterns lists the supported test patterns.
+var TestPatterns = []string{
+	TestPatternRed,
+	TestPatternGreen,
+	TestPatternBlue,
+	TestPatternWhite,
+	TestPatternRainbow,
+	TestPatternStrips,
+}
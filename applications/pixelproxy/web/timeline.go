@@ -0,0 +1,94 @@
+package web
+
+import (
+	"time"
+)
+
+// Timeline states, for Timeline.State.
+const (
+	TimelineIdle      = "idle"
+	TimelinePlaying   = "playing"
+	TimelinePaused    = "paused"
+	TimelineRecording = "recording"
+)
+
+// Timeline describes the position of the current operation along its file,
+// in a form suited to timeline (scrubber) interfaces.
+type Timeline struct {
+	// State is the state of the current operation. See the Timeline constants.
+	State string `json:"state"`
+
+	// Name is the name of the file being played or recorded.
+	Name string `json:"name,omitempty"`
+
+	// Position is the current offset within the file. Duration is the file's
+	// length. While recording, both are the amount recorded so far.
+	Position time.Duration `json:"position"`
+	Duration time.Duration `json:"duration"`
+	// Progress is the percentage of Duration that has been played.
+	Progress int `json:"progress"`
+	// Rate is the speed of playback, relative to the speed at which the file
+	// was recorded. Position and Duration are in the file's own time, so a
+	// scrubber advances Rate times faster than the wall clock. It is omitted
+	// unless playing.
+	Rate float64 `json:"rate,omitempty"`
+
+	// Rounds is the number of times that playback has looped. If MaxRounds is
+	// >0, playback stops after that many rounds.
+	Rounds    int64 `json:"rounds,omitempty"`
+	MaxRounds int64 `json:"max_rounds,omitempty"`
+	// InLoopGap is true if playback is holding between loop rounds.
+	InLoopGap bool `json:"in_loop_gap,omitempty"`
+
+	// Markers are the file's labeled cue points, ordered by offset.
+	Markers []Marker `json:"markers,omitempty"`
+	// CurrentMarker, if not nil, is the last marker at or before Position.
+	CurrentMarker *Marker `json:"current_marker,omitempty"`
+	// NextMarker, if not nil, is the first marker after Position.
+	NextMarker *Marker `json:"next_marker,omitempty"`
+
+	// NoDevices is true if none of the file's devices are registered.
+	NoDevices bool `json:"no_devices,omitempty"`
+	// NoRouteDevices describes the devices that playback could not reach.
+	NoRouteDevices []string `json:"no_route_devices,omitempty"`
+}
+
+// timelineFromStatus builds a Timeline from a ControllerStatus.
+func timelineFromStatus(st *ControllerStatus) *Timeline {
+	switch {
+	case st.RecordStatus != nil:
+		rs := st.RecordStatus
+		return &Timeline{
+			State:    TimelineRecording,
+			Name:     rs.Name,
+			Position: rs.Duration,
+			Duration: rs.Duration,
+		}
+
+	case st.PlaybackStatus != nil:
+		ps := st.PlaybackStatus
+		tl := Timeline{
+			State:          TimelinePlaying,
+			Name:           ps.Name,
+			Position:       ps.Position,
+			Duration:       ps.Duration,
+			Progress:       ps.Progress,
+			Rate:           ps.Rate,
+			Rounds:         ps.Rounds,
+			MaxRounds:      ps.MaxRounds,
+			InLoopGap:      ps.InLoopGap,
+			Markers:        ps.Markers,
+			CurrentMarker:  ps.CurrentMarker,
+			NextMarker:     ps.NextMarker,
+			NoDevices:      ps.NoDevices,
+			NoRouteDevices: ps.NoRouteDevices,
+		}
+		if ps.Paused {
+			tl.State = TimelinePaused
+		}
+		return &tl
+
+	default:
+		return &Timeline{State: TimelineIdle}
+	}
+}
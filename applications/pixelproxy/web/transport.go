@@ -0,0 +1,72 @@
+package web
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Transport intents, for TransportIntent.Action.
+const (
+	TransportPlay   = "play"
+	TransportPause  = "pause"
+	TransportResume = "resume"
+	TransportToggle = "toggle"
+	TransportStop   = "stop"
+	TransportSeek   = "seek"
+)
+
+// TransportIntent is a single transport ("show control") command. It is an
+// ergonomic alternative to the individual playback endpoints for simple
+// remote controls that send one kind of command.
+type TransportIntent struct {
+	// Action is the action to take. See the Transport constants.
+	Action string
+
+	// Name is the file to play, for TransportPlay.
+	Name string
+	// Position is the position to seek to, for TransportSeek.
+	Position time.Duration
+}
+
+// ParseTransportIntent parses a TransportIntent from its string form, one of:
+//   - "play:NAME"
+//   - "pause"
+//   - "resume"
+//   - "toggle", which pauses if playing and resumes if paused.
+//   - "stop"
+//   - "seek:MILLISECONDS"
+func ParseTransportIntent(v string) (*TransportIntent, error) {
+	action, arg := v, ""
+	if idx := strings.IndexRune(v, ':'); idx >= 0 {
+		action, arg = v[:idx], v[idx+1:]
+	}
+
+	ti := TransportIntent{Action: action}
+	switch action {
+	case TransportPlay:
+		if ti.Name = arg; ti.Name == "" {
+			return nil, errors.New("play intent requires a file name")
+		}
+		return &ti, nil
+
+	case TransportSeek:
+		ms, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || ms < 0 {
+			return nil, errors.Errorf("invalid seek position %q", arg)
+		}
+		ti.Position = time.Duration(ms) * time.Millisecond
+		return &ti, nil
+
+	case TransportPause, TransportResume, TransportToggle, TransportStop:
+		if arg != "" {
+			return nil, errors.Errorf("%s intent does not take an argument", action)
+		}
+		return &ti, nil
+
+	default:
+		return nil, errors.Errorf("unknown intent %q", action)
+	}
+}
@@ -0,0 +1,33 @@
+package web
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrZoneNotFound is returned by ControllerProxy methods when a referenced zone
+// is not defined.
+var ErrZoneNotFound = errors.New("zone not found")
+
+// Zone is a named group of devices.
+//
+// A device may belong to at most one Zone.
+type Zone struct {
+	// Name is the name of the zone.
+	Name string `json:"name"`
+	// Devices is the list of IDs of the devices in the zone. The devices need
+	// not currently be registered.
+	Devices []string `json:"devices"`
+}
+
+// ZoneCommandResult describes the result of applying a command to the devices
+// in a Zone.
+type ZoneCommandResult struct {
+	// Zone is the name of the zone.
+	Zone string `json:"zone"`
+	// Devices lists the IDs of the devices that the command was sent to.
+	Devices []string `json:"devices"`
+	// Missing lists the IDs of the zone's devices that are not registered.
+	Missing []string `json:"missing,omitempty"`
+	// Errors lists any failures to send the command.
+	Errors []string `json:"errors,omitempty"`
+}
@@ -0,0 +1,146 @@
+package pixelproxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/storage"
+	"github.com/danjacques/pixelproxy/applications/pixelproxy/web"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/pixel"
+
+	"github.com/pkg/errors"
+)
+
+// Zones implements web.ControllerProxy.
+func (ctrl *Controller) Zones(c context.Context) ([]*web.Zone, error) {
+	zones, err := ctrl.Storage.GetZones()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*web.Zone, 0, len(zones))
+	for name, ids := range zones {
+		result = append(result, &web.Zone{
+			Name:    name,
+			Devices: ids,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// validateZone returns an error wrapping ErrInvalidRequest if zone's name or
+// device list is malformed.
+func validateZone(zone *web.Zone) error {
+	invalid := func(format string, args ...interface{}) error {
+		return errors.Wrapf(web.ErrInvalidRequest, format, args...)
+	}
+
+	if zone.Name == "" {
+		return invalid("missing zone name")
+	}
+	if strings.Contains(zone.Name, "/") {
+		return invalid("zone name %q may not contain '/'", zone.Name)
+	}
+
+	seen := make(map[string]struct{}, len(zone.Devices))
+	for _, id := range zone.Devices {
+		if id == "" {
+			return invalid("empty device ID in zone %q", zone.Name)
+		}
+		if _, ok := seen[id]; ok {
+			return invalid("device %q is listed more than once in zone %q", id, zone.Name)
+		}
+		seen[id] = struct{}{}
+	}
+	return nil
+}
+
+// SetZone implements web.ControllerProxy.
+func (ctrl *Controller) SetZone(c context.Context, zone *web.Zone) error {
+	if err := validateZone(zone); err != nil {
+		return err
+	}
+
+	logging.S(c).Infof("Setting zone %q to devices %q.", zone.Name, zone.Devices)
+	return ctrl.Storage.UpdateZones(func(zones storage.Zones) error {
+		for _, id := range zone.Devices {
+			if other := zones.ZoneForDevice(id); other != "" && other != zone.Name {
+				return errors.Wrapf(web.ErrInvalidRequest, "device %q already belongs to zone %q", id, other)
+			}
+		}
+		zones[zone.Name] = append([]string(nil), zone.Devices...)
+		return nil
+	})
+}
+
+// DeleteZone implements web.ControllerProxy.
+func (ctrl *Controller) DeleteZone(c context.Context, name string) error {
+	logging.S(c).Infof("Deleting zone %q.", name)
+	return ctrl.Storage.UpdateZones(func(zones storage.Zones) error {
+		if _, ok := zones[name]; !ok {
+			return web.ErrZoneNotFound
+		}
+		delete(zones, name)
+		return nil
+	})
+}
+
+// applyToZone calls fn for each registered device in the named zone.
+//
+// If the zone is not defined, applyToZone returns ErrZoneNotFound. Errors
+// returned by fn are recorded in the result.
+func (ctrl *Controller) applyToZone(name string, fn func(d device.D) error) (*web.ZoneCommandResult, error) {
+	zones, err := ctrl.Storage.GetZones()
+	if err != nil {
+		return nil, err
+	}
+	ids, ok := zones[name]
+	if !ok {
+		return nil, web.ErrZoneNotFound
+	}
+
+	result := web.ZoneCommandResult{
+		Zone:    name,
+		Devices: make([]string, 0, len(ids)),
+	}
+	for _, id := range ids {
+		d := ctrl.lookupDevice(id)
+		if d == nil {
+			result.Missing = append(result.Missing, id)
+			continue
+		}
+
+		result.Devices = append(result.Devices, id)
+		if err := fn(d); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", id, err))
+		}
+	}
+	return &result, nil
+}
+
+// SetZoneBrightness implements web.ControllerProxy.
+func (ctrl *Controller) SetZoneBrightness(c context.Context, name string, level int) (*web.ZoneCommandResult, error) {
+	if level < 0 || level > 100 {
+		return nil, errors.Wrapf(web.ErrInvalidRequest, "brightness %d must be between 0 and 100", level)
+	}
+
+	logging.S(c).Infof("Setting brightness of zone %q to %d%%.", name, level)
+	pkt := globalBrightnessPacket(level)
+	return ctrl.applyToZone(name, func(d device.D) error {
+		return ctrl.route(device.InvalidOrdinal(), d.ID(), pkt)
+	})
+}
+
+// BlackoutZone implements web.ControllerProxy.
+func (ctrl *Controller) BlackoutZone(c context.Context, name string) (*web.ZoneCommandResult, error) {
+	logging.S(c).Infof("Blacking out zone %q.", name)
+	return ctrl.applyToZone(name, func(d device.D) error {
+		return ctrl.sendSolidColor(d, pixel.P{})
+	})
+}
@@ -0,0 +1,459 @@
+// Package replayverify implements a tool that verifies that a recorded file
+// survives playback and re-recording without divergence.
+//
+// The tool replays a file to in-process fake PixelPusher devices, records the
+// packets that those devices receive, and then compares the original file,
+// the packets received over the wire, and the re-recorded file
+// packet-for-packet.
+package replayverify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danjacques/pixelproxy/util"
+	"github.com/danjacques/pixelproxy/util/logging"
+
+	"github.com/danjacques/gopushpixels/device"
+	"github.com/danjacques/gopushpixels/protocol"
+	"github.com/danjacques/gopushpixels/protocol/pixelpusher"
+	"github.com/danjacques/gopushpixels/replay"
+	"github.com/danjacques/gopushpixels/replay/streamfile"
+	"github.com/danjacques/gopushpixels/support/bufferpool"
+	"github.com/danjacques/gopushpixels/support/byteslicereader"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	app = util.Application{
+		Verbosity:    zap.WarnLevel,
+		Production:   false,
+		ColorizeLogs: true,
+	}
+
+	settleDelay   = 500 * time.Millisecond
+	maxLagAge     = time.Second
+	maxReports    = 10
+	keepRecording = ""
+)
+
+func init() {
+	pf := rootCmd.PersistentFlags()
+
+	app.AddFlags(pf)
+
+	pf.DurationVar(&settleDelay, "settle_delay", settleDelay,
+		"Amount of time to wait after playback for in-flight packets to arrive.")
+
+	pf.DurationVar(&maxLagAge, "max_lag_age", maxLagAge,
+		"The player's MaxLagAge. Large values prevent lagging packets from being dropped.")
+
+	pf.IntVar(&maxReports, "max_reports", maxReports,
+		"The maximum number of divergences to report per comparison.")
+
+	pf.StringVar(&keepRecording, "keep_recording", keepRecording,
+		"If specified, write the re-recorded file to this path instead of discarding it.")
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "replayverify [file]",
+	Short: "Verifies that a recorded file replays and re-records losslessly.",
+	Long:  ``, // TODO: Fill in long descrpition.
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		app.Run(context.Background(), func(c context.Context) error {
+			return rootCmdRun(c, cmd, args)
+		})
+	},
+}
+
+// Execute runs the application.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func rootCmdRun(c context.Context, cmd *cobra.Command, args []string) error {
+	sourcePath := args[0]
+
+	// Load our expected frames from the source file.
+	md, expected, err := readFrames(sourcePath)
+	if err != nil {
+		logging.S(c).Errorf("Failed to read source file %q: %s", sourcePath, err)
+		return err
+	}
+	logging.S(c).Infof("Loaded %d device(s) from %q.", len(md.Devices), sourcePath)
+
+	// Set up our re-recording.
+	tempDir, err := ioutil.TempDir("", "replayverify")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary directory")
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			logging.S(c).Warnf("Failed to remove temporary directory %q: %s", tempDir, err)
+		}
+	}()
+
+	recordPath := keepRecording
+	if recordPath == "" {
+		recordPath = filepath.Join(tempDir, "recording.protostream")
+	}
+	cfg := streamfile.EventStreamConfig{
+		TempDir:                tempDir,
+		WriterCompression:      streamfile.Compression_NONE,
+		WriterCompressionLevel: -1,
+	}
+	sw, err := cfg.MakeEventStreamWriter(recordPath, md.Name)
+	if err != nil {
+		return errors.Wrap(err, "creating recording")
+	}
+	recorder := replay.Recorder{}
+	recorder.Start(sw)
+
+	// Create fake devices, one for each device in the source file.
+	var capture frameCapture
+	var reg device.Registry
+	router := device.Router{
+		Registry: &reg,
+		Logger:   logging.S(c),
+	}
+	defer router.Shutdown()
+
+	for _, mdd := range md.Devices {
+		ld, err := startFakeDevice(c, mdd, func(d device.D, pkt *protocol.Packet) {
+			capture.add(d.ID(), pkt)
+			if err := recorder.RecordPacket(d, pkt); err != nil {
+				logging.S(c).Warnf("Failed to record packet for %q: %s", d.ID(), err)
+			}
+		})
+		if err != nil {
+			return errors.Wrapf(err, "creating fake device for %q", mdd.Id)
+		}
+		defer func() {
+			if err := ld.Close(); err != nil {
+				logging.S(c).Warnf("Failed to close fake device %q: %s", ld.ID(), err)
+			}
+		}()
+
+		stub := device.MakeRemote(ld.ID(), ld.DiscoveryHeaders())
+		defer stub.MarkDone()
+		reg.Add(stub)
+	}
+
+	// Play the source file through one round.
+	packets := 0
+	for _, frames := range expected {
+		packets += len(frames)
+	}
+	if err := playOnce(c, sourcePath, packets, &router); err != nil {
+		return errors.Wrap(err, "playing source file")
+	}
+
+	logging.S(c).Debugf("Waiting %s for in-flight packets...", settleDelay)
+	if err := util.Sleep(c, settleDelay); err != nil {
+		return err
+	}
+
+	if err := recorder.Stop(); err != nil {
+		return errors.Wrap(err, "finalizing recording")
+	}
+
+	_, recorded, err := readFrames(recordPath)
+	if err != nil {
+		return errors.Wrapf(err, "reading recording %q", recordPath)
+	}
+
+	// Compare.
+	divergences := 0
+	divergences += compareFrames(os.Stdout, "source vs. received", expected, capture.frames())
+	divergences += compareFrames(os.Stdout, "source vs. re-recorded", expected, recorded)
+	if divergences > 0 {
+		return errors.Errorf("found %d divergence(s)", divergences)
+	}
+
+	fmt.Fprintln(os.Stdout, "No divergences found.")
+	return nil
+}
+
+// startFakeDevice starts a local fake PixelPusher on the loopback interface
+// that mirrors the layout of mdd. Packets received by the device are decoded
+// and passed to onPacket.
+func startFakeDevice(c context.Context, mdd *streamfile.Metadata_Device,
+	onPacket func(device.D, *protocol.Packet)) (*device.Local, error) {
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, errors.Wrap(err, "opening connection")
+	}
+
+	strips := len(mdd.Strip)
+	dh := protocol.DiscoveryHeaders{
+		DeviceHeader: protocol.DeviceHeader{
+			DeviceType:       protocol.PixelPusherDeviceType,
+			ProtocolVersion:  protocol.DefaultProtocolVersion,
+			SoftwareRevision: pixelpusher.MinAcceptableSoftwareRevision,
+		},
+		PixelPusher: &pixelpusher.Device{
+			DeviceHeader: pixelpusher.DeviceHeader{
+				StripsAttached:     uint8(strips),
+				MaxStripsPerPacket: uint8(strips),
+				PixelsPerStrip:     uint16(mdd.PixelsPerStrip),
+			},
+		},
+	}
+	dh.PixelPusher.StripFlags = make([]pixelpusher.StripFlags, strips)
+
+	d := &device.Local{
+		DeviceID: mdd.Id,
+		Logger:   logging.S(c),
+	}
+	d.OnPacketData = func(buf *bufferpool.Buffer) {
+		pr := d.DiscoveryHeaders().PixelPusher.PacketReader()
+
+		var pkt pixelpusher.Packet
+		if err := pr.ReadPacket(&byteslicereader.R{Buffer: buf.Bytes()}, &pkt); err != nil {
+			logging.S(c).Warnf("Received invalid packet (%s) on %q.", err, d.ID())
+			return
+		}
+		onPacket(d, &protocol.Packet{PixelPusher: &pkt})
+	}
+	d.Start(conn)
+	d.UpdateHeaders(&dh)
+	return d, nil
+}
+
+// playOnce plays the file at path, which contains the specified number of
+// packets, through a single round, routing its packets through router by device
+// ID.
+//
+// The Player loops, so packets after the first round are not routed. If the
+// Player drops lagging packets, the end of the round is instead noticed by
+// polling its status, and some packets from the next round may be routed;
+// the dropped packets are reported as divergences either way.
+func playOnce(c context.Context, path string, packets int, router *device.Router) error {
+	sr, err := streamfile.MakeEventStreamReader(path)
+	if err != nil {
+		return err
+	}
+
+	var sent int64
+	roundDoneC := make(chan struct{})
+	if packets == 0 {
+		close(roundDoneC)
+	}
+
+	p := replay.Player{
+		SendPacket: func(ord device.Ordinal, id string, pkt *protocol.Packet) error {
+			switch n := atomic.AddInt64(&sent, 1); {
+			case n > int64(packets):
+				// This packet belongs to the next round.
+				return nil
+			case n == int64(packets):
+				defer close(roundDoneC)
+			}
+
+			// Route by ID only, since our fake devices don't share the recorded
+			// devices' ordinals.
+			return router.Route(device.InvalidOrdinal(), id, pkt)
+		},
+		PlaybackLeaser: noopPlaybackLeaser{},
+		MaxLagAge:      maxLagAge,
+		Logger:         logging.S(c),
+	}
+	p.Play(c, sr)
+	defer p.Stop()
+
+	// Poll until the first round has completed.
+	errDone := errors.New("done")
+	err = util.LoopUntil(c, 10*time.Millisecond, func(c context.Context) error {
+		select {
+		case <-roundDoneC:
+			return errDone
+		default:
+		}
+		if st := p.Status(); st != nil && st.Rounds > 0 {
+			return errDone
+		}
+		return nil
+	})
+	if err == errDone {
+		err = nil
+	}
+	return err
+}
+
+// noopPlaybackLeaser is a replay.PlaybackLeaser that does nothing.
+type noopPlaybackLeaser struct{}
+
+func (noopPlaybackLeaser) AcquirePlaybackLease() {}
+func (noopPlaybackLeaser) ReleasePlaybackLease() {}
+
+// frame is a comparable representation of a single packet's strip data.
+type frame []stripFrame
+
+type stripFrame struct {
+	number int
+	pixels []byte
+}
+
+func frameFromPacket(pkt *protocol.Packet) frame {
+	if pkt.PixelPusher == nil {
+		return nil
+	}
+
+	f := make(frame, len(pkt.PixelPusher.StripStates))
+	for i, ss := range pkt.PixelPusher.StripStates {
+		f[i] = stripFrame{
+			number: int(ss.StripNumber),
+			pixels: append([]byte(nil), ss.Pixels.Bytes()...),
+		}
+	}
+	return f
+}
+
+func (f frame) equal(o frame) bool {
+	if len(f) != len(o) {
+		return false
+	}
+	for i := range f {
+		if f[i].number != o[i].number || !bytes.Equal(f[i].pixels, o[i].pixels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f frame) String() string {
+	var buf bytes.Buffer
+	for i, sf := range f {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(&buf, "strip %d (%d bytes)", sf.number, len(sf.pixels))
+	}
+	return buf.String()
+}
+
+// frameCapture collects frames received by fake devices.
+type frameCapture struct {
+	mu       sync.Mutex
+	byDevice map[string][]frame
+}
+
+func (fc *frameCapture) add(id string, pkt *protocol.Packet) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if fc.byDevice == nil {
+		fc.byDevice = make(map[string][]frame)
+	}
+	fc.byDevice[id] = append(fc.byDevice[id], frameFromPacket(pkt))
+}
+
+func (fc *frameCapture) frames() map[string][]frame {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.byDevice
+}
+
+// readFrames reads all of the packets in the file at path, grouped by device
+// ID.
+func readFrames(path string) (*streamfile.Metadata, map[string][]frame, error) {
+	sr, err := streamfile.MakeEventStreamReader(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "opening file")
+	}
+	defer func() {
+		_ = sr.Close()
+	}()
+
+	frames := make(map[string][]frame)
+	for index := 0; ; index++ {
+		e, err := sr.ReadEvent()
+		if err != nil {
+			if err == io.EOF {
+				return sr.Metadata(), frames, nil
+			}
+			return nil, nil, errors.Wrap(err, "reading events from file")
+		}
+
+		pkt := e.GetPacket()
+		if pkt == nil {
+			continue
+		}
+		d := sr.ResolveDeviceForIndex(pkt.Device)
+		if d == nil {
+			return nil, nil, errors.Errorf("event #%d references out-of-range device %d", index, pkt.Device)
+		}
+		decoded, err := pkt.Decode(d)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "decoding event #%d", index)
+		}
+		frames[d.Id] = append(frames[d.Id], frameFromPacket(decoded))
+	}
+}
+
+// compareFrames writes a report of the divergences between expected and actual
+// to w, and returns the number of divergences found.
+func compareFrames(w io.Writer, name string, expected, actual map[string][]frame) int {
+	ids := make(map[string]struct{}, len(expected))
+	for id := range expected {
+		ids[id] = struct{}{}
+	}
+	for id := range actual {
+		ids[id] = struct{}{}
+	}
+	sortedIDs := make([]string, 0, len(ids))
+	for id := range ids {
+		sortedIDs = append(sortedIDs, id)
+	}
+	sort.Strings(sortedIDs)
+
+	fmt.Fprintf(w, "Comparing %s:\n", name)
+	divergences := 0
+	report := func(format string, args ...interface{}) {
+		divergences++
+		if divergences <= maxReports {
+			fmt.Fprintf(w, "  "+format+"\n", args...)
+		}
+	}
+
+	for _, id := range sortedIDs {
+		exp, act := expected[id], actual[id]
+		if len(exp) != len(act) {
+			report("Device %q: expected %d packet(s), got %d.", id, len(exp), len(act))
+		}
+
+		n := len(exp)
+		if len(act) < n {
+			n = len(act)
+		}
+		for i := 0; i < n; i++ {
+			if !exp[i].equal(act[i]) {
+				report("Device %q packet #%d: expected [%s], got [%s].", id, i, exp[i], act[i])
+			}
+		}
+	}
+
+	if divergences > maxReports {
+		fmt.Fprintf(w, "  (%d more divergence(s) not shown)\n", divergences-maxReports)
+	}
+	fmt.Fprintf(w, "  %d divergence(s).\n", divergences)
+	return divergences
+}
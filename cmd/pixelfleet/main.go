@@ -0,0 +1,9 @@
+package main
+
+import (
+	"github.com/danjacques/pixelproxy/applications/pixelfleet"
+)
+
+func main() {
+	pixelfleet.Execute()
+}
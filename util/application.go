@@ -30,6 +30,16 @@ type Application struct {
 	// LogPath, if not nil, is a path to output logs to.
 	LogPath string
 
+	// LogFormat, if not empty, overrides the log encoding. By default,
+	// production logs are JSON and non-production logs are console text.
+	LogFormat logging.FormatFlag
+
+	// LogTimeFormat, if not empty, overrides the log timestamp format.
+	LogTimeFormat logging.TimeFormatFlag
+
+	// LogDisableCaller, if true, omits the calling source location from logs.
+	LogDisableCaller bool
+
 	// Profiler is the configured profiler to use.
 	Profiler profiling.Profiler
 }
@@ -46,6 +56,15 @@ func (a *Application) AddFlags(fs *pflag.FlagSet) {
 
 	fs.StringVar(&a.LogPath, "log_path", a.LogPath, "If set, write logs to this path.")
 
+	fs.Var(&a.LogFormat, "log_format",
+		"Log encoding (json, console). If empty, production uses json and non-production uses console.")
+
+	fs.Var(&a.LogTimeFormat, "log_time_format",
+		"Log timestamp format (iso8601, epoch, millis, nanos). If empty, the mode's default is used.")
+
+	fs.BoolVar(&a.LogDisableCaller, "log_disable_caller", a.LogDisableCaller,
+		"Omit the calling source location from log entries.")
+
 	// Add Profiler flags.
 	a.Profiler.AddFlags(fs)
 }
@@ -64,11 +83,16 @@ func (a *Application) Run(c context.Context, fn func(context.Context) error) {
 		logConfig = zap.NewProductionConfig()
 	} else {
 		logConfig = zap.NewDevelopmentConfig()
-		if a.ColorizeLogs {
-			logConfig.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-		}
+	}
+	a.LogFormat.Apply(&logConfig)
+
+	// Color codes would corrupt JSON output, so only colorize console logs.
+	if !a.Production && a.ColorizeLogs && logConfig.Encoding == "console" {
+		logConfig.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	}
 	logConfig.Level.SetLevel(a.Verbosity)
+	a.LogTimeFormat.Apply(&logConfig.EncoderConfig)
+	logConfig.DisableCaller = a.LogDisableCaller
 	if a.LogPath != "" {
 		logConfig.OutputPaths = append(logConfig.OutputPaths, a.LogPath)
 	}
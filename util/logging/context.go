@@ -16,8 +16,8 @@ type contextConfig struct {
 	// Logger is the installed logger.
 	Logger *zap.Logger
 
-	WarnMem MemoryLogger
-	AllMem  MemoryLogger
+	WarnMem *MemoryLogger
+	AllMem  *MemoryLogger
 }
 
 func getContextConfig(c context.Context) *contextConfig {
@@ -55,7 +55,7 @@ func S(c context.Context) *zap.SugaredLogger {
 // GetRecentLogs returns a list of recent buffered logs.
 func GetRecentLogs(c context.Context) []zapcore.Entry {
 	ccfg := getContextConfig(c)
-	if ccfg != nil {
+	if ccfg != nil && ccfg.AllMem != nil {
 		return ccfg.AllMem.Get()
 	}
 	return nil
@@ -64,7 +64,7 @@ func GetRecentLogs(c context.Context) []zapcore.Entry {
 // GetRecentEscalatedLogs returns a list of recent buffered warn and error logs.
 func GetRecentEscalatedLogs(c context.Context) []zapcore.Entry {
 	ccfg := getContextConfig(c)
-	if ccfg != nil {
+	if ccfg != nil && ccfg.WarnMem != nil {
 		return ccfg.WarnMem.Get()
 	}
 	return nil
@@ -74,11 +74,11 @@ func GetRecentEscalatedLogs(c context.Context) []zapcore.Entry {
 func WithLogger(c context.Context, cfg *zap.Config, fn func(context.Context) error) (err error) {
 	// Generate memory loggers.
 	ctxConfig := contextConfig{
-		WarnMem: MemoryLogger{
+		WarnMem: &MemoryLogger{
 			Size:     100,
 			MinLevel: zapcore.WarnLevel,
 		},
-		AllMem: MemoryLogger{
+		AllMem: &MemoryLogger{
 			Size:     100,
 			MinLevel: zapcore.DebugLevel,
 		},
@@ -110,7 +110,29 @@ func UseLogger(c context.Context, l *zap.Logger) context.Context {
 	return ctxConfig.use(c)
 }
 
+// WithComponent returns a derivative of c whose logger is named for the
+// specified component (e.g., "storage"), so that its logs can be told apart
+// from those of other subsystems. A component within another component is
+// named "outer.inner".
+//
+// The component is the log entry's logger name, so it is encoded alongside
+// each entry and is available to the buffered logs.
+//
+// If c has no logger, WithComponent returns c.
+func WithComponent(c context.Context, component string) context.Context {
+	ccfg := getContextConfig(c)
+	if ccfg == nil || ccfg.Logger == nil {
+		return c
+	}
+
+	derived := *ccfg
+	derived.Logger = ccfg.Logger.Named(component)
+	return derived.use(c)
+}
+
 // LogError outputs the contents of the error, err, to the logger.
+//
+// The reported caller is LogError's caller, rather than LogError itself.
 func LogError(c context.Context, err error) {
-	S(c).Errorf("Encountered error: %s", err)
+	L(c).WithOptions(zap.AddCallerSkip(1)).Sugar().Errorf("Encountered error: %s", err)
 }
@@ -1,6 +1,8 @@
 package logging
 
 import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -15,3 +17,76 @@ type VerbosityFlag struct {
 
 // Type implements cobra's "pflag.Value" interface.
 func (vf *VerbosityFlag) Type() string { return "zapcore.Level" }
+
+// TimeFormatFlag is a pflag.Value that selects a zapcore.TimeEncoder by name.
+//
+// The empty value means that the logger configuration's default encoder
+// should be used.
+type TimeFormatFlag string
+
+// timeFormats are the names accepted by TimeFormatFlag.
+var timeFormats = map[string]struct{}{
+	"iso8601": {},
+	"epoch":   {},
+	"millis":  {},
+	"nanos":   {},
+}
+
+// Set implements pflag.Value.
+func (tf *TimeFormatFlag) Set(v string) error {
+	if _, ok := timeFormats[v]; !ok && v != "" {
+		return errors.Errorf("unknown time format %q (must be iso8601, epoch, millis, or nanos)", v)
+	}
+	*tf = TimeFormatFlag(v)
+	return nil
+}
+
+// String implements pflag.Value.
+func (tf *TimeFormatFlag) String() string { return string(*tf) }
+
+// Type implements pflag.Value.
+func (tf *TimeFormatFlag) Type() string { return "format" }
+
+// Apply configures ec to use the selected time encoder. If no format is
+// selected, ec is left unchanged.
+func (tf TimeFormatFlag) Apply(ec *zapcore.EncoderConfig) {
+	if tf == "" {
+		return
+	}
+
+	// TimeEncoder's UnmarshalText falls back to epoch for unknown names, which
+	// is also how we spell epoch.
+	_ = ec.EncodeTime.UnmarshalText([]byte(tf))
+}
+
+// FormatFlag is a pflag.Value that selects a zap log encoding ("json" or
+// "console").
+//
+// The empty value means that the logger configuration's default encoding
+// should be used.
+type FormatFlag string
+
+// Set implements pflag.Value.
+func (ff *FormatFlag) Set(v string) error {
+	switch v {
+	case "", "json", "console":
+		*ff = FormatFlag(v)
+		return nil
+	default:
+		return errors.Errorf("unknown log format %q (must be json or console)", v)
+	}
+}
+
+// String implements pflag.Value.
+func (ff *FormatFlag) String() string { return string(*ff) }
+
+// Type implements pflag.Value.
+func (ff *FormatFlag) Type() string { return "format" }
+
+// Apply configures cfg to use the selected encoding. If no encoding is
+// selected, cfg is left unchanged.
+func (ff FormatFlag) Apply(cfg *zap.Config) {
+	if ff != "" {
+		cfg.Encoding = string(ff)
+	}
+}
@@ -2,13 +2,16 @@ package profiling
 
 import (
 	"fmt"
+	"net/http"
 	httpProf "net/http/pprof"
 	"os"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
@@ -65,7 +68,7 @@ func (p *Profiler) Start() error {
 		}
 	}
 	if p.ProfileCPU {
-		out, err := os.Create(p.generateOutPath("cpu"))
+		out, err := os.Create(p.generateOutPath("cpu", ""))
 		if err != nil {
 			return errors.Wrap(err, "failed to create CPU profile output file")
 		}
@@ -92,6 +95,26 @@ func (p *Profiler) AddHTTP(r *mux.Router) {
 		name := p.Name()
 		r.Handle(fmt.Sprintf("/debug/%s", name), httpProf.Handler(name)).Methods("GET")
 	}
+
+	// Allow heap profiles to be written to Dir on demand.
+	r.HandleFunc("/debug/heapSnapshot", p.handleHeapSnapshot).Methods("POST")
+}
+
+// handleHeapSnapshot writes a heap profile to Dir, and responds with its path.
+// The optional "label" parameter is incorporated into the profile's filename.
+func (p *Profiler) handleHeapSnapshot(rw http.ResponseWriter, req *http.Request) {
+	if p.Dir == "" {
+		http.Error(rw, "no profile output directory is configured", http.StatusNotFound)
+		return
+	}
+
+	path, err := p.DumpHeapProfile(req.FormValue("label"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprintln(rw, path)
 }
 
 // Stop stops the Profiler's operations.
@@ -112,14 +135,30 @@ func (p *Profiler) DumpSnapshot() error {
 		return nil
 	}
 	if p.ProfileHeap {
-		if err := p.dumpHeapProfile(); err != nil {
-			return errors.Wrap(err, "failed to dump heap profile")
+		if _, err := p.DumpHeapProfile(""); err != nil {
+			return err
 		}
 	}
 	return nil
 }
-func (p *Profiler) dumpHeapProfile() (err error) {
-	fd, err := os.Create(p.generateOutPath("memory"))
+
+// DumpHeapProfile writes a heap profile to the configured output directory,
+// regardless of ProfileHeap, and returns its path. If label is not empty, it
+// is incorporated into the profile's filename.
+func (p *Profiler) DumpHeapProfile(label string) (string, error) {
+	if p.Dir == "" {
+		return "", errors.New("no profile output directory is configured")
+	}
+
+	path := p.generateOutPath("memory", label)
+	if err := writeHeapProfile(path); err != nil {
+		return "", errors.Wrap(err, "failed to dump heap profile")
+	}
+	return path, nil
+}
+
+func writeHeapProfile(path string) (err error) {
+	fd, err := os.Create(path)
 	if err != nil {
 		return errors.Wrap(err, "failed to create output file")
 	}
@@ -138,12 +177,28 @@ func (p *Profiler) dumpHeapProfile() (err error) {
 	return nil
 }
 
-func (p *Profiler) generateOutPath(base string) string {
+// generateOutPath returns a unique path in Dir for a profile of type base. If
+// label is not empty, it is sanitized and appended to base.
+func (p *Profiler) generateOutPath(base, label string) string {
 	now := time.Now()
 	counter := p.uniqueCounter()
+	if label = sanitizeLabel(label); label != "" {
+		base = base + "-" + label
+	}
 	return filepath.Join(p.Dir, fmt.Sprintf("%s_%d_%d.prof", base, now.Unix(), counter))
 }
 
+// sanitizeLabel makes label safe for use in a filename. Characters other than
+// letters, numbers, '-', and '.' are replaced with '-'.
+func sanitizeLabel(label string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || r == '-' || r == '.' {
+			return r
+		}
+		return '-'
+	}, label)
+}
+
 func (p *Profiler) uniqueCounter() (v uint64) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
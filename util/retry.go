@@ -0,0 +1,52 @@
+package util
+
+import (
+	"context"
+	"time"
+)
+
+// Retry describes a retry policy with exponential backoff.
+//
+// The zero value makes a single attempt.
+type Retry struct {
+	// Attempts is the maximum number of attempts to make. Values <= 1 mean that
+	// only a single attempt will be made.
+	Attempts int
+
+	// Delay is the amount of time to wait before the first retry.
+	Delay time.Duration
+	// MaxDelay, if >0, is the maximum amount of time to wait between attempts.
+	MaxDelay time.Duration
+	// Multiplier is the factor by which the delay grows after each retry.
+	// Values < 1 are treated as 1 (constant delay).
+	Multiplier float64
+}
+
+// Do runs fn until it succeeds, the policy's attempts are exhausted, or the
+// supplied Context is cancelled.
+//
+// If all attempts fail, Do returns the error from the last attempt. If the
+// Context is cancelled while waiting to retry, Do returns the Context's error.
+func (r *Retry) Do(c context.Context, fn func() error) error {
+	var s Sleeper
+	defer s.Close()
+
+	delay := r.Delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.Attempts {
+			return err
+		}
+
+		if err := s.Sleep(c, delay); err != nil {
+			return err
+		}
+
+		if r.Multiplier > 1 {
+			delay = time.Duration(float64(delay) * r.Multiplier)
+		}
+		if r.MaxDelay > 0 && delay > r.MaxDelay {
+			delay = r.MaxDelay
+		}
+	}
+}
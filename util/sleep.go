@@ -55,8 +55,10 @@ func (s *Sleeper) Sleep(c context.Context, d time.Duration) error {
 //
 // Close is optional, but may offer better resource management if called.
 func (s *Sleeper) Close() {
-	s.t.Stop()
-	s.t = nil
+	if s.t != nil {
+		s.t.Stop()
+		s.t = nil
+	}
 }
 
 // Sleep is a shortcut for a single-use Sleeper.
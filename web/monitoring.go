@@ -1,10 +1,13 @@
 package web
 
 import (
+	"bufio"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
@@ -120,3 +123,28 @@ func (crw *capturingResponseWriter) Write(b []byte) (int, error) {
 	crw.bytes += int64(len(b))
 	return crw.base.Write(b)
 }
+
+// Flush implements http.Flusher. If the base writer is not a Flusher, Flush
+// does nothing.
+func (crw *capturingResponseWriter) Flush() {
+	if f, ok := crw.base.(http.Flusher); ok {
+		crw.hasStatus = true
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, delegating to the base writer.
+func (crw *capturingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := crw.base.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	// The connection is no longer ours; record a switch so that monitoring
+	// doesn't report the default status.
+	if !crw.hasStatus {
+		crw.status = http.StatusSwitchingProtocols
+		crw.hasStatus = true
+	}
+	return h.Hijack()
+}
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMonitoringMiddlewareFlushes(t *testing.T) {
+	t.Parallel()
+
+	var mm MonitoringMiddleware
+	h := mm.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		f, ok := rw.(http.Flusher)
+		if !ok {
+			t.Fatalf("wrapped response writer is not an http.Flusher")
+		}
+
+		if _, err := rw.Write([]byte("event")); err != nil {
+			t.Fatalf("could not write response: %s", err)
+		}
+		f.Flush()
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/_api/events", nil))
+
+	if !rec.Flushed {
+		t.Errorf("response was not flushed through the middleware")
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("response status is %d, want %d", rec.Code, http.StatusOK)
+	}
+	if body := rec.Body.String(); body != "event" {
+		t.Errorf("response body is %q, want %q", body, "event")
+	}
+}
+
+func TestMonitoringMiddlewareRefusesUnsupportedHijack(t *testing.T) {
+	t.Parallel()
+
+	var mm MonitoringMiddleware
+	h := mm.Middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		hj, ok := rw.(http.Hijacker)
+		if !ok {
+			t.Fatalf("wrapped response writer is not an http.Hijacker")
+		}
+
+		// A ResponseRecorder can't be hijacked, so neither can its wrapper.
+		if _, _, err := hj.Hijack(); err == nil {
+			t.Errorf("Hijack succeeded, want an error")
+		}
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
@@ -53,6 +53,29 @@ func (s *Site) AddTemplate(name string, dependencies ...string) error {
 	return nil
 }
 
+// ReloadTemplates rebuilds all of the Site's cached templates from their
+// content.
+//
+// If a template fails to build, its previously-cached version is retained, and
+// ReloadTemplates continues with the remaining templates and returns the first
+// error. If the Site does not cache, templates are always rebuilt on render,
+// and ReloadTemplates does nothing.
+//
+// ReloadTemplates is not safe to call concurrently with AddTemplate.
+func (s *Site) ReloadTemplates() error {
+	if !s.Cache {
+		return nil
+	}
+
+	var firstErr error
+	for name, tb := range s.templates {
+		if err := tb.rebuild(); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "reloading template %q", name)
+		}
+	}
+	return firstErr
+}
+
 // Render renders the specified asset to w.
 func (s *Site) Render(w io.Writer, name string) error {
 	data, err := s.getContent(name)
@@ -12,24 +12,43 @@ type templateBuilder struct {
 	name         string
 	dependencies []string
 
-	calcOnce sync.Once
-	t        *template.Template
-	err      error
+	// mu protects the cached template state.
+	mu    sync.Mutex
+	built bool
+	t     *template.Template
+	err   error
 }
 
 func (tb *templateBuilder) getTemplate() (*template.Template, error) {
 	// If we're caching, calculate at most once.
 	if tb.s.Cache {
-		// Build the template once.
-		tb.calcOnce.Do(func() {
+		tb.mu.Lock()
+		defer tb.mu.Unlock()
+
+		if !tb.built {
 			tb.t, tb.err = tb.buildTemplate()
-		})
+			tb.built = true
+		}
 		return tb.t, tb.err
 	}
 
 	return tb.buildTemplate()
 }
 
+// rebuild rebuilds the cached template. If the build fails, the previously
+// cached template is retained.
+func (tb *templateBuilder) rebuild() error {
+	t, err := tb.buildTemplate()
+	if err != nil {
+		return err
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.t, tb.err, tb.built = t, nil, true
+	return nil
+}
+
 func (tb *templateBuilder) buildTemplate() (*template.Template, error) {
 	// Build a new template tree.
 	t := template.New("").Funcs(tb.s.TemplateFuncMap)